@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"api-demo/app/internal/persistence/postgres"
+	"api-demo/app/internal/service"
+	"api-demo/pkg/app"
+	"api-demo/pkg/log"
+)
+
+const checkLedgerUsage = "usage: api-demo-service check-ledger"
+
+// errInconsistentLedger makes check-ledger exit with an error when the ledger is inconsistent
+var errInconsistentLedger = errors.New("the ledger is inconsistent")
+
+// checkLedgerCommand checks the consistency of the ledger on behalf of the operators, printing the report as JSON
+func checkLedgerCommand(ctx context.Context, resources app.SetupResourcesProvider, args []string) error {
+	if len(args) != 0 {
+		return errors.New(checkLedgerUsage)
+	}
+
+	db, err := resources.WithPostgresConnection(app.DefaultPostgresProfile)
+	if err != nil {
+		return err
+	}
+
+	report, err := service.NewLedger(postgres.NewLedgerRepository(db)).CheckConsistency(ctx)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	if !report.Consistent() {
+		return errInconsistentLedger
+	}
+
+	return nil
+}
+
+// newLedgerCheckJob returns the job that checks the consistency of the ledger, logging the report when it's broken.
+// It scans every posting, so it runs in the background instead of delaying the startup.
+func newLedgerCheckJob(ledger *service.Ledger) app.Job {
+	return func(ctx context.Context) error {
+		report, err := ledger.CheckConsistency(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !report.Consistent() {
+			log.FromContext(ctx).WithField("report", report).Warn("the ledger is inconsistent")
+		}
+
+		return nil
+	}
+}
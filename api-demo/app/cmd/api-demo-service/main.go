@@ -2,32 +2,261 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"time"
 
 	"api-demo/app/internal/httpapi"
+	"api-demo/app/internal/metrics"
+	"api-demo/app/internal/persistence/memory"
 	"api-demo/app/internal/persistence/postgres"
 	"api-demo/app/internal/service"
 	"api-demo/pkg/app"
+	"api-demo/pkg/log"
+	"api-demo/pkg/migrate"
 )
 
+// replicaProfile is the Postgres connection profile of the read replica
+const replicaProfile = "replica"
+
+const (
+	// storagePostgres keeps the data in Postgres
+	storagePostgres = "postgres"
+
+	// storageMemory keeps the data in memory, seeded with the demo users
+	storageMemory = "memory"
+)
+
+// defaultScheduleInterval is how often the due scheduled transfers are run by default
+const defaultScheduleInterval = 10 * time.Second
+
+// defaultHoldExpiryInterval is how often the holds past their expiration are expired by default
+const defaultHoldExpiryInterval = time.Minute
+
+// defaultLedgerCheckInterval is how often the consistency of the ledger is checked by default
+const defaultLedgerCheckInterval = time.Hour
+
+// serviceConfig is the config of the service, loaded along with the config of the app
+type serviceConfig struct {
+	PasswordCost    int           `config:"password_cost" help:"bcrypt cost of new password hashes"`
+	AccessTokenTTL  time.Duration `config:"access_token_ttl" help:"for how long access tokens are valid"`
+	RefreshTokenTTL time.Duration `config:"refresh_token_ttl" help:"for how long refresh tokens are valid"`
+	BasicAuth       bool          `config:"basic_auth" help:"whether the Basic scheme is accepted besides Bearer tokens"`
+	MigrateOnStart  bool          `config:"migrate_on_start" help:"whether the pending migrations are applied at startup"`
+	Storage         string        `config:"storage" help:"where the data is kept: postgres, or memory for local development"`
+
+	ScheduleInterval     time.Duration `config:"schedule_interval" help:"how often the due scheduled transfers are run"`
+	ScheduleRetryBackoff time.Duration `config:"schedule_retry_backoff" help:"wait before the first retry of a scheduled transfer that lacked funds"`
+	ScheduleMaxAttempts  int           `config:"schedule_max_attempts" help:"how many times a run of a scheduled transfer is tried"`
+
+	HoldExpiryInterval time.Duration `config:"hold_expiry_interval" help:"how often the holds past their expiration are expired"`
+
+	LedgerCheckInterval time.Duration `config:"ledger_check_interval" help:"how often the consistency of the ledger is checked"`
+}
+
+func (c *serviceConfig) Validate() error {
+	if c.Storage != storagePostgres && c.Storage != storageMemory {
+		return fmt.Errorf("invalid storage %q, it should be %s or %s", c.Storage, storagePostgres, storageMemory)
+	}
+
+	if c.ScheduleInterval <= 0 || c.ScheduleRetryBackoff <= 0 {
+		return fmt.Errorf("the schedule interval and retry backoff should be positive")
+	}
+
+	if c.ScheduleMaxAttempts < 1 {
+		return fmt.Errorf("invalid schedule max attempts %d, it should be at least 1", c.ScheduleMaxAttempts)
+	}
+
+	if c.HoldExpiryInterval <= 0 {
+		return fmt.Errorf("the hold expiry interval should be positive")
+	}
+
+	if c.LedgerCheckInterval <= 0 {
+		return fmt.Errorf("the ledger check interval should be positive")
+	}
+
+	return nil
+}
+
+// UsesPostgres tells the app the Postgres settings are only required when the data is kept in Postgres
+func (c *serviceConfig) UsesPostgres() bool {
+	return c.Storage == storagePostgres
+}
+
+// repository is implemented by the repositories of accounts, used by the account and authentication services
+type repository interface {
+	service.AccountRepository
+	service.AuthenticationRepository
+}
+
+// repositories are the repositories used by the services, all sharing the same storage
+type repositories struct {
+	accounts repository
+	users    service.UserRepository
+	ledger   service.LedgerRepository
+}
+
+// newPostgresRepositories creates the repositories backed by Postgres, reading from the replica when it's configured
+func newPostgresRepositories(ctx context.Context, resources app.SetupResourcesProvider,
+	config serviceConfig) (*repositories, error) {
+
+	db, err := resources.WithPostgresConnection(app.DefaultPostgresProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	// the replica is only connected to when it's configured, otherwise everything is read from the primary
+	var repoOpts []postgres.Opt
+	if resources.Config().PostgresProfiles[replicaProfile].Host != "" {
+		replica, err := resources.WithPostgresConnection(replicaProfile)
+		if err != nil {
+			return nil, err
+		}
+
+		repoOpts = append(repoOpts, postgres.WithReadReplica(replica))
+	}
+
+	if config.MigrateOnStart {
+		migrator, err := migrate.NewMigrator(db, postgres.Migrations)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := migrator.Up(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	accountRepo := postgres.NewAccountRepository(db, repoOpts...)
+	return &repositories{
+		accounts: accountRepo,
+		users:    postgres.NewUserRepository(accountRepo),
+		ledger:   postgres.NewLedgerRepository(db),
+	}, nil
+}
+
+// newMemoryRepositories creates the repositories that keep the data in memory, seeded with the demo users
+func newMemoryRepositories(ctx context.Context, hasher service.PasswordHasher) (*repositories, error) {
+
+	log.FromContext(ctx).Warn("the data is kept in memory, it's lost when the service stops")
+
+	db := memory.NewDB()
+	accountRepo := memory.NewAccountRepository(db)
+	if err := memory.Seed(ctx, accountRepo, hasher); err != nil {
+		return nil, err
+	}
+
+	return &repositories{
+		accounts: accountRepo,
+		users:    memory.NewUserRepository(accountRepo),
+		ledger:   memory.NewLedgerRepository(db),
+	}, nil
+}
+
 func main() {
-	app.New(func(ctx context.Context, resources app.SetupResourcesProvider) error {
+	config := defaultServiceConfig()
+
+	os.Exit(app.New(newSetup(&config),
+		app.WithServiceConfig(&config),
+		app.WithPostgresProfile(replicaProfile, app.PostgresConfig{}),
+		app.WithCommand("migrate", migrateCommand),
+		app.WithCommand("seed", seedCommand),
+		app.WithCommand("reverse", reverseCommand),
+		app.WithCommand("check-ledger", checkLedgerCommand)).Run())
+}
+
+// defaultServiceConfig returns the config used for the settings of the service that aren't set by any source
+func defaultServiceConfig() serviceConfig {
+	return serviceConfig{
+		PasswordCost:    service.DefaultPasswordCost,
+		AccessTokenTTL:  service.DefaultAccessTokenTTL,
+		RefreshTokenTTL: service.DefaultRefreshTokenTTL,
+		BasicAuth:       true,
+		Storage:         storagePostgres,
+
+		ScheduleInterval:     defaultScheduleInterval,
+		ScheduleRetryBackoff: service.DefaultScheduleRetryBackoff,
+		ScheduleMaxAttempts:  service.DefaultScheduleMaxAttempts,
 
-		db, err := resources.WithPostgresConnection("postgres")
+		HoldExpiryInterval: defaultHoldExpiryInterval,
+
+		LedgerCheckInterval: defaultLedgerCheckInterval,
+	}
+}
+
+// newSetup returns the setup of the service, the config is read when the setup runs so it can be loaded meanwhile
+func newSetup(config *serviceConfig) app.SetupFunc {
+	return func(ctx context.Context, resources app.SetupResourcesProvider) error {
+		passwordHasher, err := service.NewBcryptHasher(config.PasswordCost)
 		if err != nil {
 			return err
 		}
 
-		accountRepo := postgres.NewAccountRepository(db)
+		var repos *repositories
+		if config.Storage == storageMemory {
+			repos, err = newMemoryRepositories(ctx, passwordHasher)
+		} else {
+			repos, err = newPostgresRepositories(ctx, resources, *config)
+		}
 
-		accountService := service.NewAccount(accountRepo)
-		authService := service.NewAuthentication(accountRepo)
+		if err != nil {
+			return err
+		}
 
-		authWrapper := httpapi.NewAuthWrapper(authService)
+		accountService := service.NewAccount(repos.accounts)
 
-		accountAPI := httpapi.NewAccount(accountService, authWrapper)
+		authService := service.NewAuthentication(repos.accounts, passwordHasher,
+			service.WithAccessTokenTTL(config.AccessTokenTTL),
+			service.WithRefreshTokenTTL(config.RefreshTokenTTL))
+
+		// Basic auth is kept for the clients that don't use tokens yet
+		var authWrapperOpts []httpapi.AuthWrapperOpt
+		if config.BasicAuth {
+			authWrapperOpts = append(authWrapperOpts, httpapi.WithBasicAuth())
+		}
+
+		authWrapper := httpapi.NewAuthWrapper(authService, authWrapperOpts...)
+
+		usersService := service.NewUsers(repos.users, passwordHasher)
+
+		instrumentedAccountService, err := metrics.NewAccount(accountService, resources.MetricsRegisterer())
+		if err != nil {
+			return err
+		}
+
+		accountAPI := httpapi.NewAccount(instrumentedAccountService, authWrapper)
+		authAPI := httpapi.NewAuth(authService)
+		usersAPI := httpapi.NewUsers(usersService, authWrapper)
+
+		// the scheduled transfers are made by the account service, so they're checked just like the other transfers, and
+		// counted in the same metrics
+		scheduledService := service.NewScheduledTransfers(accountService,
+			service.WithScheduleRetryBackoff(config.ScheduleRetryBackoff),
+			service.WithScheduleMaxAttempts(config.ScheduleMaxAttempts),
+			service.WithScheduleObserver(instrumentedAccountService))
+
+		scheduledAPI := httpapi.NewScheduledTransfers(scheduledService, authWrapper)
 
 		resources.WithHTTPAPI(accountAPI)
+		resources.WithHTTPAPI(authAPI)
+		resources.WithHTTPAPI(usersAPI)
+		resources.WithHTTPAPI(scheduledAPI)
+
+		if err := resources.WithJob("scheduled_transfers", config.ScheduleInterval, scheduledService.RunDue); err != nil {
+			return err
+		}
+
+		if err := resources.WithJob("hold_expiration", config.HoldExpiryInterval, accountService.ExpireHolds); err != nil {
+			return err
+		}
+
+		// balances are cached on the wallets, so a broken ledger is reported as soon as the jobs start, and then
+		// periodically
+		ledgerCheckJob := newLedgerCheckJob(service.NewLedger(repos.ledger))
+		if err := resources.WithJob("ledger_consistency", config.LedgerCheckInterval, ledgerCheckJob); err != nil {
+			return err
+		}
 
 		return nil
-	}).Run()
+	}
 }
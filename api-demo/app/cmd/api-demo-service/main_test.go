@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"api-demo/app/internal/persistence/postgres"
+	"api-demo/pkg/app"
+	"api-demo/pkg/app/apptest"
+)
+
+const (
+	brenoID = "256bea59-c9a7-44d0-bcd8-d710aad69676"
+	brunoID = "c66af437-8536-4ac9-918c-5e73ef95578a"
+)
+
+// the demo users are seeded with wallets in USD as well, see demoUsers
+var (
+	brenoUSD = walletJSON("USD", "20", "20")
+	brunoUSD = walletJSON("USD", "5", "5")
+)
+
+// meJSON is the JSON returned by /me for the user with the given balances in BRL, the default currency, and wallets
+// in other currencies, in their order
+func meJSON(userID string, balance string, available string, wallets ...string) string {
+	balances := append([]string{walletJSON("BRL", balance, available)}, wallets...)
+	return `{"user_id": "` + userID + `", "balance": ` + balance + `, "available_balance": ` + available +
+		`, "balances": [` + strings.Join(balances, ", ") + `]}`
+}
+
+// walletJSON is the JSON of the balance of a wallet
+func walletJSON(currency string, balance string, available string) string {
+	return `{"currency": "` + currency + `", "balance": ` + balance + `, "available_balance": ` + available + `}`
+}
+
+// newTestApp runs the service with the demo users, on a throwaway Postgres schema when there's a Postgres to connect
+// to or in memory otherwise
+func newTestApp(t *testing.T) *apptest.TestApp {
+	config := defaultServiceConfig()
+	config.PasswordCost = bcrypt.MinCost
+	if apptest.PostgresAvailable() {
+		config.MigrateOnStart = true
+	} else {
+		config.Storage = storageMemory
+	}
+
+	testApp := apptest.New(t, newSetup(&config))
+	testApp.Run()
+
+	if apptest.PostgresAvailable() {
+		require.NoError(t, postgres.Seed(context.Background(), testApp.PostgresConnection(app.DefaultPostgresProfile)))
+	}
+
+	return testApp
+}
+
+// login logs a user in, returning its access token
+func login(t *testing.T, testApp *apptest.TestApp, userName string, password string) string {
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+
+	testApp.Do(http.MethodPost, "/auth/login", map[string]string{"username": userName, "password": password}).
+		RequireStatus(http.StatusOK).
+		Decode(&tokens)
+
+	return tokens.AccessToken
+}
+
+func TestService_Transfer(t *testing.T) {
+	testApp := newTestApp(t)
+	token := login(t, testApp, "breno", "1234")
+
+	testApp.Do(http.MethodPost, "/me/transactions", map[string]interface{}{"target_user_id": brunoID, "amount": 1.5},
+		apptest.WithBearerToken(token)).
+		RequireStatus(http.StatusOK)
+
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBearerToken(token)).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brenoID, "8.5", "8.5", brenoUSD))
+
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brunoID, "101.5", "101.5", brunoUSD))
+
+	var statement struct {
+		Transactions []struct {
+			Direction            string  `json:"direction"`
+			CounterpartyUserName string  `json:"counterparty_user_name"`
+			BalanceAfter         float64 `json:"balance_after"`
+		} `json:"transactions"`
+	}
+
+	testApp.Do(http.MethodGet, "/me/transactions", nil, apptest.WithBearerToken(token)).
+		RequireStatus(http.StatusOK).
+		Decode(&statement)
+
+	require.Len(t, statement.Transactions, 1)
+	require.Equal(t, "outgoing", statement.Transactions[0].Direction)
+	require.Equal(t, "bruno", statement.Transactions[0].CounterpartyUserName)
+	require.Equal(t, 8.5, statement.Transactions[0].BalanceAfter)
+}
+
+func TestService_Errors(t *testing.T) {
+	testApp := newTestApp(t)
+
+	testApp.Do(http.MethodGet, "/me", nil).
+		RequireStatus(http.StatusUnauthorized)
+
+	response := testApp.Do(http.MethodPost, "/me/transactions",
+		map[string]interface{}{"target_user_id": brunoID, "amount": 1000},
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusUnprocessableEntity)
+
+	require.Equal(t, "application/problem+json", response.Header.Get("Content-Type"))
+
+	var problem struct {
+		Code string `json:"code"`
+	}
+
+	response.Decode(&problem)
+	require.Equal(t, "insufficient_funds", problem.Code)
+}
+
+func TestService_SignUp(t *testing.T) {
+	testApp := newTestApp(t)
+
+	testApp.Do(http.MethodPost, "/users", map[string]string{"username": "brena", "password": "a strong password",
+		"display_name": "Brena"}).
+		RequireStatus(http.StatusOK)
+
+	token := login(t, testApp, "brena", "a strong password")
+
+	var profile map[string]interface{}
+	testApp.Do(http.MethodGet, "/me/profile", nil, apptest.WithBearerToken(token)).
+		RequireStatus(http.StatusOK).
+		Decode(&profile)
+
+	require.Equal(t, "brena", profile["username"])
+	require.Equal(t, "Brena", profile["display_name"])
+
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBearerToken(token)).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(profile["id"].(string), "0", "0"))
+}
+
+func TestService_Refund(t *testing.T) {
+	testApp := newTestApp(t)
+
+	var transaction struct {
+		ID string `json:"id"`
+	}
+
+	testApp.Do(http.MethodPost, "/me/transactions", map[string]interface{}{"target_user_id": brunoID, "amount": 5},
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&transaction)
+
+	refundPath := "/me/transactions/" + transaction.ID + "/refund"
+
+	testApp.Do(http.MethodPost, refundPath, nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusForbidden)
+
+	testApp.Do(http.MethodPost, refundPath, map[string]interface{}{"amount": 2},
+		apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusOK)
+
+	// without an amount, what's left is refunded
+	var refund map[string]interface{}
+	testApp.Do(http.MethodPost, refundPath, nil, apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusOK).
+		Decode(&refund)
+
+	require.Equal(t, 3.0, refund["amount"])
+	require.Equal(t, transaction.ID, refund["reversal_of"])
+
+	testApp.Do(http.MethodPost, refundPath, nil, apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusConflict)
+
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brenoID, "10", "10", brenoUSD))
+}
+
+func TestService_TransactionStatus(t *testing.T) {
+	testApp := newTestApp(t)
+
+	var transaction struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+
+	testApp.Do(http.MethodPost, "/me/transactions", map[string]interface{}{"target_user_id": brunoID, "amount": 5},
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&transaction)
+
+	require.Equal(t, "completed", transaction.Status)
+
+	transactionPath := "/me/transactions/" + transaction.ID
+
+	var details struct {
+		Status  string `json:"status"`
+		History []struct {
+			Status string `json:"status"`
+			Reason string `json:"reason"`
+		} `json:"history"`
+	}
+
+	testApp.Do(http.MethodPost, transactionPath+"/refund", nil, apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusOK)
+
+	// both parties can follow the status of the transaction
+	for _, user := range [][2]string{{"breno", "1234"}, {"bruno", "4321"}} {
+		testApp.Do(http.MethodGet, transactionPath, nil, apptest.WithBasicAuth(user[0], user[1])).
+			RequireStatus(http.StatusOK).
+			Decode(&details)
+
+		require.Equal(t, "reversed", details.Status)
+		require.Len(t, details.History, 3)
+		require.Equal(t, "pending", details.History[0].Status)
+		require.Equal(t, "completed", details.History[1].Status)
+		require.Equal(t, "reversed", details.History[2].Status)
+	}
+
+	testApp.Do(http.MethodPost, transactionPath+"/refund", nil, apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusConflict)
+
+	testApp.Do(http.MethodGet, "/me/transactions/"+uuid.New().String(), nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusNotFound)
+
+	testApp.Do(http.MethodGet, "/me/transactions/invalid", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusBadRequest)
+}
+
+func TestService_Holds(t *testing.T) {
+	testApp := newTestApp(t)
+
+	var hold struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+
+	testApp.Do(http.MethodPost, "/me/holds", map[string]interface{}{"target_user_id": brunoID, "amount": 6},
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&hold)
+
+	require.Equal(t, "active", hold.Status)
+
+	// the held funds are still part of the balance, but they can't be transferred
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brenoID, "10", "4", brenoUSD))
+
+	testApp.Do(http.MethodPost, "/me/transactions", map[string]interface{}{"target_user_id": brunoID, "amount": 5},
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusUnprocessableEntity)
+
+	holdPath := "/me/holds/" + hold.ID
+
+	testApp.Do(http.MethodPost, holdPath+"/capture", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusForbidden)
+
+	testApp.Do(http.MethodPost, holdPath+"/capture", map[string]interface{}{"amount": 7},
+		apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusBadRequest)
+
+	// what isn't captured is released
+	testApp.Do(http.MethodPost, holdPath+"/capture", map[string]interface{}{"amount": 2},
+		apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusOK).
+		Decode(&hold)
+
+	require.Equal(t, "captured", hold.Status)
+
+	testApp.Do(http.MethodPost, holdPath+"/void", nil, apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusConflict)
+
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brenoID, "8", "8", brenoUSD))
+
+	// a voided hold releases everything
+	testApp.Do(http.MethodPost, "/me/holds", map[string]interface{}{"target_user_id": brunoID, "amount": 8,
+		"ttl_seconds": 60}, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&hold)
+
+	testApp.Do(http.MethodPost, "/me/holds/"+hold.ID+"/void", nil, apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusOK)
+
+	testApp.Do(http.MethodGet, "/me/holds/"+hold.ID, nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&hold)
+
+	require.Equal(t, "voided", hold.Status)
+
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brenoID, "8", "8", brenoUSD))
+
+	// a hold that isn't captured in time is expired by the background job
+	testApp.Do(http.MethodPost, "/me/holds", map[string]interface{}{"target_user_id": brunoID, "amount": 3,
+		"ttl_seconds": 1}, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&hold)
+
+	testApp.RunJob("hold_expiration")
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brenoID, "8", "5", brenoUSD))
+
+	time.Sleep(time.Second)
+	testApp.RunJob("hold_expiration")
+
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brenoID, "8", "8", brenoUSD))
+
+	testApp.Do(http.MethodPost, "/me/holds/"+hold.ID+"/capture", nil, apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusConflict)
+}
+
+func TestService_ScheduledTransfers(t *testing.T) {
+	testApp := newTestApp(t)
+
+	type scheduledTransfer struct {
+		ID         string `json:"id"`
+		Status     string `json:"status"`
+		Attempts   int    `json:"attempts"`
+		Executions []struct {
+			Status        string `json:"status"`
+			TransactionID string `json:"transaction_id"`
+			ErrorCode     string `json:"error_code"`
+		} `json:"executions"`
+	}
+
+	startAt := time.Now().Add(300 * time.Millisecond)
+	schedule := func(amount float64, frequency string) scheduledTransfer {
+		var scheduled scheduledTransfer
+		testApp.Do(http.MethodPost, "/me/scheduled-transfers", map[string]interface{}{"target_user_id": brunoID,
+			"amount": amount, "start_at": startAt.Format(time.RFC3339Nano),
+			"recurrence": map[string]interface{}{"frequency": frequency}}, apptest.WithBasicAuth("breno", "1234")).
+			RequireStatus(http.StatusOK).
+			Decode(&scheduled)
+
+		require.Equal(t, "active", scheduled.Status)
+		return scheduled
+	}
+
+	once := schedule(2.5, "once")
+	daily := schedule(100, "daily")
+
+	testApp.Do(http.MethodPost, "/me/scheduled-transfers", map[string]interface{}{"target_user_id": brunoID,
+		"amount": 1, "start_at": time.Now().Add(-time.Minute).Format(time.RFC3339),
+		"recurrence": map[string]interface{}{"frequency": "once"}}, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusBadRequest)
+
+	var list struct {
+		ScheduledTransfers []scheduledTransfer `json:"scheduled_transfers"`
+	}
+
+	testApp.Do(http.MethodGet, "/me/scheduled-transfers", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&list)
+
+	require.Len(t, list.ScheduledTransfers, 2)
+
+	// nothing is due yet
+	testApp.RunJob("scheduled_transfers")
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brenoID, "10", "10", brenoUSD))
+
+	time.Sleep(time.Until(startAt))
+	testApp.RunJob("scheduled_transfers")
+
+	var found scheduledTransfer
+	testApp.Do(http.MethodGet, "/me/scheduled-transfers/"+once.ID, nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&found)
+
+	require.Equal(t, "completed", found.Status)
+	require.Len(t, found.Executions, 1)
+	require.Equal(t, "succeeded", found.Executions[0].Status)
+	require.NotEmpty(t, found.Executions[0].TransactionID)
+
+	// the transfer the balance can't cover is retried later
+	testApp.Do(http.MethodGet, "/me/scheduled-transfers/"+daily.ID, nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&found)
+
+	require.Equal(t, "active", found.Status)
+	require.Equal(t, 1, found.Attempts)
+	require.Len(t, found.Executions, 1)
+	require.Equal(t, "retrying", found.Executions[0].Status)
+	require.Equal(t, "insufficient_funds", found.Executions[0].ErrorCode)
+
+	// the failed attempt is a failed transaction, which moved no money
+	var failed struct {
+		Status        string `json:"status"`
+		FailureReason string `json:"failure_reason"`
+		History       []struct {
+			Status string `json:"status"`
+		} `json:"history"`
+	}
+
+	testApp.Do(http.MethodGet, "/me/transactions/"+found.Executions[0].TransactionID, nil,
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&failed)
+
+	require.Equal(t, "failed", failed.Status)
+	require.Equal(t, "insufficient balance for the transaction", failed.FailureReason)
+	require.Len(t, failed.History, 2)
+	require.Equal(t, "pending", failed.History[0].Status)
+	require.Equal(t, "failed", failed.History[1].Status)
+
+	// it's listed in the statement without a balance after it, the schedules were due at the same time so the order
+	// of their transactions isn't checked
+	var statement struct {
+		Transactions []map[string]interface{} `json:"transactions"`
+	}
+
+	testApp.Do(http.MethodGet, "/me/transactions", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&statement)
+
+	var entry map[string]interface{}
+	for _, transaction := range statement.Transactions {
+		if transaction["id"] == found.Executions[0].TransactionID {
+			entry = transaction
+		}
+	}
+
+	require.NotNil(t, entry)
+	require.Equal(t, "failed", entry["status"])
+	require.Contains(t, entry, "balance_after")
+	require.Nil(t, entry["balance_after"])
+
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brenoID, "7.5", "7.5", brenoUSD))
+
+	testApp.Do(http.MethodGet, "/me/scheduled-transfers/"+daily.ID, nil, apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusNotFound)
+
+	testApp.Do(http.MethodDelete, "/me/scheduled-transfers/"+daily.ID, nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&found)
+
+	require.Equal(t, "cancelled", found.Status)
+
+	testApp.Do(http.MethodDelete, "/me/scheduled-transfers/"+daily.ID, nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusConflict)
+}
+
+func TestService_Currencies(t *testing.T) {
+	testApp := newTestApp(t)
+
+	requireCode := func(response *apptest.Response, code string) {
+		var problem struct {
+			Code string `json:"code"`
+		}
+
+		response.Decode(&problem)
+		require.Equal(t, code, problem.Code)
+	}
+
+	var transaction struct {
+		Amount   float64 `json:"amount"`
+		Currency string  `json:"currency"`
+	}
+
+	testApp.Do(http.MethodPost, "/me/transactions",
+		map[string]interface{}{"target_user_id": brunoID, "amount": "2.50", "currency": "USD"},
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&transaction)
+
+	require.Equal(t, 2.5, transaction.Amount)
+	require.Equal(t, "USD", transaction.Currency)
+
+	// only the wallets in the currency of the transfer change
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brenoID, "10", "10", walletJSON("USD", "17.5", "17.5")))
+
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("bruno", "4321")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brunoID, "100", "100", walletJSON("USD", "7.5", "7.5")))
+
+	// the statement is filtered by amount in the minor units of a currency
+	var statement struct {
+		Transactions []struct {
+			Amount   float64 `json:"amount"`
+			Currency string  `json:"currency"`
+		} `json:"transactions"`
+	}
+
+	testApp.Do(http.MethodGet, "/me/transactions?currency=USD&min_amount=2.5", nil,
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&statement)
+
+	require.Len(t, statement.Transactions, 1)
+	require.Equal(t, 2.5, statement.Transactions[0].Amount)
+	require.Equal(t, "USD", statement.Transactions[0].Currency)
+
+	for query, code := range map[string]string{
+		"min_amount=2.5":                             "missing_currency",
+		"currency=XYZ&min_amount=2.5":                "invalid_currency",
+		"currency=JPY&min_amount=2.5":                "invalid_min_amount",
+		"currency=KWD&max_amount=0.0001":             "invalid_max_amount",
+		"currency=KWD&min_amount=2&max_amount=1.999": "invalid_amount_range",
+	} {
+		response := testApp.Do(http.MethodGet, "/me/transactions?"+query, nil, apptest.WithBasicAuth("breno", "1234")).
+			RequireStatus(http.StatusBadRequest)
+		requireCode(response, code)
+	}
+
+	testApp.Do(http.MethodGet, "/me/transactions?currency=KWD&min_amount=0.001", nil,
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		Decode(&statement)
+
+	require.Empty(t, statement.Transactions)
+
+	// both users should hold the currency
+	testApp.Do(http.MethodPost, "/me/wallets", map[string]string{"currency": "JPY"},
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(walletJSON("JPY", "0", "0"))
+
+	response := testApp.Do(http.MethodPost, "/me/wallets", map[string]string{"currency": "JPY"},
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusConflict)
+	requireCode(response, "wallet_exists")
+
+	response = testApp.Do(http.MethodPost, "/me/transactions",
+		map[string]interface{}{"target_user_id": brunoID, "amount": 100, "currency": "JPY"},
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusConflict)
+	requireCode(response, "currency_mismatch")
+
+	// amounts have at most the minor units of their currency
+	response = testApp.Do(http.MethodPost, "/me/transactions",
+		map[string]interface{}{"target_user_id": brunoID, "amount": 1.5, "currency": "JPY"},
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusBadRequest)
+	requireCode(response, "invalid_amount")
+
+	response = testApp.Do(http.MethodPost, "/me/transactions",
+		map[string]interface{}{"target_user_id": brunoID, "amount": 1, "currency": "XYZ"},
+		apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusBadRequest)
+	requireCode(response, "invalid_currency")
+
+	testApp.Do(http.MethodGet, "/me", nil, apptest.WithBasicAuth("breno", "1234")).
+		RequireStatus(http.StatusOK).
+		RequireJSON(meJSON(brenoID, "10", "10", walletJSON("JPY", "0", "0"), walletJSON("USD", "17.5", "17.5")))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"api-demo/app/internal/persistence/postgres"
+	"api-demo/pkg/app"
+	"api-demo/pkg/log"
+	"api-demo/pkg/migrate"
+)
+
+const migrateUsage = "usage: api-demo-service migrate up|down [steps]|status"
+
+// migrateCommand applies or rolls back the migrations of the schema, or prints their status
+func migrateCommand(ctx context.Context, resources app.SetupResourcesProvider, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	var steps int
+	switch {
+	case args[0] == "down" && len(args) == 2:
+		var err error
+		if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+			return fmt.Errorf("invalid steps %q, it should be a positive number", args[1])
+		}
+	case args[0] == "down" && len(args) == 1:
+		steps = 1
+	case len(args) > 1:
+		return errors.New(migrateUsage)
+	}
+
+	db, err := resources.WithPostgresConnection(app.DefaultPostgresProfile)
+	if err != nil {
+		return err
+	}
+
+	migrator, err := migrate.NewMigrator(db, postgres.Migrations)
+	if err != nil {
+		return err
+	}
+
+	logger := log.FromContext(ctx)
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			logger.WithField("version", migration.Version).WithField("name", migration.Name).Info("migration applied")
+		}
+
+		return err
+	case "down":
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, migration := range rolledBack {
+			logger.WithField("version", migration.Version).WithField("name", migration.Name).
+				Info("migration rolled back")
+		}
+
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "-"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", status.Version, status.Name, status.State, appliedAt)
+		}
+
+		return writer.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// seedCommand creates the demo users, it's meant for development databases only
+func seedCommand(ctx context.Context, resources app.SetupResourcesProvider, args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: api-demo-service seed")
+	}
+
+	db, err := resources.WithPostgresConnection(app.DefaultPostgresProfile)
+	if err != nil {
+		return err
+	}
+
+	if err := postgres.Seed(ctx, db); err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Info("database seeded")
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+
+	"api-demo/app/internal/persistence/postgres"
+	"api-demo/app/internal/service"
+	"api-demo/pkg/app"
+	"api-demo/pkg/log"
+	"api-demo/pkg/money"
+)
+
+const reverseUsage = "usage: api-demo-service reverse <transaction-id> [amount]"
+
+// reverseCommand reverses a transaction on behalf of the operators, the whole of it or the given amount. The reversal
+// is printed as JSON, along with the claim on the recipient when their balance went negative.
+func reverseCommand(ctx context.Context, resources app.SetupResourcesProvider, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New(reverseUsage)
+	}
+
+	transactionID, err := uuid.Parse(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid transaction ID %q", args[0])
+	}
+
+	db, err := resources.WithPostgresConnection(app.DefaultPostgresProfile)
+	if err != nil {
+		return err
+	}
+
+	repo := postgres.NewAccountRepository(db)
+
+	var amount *money.Amount
+	if len(args) == 2 {
+		// the amount is in the currency of the transaction
+		transaction, err := repo.FindTransactionByID(ctx, transactionID)
+		if err != nil {
+			return err
+		}
+
+		parsed, err := transaction.Currency.Parse(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid amount %q in %s: %v", args[1], transaction.Currency, err)
+		}
+
+		amount = &parsed
+	}
+
+	reversal, err := service.NewAccount(repo).ReverseTransaction(ctx, transactionID, amount)
+	if err != nil {
+		return err
+	}
+
+	logger := log.FromContext(ctx).WithField("transaction_id", transactionID).
+		WithField("reversal_id", reversal.Transaction.ID)
+	if reversal.Claim != nil {
+		claim := money.Money{Amount: reversal.Claim.Amount, Currency: reversal.Claim.Currency}
+		logger.WithField("claim", claim.String()).Warn("the balance of the recipient went negative")
+	}
+
+	logger.Info("transaction reversed")
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(reversal)
+}
@@ -4,25 +4,62 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 
 	"api-demo/app/internal/service"
 	customhttp "api-demo/pkg/http"
+	"api-demo/pkg/money"
 )
 
+// idempotencyKeyHeader is the header clients use to make retries of a request safe
+const idempotencyKeyHeader = "Idempotency-Key"
+
 // AccountService abstracts the services that should be provided to the HTTP API
 type AccountService interface {
 
-	// CreateTransaction creates a transaction to transfer amount from sourceUserID to targetUserID
-	CreateTransaction(ctx context.Context, sourceUserID uuid.UUID, targetUserID uuid.UUID, amount float64) (*service.Transaction, error)
+	// CreateTransaction creates a transaction to transfer amount in the currency from sourceUserID to targetUserID
+	CreateTransaction(ctx context.Context, sourceUserID uuid.UUID, targetUserID uuid.UUID, amount money.Amount,
+		currency money.Currency) (*service.Transaction, error)
+
+	// CreateIdempotentTransaction creates a transaction at most once per idempotency key, returning the stored
+	// response for the key in case it was already used
+	CreateIdempotentTransaction(ctx context.Context, idempotencyKey string, sourceUserID uuid.UUID,
+		targetUserID uuid.UUID, amount money.Amount, currency money.Currency,
+		render service.ResponseRenderer) (*service.IdempotencyRecord, error)
+
+	// RefundTransaction sends back to its source user an amount of a transaction received by userID, or what's left
+	// to be refunded when amount is nil
+	RefundTransaction(ctx context.Context, userID uuid.UUID, transactionID uuid.UUID, amount *money.Amount) (*service.Transaction, error)
+
+	// GetTransaction retrieves a transaction sent or received by userID along with its status history
+	GetTransaction(ctx context.Context, userID uuid.UUID, transactionID uuid.UUID) (*service.TransactionDetails, error)
+
+	// GetBalances retrieves the current balance of every wallet of the user and what's available of it, not reserved
+	// by holds
+	GetBalances(ctx context.Context, userID uuid.UUID) ([]service.Balance, error)
+
+	// OpenWallet opens an empty wallet in the currency for the user
+	OpenWallet(ctx context.Context, userID uuid.UUID, currency money.Currency) (*service.Balance, error)
 
-	// GetBalance retrieves the balance of the user
-	GetBalance(ctx context.Context, userID uuid.UUID) (float64, error)
+	// PlaceHold reserves an amount of the balance of userID in the currency to be captured by targetUserID within the
+	// TTL
+	PlaceHold(ctx context.Context, userID uuid.UUID, targetUserID uuid.UUID, amount money.Amount,
+		currency money.Currency, ttl time.Duration) (*service.Hold, error)
 
-	// ListTransactions list all the transaction from a certain User
-	ListTransactions(ctx context.Context, userID uuid.UUID) ([]service.Transaction, error)
+	// GetHold retrieves a hold placed by or targeting userID
+	GetHold(ctx context.Context, userID uuid.UUID, holdID uuid.UUID) (*service.Hold, error)
+
+	// CaptureHold transfers an amount of a hold targeting userID, or the whole held amount when amount is nil
+	CaptureHold(ctx context.Context, userID uuid.UUID, holdID uuid.UUID, amount *money.Amount) (*service.Hold, error)
+
+	// VoidHold releases the funds reserved by a hold targeting userID
+	VoidHold(ctx context.Context, userID uuid.UUID, holdID uuid.UUID) (*service.Hold, error)
+
+	// ListTransactions lists a page of the transactions from a certain User that match the filter
+	ListTransactions(ctx context.Context, userID uuid.UUID, filter service.TransactionFilter) (*service.TransactionPage, error)
 }
 
 type Account struct {
@@ -36,64 +73,240 @@ func NewAccount(accountService AccountService, authWrapper *AuthWrapper) *Accoun
 
 func (d *Account) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/me", d.authWrapper.WithAuth(d.getBalance)).Methods(http.MethodGet)
+	router.HandleFunc("/me/wallets", d.authWrapper.WithAuth(d.openWallet)).Methods(http.MethodPost)
 	router.HandleFunc("/me/transactions", d.authWrapper.WithAuth(d.listTransactions)).Methods(http.MethodGet)
 	router.HandleFunc("/me/transactions", d.authWrapper.WithAuth(d.createTransaction)).Methods(http.MethodPost)
+	router.HandleFunc("/me/transactions/{id}", d.authWrapper.WithAuth(d.getTransaction)).Methods(http.MethodGet)
+	router.HandleFunc("/me/transactions/{id}/refund", d.authWrapper.WithAuth(d.refundTransaction)).
+		Methods(http.MethodPost)
+	router.HandleFunc("/me/holds", d.authWrapper.WithAuth(d.placeHold)).Methods(http.MethodPost)
+	router.HandleFunc("/me/holds/{id}", d.authWrapper.WithAuth(d.getHold)).Methods(http.MethodGet)
+	router.HandleFunc("/me/holds/{id}/capture", d.authWrapper.WithAuth(d.captureHold)).Methods(http.MethodPost)
+	router.HandleFunc("/me/holds/{id}/void", d.authWrapper.WithAuth(d.voidHold)).Methods(http.MethodPost)
+}
+
+// balanceResponse is the balance of a wallet, the available balance leaves out what's reserved by holds
+type balanceResponse struct {
+	Currency         money.Currency `json:"currency"`
+	Balance          money.Money    `json:"balance"`
+	AvailableBalance money.Money    `json:"available_balance"`
+}
+
+func newBalanceResponse(balance service.Balance) balanceResponse {
+	return balanceResponse{
+		Currency:         balance.Currency,
+		Balance:          money.Money{Amount: balance.Current, Currency: balance.Currency},
+		AvailableBalance: money.Money{Amount: balance.Available, Currency: balance.Currency},
+	}
 }
 
 func (d *Account) getBalance(w http.ResponseWriter, r *http.Request, user *service.User) {
 
-	balance, err := d.accountService.GetBalance(r.Context(), user.ID)
+	balances, err := d.accountService.GetBalances(r.Context(), user.ID)
 	if err != nil {
-		customhttp.WriteError(w, err, http.StatusBadRequest)
+		customhttp.WriteError(w, r, err)
 		return
 	}
 
+	// the top-level balances are the ones in the default currency, kept for the clients from before the wallets
 	getBalanceResponse := struct {
-		UserID  uuid.UUID `json:"user_id"`
-		Balance float64   `json:"balance"`
+		UserID           uuid.UUID         `json:"user_id"`
+		Balance          money.Money       `json:"balance"`
+		AvailableBalance money.Money       `json:"available_balance"`
+		Balances         []balanceResponse `json:"balances"`
 	}{
-		user.ID, balance,
+		UserID:           user.ID,
+		Balance:          money.Money{Currency: service.DefaultCurrency},
+		AvailableBalance: money.Money{Currency: service.DefaultCurrency},
+		Balances:         make([]balanceResponse, 0, len(balances)),
+	}
+
+	for _, balance := range balances {
+		response := newBalanceResponse(balance)
+		if balance.Currency == service.DefaultCurrency {
+			getBalanceResponse.Balance = response.Balance
+			getBalanceResponse.AvailableBalance = response.AvailableBalance
+		}
+
+		getBalanceResponse.Balances = append(getBalanceResponse.Balances, response)
+	}
+
+	customhttp.WriteJSON(w, r, getBalanceResponse)
+}
+
+func (d *Account) openWallet(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	var openWalletRequest struct {
+		Currency money.Currency `json:"currency"`
 	}
 
-	customhttp.WriteJSON(w, getBalanceResponse)
+	if err := decodeJSONBody(r, &openWalletRequest); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	balance, err := d.accountService.OpenWallet(r.Context(), user.ID, openWalletRequest.Currency)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, newBalanceResponse(*balance))
 }
 
 func (d *Account) listTransactions(w http.ResponseWriter, r *http.Request, user *service.User) {
 
-	transactions, err := d.accountService.ListTransactions(r.Context(), user.ID)
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	page, err := d.accountService.ListTransactions(r.Context(), user.ID, filter)
 	if err != nil {
-		customhttp.WriteError(w, err, http.StatusBadRequest)
+		customhttp.WriteError(w, r, err)
 		return
 	}
 
 	listTransactionsResponse := struct {
-		UserID       uuid.UUID             `json:"user_id"`
-		Transactions []service.Transaction `json:"transactions"`
+		UserID       uuid.UUID                `json:"user_id"`
+		Transactions []service.StatementEntry `json:"transactions"`
+		NextCursor   string                   `json:"next_cursor,omitempty"`
 	}{
-		user.ID, transactions,
+		user.ID, page.Transactions, page.NextCursor,
 	}
 
-	customhttp.WriteJSON(w, listTransactionsResponse)
+	customhttp.WriteJSON(w, r, listTransactionsResponse)
 }
 
 func (d *Account) createTransaction(w http.ResponseWriter, r *http.Request, user *service.User) {
 
 	var createTransactionRequest struct {
-		TargetUserID uuid.UUID `json:"target_user_id"`
-		Amount       float64   `json:"amount"`
+		TargetUserID uuid.UUID     `json:"target_user_id"`
+		Amount       money.Decimal `json:"amount"`
+		Currency     string        `json:"currency"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&createTransactionRequest); err != nil {
-		customhttp.WriteError(w, err, http.StatusBadRequest)
+	if err := decodeJSONBody(r, &createTransactionRequest); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	amount, err := parseMoney(createTransactionRequest.Amount, createTransactionRequest.Currency)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	if idempotencyKey := r.Header.Get(idempotencyKeyHeader); idempotencyKey != "" {
+		d.createIdempotentTransaction(w, r, user, idempotencyKey, createTransactionRequest.TargetUserID, amount)
 		return
 	}
 
 	transaction, err := d.accountService.CreateTransaction(r.Context(), user.ID, createTransactionRequest.TargetUserID,
-		createTransactionRequest.Amount)
+		amount.Amount, amount.Currency)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, transaction)
+}
+
+// createIdempotentTransaction creates a transaction honouring the Idempotency-Key header, replaying the stored response
+// when the key was already used for the same payload
+func (d *Account) createIdempotentTransaction(w http.ResponseWriter, r *http.Request, user *service.User,
+	idempotencyKey string, targetUserID uuid.UUID, amount money.Money) {
+
+	render := func(transaction *service.Transaction) (int, []byte, error) {
+		body, err := json.Marshal(transaction)
+		return http.StatusOK, body, err
+	}
+
+	record, err := d.accountService.CreateIdempotentTransaction(r.Context(), idempotencyKey, user.ID, targetUserID,
+		amount.Amount, amount.Currency, render)
+
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	if record.Replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+
+	customhttp.WriteRawJSON(w, r, record.ResponseStatus, record.ResponseBody)
+}
+
+func (d *Account) getTransaction(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	transactionID, err := transactionIDFromPath(r)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	details, err := d.accountService.GetTransaction(r.Context(), user.ID, transactionID)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, details)
+}
+
+func (d *Account) refundTransaction(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	transactionID, err := transactionIDFromPath(r)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	// the body is optional, the whole transaction is refunded when there's no amount
+	var refundTransactionRequest struct {
+		Amount *money.Decimal `json:"amount"`
+	}
+
+	if err := decodeOptionalJSONBody(r, &refundTransactionRequest); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	var amount *money.Amount
+	if refundTransactionRequest.Amount != nil {
+		// the amount is in the currency of the transaction, which is only looked up to parse it
+		details, err := d.accountService.GetTransaction(r.Context(), user.ID, transactionID)
+		if err != nil {
+			customhttp.WriteError(w, r, err)
+			return
+		}
+
+		parsed, err := parseAmountIn(*refundTransactionRequest.Amount, details.Currency)
+		if err != nil {
+			customhttp.WriteError(w, r, err)
+			return
+		}
+
+		amount = &parsed
+	}
+
+	refund, err := d.accountService.RefundTransaction(r.Context(), user.ID, transactionID, amount)
 	if err != nil {
-		customhttp.WriteError(w, err, http.StatusBadRequest)
+		customhttp.WriteError(w, r, err)
 		return
 	}
 
-	customhttp.WriteJSON(w, transaction)
+	customhttp.WriteJSON(w, r, refund)
+}
+
+// transactionIDFromPath parses the ID of the transaction in the path of the request
+func transactionIDFromPath(r *http.Request) (uuid.UUID, error) {
+	transactionID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return uuid.Nil, service.NewFieldError("id", "invalid_transaction_id", "invalid transaction ID %q",
+			mux.Vars(r)["id"])
+	}
+
+	return transactionID, nil
 }
@@ -3,10 +3,11 @@ package httpapi
 import (
 	"context"
 	"encoding/base64"
-	"errors"
-	"fmt"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
 
 	"api-demo/app/internal/service"
 	customhttp "api-demo/pkg/http"
@@ -17,16 +18,54 @@ type AuthenticationService interface {
 
 	// Authenticate returns a user that matches the userName and password combination
 	Authenticate(ctx context.Context, userName string, password string) (*service.User, error)
+
+	// AuthenticateToken returns the user that owns the access token
+	AuthenticateToken(ctx context.Context, accessToken string) (*service.User, error)
+
+	// Login authenticates a user by its credentials, issuing a pair of tokens
+	Login(ctx context.Context, userName string, password string) (*service.TokenPair, error)
+
+	// Refresh exchanges a refresh token for a new pair of tokens
+	Refresh(ctx context.Context, refreshToken string) (*service.TokenPair, error)
+
+	// Logout revokes every token of the session the access token belongs to
+	Logout(ctx context.Context, accessToken string) error
 }
 
+var (
+	// errMissingAuthorization is returned when a request has no Authorization header
+	errMissingAuthorization = service.NewError(service.KindUnauthorized, "missing_authorization",
+		"no authorization provided")
+
+	// errInvalidAuthorization is returned when the Authorization header isn't in the "<scheme> <credentials>" format
+	errInvalidAuthorization = service.NewError(service.KindUnauthorized, "invalid_authorization",
+		"invalid authorization header provided")
+)
+
 // AuthWrapper wraps a decorated http.HandlerFunc (that receives a user) to a normal one, inspecting the request
 // looking for user credentials
 type AuthWrapper struct {
 	authService AuthenticationService
+	basicAuth   bool
 }
 
-func NewAuthWrapper(authService AuthenticationService) *AuthWrapper {
-	return &AuthWrapper{authService: authService}
+// AuthWrapperOpt is an option that can be passed to NewAuthWrapper to configure the wrapper
+type AuthWrapperOpt func(*AuthWrapper)
+
+// WithBasicAuth returns an AuthWrapperOpt that also accepts credentials sent with the Basic scheme, besides Bearer tokens
+func WithBasicAuth() AuthWrapperOpt {
+	return func(wrapper *AuthWrapper) {
+		wrapper.basicAuth = true
+	}
+}
+
+func NewAuthWrapper(authService AuthenticationService, opts ...AuthWrapperOpt) *AuthWrapper {
+	wrapper := &AuthWrapper{authService: authService}
+	for _, opt := range opts {
+		opt(wrapper)
+	}
+
+	return wrapper
 }
 
 // WithAuth wraps the given function to a normal http.HandlerFunc
@@ -35,51 +74,31 @@ func (wrapper *AuthWrapper) WithAuth(f func(w http.ResponseWriter, r *http.Reque
 
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			customhttp.WriteError(w,
-				errors.New("no authorization provided"),
-				http.StatusUnauthorized)
+			customhttp.WriteError(w, r, errMissingAuthorization)
 			return
 		}
 
 		splitAuthHeader := strings.Split(authHeader, " ")
-		if len(splitAuthHeader) != 2 || splitAuthHeader[0] != "Basic" {
-			customhttp.WriteError(w,
-				errors.New("invalid authorization header provided"),
-				http.StatusUnauthorized)
+		if len(splitAuthHeader) != 2 {
+			customhttp.WriteError(w, r, errInvalidAuthorization)
 			return
 		}
 
-		digest, err := base64.StdEncoding.DecodeString(splitAuthHeader[1])
-		if err != nil {
-			customhttp.WriteError(w,
-				errors.New("failed to decode base64 basic auth content"),
-				http.StatusUnauthorized)
-			return
-		}
+		var user *service.User
+		var err error
 
-		authContent := strings.Split(string(digest), ":")
-		if len(authContent) != 2 {
-			customhttp.WriteError(w,
-				errors.New("invalid format for username:password"),
-				http.StatusUnauthorized)
-			return
+		switch {
+		case splitAuthHeader[0] == "Bearer":
+			user, err = wrapper.authService.AuthenticateToken(r.Context(), splitAuthHeader[1])
+		case splitAuthHeader[0] == "Basic" && wrapper.basicAuth:
+			user, err = wrapper.authenticateBasic(r.Context(), splitAuthHeader[1])
+		default:
+			err = service.NewError(service.KindUnauthorized, "unsupported_authorization_scheme",
+				"unsupported authorization scheme %q", splitAuthHeader[0])
 		}
 
-		userName := authContent[0]
-		password := authContent[1]
-
-		if userName == "" || password == "" {
-			customhttp.WriteError(w,
-				errors.New("credentials not provided, please provide username and password in the query params"),
-				http.StatusUnauthorized)
-			return
-		}
-
-		user, err := wrapper.authService.Authenticate(r.Context(), userName, password)
 		if err != nil {
-			customhttp.WriteError(w,
-				fmt.Errorf("invalid credentials for user, error: %v", err),
-				http.StatusUnauthorized)
+			customhttp.WriteError(w, r, err)
 			return
 		}
 
@@ -87,3 +106,131 @@ func (wrapper *AuthWrapper) WithAuth(f func(w http.ResponseWriter, r *http.Reque
 		f(w, r, user)
 	}
 }
+
+// authenticateBasic authenticates the username:password credentials of the Basic scheme
+func (wrapper *AuthWrapper) authenticateBasic(ctx context.Context, credentials string) (*service.User, error) {
+
+	digest, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return nil, service.NewError(service.KindUnauthorized, "invalid_authorization",
+			"failed to decode base64 basic auth content")
+	}
+
+	authContent := strings.SplitN(string(digest), ":", 2)
+	if len(authContent) != 2 {
+		return nil, service.NewError(service.KindUnauthorized, "invalid_authorization",
+			"invalid format for username:password")
+	}
+
+	userName := authContent[0]
+	password := authContent[1]
+
+	if userName == "" || password == "" {
+		return nil, service.NewError(service.KindUnauthorized, "invalid_authorization",
+			"credentials not provided, please provide username and password")
+	}
+
+	return wrapper.authService.Authenticate(ctx, userName, password)
+}
+
+// bearerToken extracts the token from the Authorization header of a request using the Bearer scheme
+func bearerToken(r *http.Request) (string, error) {
+	splitAuthHeader := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(splitAuthHeader) != 2 || splitAuthHeader[0] != "Bearer" || splitAuthHeader[1] == "" {
+		return "", service.NewError(service.KindUnauthorized, "invalid_authorization",
+			"a Bearer token should be provided")
+	}
+
+	return splitAuthHeader[1], nil
+}
+
+// Auth is the API that issues and revokes tokens
+type Auth struct {
+	authService AuthenticationService
+}
+
+func NewAuth(authService AuthenticationService) *Auth {
+	return &Auth{authService: authService}
+}
+
+func (d *Auth) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/auth/login", d.login).Methods(http.MethodPost)
+	router.HandleFunc("/auth/refresh", d.refresh).Methods(http.MethodPost)
+	router.HandleFunc("/auth/logout", d.logout).Methods(http.MethodPost)
+}
+
+func (d *Auth) login(w http.ResponseWriter, r *http.Request) {
+
+	var loginRequest struct {
+		UserName string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	if err := decodeJSONBody(r, &loginRequest); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	pair, err := d.authService.Login(r.Context(), loginRequest.UserName, loginRequest.Password)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, newTokenResponse(pair))
+}
+
+func (d *Auth) refresh(w http.ResponseWriter, r *http.Request) {
+
+	var refreshRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := decodeJSONBody(r, &refreshRequest); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	pair, err := d.authService.Refresh(r.Context(), refreshRequest.RefreshToken)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, newTokenResponse(pair))
+}
+
+func (d *Auth) logout(w http.ResponseWriter, r *http.Request) {
+
+	token, err := bearerToken(r)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	if err := d.authService.Logout(r.Context(), token); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tokenResponse is the representation of a pair of tokens, following the OAuth 2.0 token response
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
+
+func newTokenResponse(pair *service.TokenPair) tokenResponse {
+	return tokenResponse{
+		AccessToken:      pair.AccessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(time.Until(pair.AccessTokenExpiresAt).Seconds()),
+		RefreshToken:     pair.RefreshToken,
+		RefreshExpiresIn: int64(time.Until(pair.RefreshTokenExpiresAt).Seconds()),
+	}
+}
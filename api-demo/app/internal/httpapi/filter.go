@@ -0,0 +1,108 @@
+package httpapi
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+// parseTransactionFilter parses the query parameters accepted when listing transactions, reporting every invalid one
+func parseTransactionFilter(query url.Values) (service.TransactionFilter, error) {
+	var filter service.TransactionFilter
+	var errs []*service.Error
+
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil {
+			errs = append(errs, service.NewFieldError("limit", "invalid_limit", "invalid limit %q", limit))
+		}
+		filter.Limit = parsed
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		parsed, err := service.ParseTransactionCursor(cursor)
+		if err != nil {
+			errs = append(errs, service.ErrInvalidCursor)
+		}
+		filter.After = parsed
+	}
+
+	var err *service.Error
+	if filter.From, err = parseTime(query, "from"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if filter.To, err = parseTime(query, "to"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if code := query.Get("currency"); code != "" {
+		currency, err := money.ParseCurrency(code)
+		if err != nil {
+			errs = append(errs, service.NewFieldError("currency", "invalid_currency", "%v", err))
+		}
+		filter.Currency = currency
+	}
+
+	// the amounts are parsed in the minor units of the currency, without one they're only checked to be set
+	if filter.MinAmount, err = parseAmount(query, "min_amount", filter.Currency); err != nil {
+		errs = append(errs, err)
+	}
+
+	if filter.MaxAmount, err = parseAmount(query, "max_amount", filter.Currency); err != nil {
+		errs = append(errs, err)
+	}
+
+	if counterpartyID := query.Get("counterparty_id"); counterpartyID != "" {
+		parsed, err := uuid.Parse(counterpartyID)
+		if err != nil {
+			errs = append(errs, service.NewFieldError("counterparty_id", "invalid_counterparty_id",
+				"invalid counterparty_id %q, it should be a UUID", counterpartyID))
+		}
+		filter.CounterpartyID = parsed
+	}
+
+	filter.Direction = service.Direction(query.Get("direction"))
+
+	return filter, service.JoinFieldErrors(errs...)
+}
+
+// parseTime parses an optional RFC 3339 time from the query parameter
+func parseTime(query url.Values, param string) (time.Time, *service.Error) {
+	value := query.Get(param)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, service.NewFieldError(param, "invalid_"+param,
+			"invalid %s %q, it should be a RFC 3339 time", param, value)
+	}
+
+	return parsed, nil
+}
+
+// parseAmount parses an optional amount of the currency from the query parameter
+func parseAmount(query url.Values, param string, currency money.Currency) (*money.Amount, *service.Error) {
+	value := query.Get(param)
+	if value == "" {
+		return nil, nil
+	}
+
+	if currency == "" {
+		return new(money.Amount), nil
+	}
+
+	parsed, err := currency.Parse(value)
+	if err != nil {
+		return nil, service.NewFieldError(param, "invalid_"+param, "invalid %s: %v", param, err)
+	}
+
+	return &parsed, nil
+}
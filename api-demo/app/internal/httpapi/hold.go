@@ -0,0 +1,138 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"api-demo/app/internal/service"
+	customhttp "api-demo/pkg/http"
+	"api-demo/pkg/money"
+)
+
+func (d *Account) placeHold(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	var placeHoldRequest struct {
+		TargetUserID uuid.UUID     `json:"target_user_id"`
+		Amount       money.Decimal `json:"amount"`
+		Currency     string        `json:"currency"`
+		TTLSeconds   int64         `json:"ttl_seconds"`
+	}
+
+	if err := decodeJSONBody(r, &placeHoldRequest); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	amount, err := parseMoney(placeHoldRequest.Amount, placeHoldRequest.Currency)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	// TTLs over the max are rejected by the service, they're clamped here only so they can't overflow a duration
+	seconds := placeHoldRequest.TTLSeconds
+	if max := int64(service.MaxHoldTTL / time.Second); seconds > max {
+		seconds = max + 1
+	}
+
+	hold, err := d.accountService.PlaceHold(r.Context(), user.ID, placeHoldRequest.TargetUserID, amount.Amount,
+		amount.Currency, time.Duration(seconds)*time.Second)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, hold)
+}
+
+func (d *Account) getHold(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	holdID, err := holdIDFromPath(r)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	hold, err := d.accountService.GetHold(r.Context(), user.ID, holdID)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, hold)
+}
+
+func (d *Account) captureHold(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	holdID, err := holdIDFromPath(r)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	// the body is optional, the whole hold is captured when there's no amount
+	var captureHoldRequest struct {
+		Amount *money.Decimal `json:"amount"`
+	}
+
+	if err := decodeOptionalJSONBody(r, &captureHoldRequest); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	var amount *money.Amount
+	if captureHoldRequest.Amount != nil {
+		// the amount is in the currency of the hold, which is only looked up to parse it
+		found, err := d.accountService.GetHold(r.Context(), user.ID, holdID)
+		if err != nil {
+			customhttp.WriteError(w, r, err)
+			return
+		}
+
+		parsed, err := parseAmountIn(*captureHoldRequest.Amount, found.Currency)
+		if err != nil {
+			customhttp.WriteError(w, r, err)
+			return
+		}
+
+		amount = &parsed
+	}
+
+	hold, err := d.accountService.CaptureHold(r.Context(), user.ID, holdID, amount)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, hold)
+}
+
+func (d *Account) voidHold(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	holdID, err := holdIDFromPath(r)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	hold, err := d.accountService.VoidHold(r.Context(), user.ID, holdID)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, hold)
+}
+
+// holdIDFromPath parses the ID of the hold in the path of the request
+func holdIDFromPath(r *http.Request) (uuid.UUID, error) {
+	holdID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return uuid.Nil, service.NewFieldError("id", "invalid_hold_id", "invalid hold ID %q", mux.Vars(r)["id"])
+	}
+
+	return holdID, nil
+}
@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+// decodeJSONBody decodes the JSON body of a request into v, failing with a validation error if it's malformed
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return service.NewError(service.KindValidation, "invalid_body", "invalid request body: %v", err)
+	}
+
+	return nil
+}
+
+// decodeOptionalJSONBody decodes the JSON body of a request into v like decodeJSONBody, leaving v untouched when the
+// request has no body
+func decodeOptionalJSONBody(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == io.EOF {
+		return nil
+	}
+
+	if err != nil {
+		return service.NewError(service.KindValidation, "invalid_body", "invalid request body: %v", err)
+	}
+
+	return nil
+}
+
+// parseMoney parses the amount and the ISO 4217 currency code of a request, requests without a currency are in
+// service.DefaultCurrency
+func parseMoney(amount money.Decimal, code string) (money.Money, error) {
+	currency := service.DefaultCurrency
+	if code != "" {
+		var err error
+		if currency, err = money.ParseCurrency(code); err != nil {
+			return money.Money{}, service.NewFieldError("currency", "invalid_currency", "%v", err)
+		}
+	}
+
+	parsed, err := parseAmountIn(amount, currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	return money.Money{Amount: parsed, Currency: currency}, nil
+}
+
+// parseAmountIn parses the amount of a request in the currency, which allows as many fractional digits as its minor
+// units
+func parseAmountIn(amount money.Decimal, currency money.Currency) (money.Amount, error) {
+	parsed, err := currency.Parse(string(amount))
+	if err != nil {
+		return 0, service.NewFieldError("amount", "invalid_amount", "invalid amount in %s: %v", currency, err)
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"api-demo/app/internal/service"
+	customhttp "api-demo/pkg/http"
+	"api-demo/pkg/money"
+)
+
+// ScheduledTransfersService abstracts the services to schedule transfers for later and manage them
+type ScheduledTransfersService interface {
+
+	// Schedule schedules a transfer in the currency to the target user, run from start on according to the recurrence
+	Schedule(ctx context.Context, userID uuid.UUID, targetUserID uuid.UUID, amount money.Amount,
+		currency money.Currency, start time.Time, recurrence service.Recurrence) (*service.ScheduledTransfer, error)
+
+	// List lists the transfers scheduled by the user
+	List(ctx context.Context, userID uuid.UUID) ([]service.ScheduledTransfer, error)
+
+	// Get retrieves a transfer scheduled by the user along with its execution history
+	Get(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (*service.ScheduledTransferDetails, error)
+
+	// Cancel cancels the runs ahead of a transfer scheduled by the user
+	Cancel(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (*service.ScheduledTransfer, error)
+}
+
+type ScheduledTransfers struct {
+	scheduledService ScheduledTransfersService
+	authWrapper      *AuthWrapper
+}
+
+func NewScheduledTransfers(scheduledService ScheduledTransfersService, authWrapper *AuthWrapper) *ScheduledTransfers {
+	return &ScheduledTransfers{scheduledService: scheduledService, authWrapper: authWrapper}
+}
+
+func (d *ScheduledTransfers) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/me/scheduled-transfers", d.authWrapper.WithAuth(d.schedule)).Methods(http.MethodPost)
+	router.HandleFunc("/me/scheduled-transfers", d.authWrapper.WithAuth(d.list)).Methods(http.MethodGet)
+	router.HandleFunc("/me/scheduled-transfers/{id}", d.authWrapper.WithAuth(d.get)).Methods(http.MethodGet)
+	router.HandleFunc("/me/scheduled-transfers/{id}", d.authWrapper.WithAuth(d.cancel)).Methods(http.MethodDelete)
+}
+
+func (d *ScheduledTransfers) schedule(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	var scheduleRequest struct {
+		TargetUserID uuid.UUID          `json:"target_user_id"`
+		Amount       money.Decimal      `json:"amount"`
+		Currency     string             `json:"currency"`
+		StartAt      time.Time          `json:"start_at"`
+		Recurrence   service.Recurrence `json:"recurrence"`
+	}
+
+	if err := decodeJSONBody(r, &scheduleRequest); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	amount, err := parseMoney(scheduleRequest.Amount, scheduleRequest.Currency)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	schedule, err := d.scheduledService.Schedule(r.Context(), user.ID, scheduleRequest.TargetUserID, amount.Amount,
+		amount.Currency, scheduleRequest.StartAt, scheduleRequest.Recurrence)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, schedule)
+}
+
+func (d *ScheduledTransfers) list(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	schedules, err := d.scheduledService.List(r.Context(), user.ID)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	listResponse := struct {
+		UserID             uuid.UUID                   `json:"user_id"`
+		ScheduledTransfers []service.ScheduledTransfer `json:"scheduled_transfers"`
+	}{
+		user.ID, schedules,
+	}
+
+	customhttp.WriteJSON(w, r, listResponse)
+}
+
+func (d *ScheduledTransfers) get(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	scheduleID, err := scheduleIDFromPath(r)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	details, err := d.scheduledService.Get(r.Context(), user.ID, scheduleID)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, details)
+}
+
+func (d *ScheduledTransfers) cancel(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	scheduleID, err := scheduleIDFromPath(r)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	schedule, err := d.scheduledService.Cancel(r.Context(), user.ID, scheduleID)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, schedule)
+}
+
+// scheduleIDFromPath parses the ID of the scheduled transfer in the path of the request
+func scheduleIDFromPath(r *http.Request) (uuid.UUID, error) {
+	scheduleID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return uuid.Nil, service.NewFieldError("id", "invalid_scheduled_transfer_id",
+			"invalid scheduled transfer ID %q", mux.Vars(r)["id"])
+	}
+
+	return scheduleID, nil
+}
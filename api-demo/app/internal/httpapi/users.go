@@ -0,0 +1,151 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"api-demo/app/internal/service"
+	customhttp "api-demo/pkg/http"
+)
+
+// UsersService abstracts the services to sign up users and manage their profiles
+type UsersService interface {
+
+	// Register signs up a new user
+	Register(ctx context.Context, registration service.Registration) (*service.User, error)
+
+	// GetProfile retrieves the user with its profile
+	GetProfile(ctx context.Context, userID uuid.UUID) (*service.User, error)
+
+	// UpdateProfile updates the profile fields that are set on the update
+	UpdateProfile(ctx context.Context, userID uuid.UUID, update service.ProfileUpdate) (*service.User, error)
+
+	// ChangePassword replaces the password of a user once the current one is confirmed
+	ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword string, newPassword string) error
+}
+
+type Users struct {
+	usersService UsersService
+	authWrapper  *AuthWrapper
+}
+
+func NewUsers(usersService UsersService, authWrapper *AuthWrapper) *Users {
+	return &Users{usersService: usersService, authWrapper: authWrapper}
+}
+
+func (d *Users) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/users", d.register).Methods(http.MethodPost)
+	router.HandleFunc("/me/profile", d.authWrapper.WithAuth(d.getProfile)).Methods(http.MethodGet)
+	router.HandleFunc("/me/profile", d.authWrapper.WithAuth(d.updateProfile)).Methods(http.MethodPatch)
+	router.HandleFunc("/me/password", d.authWrapper.WithAuth(d.changePassword)).Methods(http.MethodPut)
+}
+
+// profileResponse is the representation of the profile of a user
+type profileResponse struct {
+	ID          uuid.UUID `json:"id"`
+	UserName    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Email       string    `json:"email"`
+}
+
+func newProfileResponse(user *service.User) profileResponse {
+	return profileResponse{
+		ID:          user.ID,
+		UserName:    user.UserName,
+		DisplayName: user.DisplayName,
+		Email:       user.Email,
+	}
+}
+
+func (d *Users) register(w http.ResponseWriter, r *http.Request) {
+
+	var registerRequest struct {
+		UserName    string `json:"username"`
+		Password    string `json:"password"`
+		DisplayName string `json:"display_name"`
+		Email       string `json:"email"`
+	}
+
+	if err := decodeJSONBody(r, &registerRequest); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	user, err := d.usersService.Register(r.Context(), service.Registration{
+		UserName:    registerRequest.UserName,
+		Password:    registerRequest.Password,
+		DisplayName: registerRequest.DisplayName,
+		Email:       registerRequest.Email,
+	})
+
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, newProfileResponse(user))
+}
+
+func (d *Users) getProfile(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	profile, err := d.usersService.GetProfile(r.Context(), user.ID)
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, newProfileResponse(profile))
+}
+
+func (d *Users) updateProfile(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	var updateProfileRequest struct {
+		UserName    *string `json:"username"`
+		DisplayName *string `json:"display_name"`
+		Email       *string `json:"email"`
+	}
+
+	if err := decodeJSONBody(r, &updateProfileRequest); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	profile, err := d.usersService.UpdateProfile(r.Context(), user.ID, service.ProfileUpdate{
+		UserName:    updateProfileRequest.UserName,
+		DisplayName: updateProfileRequest.DisplayName,
+		Email:       updateProfileRequest.Email,
+	})
+
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	customhttp.WriteJSON(w, r, newProfileResponse(profile))
+}
+
+func (d *Users) changePassword(w http.ResponseWriter, r *http.Request, user *service.User) {
+
+	var changePasswordRequest struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	if err := decodeJSONBody(r, &changePasswordRequest); err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	err := d.usersService.ChangePassword(r.Context(), user.ID, changePasswordRequest.CurrentPassword,
+		changePasswordRequest.NewPassword)
+
+	if err != nil {
+		customhttp.WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"api-demo/app/internal/httpapi"
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+// Account decorates a httpapi.AccountService collecting metrics of the money it moves, labeled by the kind of
+// transfer. It's also a service.TransferObserver, so the scheduled transfers are counted along with the ones made
+// through the API. The reversals are made by the operators with a command, so they aren't counted.
+type Account struct {
+	httpapi.AccountService
+
+	transfers *prometheus.CounterVec
+	volume    *prometheus.CounterVec
+	failures  *prometheus.CounterVec
+}
+
+const (
+	// kindTransfer labels the transfers between users, scheduled or not
+	kindTransfer = "transfer"
+
+	// kindHoldCapture labels the captures of holds
+	kindHoldCapture = "hold_capture"
+
+	// kindRefund labels the refunds made by the recipients of transfers
+	kindRefund = "refund"
+)
+
+// NewAccount creates an Account decorating next, registering its metrics on the registerer
+func NewAccount(next httpapi.AccountService, registerer prometheus.Registerer) (*Account, error) {
+	a := &Account{
+		AccountService: next,
+		transfers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "transfers_created_total",
+			Help: "Number of transfers, hold captures and refunds made, by kind and whether they were requested " +
+				"with an idempotency key.",
+		}, []string{"kind", "idempotent"}),
+		volume: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "transfers_volume_total",
+			Help: "Sum of the amounts moved by transfers, hold captures and refunds, in major units of their currency.",
+		}, []string{"kind", "currency"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "transfer_failures_total",
+			Help: "Number of transfers, hold captures and refunds that failed, by kind and error code.",
+		}, []string{"kind", "code"}),
+	}
+
+	for _, collector := range []prometheus.Collector{a.transfers, a.volume, a.failures} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+func (a *Account) CreateTransaction(ctx context.Context, sourceUserID uuid.UUID, targetUserID uuid.UUID,
+	amount money.Amount, currency money.Currency) (*service.Transaction, error) {
+
+	transaction, err := a.AccountService.CreateTransaction(ctx, sourceUserID, targetUserID, amount, currency)
+	if err != nil {
+		a.TransferFailed(errorCode(err))
+		return nil, err
+	}
+
+	a.TransferCreated(amount, currency)
+	return transaction, nil
+}
+
+func (a *Account) CreateIdempotentTransaction(ctx context.Context, idempotencyKey string, sourceUserID uuid.UUID,
+	targetUserID uuid.UUID, amount money.Amount, currency money.Currency,
+	render service.ResponseRenderer) (*service.IdempotencyRecord, error) {
+
+	record, err := a.AccountService.CreateIdempotentTransaction(ctx, idempotencyKey, sourceUserID, targetUserID,
+		amount, currency, render)
+	if err != nil {
+		a.TransferFailed(errorCode(err))
+		return nil, err
+	}
+
+	// replayed responses didn't move money again
+	if !record.Replayed {
+		a.moved(kindTransfer, true, amount, currency)
+	}
+
+	return record, nil
+}
+
+func (a *Account) CaptureHold(ctx context.Context, userID uuid.UUID, holdID uuid.UUID,
+	amount *money.Amount) (*service.Hold, error) {
+
+	hold, err := a.AccountService.CaptureHold(ctx, userID, holdID, amount)
+	if err != nil {
+		a.failures.WithLabelValues(kindHoldCapture, errorCode(err)).Inc()
+		return nil, err
+	}
+
+	a.moved(kindHoldCapture, false, hold.CapturedAmount, hold.Currency)
+	return hold, nil
+}
+
+func (a *Account) RefundTransaction(ctx context.Context, userID uuid.UUID, transactionID uuid.UUID,
+	amount *money.Amount) (*service.Transaction, error) {
+
+	refund, err := a.AccountService.RefundTransaction(ctx, userID, transactionID, amount)
+	if err != nil {
+		a.failures.WithLabelValues(kindRefund, errorCode(err)).Inc()
+		return nil, err
+	}
+
+	a.moved(kindRefund, false, refund.Amount, refund.Currency)
+	return refund, nil
+}
+
+// TransferCreated counts a transfer that wasn't requested with an idempotency key
+func (a *Account) TransferCreated(amount money.Amount, currency money.Currency) {
+	a.moved(kindTransfer, false, amount, currency)
+}
+
+// TransferFailed counts a transfer that failed with the error code
+func (a *Account) TransferFailed(code string) {
+	a.failures.WithLabelValues(kindTransfer, code).Inc()
+}
+
+// moved counts money moved by the kind of transfer, adding the amount to the volume of its currency, amounts in
+// different currencies can't be summed
+func (a *Account) moved(kind string, idempotent bool, amount money.Amount, currency money.Currency) {
+	a.transfers.WithLabelValues(kind, strconv.FormatBool(idempotent)).Inc()
+	a.volume.WithLabelValues(kind, string(currency)).Add(money.Money{Amount: amount, Currency: currency}.Float64())
+}
+
+// errorCode returns the code of a service.Error, errors of other types are internal
+func errorCode(err error) string {
+	var serviceErr *service.Error
+	if errors.As(err, &serviceErr) {
+		return serviceErr.Code
+	}
+
+	return string(service.KindInternal)
+}
@@ -0,0 +1,136 @@
+package metrics_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/app/internal/httpapi"
+	"api-demo/app/internal/metrics"
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+// accountServiceMock is a httpapi.AccountService that answers transfers, captures and refunds with the given results
+type accountServiceMock struct {
+	httpapi.AccountService
+	err      error
+	replayed bool
+}
+
+func (m *accountServiceMock) CreateTransaction(context.Context, uuid.UUID, uuid.UUID, money.Amount,
+	money.Currency) (*service.Transaction, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return &service.Transaction{}, nil
+}
+
+func (m *accountServiceMock) CreateIdempotentTransaction(context.Context, string, uuid.UUID, uuid.UUID, money.Amount,
+	money.Currency, service.ResponseRenderer) (*service.IdempotencyRecord, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return &service.IdempotencyRecord{Replayed: m.replayed}, nil
+}
+
+func (m *accountServiceMock) CaptureHold(_ context.Context, _ uuid.UUID, _ uuid.UUID,
+	amount *money.Amount) (*service.Hold, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return &service.Hold{Amount: 500, CapturedAmount: *amount, Currency: money.BRL}, nil
+}
+
+func (m *accountServiceMock) RefundTransaction(_ context.Context, _ uuid.UUID, _ uuid.UUID,
+	amount *money.Amount) (*service.Transaction, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return &service.Transaction{Amount: *amount, Currency: money.KWD}, nil
+}
+
+func TestAccount(t *testing.T) {
+
+	ctx := context.Background()
+	next := &accountServiceMock{}
+	registry := prometheus.NewRegistry()
+
+	account, err := metrics.NewAccount(next, registry)
+	require.NoError(t, err)
+
+	_, err = account.CreateTransaction(ctx, uuid.New(), uuid.New(), money.MustParse("10.50"), money.BRL)
+	require.NoError(t, err)
+
+	_, err = account.CreateIdempotentTransaction(ctx, "key", uuid.New(), uuid.New(), money.MustParse("2"), money.BRL,
+		nil)
+	require.NoError(t, err)
+
+	_, err = account.CreateTransaction(ctx, uuid.New(), uuid.New(), 1500, money.JPY)
+	require.NoError(t, err)
+
+	next.replayed = true
+	_, err = account.CreateIdempotentTransaction(ctx, "key", uuid.New(), uuid.New(), money.MustParse("2"), money.BRL,
+		nil)
+	require.NoError(t, err)
+
+	captured := money.MustParse("3")
+	_, err = account.CaptureHold(ctx, uuid.New(), uuid.New(), &captured)
+	require.NoError(t, err)
+
+	refunded := money.Amount(1500)
+	_, err = account.RefundTransaction(ctx, uuid.New(), uuid.New(), &refunded)
+	require.NoError(t, err)
+
+	next.err = service.ErrInsufficientFunds
+	_, err = account.CreateTransaction(ctx, uuid.New(), uuid.New(), money.MustParse("1000"), money.BRL)
+	require.Error(t, err)
+
+	_, err = account.RefundTransaction(ctx, uuid.New(), uuid.New(), &refunded)
+	require.Error(t, err)
+
+	next.err = service.ErrHoldNotFound
+	_, err = account.CaptureHold(ctx, uuid.New(), uuid.New(), nil)
+	require.Error(t, err)
+
+	// the scheduled transfers are reported by the service
+	var observer service.TransferObserver = account
+	observer.TransferCreated(money.MustParse("1"), money.BRL)
+	observer.TransferFailed("insufficient_funds")
+	observer.TransferFailed("internal")
+
+	expected := `
+# HELP transfer_failures_total Number of transfers, hold captures and refunds that failed, by kind and error code.
+# TYPE transfer_failures_total counter
+transfer_failures_total{code="hold_not_found",kind="hold_capture"} 1
+transfer_failures_total{code="insufficient_funds",kind="refund"} 1
+transfer_failures_total{code="insufficient_funds",kind="transfer"} 2
+transfer_failures_total{code="internal",kind="transfer"} 1
+# HELP transfers_created_total Number of transfers, hold captures and refunds made, by kind and whether they were requested with an idempotency key.
+# TYPE transfers_created_total counter
+transfers_created_total{idempotent="false",kind="hold_capture"} 1
+transfers_created_total{idempotent="false",kind="refund"} 1
+transfers_created_total{idempotent="false",kind="transfer"} 3
+transfers_created_total{idempotent="true",kind="transfer"} 1
+# HELP transfers_volume_total Sum of the amounts moved by transfers, hold captures and refunds, in major units of their currency.
+# TYPE transfers_volume_total counter
+transfers_volume_total{currency="BRL",kind="hold_capture"} 3
+transfers_volume_total{currency="BRL",kind="transfer"} 13.5
+transfers_volume_total{currency="JPY",kind="transfer"} 1500
+transfers_volume_total{currency="KWD",kind="refund"} 1.5
+`
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected)))
+
+	_, err = metrics.NewAccount(next, registry)
+	require.Error(t, err, "registering the metrics twice should conflict")
+}
@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+// LedgerRepository is a persistence repository for the ledger that keeps the data in memory
+type LedgerRepository struct {
+	db *DB
+}
+
+// NewLedgerRepository creates an in-memory repository for the ledger
+func NewLedgerRepository(db *DB) *LedgerRepository {
+	return &LedgerRepository{db: db}
+}
+
+func (repo *LedgerRepository) SumPostings(ctx context.Context) (map[money.Currency]money.Amount, error) {
+	release, err := repo.db.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	sums := map[money.Currency]money.Amount{}
+	for _, posting := range repo.db.postings {
+		sums[posting.Currency] += posting.Amount
+	}
+
+	return sums, nil
+}
+
+func (repo *LedgerRepository) ListUnbalancedEntries(ctx context.Context) ([]uuid.UUID, error) {
+	release, err := repo.db.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	// entries should sum to zero in each of their currencies, amounts in different currencies never offset each other
+	sums := map[ledgerAccount]money.Amount{}
+	var entries []uuid.UUID
+	for _, posting := range repo.db.postings {
+		key := ledgerAccount{id: posting.EntryID, currency: posting.Currency}
+		if _, ok := sums[key]; !ok {
+			entries = append(entries, posting.EntryID)
+		}
+
+		sums[key] += posting.Amount
+	}
+
+	unbalancedEntries := map[uuid.UUID]bool{}
+	for key, sum := range sums {
+		if sum != 0 {
+			unbalancedEntries[key.id] = true
+		}
+	}
+
+	var unbalanced []uuid.UUID
+	for _, entryID := range entries {
+		if unbalancedEntries[entryID] {
+			unbalanced = append(unbalanced, entryID)
+
+			// an entry unbalanced in many currencies is listed once
+			delete(unbalancedEntries, entryID)
+		}
+	}
+
+	return unbalanced, nil
+}
+
+func (repo *LedgerRepository) ListBalanceMismatches(ctx context.Context) ([]service.BalanceMismatch, error) {
+	release, err := repo.db.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	ledgerBalances := map[ledgerAccount]money.Amount{}
+	for _, posting := range repo.db.postings {
+		ledgerBalances[ledgerAccount{id: posting.AccountID, currency: posting.Currency}] += posting.Amount
+	}
+
+	var mismatches []service.BalanceMismatch
+	for _, wallet := range repo.db.wallets {
+		ledgerBalance := ledgerBalances[ledgerAccount{id: wallet.UserID, currency: wallet.Currency}]
+		if wallet.Balance != ledgerBalance {
+			mismatches = append(mismatches, service.BalanceMismatch{
+				UserID:        wallet.UserID,
+				Currency:      wallet.Currency,
+				CachedBalance: wallet.Balance,
+				LedgerBalance: ledgerBalance,
+			})
+		}
+	}
+
+	return mismatches, nil
+}
+
+// ledgerAccount identifies the postings of an account, or of an entry, in a currency
+type ledgerAccount struct {
+	id       uuid.UUID
+	currency money.Currency
+}
@@ -0,0 +1,1100 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+// DB keeps the data of the in-memory repositories, which can share it just like the Postgres ones share a database.
+// Transactions are serialized: a transaction holds the DB from its start to its end, so it's isolated from the others
+// as if every row was locked, and the operations made outside transactions wait for it.
+type DB struct {
+	// sem is a semaphore with a single slot, held by a transaction or by an operation outside transactions. Unlike a
+	// mutex it can be acquired honouring the cancellation of a context.
+	sem chan struct{}
+
+	users           map[uuid.UUID]service.User
+	userNames       map[string]uuid.UUID
+	wallets         map[walletKey]service.Wallet
+	transactions    []service.Transaction
+	postings        []service.Posting
+	claims          []service.Claim
+	statusChanges   []service.StatusChange
+	holds           map[uuid.UUID]service.Hold
+	schedules       map[uuid.UUID]service.ScheduledTransfer
+	executions      []service.ScheduleExecution
+	tokens          map[string]service.Token
+	idempotencyKeys map[idempotencyKey]service.IdempotencyRecord
+}
+
+// walletKey identifies a wallet, users hold at most one wallet per currency
+type walletKey struct {
+	userID   uuid.UUID
+	currency money.Currency
+}
+
+// idempotencyKey identifies an idempotency record, keys are unique per user
+type idempotencyKey struct {
+	userID uuid.UUID
+	key    string
+}
+
+// NewDB creates an empty DB
+func NewDB() *DB {
+	return &DB{
+		sem:             make(chan struct{}, 1),
+		users:           map[uuid.UUID]service.User{},
+		userNames:       map[string]uuid.UUID{},
+		wallets:         map[walletKey]service.Wallet{},
+		holds:           map[uuid.UUID]service.Hold{},
+		schedules:       map[uuid.UUID]service.ScheduledTransfer{},
+		tokens:          map[string]service.Token{},
+		idempotencyKeys: map[idempotencyKey]service.IdempotencyRecord{},
+	}
+}
+
+// acquire waits for exclusive access to the DB, returning the function that releases it
+func (db *DB) acquire(ctx context.Context) (func(), error) {
+	select {
+	case db.sem <- struct{}{}:
+		return func() { <-db.sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// AccountRepository is a persistence repository that keeps the data in memory, it's meant for tests and local
+// development. It implements the same repositories as the Postgres one.
+type AccountRepository struct {
+	db *DB
+
+	// tx is set on the transactioned versions of the repository
+	tx *tx
+}
+
+// tx is a transaction, which holds the DB and keeps how to undo its changes until it's done
+type tx struct {
+	undo []func()
+	done bool
+}
+
+// NewAccountRepository creates an in-memory repository for accounts
+func NewAccountRepository(db *DB) *AccountRepository {
+	return &AccountRepository{db: db}
+}
+
+// access gives exclusive access to the DB, the ongoing transaction has it already
+func (repo *AccountRepository) access(ctx context.Context) (func(), error) {
+	if repo.tx != nil && repo.tx.done {
+		return nil, sql.ErrTxDone
+	}
+
+	if repo.tx != nil {
+		return func() {}, nil
+	}
+
+	return repo.db.acquire(ctx)
+}
+
+// onRollback registers how to undo a change, it's ignored outside transactions since they can't be rolled back
+func (repo *AccountRepository) onRollback(undo func()) {
+	if repo.tx != nil {
+		repo.tx.undo = append(repo.tx.undo, undo)
+	}
+}
+
+func (repo *AccountRepository) FindUserByID(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+	return repo.findUser(userID)
+}
+
+func (repo *AccountRepository) FindUserByUserName(ctx context.Context, userName string) (*service.User, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	userID, ok := repo.db.userNames[userName]
+	if !ok {
+		return nil, service.ErrUserNotFound
+	}
+
+	return repo.findUser(userID)
+}
+
+// FindUserByIDForReport looks up for a user, there's no replica so it's never behind the latest writes
+func (repo *AccountRepository) FindUserByIDForReport(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+	return repo.FindUserByID(ctx, userID)
+}
+
+// FindAndLockUserByID looks up for a user, the transaction holds the whole DB already so there's nothing to lock
+func (repo *AccountRepository) FindAndLockUserByID(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+	return repo.FindUserByID(ctx, userID)
+}
+
+// findUser returns a copy of a user, so it can't be changed without the repository
+func (repo *AccountRepository) findUser(userID uuid.UUID) (*service.User, error) {
+	user, ok := repo.db.users[userID]
+	if !ok {
+		return nil, service.ErrUserNotFound
+	}
+
+	return &user, nil
+}
+
+func (repo *AccountRepository) CreateUser(ctx context.Context, user *service.User) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	if _, ok := repo.db.users[user.ID]; ok {
+		return fmt.Errorf("a user with ID %s already exists", user.ID)
+	}
+
+	if _, ok := repo.db.userNames[user.UserName]; ok {
+		return service.ErrUserNameTaken
+	}
+
+	repo.setUser(*user)
+	return nil
+}
+
+func (repo *AccountRepository) UpdateUserProfile(ctx context.Context, user *service.User) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	existing, ok := repo.db.users[user.ID]
+	if !ok {
+		return service.ErrUserNotFound
+	}
+
+	if ownerID, ok := repo.db.userNames[user.UserName]; ok && ownerID != user.ID {
+		return service.ErrUserNameTaken
+	}
+
+	existing.UserName = user.UserName
+	existing.DisplayName = user.DisplayName
+	existing.Email = user.Email
+	repo.setUser(existing)
+	return nil
+}
+
+func (repo *AccountRepository) UpdateUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	return repo.updateUser(ctx, userID, func(user *service.User) {
+		user.PasswordHash = passwordHash
+	})
+}
+
+// updateUser changes an existing user, failing with service.ErrUserNotFound if it's missing
+func (repo *AccountRepository) updateUser(ctx context.Context, userID uuid.UUID, update func(*service.User)) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	user, ok := repo.db.users[userID]
+	if !ok {
+		return service.ErrUserNotFound
+	}
+
+	update(&user)
+	repo.setUser(user)
+	return nil
+}
+
+// setUser creates or replaces a user, keeping the index of the usernames
+func (repo *AccountRepository) setUser(user service.User) {
+	previous, existed := repo.db.users[user.ID]
+	if existed {
+		delete(repo.db.userNames, previous.UserName)
+	}
+
+	repo.db.users[user.ID] = user
+	repo.db.userNames[user.UserName] = user.ID
+
+	repo.onRollback(func() {
+		delete(repo.db.userNames, user.UserName)
+		delete(repo.db.users, user.ID)
+		if existed {
+			repo.db.users[user.ID] = previous
+			repo.db.userNames[previous.UserName] = previous.ID
+		}
+	})
+}
+
+func (repo *AccountRepository) CreateWallet(ctx context.Context, wallet *service.Wallet) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	// the user is a foreign key of the wallet
+	if _, ok := repo.db.users[wallet.UserID]; !ok {
+		return fmt.Errorf("the user %s of the wallet doesn't exist", wallet.UserID)
+	}
+
+	if _, ok := repo.db.wallets[walletKey{userID: wallet.UserID, currency: wallet.Currency}]; ok {
+		return service.ErrWalletExists
+	}
+
+	repo.setWallet(*wallet)
+	return nil
+}
+
+func (repo *AccountRepository) FindWallet(ctx context.Context, userID uuid.UUID, currency money.Currency) (*service.Wallet, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	wallet, ok := repo.db.wallets[walletKey{userID: userID, currency: currency}]
+	if !ok {
+		return nil, service.ErrWalletNotFound
+	}
+
+	return &wallet, nil
+}
+
+func (repo *AccountRepository) ListWalletsByUserID(ctx context.Context, userID uuid.UUID) ([]service.Wallet, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	var wallets []service.Wallet
+	for _, wallet := range repo.db.wallets {
+		if wallet.UserID == userID {
+			wallets = append(wallets, wallet)
+		}
+	}
+
+	sort.Slice(wallets, func(i, j int) bool {
+		return wallets[i].Currency < wallets[j].Currency
+	})
+
+	return wallets, nil
+}
+
+// UpdateWalletBalance changes the balance of an existing wallet, missing wallets are ignored just like an UPDATE
+// matching no rows
+func (repo *AccountRepository) UpdateWalletBalance(ctx context.Context, userID uuid.UUID, currency money.Currency, newBalance money.Amount) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	wallet, ok := repo.db.wallets[walletKey{userID: userID, currency: currency}]
+	if !ok {
+		return nil
+	}
+
+	wallet.Balance = newBalance
+	repo.setWallet(wallet)
+	return nil
+}
+
+// setWallet creates or replaces a wallet
+func (repo *AccountRepository) setWallet(wallet service.Wallet) {
+	key := walletKey{userID: wallet.UserID, currency: wallet.Currency}
+	previous, existed := repo.db.wallets[key]
+	repo.db.wallets[key] = wallet
+
+	repo.onRollback(func() {
+		delete(repo.db.wallets, key)
+		if existed {
+			repo.db.wallets[key] = previous
+		}
+	})
+}
+
+func (repo *AccountRepository) CreateTransaction(ctx context.Context, transaction *service.Transaction) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	// the users are foreign keys of the transaction
+	for _, userID := range []uuid.UUID{transaction.SourceUserID, transaction.TargetUserID} {
+		if _, ok := repo.db.users[userID]; !ok {
+			return fmt.Errorf("the user %s of the transaction doesn't exist", userID)
+		}
+	}
+
+	if transaction.ReversalOf != nil && !repo.transactionExists(*transaction.ReversalOf) {
+		return fmt.Errorf("the reversed transaction %s doesn't exist", *transaction.ReversalOf)
+	}
+
+	length := len(repo.db.transactions)
+	repo.db.transactions = append(repo.db.transactions, *transaction)
+	repo.onRollback(func() {
+		repo.db.transactions = repo.db.transactions[:length]
+	})
+
+	return nil
+}
+
+func (repo *AccountRepository) FindTransactionByID(ctx context.Context, transactionID uuid.UUID) (*service.Transaction, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	index := repo.transactionIndex(transactionID)
+	if index < 0 {
+		return nil, service.ErrTransactionNotFound
+	}
+
+	transaction := repo.db.transactions[index]
+	return &transaction, nil
+}
+
+func (repo *AccountRepository) SumReversals(ctx context.Context, transactionID uuid.UUID) (money.Amount, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer release()
+
+	var sum money.Amount
+	for _, transaction := range repo.db.transactions {
+		if transaction.ReversalOf != nil && *transaction.ReversalOf == transactionID {
+			sum += transaction.Amount
+		}
+	}
+
+	return sum, nil
+}
+
+func (repo *AccountRepository) CreateClaim(ctx context.Context, claim *service.Claim) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	// the user and the transaction are foreign keys of the claim
+	if _, ok := repo.db.users[claim.UserID]; !ok {
+		return fmt.Errorf("the user %s of the claim doesn't exist", claim.UserID)
+	}
+
+	if !repo.transactionExists(claim.TransactionID) {
+		return fmt.Errorf("the transaction %s of the claim doesn't exist", claim.TransactionID)
+	}
+
+	length := len(repo.db.claims)
+	repo.db.claims = append(repo.db.claims, *claim)
+	repo.onRollback(func() {
+		repo.db.claims = repo.db.claims[:length]
+	})
+
+	return nil
+}
+
+func (repo *AccountRepository) RecordStatusChange(ctx context.Context, change *service.StatusChange) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	index := repo.transactionIndex(change.TransactionID)
+	if index < 0 {
+		return service.ErrTransactionNotFound
+	}
+
+	previous := repo.db.transactions[index]
+	updated := previous
+	updated.Status = change.Status
+	updated.UpdatedAt = change.ChangedAt
+	if change.Status == service.TransactionFailed {
+		updated.FailureReason = change.Reason
+	}
+
+	repo.db.transactions[index] = updated
+
+	length := len(repo.db.statusChanges)
+	repo.db.statusChanges = append(repo.db.statusChanges, *change)
+	repo.onRollback(func() {
+		repo.db.statusChanges = repo.db.statusChanges[:length]
+		repo.db.transactions[index] = previous
+	})
+
+	return nil
+}
+
+func (repo *AccountRepository) ListStatusChanges(ctx context.Context, transactionID uuid.UUID) ([]service.StatusChange, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	var changes []service.StatusChange
+	for _, change := range repo.db.statusChanges {
+		if change.TransactionID == transactionID {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, nil
+}
+
+func (repo *AccountRepository) CreateHold(ctx context.Context, hold *service.Hold) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	if _, ok := repo.db.holds[hold.ID]; ok {
+		return fmt.Errorf("a hold with ID %s already exists", hold.ID)
+	}
+
+	// the users are foreign keys of the hold
+	for _, userID := range []uuid.UUID{hold.UserID, hold.TargetUserID} {
+		if _, ok := repo.db.users[userID]; !ok {
+			return fmt.Errorf("the user %s of the hold doesn't exist", userID)
+		}
+	}
+
+	repo.setHold(*hold)
+	return nil
+}
+
+func (repo *AccountRepository) FindHoldByID(ctx context.Context, holdID uuid.UUID) (*service.Hold, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	hold, ok := repo.db.holds[holdID]
+	if !ok {
+		return nil, service.ErrHoldNotFound
+	}
+
+	return &hold, nil
+}
+
+func (repo *AccountRepository) UpdateHold(ctx context.Context, hold *service.Hold) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	existing, ok := repo.db.holds[hold.ID]
+	if !ok {
+		return service.ErrHoldNotFound
+	}
+
+	if hold.TransactionID != nil && !repo.transactionExists(*hold.TransactionID) {
+		return fmt.Errorf("the transaction %s of the hold doesn't exist", *hold.TransactionID)
+	}
+
+	existing.Status = hold.Status
+	existing.CapturedAmount = hold.CapturedAmount
+	existing.TransactionID = hold.TransactionID
+	existing.UpdatedAt = hold.UpdatedAt
+	repo.setHold(existing)
+	return nil
+}
+
+func (repo *AccountRepository) ExpireHolds(ctx context.Context, at time.Time) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	for _, hold := range repo.db.holds {
+		if hold.Status == service.HoldActive && !at.Before(hold.ExpiresAt) {
+			hold.Status = service.HoldExpired
+			hold.UpdatedAt = hold.ExpiresAt
+			repo.setHold(hold)
+		}
+	}
+
+	return nil
+}
+
+func (repo *AccountRepository) SumActiveHolds(ctx context.Context, userID uuid.UUID, currency money.Currency, at time.Time) (money.Amount, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer release()
+
+	var sum money.Amount
+	for _, hold := range repo.db.holds {
+		if hold.UserID == userID && hold.Currency == currency && hold.Status == service.HoldActive &&
+			hold.ExpiresAt.After(at) {
+
+			sum += hold.Amount
+		}
+	}
+
+	return sum, nil
+}
+
+// setHold creates or replaces a hold
+func (repo *AccountRepository) setHold(hold service.Hold) {
+	previous, existed := repo.db.holds[hold.ID]
+	repo.db.holds[hold.ID] = hold
+
+	repo.onRollback(func() {
+		delete(repo.db.holds, hold.ID)
+		if existed {
+			repo.db.holds[hold.ID] = previous
+		}
+	})
+}
+
+func (repo *AccountRepository) CreateScheduledTransfer(ctx context.Context, schedule *service.ScheduledTransfer) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	if _, ok := repo.db.schedules[schedule.ID]; ok {
+		return fmt.Errorf("a scheduled transfer with ID %s already exists", schedule.ID)
+	}
+
+	// the users are foreign keys of the scheduled transfer
+	for _, userID := range []uuid.UUID{schedule.UserID, schedule.TargetUserID} {
+		if _, ok := repo.db.users[userID]; !ok {
+			return fmt.Errorf("the user %s of the scheduled transfer doesn't exist", userID)
+		}
+	}
+
+	repo.setSchedule(*schedule)
+	return nil
+}
+
+func (repo *AccountRepository) FindScheduledTransferByID(ctx context.Context, scheduleID uuid.UUID) (*service.ScheduledTransfer, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	schedule, ok := repo.db.schedules[scheduleID]
+	if !ok {
+		return nil, service.ErrScheduledTransferNotFound
+	}
+
+	return &schedule, nil
+}
+
+// FindAndLockScheduledTransferByID looks up for a scheduled transfer, the transaction holds the whole DB already so
+// there's nothing to lock
+func (repo *AccountRepository) FindAndLockScheduledTransferByID(ctx context.Context, scheduleID uuid.UUID) (*service.ScheduledTransfer, error) {
+	return repo.FindScheduledTransferByID(ctx, scheduleID)
+}
+
+func (repo *AccountRepository) ListScheduledTransfersByUserID(ctx context.Context, userID uuid.UUID) ([]service.ScheduledTransfer, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	var schedules []service.ScheduledTransfer
+	for _, schedule := range repo.db.schedules {
+		if schedule.UserID == userID {
+			schedules = append(schedules, schedule)
+		}
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		if !schedules[i].CreatedAt.Equal(schedules[j].CreatedAt) {
+			return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+		}
+
+		return bytes.Compare(schedules[i].ID[:], schedules[j].ID[:]) < 0
+	})
+
+	return schedules, nil
+}
+
+// ClaimDueScheduledTransfer finds the active scheduled transfer with the earliest attempt due, the transaction holds the
+// whole DB already so there's nothing locked by others to skip
+func (repo *AccountRepository) ClaimDueScheduledTransfer(ctx context.Context, at time.Time) (*service.ScheduledTransfer, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	var due *service.ScheduledTransfer
+	for _, schedule := range repo.db.schedules {
+		if schedule.Status != service.ScheduleActive || schedule.NextAttemptAt.After(at) {
+			continue
+		}
+
+		if due == nil || schedule.NextAttemptAt.Before(due.NextAttemptAt) ||
+			(schedule.NextAttemptAt.Equal(due.NextAttemptAt) && bytes.Compare(schedule.ID[:], due.ID[:]) < 0) {
+
+			schedule := schedule
+			due = &schedule
+		}
+	}
+
+	return due, nil
+}
+
+func (repo *AccountRepository) UpdateScheduledTransfer(ctx context.Context, schedule *service.ScheduledTransfer) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	existing, ok := repo.db.schedules[schedule.ID]
+	if !ok {
+		return service.ErrScheduledTransferNotFound
+	}
+
+	existing.Status = schedule.Status
+	existing.NextRunAt = schedule.NextRunAt
+	existing.NextAttemptAt = schedule.NextAttemptAt
+	existing.Attempts = schedule.Attempts
+	existing.UpdatedAt = schedule.UpdatedAt
+	repo.setSchedule(existing)
+	return nil
+}
+
+// setSchedule creates or replaces a scheduled transfer
+func (repo *AccountRepository) setSchedule(schedule service.ScheduledTransfer) {
+	previous, existed := repo.db.schedules[schedule.ID]
+	repo.db.schedules[schedule.ID] = schedule
+
+	repo.onRollback(func() {
+		delete(repo.db.schedules, schedule.ID)
+		if existed {
+			repo.db.schedules[schedule.ID] = previous
+		}
+	})
+}
+
+func (repo *AccountRepository) CreateScheduleExecution(ctx context.Context, execution *service.ScheduleExecution) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	// the scheduled transfer and the transaction are foreign keys of the execution
+	if _, ok := repo.db.schedules[execution.ScheduleID]; !ok {
+		return fmt.Errorf("the scheduled transfer %s of the execution doesn't exist", execution.ScheduleID)
+	}
+
+	if execution.TransactionID != nil && !repo.transactionExists(*execution.TransactionID) {
+		return fmt.Errorf("the transaction %s of the execution doesn't exist", *execution.TransactionID)
+	}
+
+	length := len(repo.db.executions)
+	repo.db.executions = append(repo.db.executions, *execution)
+	repo.onRollback(func() {
+		repo.db.executions = repo.db.executions[:length]
+	})
+
+	return nil
+}
+
+func (repo *AccountRepository) ListScheduleExecutions(ctx context.Context, scheduleID uuid.UUID) ([]service.ScheduleExecution, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	var executions []service.ScheduleExecution
+	for _, execution := range repo.db.executions {
+		if execution.ScheduleID == scheduleID {
+			executions = append(executions, execution)
+		}
+	}
+
+	return executions, nil
+}
+
+// transactionExists tells whether there's a transaction with the given ID
+func (repo *AccountRepository) transactionExists(transactionID uuid.UUID) bool {
+	return repo.transactionIndex(transactionID) >= 0
+}
+
+// transactionIndex returns the index of the transaction with the given ID, or -1 if there's none
+func (repo *AccountRepository) transactionIndex(transactionID uuid.UUID) int {
+	for i, transaction := range repo.db.transactions {
+		if transaction.ID == transactionID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (repo *AccountRepository) CreatePostings(ctx context.Context, postings []service.Posting) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	length := len(repo.db.postings)
+	repo.db.postings = append(repo.db.postings, postings...)
+	repo.onRollback(func() {
+		repo.db.postings = repo.db.postings[:length]
+	})
+
+	return nil
+}
+
+func (repo *AccountRepository) ListTransactionsByUserID(ctx context.Context, userID uuid.UUID, filter service.TransactionFilter) ([]service.StatementEntry, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	// the running balance is the sum of the postings of the user in the currency in the order they were written to the
+	// ledger, it's calculated over the whole history so it's correct regardless of the filters. The transactions that
+	// didn't move money, like the failed ones, have no postings and no running balance.
+	balancesAfter := map[uuid.UUID]money.Amount{}
+	balances := map[money.Currency]money.Amount{}
+	for _, posting := range repo.db.postings {
+		if posting.AccountID == userID {
+			balances[posting.Currency] += posting.Amount
+			balancesAfter[posting.EntryID] = balances[posting.Currency]
+		}
+	}
+
+	var entries []service.StatementEntry
+	for _, transaction := range repo.db.transactions {
+		if !matchesFilter(transaction, userID, filter) {
+			continue
+		}
+
+		entry := service.StatementEntry{
+			Transaction:    transaction,
+			Direction:      service.DirectionIncoming,
+			CounterpartyID: transaction.SourceUserID,
+		}
+
+		if balanceAfter, ok := balancesAfter[transaction.ID]; ok {
+			entry.BalanceAfter = &balanceAfter
+		}
+
+		if transaction.SourceUserID == userID {
+			entry.Direction = service.DirectionOutgoing
+			entry.CounterpartyID = transaction.TargetUserID
+		}
+
+		counterparty, ok := repo.db.users[entry.CounterpartyID]
+		if !ok {
+			continue
+		}
+
+		entry.CounterpartyUserName = counterparty.UserName
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return transactionBefore(entries[i].Transaction, entries[j].CreatedAt, entries[j].ID)
+	})
+
+	if len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+
+	return entries, nil
+}
+
+// matchesFilter tells whether a transaction of the user matches the filter
+func matchesFilter(transaction service.Transaction, userID uuid.UUID, filter service.TransactionFilter) bool {
+	switch filter.Direction {
+	case service.DirectionIncoming:
+		if transaction.TargetUserID != userID {
+			return false
+		}
+	case service.DirectionAll:
+		if transaction.SourceUserID != userID && transaction.TargetUserID != userID {
+			return false
+		}
+	default:
+		if transaction.SourceUserID != userID {
+			return false
+		}
+	}
+
+	// the user is always one of the sides and can't transfer to itself, so the counterparty is the other side
+	if filter.CounterpartyID != uuid.Nil && transaction.SourceUserID != filter.CounterpartyID &&
+		transaction.TargetUserID != filter.CounterpartyID {
+		return false
+	}
+
+	if filter.After != nil && !transactionBefore(service.Transaction{CreatedAt: filter.After.CreatedAt,
+		ID: filter.After.ID}, transaction.CreatedAt, transaction.ID) {
+		return false
+	}
+
+	if !filter.From.IsZero() && transaction.CreatedAt.Before(filter.From) {
+		return false
+	}
+
+	if !filter.To.IsZero() && !transaction.CreatedAt.Before(filter.To) {
+		return false
+	}
+
+	if filter.Currency != "" && transaction.Currency != filter.Currency {
+		return false
+	}
+
+	if filter.MinAmount != nil && transaction.Amount < *filter.MinAmount {
+		return false
+	}
+
+	return filter.MaxAmount == nil || transaction.Amount <= *filter.MaxAmount
+}
+
+// transactionBefore tells whether the transaction comes before the given creation time and ID, which is the order of
+// the statements. UUIDs are compared by their bytes, like Postgres does.
+func transactionBefore(transaction service.Transaction, createdAt time.Time, id uuid.UUID) bool {
+	if !transaction.CreatedAt.Equal(createdAt) {
+		return transaction.CreatedAt.Before(createdAt)
+	}
+
+	return bytes.Compare(transaction.ID[:], id[:]) < 0
+}
+
+func (repo *AccountRepository) FindIdempotencyRecord(ctx context.Context, userID uuid.UUID, key string) (*service.IdempotencyRecord, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	record, ok := repo.db.idempotencyKeys[idempotencyKey{userID: userID, key: key}]
+	if !ok {
+		return nil, nil
+	}
+
+	return &record, nil
+}
+
+func (repo *AccountRepository) CreateIdempotencyRecord(ctx context.Context, record *service.IdempotencyRecord) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	key := idempotencyKey{userID: record.UserID, key: record.Key}
+	if _, ok := repo.db.idempotencyKeys[key]; ok {
+		return service.ErrIdempotencyKeyInProgress
+	}
+
+	stored := *record
+	stored.Replayed = false
+	repo.db.idempotencyKeys[key] = stored
+	repo.onRollback(func() {
+		delete(repo.db.idempotencyKeys, key)
+	})
+
+	return nil
+}
+
+func (repo *AccountRepository) CreateToken(ctx context.Context, token *service.Token) error {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	repo.setToken(*token)
+	return nil
+}
+
+func (repo *AccountRepository) FindTokenByHash(ctx context.Context, tokenHash string) (*service.Token, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	token, ok := repo.db.tokens[tokenHash]
+	if !ok {
+		return nil, service.ErrInvalidToken
+	}
+
+	return &token, nil
+}
+
+func (repo *AccountRepository) RevokeToken(ctx context.Context, tokenHash string, revokedAt time.Time) error {
+	revoked, err := repo.revokeTokens(ctx, revokedAt, func(token service.Token) bool {
+		return token.Hash == tokenHash
+	})
+
+	if err == nil && revoked == 0 {
+		return service.ErrInvalidToken
+	}
+
+	return err
+}
+
+func (repo *AccountRepository) RevokeSession(ctx context.Context, sessionID uuid.UUID, revokedAt time.Time) error {
+	_, err := repo.revokeTokens(ctx, revokedAt, func(token service.Token) bool {
+		return token.SessionID == sessionID
+	})
+
+	return err
+}
+
+func (repo *AccountRepository) RevokeUserTokens(ctx context.Context, userID uuid.UUID, revokedAt time.Time) error {
+	_, err := repo.revokeTokens(ctx, revokedAt, func(token service.Token) bool {
+		return token.UserID == userID
+	})
+
+	return err
+}
+
+// revokeTokens revokes the tokens that match and aren't revoked yet, returning how many were revoked
+func (repo *AccountRepository) revokeTokens(ctx context.Context, revokedAt time.Time, matches func(service.Token) bool) (int, error) {
+	release, err := repo.access(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer release()
+
+	var revoked int
+	for _, token := range repo.db.tokens {
+		if token.RevokedAt != nil || !matches(token) {
+			continue
+		}
+
+		at := revokedAt
+		token.RevokedAt = &at
+		repo.setToken(token)
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+// setToken creates or replaces a token
+func (repo *AccountRepository) setToken(token service.Token) {
+	previous, existed := repo.db.tokens[token.Hash]
+	repo.db.tokens[token.Hash] = token
+
+	repo.onRollback(func() {
+		delete(repo.db.tokens, token.Hash)
+		if existed {
+			repo.db.tokens[token.Hash] = previous
+		}
+	})
+}
+
+// WithTx runs the function inside a transaction, which holds the DB until it's committed or rolled back
+func (repo *AccountRepository) WithTx(ctx context.Context, transactionedFunction func(repository service.AccountRepository) error) error {
+	return repo.withTx(ctx, func(txRepo *AccountRepository) error {
+		return transactionedFunction(txRepo)
+	})
+}
+
+// withTx implements WithTx, it's shared by the repositories built on AccountRepository
+func (repo *AccountRepository) withTx(ctx context.Context, transactionedFunction func(repository *AccountRepository) error) error {
+
+	// the repository is already transactioned, so the function simply joins the ongoing transaction
+	if repo.tx != nil {
+		return transactionedFunction(repo)
+	}
+
+	release, err := repo.db.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	// the changes are rolled back when the function fails or panics
+	txRepo := &AccountRepository{db: repo.db, tx: &tx{}}
+	committed := false
+	defer func() {
+		if !committed {
+			for i := len(txRepo.tx.undo) - 1; i >= 0; i-- {
+				txRepo.tx.undo[i]()
+			}
+		}
+
+		txRepo.tx.done = true
+	}()
+
+	if err := transactionedFunction(txRepo); err != nil {
+		return err
+	}
+
+	committed = true
+	return nil
+}
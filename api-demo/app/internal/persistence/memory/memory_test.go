@@ -0,0 +1,82 @@
+package memory_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/app/internal/persistence/memory"
+	"api-demo/app/internal/persistence/persistencetest"
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+func TestRepositories(t *testing.T) {
+	persistencetest.RunRepositoryTests(t, func(t *testing.T) (persistencetest.Repository, service.LedgerRepository) {
+		db := memory.NewDB()
+		return memory.NewAccountRepository(db), memory.NewLedgerRepository(db)
+	})
+}
+
+func TestAccountRepository_WithTx(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewAccountRepository(memory.NewDB())
+
+	var leaked service.AccountRepository
+	require.NoError(t, repo.WithTx(ctx, func(txRepo service.AccountRepository) error {
+		leaked = txRepo
+		return nil
+	}))
+
+	_, err := leaked.FindUserByID(ctx, service.ExternalAccountID)
+	require.Equal(t, sql.ErrTxDone, err, "a transactioned repository can't be used once the transaction is done")
+
+	// the transaction holds the DB, so the operations outside it wait for it
+	cancelled, cancel := context.WithCancel(ctx)
+	err = repo.WithTx(ctx, func(txRepo service.AccountRepository) error {
+		cancel()
+		_, err := repo.FindUserByID(cancelled, service.ExternalAccountID)
+		return err
+	})
+
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestUserRepository_WithTx(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewUserRepository(memory.NewAccountRepository(memory.NewDB()))
+
+	// the user is rolled back along with its wallet when opening the second wallet in the same currency fails
+	user := &service.User{ID: uuid.New(), UserName: "breno", DisplayName: "Breno"}
+	err := repo.WithTx(ctx, func(txRepo service.UserRepository) error {
+		if err := txRepo.CreateUser(ctx, user); err != nil {
+			return err
+		}
+
+		if err := txRepo.CreateWallet(ctx, &service.Wallet{UserID: user.ID, Currency: money.BRL}); err != nil {
+			return err
+		}
+
+		return txRepo.CreateWallet(ctx, &service.Wallet{UserID: user.ID, Currency: money.BRL})
+	})
+
+	require.True(t, errors.Is(err, service.ErrWalletExists))
+
+	_, err = repo.FindUserByID(ctx, user.ID)
+	require.True(t, errors.Is(err, service.ErrUserNotFound))
+
+	_, err = repo.FindWallet(ctx, user.ID, money.BRL)
+	require.True(t, errors.Is(err, service.ErrWalletNotFound))
+
+	require.NoError(t, repo.WithTx(ctx, func(txRepo service.UserRepository) error {
+		return txRepo.CreateUser(ctx, user)
+	}))
+
+	found, err := repo.FindUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, user, found)
+}
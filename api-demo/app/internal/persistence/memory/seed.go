@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+// demoUsers are the users created by Seed with the balances of their wallets, the same ones seeded in Postgres
+var demoUsers = []struct {
+	id       string
+	userName string
+	password string
+	balances []money.Money
+}{
+	{"256bea59-c9a7-44d0-bcd8-d710aad69676", "breno", "1234", []money.Money{{Amount: 1000, Currency: money.BRL},
+		{Amount: 2000, Currency: money.USD}}},
+	{"c66af437-8536-4ac9-918c-5e73ef95578a", "bruno", "4321", []money.Money{{Amount: 10000, Currency: money.BRL},
+		{Amount: 500, Currency: money.USD}}},
+	{"9e321e7b-918b-4bef-9c85-81b1729b31d9", "brono", "abcd", []money.Money{{Amount: 100000, Currency: money.BRL}}},
+	{"007dcaec-6963-4d4c-a40d-9b5eda420f10", "brano", "abcdef", []money.Money{{Amount: 1000000, Currency: money.BRL}}},
+}
+
+// Seed creates the demo users with the opening balances of their wallets, funded by the external account. It's meant
+// to be run on an empty DB before it's used.
+func Seed(ctx context.Context, repo *AccountRepository, hasher service.PasswordHasher) error {
+	for _, demoUser := range demoUsers {
+		passwordHash, err := hasher.Hash(demoUser.password)
+		if err != nil {
+			return fmt.Errorf("error hashing the password of %s: %w", demoUser.userName, err)
+		}
+
+		user := &service.User{
+			ID:           uuid.MustParse(demoUser.id),
+			UserName:     demoUser.userName,
+			PasswordHash: passwordHash,
+		}
+
+		if err := repo.CreateUser(ctx, user); err != nil {
+			return err
+		}
+
+		for _, balance := range demoUser.balances {
+			wallet := &service.Wallet{UserID: user.ID, Currency: balance.Currency, Balance: balance.Amount}
+			if err := repo.CreateWallet(ctx, wallet); err != nil {
+				return err
+			}
+
+			// the user ID is used as the entry ID of the opening balances, which are balanced in each currency
+			now := time.Now()
+			err = repo.CreatePostings(ctx, []service.Posting{
+				{EntryID: user.ID, AccountID: service.ExternalAccountID, Amount: -balance.Amount,
+					Currency: balance.Currency, CreatedAt: now},
+				{EntryID: user.ID, AccountID: user.ID, Amount: balance.Amount, Currency: balance.Currency,
+					CreatedAt: now},
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
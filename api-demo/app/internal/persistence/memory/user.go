@@ -0,0 +1,24 @@
+package memory
+
+import (
+	"context"
+
+	"api-demo/app/internal/service"
+)
+
+// UserRepository is the repository of the users, it shares the data and the transactions of an AccountRepository
+type UserRepository struct {
+	*AccountRepository
+}
+
+// NewUserRepository creates an in-memory repository for the users on top of the account repository
+func NewUserRepository(accounts *AccountRepository) *UserRepository {
+	return &UserRepository{AccountRepository: accounts}
+}
+
+// WithTx runs the function inside a transaction, which holds the DB until it's committed or rolled back
+func (repo *UserRepository) WithTx(ctx context.Context, transactionedFunction func(repository service.UserRepository) error) error {
+	return repo.withTx(ctx, func(txRepo *AccountRepository) error {
+		return transactionedFunction(NewUserRepository(txRepo))
+	})
+}
@@ -0,0 +1,972 @@
+// Package persistencetest has the conformance tests every implementation of the repositories should pass, so the
+// services behave the same regardless of where the data is stored
+package persistencetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+// Repository is implemented by the repositories of accounts, which are used by all the services
+type Repository interface {
+	service.AccountRepository
+	service.AuthenticationRepository
+
+	// the rest of service.UserRepository, whose WithTx is tested with the repositories of each implementation
+	CreateUser(ctx context.Context, user *service.User) error
+	UpdateUserProfile(ctx context.Context, user *service.User) error
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID, revokedAt time.Time) error
+}
+
+// Factory creates an empty repository of accounts, and one of the ledger sharing its data
+type Factory func(t *testing.T) (Repository, service.LedgerRepository)
+
+// RunRepositoryTests runs the conformance tests on the repositories created by the factory, a pair per test
+func RunRepositoryTests(t *testing.T, factory Factory) {
+	tests := map[string]func(t *testing.T, repo Repository, ledger service.LedgerRepository){
+		"users":                   testUsers,
+		"wallets":                 testWallets,
+		"statement":               testStatement,
+		"tx commit":               testTxCommit,
+		"tx rollback":             testTxRollback,
+		"tx row locking":          testTxRowLocking,
+		"idempotency records":     testIdempotencyRecords,
+		"tokens":                  testTokens,
+		"reversals":               testReversals,
+		"status changes":          testStatusChanges,
+		"holds":                   testHolds,
+		"scheduled transfers":     testScheduledTransfers,
+		"ledger":                  testLedger,
+		"ledger balance mismatch": testLedgerBalanceMismatch,
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			repo, ledger := factory(t)
+			test(t, repo, ledger)
+		})
+	}
+}
+
+// base is the creation time of the data of the tests, times are in UTC with microsecond precision like in Postgres
+var base = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func testUsers(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+
+	user := &service.User{ID: uuid.New(), UserName: "breno", PasswordHash: "hash", DisplayName: "Breno",
+		Email: "breno@example.com"}
+	require.NoError(t, repo.CreateUser(ctx, user))
+
+	found, err := repo.FindUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, user, found)
+
+	found, err = repo.FindUserByIDForReport(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, user, found)
+
+	found, err = repo.FindUserByUserName(ctx, "breno")
+	require.NoError(t, err)
+	require.Equal(t, user, found)
+
+	_, err = repo.FindUserByID(ctx, uuid.New())
+	require.True(t, errors.Is(err, service.ErrUserNotFound))
+
+	_, err = repo.FindUserByIDForReport(ctx, uuid.New())
+	require.True(t, errors.Is(err, service.ErrUserNotFound))
+
+	_, err = repo.FindUserByUserName(ctx, "unknown")
+	require.True(t, errors.Is(err, service.ErrUserNotFound))
+
+	err = repo.CreateUser(ctx, &service.User{ID: uuid.New(), UserName: "breno", PasswordHash: "hash"})
+	require.True(t, errors.Is(err, service.ErrUserNameTaken))
+
+	other := &service.User{ID: uuid.New(), UserName: "bruno", PasswordHash: "hash"}
+	require.NoError(t, repo.CreateUser(ctx, other))
+
+	renamed := *user
+	renamed.UserName = "bruno"
+	require.True(t, errors.Is(repo.UpdateUserProfile(ctx, &renamed), service.ErrUserNameTaken))
+
+	renamed.UserName = "brena"
+	renamed.DisplayName = "Brena"
+	renamed.Email = "brena@example.com"
+	require.NoError(t, repo.UpdateUserProfile(ctx, &renamed))
+	require.NoError(t, repo.UpdateUserPassword(ctx, user.ID, "new hash"))
+
+	found, err = repo.FindUserByUserName(ctx, "brena")
+	require.NoError(t, err)
+	renamed.PasswordHash = "new hash"
+	require.Equal(t, &renamed, found)
+
+	_, err = repo.FindUserByUserName(ctx, "breno")
+	require.True(t, errors.Is(err, service.ErrUserNotFound), "the old username should be free")
+	require.NoError(t, repo.CreateUser(ctx, &service.User{ID: uuid.New(), UserName: "breno", PasswordHash: "hash"}))
+
+	// missing users aren't updated silently
+	missing := &service.User{ID: uuid.New(), UserName: "missing"}
+	require.True(t, errors.Is(repo.UpdateUserProfile(ctx, missing), service.ErrUserNotFound))
+	require.True(t, errors.Is(repo.UpdateUserPassword(ctx, missing.ID, "hash"), service.ErrUserNotFound))
+
+	_, err = repo.FindUserByUserName(ctx, "missing")
+	require.True(t, errors.Is(err, service.ErrUserNotFound))
+}
+
+func testWallets(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+
+	breno := createFundedUser(t, repo, "breno", 1000)
+	bruno := createFundedUser(t, repo, "bruno", 0)
+	createWallet(t, repo, breno, money.USD, 500)
+	createWallet(t, repo, breno, money.EUR, 0)
+	createWallet(t, repo, bruno, money.USD, 0)
+
+	found, err := repo.FindWallet(ctx, breno.ID, money.USD)
+	require.NoError(t, err)
+	require.Equal(t, &service.Wallet{UserID: breno.ID, Currency: money.USD, Balance: 500}, found)
+
+	_, err = repo.FindWallet(ctx, bruno.ID, money.EUR)
+	require.True(t, errors.Is(err, service.ErrWalletNotFound))
+
+	err = repo.CreateWallet(ctx, &service.Wallet{UserID: breno.ID, Currency: money.USD})
+	require.True(t, errors.Is(err, service.ErrWalletExists), "a user holds at most one wallet per currency")
+
+	require.Error(t, repo.CreateWallet(ctx, &service.Wallet{UserID: uuid.New(), Currency: money.USD}),
+		"the user of a wallet should exist")
+
+	wallets, err := repo.ListWalletsByUserID(ctx, breno.ID)
+	require.NoError(t, err)
+	require.Equal(t, []service.Wallet{
+		{UserID: breno.ID, Currency: money.BRL, Balance: 1000},
+		{UserID: breno.ID, Currency: money.EUR},
+		{UserID: breno.ID, Currency: money.USD, Balance: 500},
+	}, wallets)
+
+	wallets, err = repo.ListWalletsByUserID(ctx, uuid.New())
+	require.NoError(t, err)
+	require.Empty(t, wallets)
+
+	// the running balances of the statements are kept per currency
+	first := transferIn(t, repo, breno, bruno, 100, money.BRL, base.Add(time.Minute))
+	second := transferIn(t, repo, breno, bruno, 50, money.USD, base.Add(2*time.Minute))
+
+	entries, err := repo.ListTransactionsByUserID(ctx, breno.ID,
+		service.TransactionFilter{Limit: 10, Direction: service.DirectionAll})
+	require.NoError(t, err)
+	require.Equal(t, []service.StatementEntry{
+		statementEntry(first, service.DirectionOutgoing, bruno, 900),
+		statementEntry(second, service.DirectionOutgoing, bruno, 450),
+	}, normalizeEntries(entries))
+
+	found, err = repo.FindWallet(ctx, bruno.ID, money.USD)
+	require.NoError(t, err)
+	require.Equal(t, money.Amount(50), found.Balance)
+
+	// the amounts are in the minor units of their currency, the same bound is 1000 yens but 1 dinar
+	for _, currency := range []money.Currency{money.JPY, money.KWD} {
+		createWallet(t, repo, breno, currency, 5000)
+		createWallet(t, repo, bruno, currency, 0)
+	}
+
+	inJPY := transferIn(t, repo, breno, bruno, 1000, money.JPY, base.Add(3*time.Minute))
+	inKWD := transferIn(t, repo, breno, bruno, 1000, money.KWD, base.Add(4*time.Minute))
+	transferIn(t, repo, breno, bruno, 999, money.KWD, base.Add(5*time.Minute))
+
+	bound := money.Amount(1000)
+	for currency, expected := range map[money.Currency][]service.StatementEntry{
+		money.BRL: nil,
+		money.JPY: {statementEntry(inJPY, service.DirectionOutgoing, bruno, 4000)},
+		money.KWD: {statementEntry(inKWD, service.DirectionOutgoing, bruno, 4000)},
+	} {
+		entries, err := repo.ListTransactionsByUserID(ctx, breno.ID, service.TransactionFilter{Limit: 10,
+			Direction: service.DirectionAll, Currency: currency, MinAmount: &bound})
+		require.NoError(t, err)
+		require.Equal(t, expected, normalizeEntries(entries), "filtering by %s", currency)
+	}
+}
+
+func testStatement(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+
+	breno := createFundedUser(t, repo, "breno", 1000)
+	bruno := createFundedUser(t, repo, "bruno", 1000)
+	brono := createFundedUser(t, repo, "brono", 1000)
+
+	first := transfer(t, repo, breno, bruno, 100, base.Add(time.Minute))
+	second := transfer(t, repo, bruno, breno, 50, base.Add(2*time.Minute))
+	third := transfer(t, repo, breno, brono, 300, base.Add(3*time.Minute))
+	transfer(t, repo, bruno, brono, 10, base.Add(4*time.Minute))
+
+	amount := func(amount money.Amount) *money.Amount {
+		return &amount
+	}
+
+	tests := map[string]struct {
+		filter   service.TransactionFilter
+		expected []service.StatementEntry
+	}{
+		"all the transactions": {
+			filter: service.TransactionFilter{Limit: 10, Direction: service.DirectionAll},
+			expected: []service.StatementEntry{
+				statementEntry(first, service.DirectionOutgoing, bruno, 900),
+				statementEntry(second, service.DirectionIncoming, bruno, 950),
+				statementEntry(third, service.DirectionOutgoing, brono, 650),
+			},
+		},
+		"limited": {
+			filter: service.TransactionFilter{Limit: 1, Direction: service.DirectionAll},
+			expected: []service.StatementEntry{
+				statementEntry(first, service.DirectionOutgoing, bruno, 900),
+			},
+		},
+		"after a cursor": {
+			filter: service.TransactionFilter{Limit: 10, Direction: service.DirectionAll,
+				After: &service.TransactionCursor{CreatedAt: first.CreatedAt, ID: first.ID}},
+			expected: []service.StatementEntry{
+				statementEntry(second, service.DirectionIncoming, bruno, 950),
+				statementEntry(third, service.DirectionOutgoing, brono, 650),
+			},
+		},
+		"outgoing": {
+			filter: service.TransactionFilter{Limit: 10, Direction: service.DirectionOutgoing},
+			expected: []service.StatementEntry{
+				statementEntry(first, service.DirectionOutgoing, bruno, 900),
+				statementEntry(third, service.DirectionOutgoing, brono, 650),
+			},
+		},
+		"incoming": {
+			filter: service.TransactionFilter{Limit: 10, Direction: service.DirectionIncoming},
+			expected: []service.StatementEntry{
+				statementEntry(second, service.DirectionIncoming, bruno, 950),
+			},
+		},
+		"by counterparty": {
+			filter: service.TransactionFilter{Limit: 10, Direction: service.DirectionAll, CounterpartyID: brono.ID},
+			expected: []service.StatementEntry{
+				statementEntry(third, service.DirectionOutgoing, brono, 650),
+			},
+		},
+		"by date range": {
+			filter: service.TransactionFilter{Limit: 10, Direction: service.DirectionAll,
+				From: second.CreatedAt, To: third.CreatedAt},
+			expected: []service.StatementEntry{
+				statementEntry(second, service.DirectionIncoming, bruno, 950),
+			},
+		},
+		"by amount range": {
+			filter: service.TransactionFilter{Limit: 10, Direction: service.DirectionAll,
+				Currency: money.BRL, MinAmount: amount(50), MaxAmount: amount(100)},
+			expected: []service.StatementEntry{
+				statementEntry(first, service.DirectionOutgoing, bruno, 900),
+				statementEntry(second, service.DirectionIncoming, bruno, 950),
+			},
+		},
+		"no matches": {
+			filter: service.TransactionFilter{Limit: 10, Direction: service.DirectionAll, Currency: money.BRL,
+				MinAmount: amount(1000)},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			entries, err := repo.ListTransactionsByUserID(ctx, breno.ID, tt.filter)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, normalizeEntries(entries))
+		})
+	}
+}
+
+func testTxCommit(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+	user := createFundedUser(t, repo, "breno", 1000)
+
+	err := repo.WithTx(ctx, func(txRepo service.AccountRepository) error {
+		if err := txRepo.UpdateWalletBalance(ctx, user.ID, service.DefaultCurrency, 500); err != nil {
+			return err
+		}
+
+		// the transaction sees its own changes, and joins itself when nested
+		return txRepo.WithTx(ctx, func(nested service.AccountRepository) error {
+			found, err := nested.FindWallet(ctx, user.ID, service.DefaultCurrency)
+			require.NoError(t, err)
+			require.Equal(t, money.Amount(500), found.Balance)
+			return nil
+		})
+	})
+
+	require.NoError(t, err)
+	requireBalance(t, repo, user.ID, 500)
+}
+
+func testTxRollback(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+	breno := createFundedUser(t, repo, "breno", 1000)
+	bruno := createFundedUser(t, repo, "bruno", 1000)
+
+	failure := errors.New("failure")
+	transaction := &service.Transaction{ID: uuid.New(), SourceUserID: breno.ID, TargetUserID: bruno.ID, Amount: 100,
+		Currency: service.DefaultCurrency, Status: service.TransactionCompleted, CreatedAt: base, UpdatedAt: base}
+
+	err := repo.WithTx(ctx, func(txRepo service.AccountRepository) error {
+		require.NoError(t, txRepo.UpdateWalletBalance(ctx, breno.ID, service.DefaultCurrency, 900))
+		require.NoError(t, txRepo.CreateTransaction(ctx, transaction))
+		require.NoError(t, txRepo.CreatePostings(ctx, transferPostings(transaction)))
+		require.NoError(t, txRepo.CreateIdempotencyRecord(ctx, &service.IdempotencyRecord{UserID: breno.ID,
+			Key: "key", RequestHash: "hash", ResponseStatus: 201, ResponseBody: []byte("{}"), CreatedAt: base}))
+		return failure
+	})
+
+	require.Equal(t, failure, err)
+	requireBalance(t, repo, breno.ID, 1000)
+
+	entries, err := repo.ListTransactionsByUserID(ctx, breno.ID,
+		service.TransactionFilter{Limit: 10, Direction: service.DirectionAll})
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	record, err := repo.FindIdempotencyRecord(ctx, breno.ID, "key")
+	require.NoError(t, err)
+	require.Nil(t, record)
+}
+
+// testTxRowLocking increments a balance concurrently, no increment is lost when the user is locked before reading it
+func testTxRowLocking(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+	user := createFundedUser(t, repo, "breno", 0)
+
+	const increments = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, increments)
+	for i := 0; i < increments; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- repo.WithTx(ctx, func(txRepo service.AccountRepository) error {
+				if _, err := txRepo.FindAndLockUserByID(ctx, user.ID); err != nil {
+					return err
+				}
+
+				wallet, err := txRepo.FindWallet(ctx, user.ID, service.DefaultCurrency)
+				if err != nil {
+					return err
+				}
+
+				// gives the other transactions the chance to read the balance meanwhile
+				time.Sleep(time.Millisecond)
+				return txRepo.UpdateWalletBalance(ctx, user.ID, service.DefaultCurrency, wallet.Balance+1)
+			})
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	requireBalance(t, repo, user.ID, increments)
+}
+
+func testIdempotencyRecords(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+	user := createFundedUser(t, repo, "breno", 0)
+
+	record, err := repo.FindIdempotencyRecord(ctx, user.ID, "key")
+	require.NoError(t, err)
+	require.Nil(t, record)
+
+	stored := &service.IdempotencyRecord{UserID: user.ID, Key: "key", RequestHash: "hash", ResponseStatus: 201,
+		ResponseBody: []byte(`{"id":"1"}`), CreatedAt: base}
+	require.NoError(t, repo.CreateIdempotencyRecord(ctx, stored))
+
+	record, err = repo.FindIdempotencyRecord(ctx, user.ID, "key")
+	require.NoError(t, err)
+	record.CreatedAt = record.CreatedAt.UTC()
+	require.Equal(t, stored, record)
+
+	err = repo.CreateIdempotencyRecord(ctx, stored)
+	require.True(t, errors.Is(err, service.ErrIdempotencyKeyInProgress))
+
+	other := createFundedUser(t, repo, "bruno", 0)
+	record, err = repo.FindIdempotencyRecord(ctx, other.ID, "key")
+	require.NoError(t, err)
+	require.Nil(t, record, "the keys are per user")
+}
+
+func testTokens(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+	user := createFundedUser(t, repo, "breno", 0)
+	sessionID := uuid.New()
+
+	newToken := func(hash string, sessionID uuid.UUID) *service.Token {
+		token := &service.Token{Hash: hash, SessionID: sessionID, UserID: user.ID, Kind: service.TokenKindAccess,
+			CreatedAt: base, ExpiresAt: base.Add(time.Hour)}
+		require.NoError(t, repo.CreateToken(ctx, token))
+		return token
+	}
+
+	access := newToken("access", sessionID)
+	refresh := newToken("refresh", sessionID)
+	other := newToken("other", uuid.New())
+
+	found, err := repo.FindTokenByHash(ctx, "access")
+	require.NoError(t, err)
+	require.Equal(t, access, normalizeToken(found))
+
+	_, err = repo.FindTokenByHash(ctx, "unknown")
+	require.True(t, errors.Is(err, service.ErrInvalidToken))
+
+	revokedAt := base.Add(time.Minute)
+	require.NoError(t, repo.RevokeToken(ctx, "access", revokedAt))
+	require.True(t, errors.Is(repo.RevokeToken(ctx, "access", revokedAt), service.ErrInvalidToken))
+	require.True(t, errors.Is(repo.RevokeToken(ctx, "unknown", revokedAt), service.ErrInvalidToken))
+
+	found, err = repo.FindTokenByHash(ctx, "access")
+	require.NoError(t, err)
+	require.NotNil(t, found.RevokedAt)
+	require.True(t, found.RevokedAt.Equal(revokedAt))
+
+	// the token revoked already keeps its revocation time
+	require.NoError(t, repo.RevokeSession(ctx, sessionID, revokedAt.Add(time.Minute)))
+	requireRevokedAt(t, repo, access.Hash, revokedAt)
+	requireRevokedAt(t, repo, refresh.Hash, revokedAt.Add(time.Minute))
+
+	found, err = repo.FindTokenByHash(ctx, other.Hash)
+	require.NoError(t, err)
+	require.Nil(t, found.RevokedAt, "the tokens of other sessions aren't revoked")
+
+	require.NoError(t, repo.RevokeUserTokens(ctx, user.ID, revokedAt.Add(2*time.Minute)))
+	requireRevokedAt(t, repo, other.Hash, revokedAt.Add(2*time.Minute))
+	requireRevokedAt(t, repo, refresh.Hash, revokedAt.Add(time.Minute))
+}
+
+func testReversals(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+
+	breno := createFundedUser(t, repo, "breno", 1000)
+	bruno := createFundedUser(t, repo, "bruno", 0)
+	original := transfer(t, repo, breno, bruno, 100, base.Add(time.Minute))
+
+	found, err := repo.FindTransactionByID(ctx, original.ID)
+	require.NoError(t, err)
+	require.Equal(t, original, normalizeTransaction(found))
+
+	_, err = repo.FindTransactionByID(ctx, uuid.New())
+	require.True(t, errors.Is(err, service.ErrTransactionNotFound))
+
+	sum, err := repo.SumReversals(ctx, original.ID)
+	require.NoError(t, err)
+	require.Equal(t, money.Amount(0), sum)
+
+	var reversal *service.Transaction
+	for i, amount := range []money.Amount{30, 50} {
+		createdAt := base.Add(time.Duration(i+2) * time.Minute)
+		reversal = &service.Transaction{ID: uuid.New(), SourceUserID: bruno.ID, TargetUserID: breno.ID, Amount: amount,
+			Currency: original.Currency, Status: service.TransactionCompleted, CreatedAt: createdAt, UpdatedAt: createdAt,
+			ReversalOf: &original.ID}
+		require.NoError(t, repo.CreateTransaction(ctx, reversal))
+	}
+
+	sum, err = repo.SumReversals(ctx, original.ID)
+	require.NoError(t, err)
+	require.Equal(t, money.Amount(80), sum)
+
+	found, err = repo.FindTransactionByID(ctx, reversal.ID)
+	require.NoError(t, err)
+	require.Equal(t, &original.ID, found.ReversalOf)
+
+	require.NoError(t, repo.CreateClaim(ctx, &service.Claim{ID: uuid.New(), UserID: bruno.ID,
+		TransactionID: reversal.ID, Amount: 30, Currency: reversal.Currency, CreatedAt: base}))
+	require.Error(t, repo.CreateClaim(ctx, &service.Claim{ID: uuid.New(), UserID: bruno.ID,
+		TransactionID: uuid.New(), Amount: 30, Currency: reversal.Currency, CreatedAt: base}),
+		"the transaction of a claim should exist")
+}
+
+func testStatusChanges(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+
+	breno := createFundedUser(t, repo, "breno", 1000)
+	bruno := createFundedUser(t, repo, "bruno", 0)
+
+	transaction := &service.Transaction{ID: uuid.New(), SourceUserID: breno.ID, TargetUserID: bruno.ID, Amount: 100,
+		Currency: service.DefaultCurrency, Status: service.TransactionPending, CreatedAt: base, UpdatedAt: base}
+	require.NoError(t, repo.CreateTransaction(ctx, transaction))
+
+	changes := []service.StatusChange{
+		{TransactionID: transaction.ID, Status: service.TransactionPending, ChangedAt: base},
+		{TransactionID: transaction.ID, Status: service.TransactionFailed, Reason: "insufficient funds",
+			ChangedAt: base.Add(time.Minute)},
+	}
+
+	for i := range changes {
+		require.NoError(t, repo.RecordStatusChange(ctx, &changes[i]))
+	}
+
+	// the transaction takes the status of the latest change, and the reason when it failed
+	found, err := repo.FindTransactionByID(ctx, transaction.ID)
+	require.NoError(t, err)
+	require.Equal(t, service.TransactionFailed, found.Status)
+	require.Equal(t, "insufficient funds", found.FailureReason)
+	require.Equal(t, base.Add(time.Minute), found.UpdatedAt.UTC())
+
+	history, err := repo.ListStatusChanges(ctx, transaction.ID)
+	require.NoError(t, err)
+	for i := range history {
+		history[i].ChangedAt = history[i].ChangedAt.UTC()
+	}
+
+	require.Equal(t, changes, history)
+
+	history, err = repo.ListStatusChanges(ctx, uuid.New())
+	require.NoError(t, err)
+	require.Empty(t, history)
+
+	err = repo.RecordStatusChange(ctx, &service.StatusChange{TransactionID: uuid.New(),
+		Status: service.TransactionCompleted, ChangedAt: base})
+	require.True(t, errors.Is(err, service.ErrTransactionNotFound))
+
+	// a change rolled back leaves the transaction as it was
+	failure := errors.New("failure")
+	err = repo.WithTx(ctx, func(txRepo service.AccountRepository) error {
+		require.NoError(t, txRepo.RecordStatusChange(ctx, &service.StatusChange{TransactionID: transaction.ID,
+			Status: service.TransactionCompleted, ChangedAt: base.Add(2 * time.Minute)}))
+		return failure
+	})
+
+	require.Equal(t, failure, err)
+
+	found, err = repo.FindTransactionByID(ctx, transaction.ID)
+	require.NoError(t, err)
+	require.Equal(t, service.TransactionFailed, found.Status)
+
+	history, err = repo.ListStatusChanges(ctx, transaction.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+
+	// the failed transaction is in the statements of both users, without a balance as it didn't move money
+	completed := transfer(t, repo, breno, bruno, 50, base.Add(3*time.Minute))
+	normalizeTransaction(found)
+
+	for user, expected := range map[*service.User][]service.StatementEntry{
+		breno: {
+			{Transaction: *found, Direction: service.DirectionOutgoing, CounterpartyID: bruno.ID,
+				CounterpartyUserName: bruno.UserName},
+			statementEntry(completed, service.DirectionOutgoing, bruno, 950),
+		},
+		bruno: {
+			{Transaction: *found, Direction: service.DirectionIncoming, CounterpartyID: breno.ID,
+				CounterpartyUserName: breno.UserName},
+			statementEntry(completed, service.DirectionIncoming, breno, 50),
+		},
+	} {
+		entries, err := repo.ListTransactionsByUserID(ctx, user.ID,
+			service.TransactionFilter{Limit: 10, Direction: service.DirectionAll})
+		require.NoError(t, err)
+		require.Equal(t, expected, normalizeEntries(entries), "statement of %s", user.UserName)
+	}
+}
+
+func testHolds(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+
+	breno := createFundedUser(t, repo, "breno", 1000)
+	bruno := createFundedUser(t, repo, "bruno", 0)
+
+	hold := func(amount money.Amount, currency money.Currency, expiresAt time.Time) *service.Hold {
+		hold := &service.Hold{ID: uuid.New(), UserID: breno.ID, TargetUserID: bruno.ID, Amount: amount,
+			Currency: currency, Status: service.HoldActive, ExpiresAt: expiresAt, CreatedAt: base, UpdatedAt: base}
+		require.NoError(t, repo.CreateHold(ctx, hold))
+		return hold
+	}
+
+	active := hold(100, money.BRL, base.Add(time.Hour))
+	longer := hold(200, money.BRL, base.Add(2*time.Hour))
+	shorter := hold(400, money.BRL, base.Add(time.Minute))
+	inUSD := hold(800, money.USD, base.Add(time.Hour))
+
+	found, err := repo.FindHoldByID(ctx, active.ID)
+	require.NoError(t, err)
+	require.Equal(t, active, normalizeHold(found))
+
+	_, err = repo.FindHoldByID(ctx, uuid.New())
+	require.True(t, errors.Is(err, service.ErrHoldNotFound))
+
+	// the holds are only summed until they expire, and only with the holds in the same currency
+	for at, expected := range map[time.Time]money.Amount{
+		base:                    700,
+		base.Add(time.Minute):   300,
+		base.Add(time.Hour):     200,
+		base.Add(2 * time.Hour): 0,
+	} {
+		sum, err := repo.SumActiveHolds(ctx, breno.ID, money.BRL, at)
+		require.NoError(t, err)
+		require.Equal(t, expected, sum, "sum at %s", at)
+	}
+
+	sum, err := repo.SumActiveHolds(ctx, breno.ID, money.USD, base)
+	require.NoError(t, err)
+	require.Equal(t, money.Amount(800), sum)
+
+	sum, err = repo.SumActiveHolds(ctx, bruno.ID, money.BRL, base)
+	require.NoError(t, err)
+	require.Equal(t, money.Amount(0), sum)
+
+	captured := transfer(t, repo, breno, bruno, 60, base.Add(time.Minute))
+	active.Status = service.HoldCaptured
+	active.CapturedAmount = 60
+	active.TransactionID = &captured.ID
+	active.UpdatedAt = base.Add(time.Minute)
+	require.NoError(t, repo.UpdateHold(ctx, active))
+
+	found, err = repo.FindHoldByID(ctx, active.ID)
+	require.NoError(t, err)
+	require.Equal(t, active, normalizeHold(found))
+
+	sum, err = repo.SumActiveHolds(ctx, breno.ID, money.BRL, base)
+	require.NoError(t, err)
+	require.Equal(t, money.Amount(600), sum)
+
+	// only the active holds past their expiration are expired, as of their expiration
+	require.NoError(t, repo.ExpireHolds(ctx, base.Add(time.Hour)))
+
+	for _, expired := range []*service.Hold{shorter, inUSD} {
+		expired.Status = service.HoldExpired
+		expired.UpdatedAt = expired.ExpiresAt
+	}
+
+	for _, expected := range []*service.Hold{active, longer, shorter, inUSD} {
+		found, err = repo.FindHoldByID(ctx, expected.ID)
+		require.NoError(t, err)
+		require.Equal(t, expected, normalizeHold(found))
+	}
+
+	err = repo.UpdateHold(ctx, &service.Hold{ID: uuid.New(), Status: service.HoldVoided, UpdatedAt: base})
+	require.True(t, errors.Is(err, service.ErrHoldNotFound))
+
+	require.Error(t, repo.CreateHold(ctx, &service.Hold{ID: uuid.New(), UserID: breno.ID, TargetUserID: uuid.New(),
+		Amount: 10, Currency: money.BRL, Status: service.HoldActive, ExpiresAt: base, CreatedAt: base,
+		UpdatedAt: base}),
+		"the target of a hold should exist")
+}
+
+func testScheduledTransfers(t *testing.T, repo Repository, _ service.LedgerRepository) {
+	ctx := context.Background()
+
+	breno := createFundedUser(t, repo, "breno", 1000)
+	bruno := createFundedUser(t, repo, "bruno", 0)
+
+	schedule := func(createdAt time.Time, nextAttemptAt time.Time,
+		status service.ScheduleStatus) *service.ScheduledTransfer {
+
+		schedule := &service.ScheduledTransfer{ID: uuid.New(), UserID: breno.ID, TargetUserID: bruno.ID, Amount: 100,
+			Currency: service.DefaultCurrency, Recurrence: service.Recurrence{Frequency: service.FrequencyMonthly,
+				DayOfMonth: 31}, Status: status, NextRunAt: nextAttemptAt, NextAttemptAt: nextAttemptAt,
+			CreatedAt: createdAt, UpdatedAt: createdAt}
+		require.NoError(t, repo.CreateScheduledTransfer(ctx, schedule))
+		return schedule
+	}
+
+	later := schedule(base.Add(time.Second), base.Add(2*time.Hour), service.ScheduleActive)
+	earlier := schedule(base, base.Add(time.Hour), service.ScheduleActive)
+	schedule(base.Add(2*time.Second), base, service.ScheduleCancelled)
+
+	found, err := repo.FindScheduledTransferByID(ctx, earlier.ID)
+	require.NoError(t, err)
+	require.Equal(t, earlier, normalizeScheduledTransfer(found))
+
+	_, err = repo.FindScheduledTransferByID(ctx, uuid.New())
+	require.True(t, errors.Is(err, service.ErrScheduledTransferNotFound))
+
+	schedules, err := repo.ListScheduledTransfersByUserID(ctx, breno.ID)
+	require.NoError(t, err)
+	require.Len(t, schedules, 3)
+	require.Equal(t, []uuid.UUID{earlier.ID, later.ID}, []uuid.UUID{schedules[0].ID, schedules[1].ID})
+
+	schedules, err = repo.ListScheduledTransfersByUserID(ctx, bruno.ID)
+	require.NoError(t, err)
+	require.Empty(t, schedules)
+
+	// only the active schedules are claimed, the earliest attempt first
+	claimed, err := repo.ClaimDueScheduledTransfer(ctx, base.Add(time.Minute))
+	require.NoError(t, err)
+	require.Nil(t, claimed)
+
+	claimed, err = repo.ClaimDueScheduledTransfer(ctx, base.Add(3*time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	require.Equal(t, earlier.ID, claimed.ID)
+
+	made := transfer(t, repo, breno, bruno, 100, base.Add(time.Hour))
+	earlier.NextRunAt = base.Add(24 * time.Hour)
+	earlier.NextAttemptAt = base.Add(24 * time.Hour)
+	earlier.Attempts = 2
+	earlier.UpdatedAt = base.Add(time.Hour)
+	require.NoError(t, repo.UpdateScheduledTransfer(ctx, earlier))
+
+	found, err = repo.FindAndLockScheduledTransferByID(ctx, earlier.ID)
+	require.NoError(t, err)
+	require.Equal(t, earlier, normalizeScheduledTransfer(found))
+
+	claimed, err = repo.ClaimDueScheduledTransfer(ctx, base.Add(3*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, later.ID, claimed.ID)
+
+	err = repo.UpdateScheduledTransfer(ctx, &service.ScheduledTransfer{ID: uuid.New(), Status: service.ScheduleFailed,
+		NextRunAt: base, NextAttemptAt: base, UpdatedAt: base})
+	require.True(t, errors.Is(err, service.ErrScheduledTransferNotFound))
+
+	executions := []service.ScheduleExecution{
+		{ID: uuid.New(), ScheduleID: earlier.ID, RunAt: base.Add(time.Hour), Attempt: 1,
+			Status: service.ExecutionRetrying, ErrorCode: "insufficient_funds", ExecutedAt: base.Add(time.Hour)},
+		{ID: uuid.New(), ScheduleID: earlier.ID, RunAt: base.Add(time.Hour), Attempt: 2,
+			Status: service.ExecutionSucceeded, TransactionID: &made.ID, ExecutedAt: base.Add(2 * time.Hour)},
+	}
+
+	for i := range executions {
+		require.NoError(t, repo.CreateScheduleExecution(ctx, &executions[i]))
+	}
+
+	listed, err := repo.ListScheduleExecutions(ctx, earlier.ID)
+	require.NoError(t, err)
+	require.Equal(t, executions, normalizeScheduleExecutions(listed))
+
+	listed, err = repo.ListScheduleExecutions(ctx, later.ID)
+	require.NoError(t, err)
+	require.Empty(t, listed)
+
+	require.Error(t, repo.CreateScheduleExecution(ctx, &service.ScheduleExecution{ID: uuid.New(),
+		ScheduleID: uuid.New(), RunAt: base, Attempt: 1, Status: service.ExecutionFailed, ExecutedAt: base}),
+		"the scheduled transfer of an execution should exist")
+
+	require.Error(t, repo.CreateScheduledTransfer(ctx, &service.ScheduledTransfer{ID: uuid.New(), UserID: breno.ID,
+		TargetUserID: uuid.New(), Amount: 10, Currency: service.DefaultCurrency,
+		Recurrence: service.Recurrence{Frequency: service.FrequencyOnce}, Status: service.ScheduleActive,
+		NextRunAt: base, NextAttemptAt: base, CreatedAt: base, UpdatedAt: base}),
+		"the target of a scheduled transfer should exist")
+}
+
+func testLedger(t *testing.T, repo Repository, ledger service.LedgerRepository) {
+	ctx := context.Background()
+
+	breno := createFundedUser(t, repo, "breno", 1000)
+	bruno := createFundedUser(t, repo, "bruno", 500)
+	transfer(t, repo, breno, bruno, 100, base.Add(time.Minute))
+
+	sums, err := ledger.SumPostings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[money.Currency]money.Amount{money.BRL: 0}, sums)
+
+	unbalanced, err := ledger.ListUnbalancedEntries(ctx)
+	require.NoError(t, err)
+	require.Empty(t, unbalanced)
+
+	mismatches, err := ledger.ListBalanceMismatches(ctx)
+	require.NoError(t, err)
+	require.Empty(t, mismatches)
+
+	entryID := uuid.New()
+	require.NoError(t, repo.CreatePostings(ctx, []service.Posting{
+		{EntryID: entryID, AccountID: service.ExternalAccountID, Amount: -10, Currency: money.BRL, CreatedAt: base},
+	}))
+
+	// amounts in different currencies don't offset each other
+	exchangeID := uuid.New()
+	require.NoError(t, repo.CreatePostings(ctx, []service.Posting{
+		{EntryID: exchangeID, AccountID: breno.ID, Amount: -10, Currency: money.BRL, CreatedAt: base},
+		{EntryID: exchangeID, AccountID: bruno.ID, Amount: 10, Currency: money.USD, CreatedAt: base},
+	}))
+
+	sums, err = ledger.SumPostings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[money.Currency]money.Amount{money.BRL: -20, money.USD: 10}, sums)
+
+	unbalanced, err = ledger.ListUnbalancedEntries(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uuid.UUID{entryID, exchangeID}, unbalanced)
+}
+
+func testLedgerBalanceMismatch(t *testing.T, repo Repository, ledger service.LedgerRepository) {
+	ctx := context.Background()
+
+	user := createFundedUser(t, repo, "breno", 1000)
+	createWallet(t, repo, user, money.USD, 1000)
+	require.NoError(t, repo.UpdateWalletBalance(ctx, user.ID, money.USD, 1500))
+
+	mismatches, err := ledger.ListBalanceMismatches(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []service.BalanceMismatch{{UserID: user.ID, Currency: money.USD, CachedBalance: 1500,
+		LedgerBalance: 1000}}, mismatches)
+}
+
+// createFundedUser creates a user with an opening balance in the default currency funded by the external account
+func createFundedUser(t *testing.T, repo Repository, userName string, balance money.Amount) *service.User {
+	user := &service.User{ID: uuid.New(), UserName: userName, PasswordHash: "hash"}
+
+	require.NoError(t, repo.CreateUser(context.Background(), user))
+	createWallet(t, repo, user, service.DefaultCurrency, balance)
+	return user
+}
+
+// createWallet opens a wallet for the user with an opening balance funded by the external account
+func createWallet(t *testing.T, repo Repository, user *service.User, currency money.Currency, balance money.Amount) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateWallet(ctx, &service.Wallet{UserID: user.ID, Currency: currency, Balance: balance}))
+	if balance != 0 {
+		require.NoError(t, repo.CreatePostings(ctx, []service.Posting{
+			{EntryID: user.ID, AccountID: service.ExternalAccountID, Amount: -balance, Currency: currency,
+				CreatedAt: base},
+			{EntryID: user.ID, AccountID: user.ID, Amount: balance, Currency: currency, CreatedAt: base},
+		}))
+	}
+}
+
+// transfer moves money in the default currency between users, see transferIn
+func transfer(t *testing.T, repo Repository, source *service.User, target *service.User, amount money.Amount,
+	createdAt time.Time) *service.Transaction {
+
+	return transferIn(t, repo, source, target, amount, service.DefaultCurrency, createdAt)
+}
+
+// transferIn moves money in the currency between users the way the account service does, keeping the cached balances
+// of their wallets up to date
+func transferIn(t *testing.T, repo Repository, source *service.User, target *service.User, amount money.Amount,
+	currency money.Currency, createdAt time.Time) *service.Transaction {
+
+	ctx := context.Background()
+	transaction := &service.Transaction{ID: uuid.New(), SourceUserID: source.ID, TargetUserID: target.ID,
+		Amount: amount, Currency: currency, Status: service.TransactionCompleted, CreatedAt: createdAt,
+		UpdatedAt: createdAt}
+
+	err := repo.WithTx(ctx, func(txRepo service.AccountRepository) error {
+		for _, change := range []struct {
+			user   *service.User
+			amount money.Amount
+		}{{source, -amount}, {target, amount}} {
+			if _, err := txRepo.FindAndLockUserByID(ctx, change.user.ID); err != nil {
+				return err
+			}
+
+			wallet, err := txRepo.FindWallet(ctx, change.user.ID, currency)
+			if err != nil {
+				return err
+			}
+
+			if err := txRepo.UpdateWalletBalance(ctx, wallet.UserID, currency, wallet.Balance+change.amount); err != nil {
+				return err
+			}
+		}
+
+		if err := txRepo.CreateTransaction(ctx, transaction); err != nil {
+			return err
+		}
+
+		return txRepo.CreatePostings(ctx, transferPostings(transaction))
+	})
+
+	require.NoError(t, err)
+	return transaction
+}
+
+// transferPostings returns the balanced postings of a transfer
+func transferPostings(transaction *service.Transaction) []service.Posting {
+	return []service.Posting{
+		{EntryID: transaction.ID, AccountID: transaction.SourceUserID, Amount: -transaction.Amount,
+			Currency: transaction.Currency, CreatedAt: transaction.CreatedAt},
+		{EntryID: transaction.ID, AccountID: transaction.TargetUserID, Amount: transaction.Amount,
+			Currency: transaction.Currency, CreatedAt: transaction.CreatedAt},
+	}
+}
+
+func statementEntry(transaction *service.Transaction, direction service.Direction, counterparty *service.User,
+	balanceAfter money.Amount) service.StatementEntry {
+
+	return service.StatementEntry{
+		Transaction:          *transaction,
+		Direction:            direction,
+		CounterpartyID:       counterparty.ID,
+		CounterpartyUserName: counterparty.UserName,
+		BalanceAfter:         &balanceAfter,
+	}
+}
+
+// normalizeEntries puts the times of the entries in UTC, so they can be compared regardless of their location
+func normalizeEntries(entries []service.StatementEntry) []service.StatementEntry {
+	for i := range entries {
+		normalizeTransaction(&entries[i].Transaction)
+	}
+
+	return entries
+}
+
+// normalizeTransaction puts the times of the transaction in UTC, so it can be compared regardless of their location
+func normalizeTransaction(transaction *service.Transaction) *service.Transaction {
+	transaction.CreatedAt = transaction.CreatedAt.UTC()
+	transaction.UpdatedAt = transaction.UpdatedAt.UTC()
+	return transaction
+}
+
+// normalizeToken puts the times of the token in UTC, so it can be compared regardless of their location
+func normalizeToken(token *service.Token) *service.Token {
+	token.CreatedAt = token.CreatedAt.UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	if token.RevokedAt != nil {
+		revokedAt := token.RevokedAt.UTC()
+		token.RevokedAt = &revokedAt
+	}
+
+	return token
+}
+
+// normalizeHold puts the times of the hold in UTC, so it can be compared regardless of their location
+func normalizeHold(hold *service.Hold) *service.Hold {
+	hold.ExpiresAt = hold.ExpiresAt.UTC()
+	hold.CreatedAt = hold.CreatedAt.UTC()
+	hold.UpdatedAt = hold.UpdatedAt.UTC()
+	return hold
+}
+
+// normalizeScheduledTransfer puts the times of the scheduled transfer in UTC, so it can be compared regardless of their
+// location
+func normalizeScheduledTransfer(schedule *service.ScheduledTransfer) *service.ScheduledTransfer {
+	schedule.NextRunAt = schedule.NextRunAt.UTC()
+	schedule.NextAttemptAt = schedule.NextAttemptAt.UTC()
+	schedule.CreatedAt = schedule.CreatedAt.UTC()
+	schedule.UpdatedAt = schedule.UpdatedAt.UTC()
+	return schedule
+}
+
+// normalizeScheduleExecutions puts the times of the executions in UTC, so they can be compared regardless of their
+// location
+func normalizeScheduleExecutions(executions []service.ScheduleExecution) []service.ScheduleExecution {
+	for i := range executions {
+		executions[i].RunAt = executions[i].RunAt.UTC()
+		executions[i].ExecutedAt = executions[i].ExecutedAt.UTC()
+	}
+
+	return executions
+}
+
+func requireBalance(t *testing.T, repo Repository, userID uuid.UUID, expected money.Amount) {
+	wallet, err := repo.FindWallet(context.Background(), userID, service.DefaultCurrency)
+	require.NoError(t, err)
+	require.Equal(t, expected, wallet.Balance)
+}
+
+func requireRevokedAt(t *testing.T, repo Repository, tokenHash string, expected time.Time) {
+	token, err := repo.FindTokenByHash(context.Background(), tokenHash)
+	require.NoError(t, err)
+	require.NotNil(t, token.RevokedAt)
+	require.True(t, token.RevokedAt.Equal(expected), "revoked at %s instead of %s", token.RevokedAt, expected)
+}
@@ -0,0 +1,25 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/pqutil"
+)
+
+const holdFields = `id, user_id, target_user_id, amount, currency, status, captured_amount, transaction_id, expires_at,
+	created_at, updated_at`
+
+func scanHold(scanner pqutil.Scanner) (*service.Hold, error) {
+	var out service.Hold
+	err := scanner.Scan(&out.ID, &out.UserID, &out.TargetUserID, &out.Amount, &out.Currency, &out.Status,
+		&out.CapturedAmount, &out.TransactionID, &out.ExpiresAt, &out.CreatedAt, &out.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrHoldNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error scanning hold: %w", err)
+	}
+	return &out, nil
+}
@@ -0,0 +1,24 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/pqutil"
+)
+
+const idempotencyRecordFields = `user_id, key, request_hash, response_status, response_body, created_at`
+
+// scanIdempotencyRecord scans a record, returning sql.ErrNoRows untouched so callers can tell a missing key apart
+func scanIdempotencyRecord(scanner pqutil.Scanner) (*service.IdempotencyRecord, error) {
+	var out service.IdempotencyRecord
+	err := scanner.Scan(&out.UserID, &out.Key, &out.RequestHash, &out.ResponseStatus, &out.ResponseBody, &out.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error scanning idempotency record: %w", err)
+	}
+	return &out, nil
+}
@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+	"api-demo/pkg/pqutil"
+)
+
+// LedgerRepository is a persistence repository for the ledger that uses Postgres as its DB
+type LedgerRepository struct {
+	queryer pqutil.Queryer
+}
+
+// NewLedgerRepository creates a postgres repository for the ledger
+func NewLedgerRepository(db *sql.DB) *LedgerRepository {
+	return &LedgerRepository{queryer: db}
+}
+
+func (repo *LedgerRepository) SumPostings(ctx context.Context) (map[money.Currency]money.Amount, error) {
+	const query = `SELECT currency, SUM(amount)::BIGINT FROM postings GROUP BY currency`
+
+	rows, err := repo.queryer.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error summing postings: %w", err)
+	}
+
+	defer rows.Close()
+
+	sums := map[money.Currency]money.Amount{}
+	for rows.Next() {
+		var currency money.Currency
+		var sum money.Amount
+		if err := rows.Scan(&currency, &sum); err != nil {
+			return nil, fmt.Errorf("unexpected error scanning postings sum: %w", err)
+		}
+		sums[currency] = sum
+	}
+
+	return sums, rows.Err()
+}
+
+func (repo *LedgerRepository) ListUnbalancedEntries(ctx context.Context) ([]uuid.UUID, error) {
+	// entries should sum to zero in each of their currencies, amounts in different currencies never offset each other
+	const query = `
+		SELECT DISTINCT entry_id
+		FROM (
+			SELECT entry_id FROM postings GROUP BY entry_id, currency HAVING SUM(amount) <> 0
+		) unbalanced`
+
+	rows, err := repo.queryer.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error listing unbalanced entries: %w", err)
+	}
+
+	defer rows.Close()
+
+	var entries []uuid.UUID
+	for rows.Next() {
+		var entryID uuid.UUID
+		if err := rows.Scan(&entryID); err != nil {
+			return nil, fmt.Errorf("unexpected error scanning entry: %w", err)
+		}
+		entries = append(entries, entryID)
+	}
+
+	return entries, rows.Err()
+}
+
+func (repo *LedgerRepository) ListBalanceMismatches(ctx context.Context) ([]service.BalanceMismatch, error) {
+	const query = `
+		SELECT w.user_id, w.currency, w.balance, COALESCE(SUM(p.amount), 0)::BIGINT
+		FROM wallets w
+		LEFT JOIN postings p ON p.account_id = w.user_id AND p.currency = w.currency
+		GROUP BY w.user_id, w.currency, w.balance
+		HAVING w.balance <> COALESCE(SUM(p.amount), 0)`
+
+	rows, err := repo.queryer.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error listing balance mismatches: %w", err)
+	}
+
+	defer rows.Close()
+	return collectBalanceMismatches(rows)
+}
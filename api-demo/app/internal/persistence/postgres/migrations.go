@@ -0,0 +1,364 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"api-demo/pkg/migrate"
+	"api-demo/pkg/pqutil"
+)
+
+// Migrations are the versioned changes of the schema used by the repositories, new ones are appended with the next
+// version and applied migrations should never be modified
+var Migrations = []migrate.Migration{
+	{Version: 1, Name: "initial_schema", Up: initialSchemaUp, Down: initialSchemaDown},
+	{Version: 2, Name: "reversals", Up: reversalsUp, Down: reversalsDown},
+	{Version: 3, Name: "transaction_status", Up: transactionStatusUp, Down: transactionStatusDown},
+	{Version: 4, Name: "holds", Up: holdsUp, Down: holdsDown},
+	{Version: 5, Name: "scheduled_transfers", Up: scheduledTransfersUp, Down: scheduledTransfersDown},
+	{Version: 6, Name: "currencies", Up: currenciesUp, Down: currenciesDown},
+	{Version: 7, Name: "holds_expiration", Up: holdsExpirationUp, Down: holdsExpirationDown},
+}
+
+// initialSchemaUp creates the schema formerly created by schema.sql, its statements are idempotent so the databases
+// created by it are adopted by the migrations
+const initialSchemaUp = `
+CREATE TABLE IF NOT EXISTS users
+(
+    ID           UUID PRIMARY KEY,
+    username     TEXT   NOT NULL UNIQUE,
+    -- bcrypt hash of the password, never the plaintext
+    password     TEXT   NOT NULL,
+    -- balances and amounts are stored in minor units (cents) to keep them exact
+    balance      BIGINT NOT NULL DEFAULT 0,
+    display_name TEXT   NOT NULL DEFAULT '',
+    email        TEXT   NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS transactions
+(
+    ID             UUID PRIMARY KEY,
+    source_user_id UUID REFERENCES users (ID)  NOT NULL,
+    target_user_id UUID REFERENCES users (ID)  NOT NULL,
+    amount         BIGINT                      NOT NULL CHECK (amount > 0),
+    created_at     TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- transactions are listed per user in the (created_at, id) order, in both directions
+CREATE INDEX IF NOT EXISTS transactions_source_user_id_created_at_idx ON transactions (source_user_id, created_at, ID);
+CREATE INDEX IF NOT EXISTS transactions_target_user_id_created_at_idx ON transactions (target_user_id, created_at, ID);
+
+-- double-entry ledger, every entry (e.g. a transfer, whose entry_id is the transaction ID) has postings summing to zero
+-- and users.balance caches the sum of the postings of each user. account_id isn't a foreign key because the external
+-- account 00000000-0000-0000-0000-000000000001, the source of the money held by users, isn't a user
+CREATE TABLE IF NOT EXISTS postings
+(
+    ID         BIGSERIAL PRIMARY KEY,
+    entry_id   UUID                        NOT NULL,
+    account_id UUID                        NOT NULL,
+    amount     BIGINT                      NOT NULL CHECK (amount <> 0),
+    created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS postings_entry_id_idx ON postings (entry_id);
+-- also serves the running balances of the statements, that sum the postings of a user in their ID order
+CREATE INDEX IF NOT EXISTS postings_account_id_idx ON postings (account_id, ID);
+
+-- opaque tokens issued to logged in users, only their SHA-256 hashes are stored. Tokens issued by a login and by the
+-- refreshes that followed it share the same session
+CREATE TABLE IF NOT EXISTS auth_tokens
+(
+    token_hash TEXT PRIMARY KEY,
+    session_id UUID                        NOT NULL,
+    user_id    UUID REFERENCES users (ID)  NOT NULL,
+    kind       TEXT                        NOT NULL,
+    created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITHOUT TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS auth_tokens_session_id_idx ON auth_tokens (session_id);
+
+-- stores the response of requests made with an Idempotency-Key, so retries can be replayed instead of executed again
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    user_id         UUID REFERENCES users (ID)  NOT NULL,
+    key             TEXT                        NOT NULL,
+    request_hash    TEXT                        NOT NULL,
+    response_status INTEGER                     NOT NULL,
+    response_body   BYTEA                       NOT NULL,
+    created_at      TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, key)
+);
+`
+
+const initialSchemaDown = `
+DROP TABLE idempotency_keys;
+DROP TABLE auth_tokens;
+DROP TABLE postings;
+DROP TABLE transactions;
+DROP TABLE users;
+`
+
+const reversalsUp = `
+-- reversals are transactions in the opposite direction of the one they reverse, the sum of the reversals of a
+-- transaction can't exceed its amount
+ALTER TABLE transactions ADD COLUMN reversal_of UUID REFERENCES transactions (ID);
+
+CREATE INDEX transactions_reversal_of_idx ON transactions (reversal_of) WHERE reversal_of IS NOT NULL;
+
+-- the money owed by users whose balance went negative because of a reversal
+CREATE TABLE claims
+(
+    ID             UUID PRIMARY KEY,
+    user_id        UUID REFERENCES users (ID)        NOT NULL,
+    transaction_id UUID REFERENCES transactions (ID) NOT NULL,
+    amount         BIGINT                            NOT NULL CHECK (amount > 0),
+    created_at     TIMESTAMP WITHOUT TIME ZONE       NOT NULL DEFAULT now()
+);
+
+CREATE INDEX claims_user_id_idx ON claims (user_id);
+`
+
+const reversalsDown = `
+DROP TABLE claims;
+ALTER TABLE transactions DROP COLUMN reversal_of;
+`
+
+const transactionStatusUp = `
+-- the transactions created so far were completed when created, unless they were fully reversed
+ALTER TABLE transactions
+    ADD COLUMN status         TEXT NOT NULL DEFAULT 'completed'
+        CHECK (status IN ('pending', 'completed', 'failed', 'reversed')),
+    ADD COLUMN failure_reason TEXT NOT NULL DEFAULT '',
+    ADD COLUMN updated_at     TIMESTAMP WITHOUT TIME ZONE;
+
+UPDATE transactions SET updated_at = created_at;
+
+UPDATE transactions t
+SET status     = 'reversed',
+    updated_at = r.reversed_at
+FROM (
+    SELECT reversal_of, SUM(amount) AS amount, MAX(created_at) AS reversed_at
+    FROM transactions
+    WHERE reversal_of IS NOT NULL
+    GROUP BY reversal_of
+) r
+WHERE r.reversal_of = t.ID AND r.amount = t.amount;
+
+ALTER TABLE transactions
+    ALTER COLUMN status DROP DEFAULT,
+    ALTER COLUMN updated_at SET NOT NULL;
+
+-- every status a transaction went through, in the order of the IDs
+CREATE TABLE transaction_status_changes
+(
+    ID             BIGSERIAL PRIMARY KEY,
+    transaction_id UUID REFERENCES transactions (ID) NOT NULL,
+    status         TEXT                              NOT NULL,
+    reason         TEXT                              NOT NULL DEFAULT '',
+    changed_at     TIMESTAMP WITHOUT TIME ZONE       NOT NULL
+);
+
+CREATE INDEX transaction_status_changes_transaction_id_idx ON transaction_status_changes (transaction_id, ID);
+
+INSERT INTO transaction_status_changes (transaction_id, status, changed_at)
+SELECT ID, 'completed', created_at
+FROM transactions
+ORDER BY created_at, ID;
+
+INSERT INTO transaction_status_changes (transaction_id, status, changed_at)
+SELECT ID, 'reversed', updated_at
+FROM transactions
+WHERE status = 'reversed'
+ORDER BY updated_at, ID;
+`
+
+const transactionStatusDown = `
+DROP TABLE transaction_status_changes;
+ALTER TABLE transactions DROP COLUMN status, DROP COLUMN failure_reason, DROP COLUMN updated_at;
+`
+
+const holdsUp = `
+-- amounts reserved on the balance of users to be captured by the target, the holds that are active and not expired
+-- are subtracted from the balance to get what's available for transfers
+CREATE TABLE holds
+(
+    ID              UUID PRIMARY KEY,
+    user_id         UUID REFERENCES users (ID)        NOT NULL,
+    target_user_id  UUID REFERENCES users (ID)        NOT NULL,
+    amount          BIGINT                            NOT NULL CHECK (amount > 0),
+    status          TEXT                              NOT NULL
+        CHECK (status IN ('active', 'captured', 'voided', 'expired')),
+    captured_amount BIGINT                            NOT NULL DEFAULT 0 CHECK (captured_amount <= amount),
+    transaction_id  UUID REFERENCES transactions (ID),
+    expires_at      TIMESTAMP WITHOUT TIME ZONE       NOT NULL,
+    created_at      TIMESTAMP WITHOUT TIME ZONE       NOT NULL,
+    updated_at      TIMESTAMP WITHOUT TIME ZONE       NOT NULL
+);
+
+CREATE INDEX holds_user_id_active_idx ON holds (user_id, expires_at) WHERE status = 'active';
+`
+
+const holdsDown = `
+DROP TABLE holds;
+`
+
+const scheduledTransfersUp = `
+-- transfers run by the worker on the dates of their recurrence, next_attempt_at is later than next_run_at while a run
+-- that failed for lack of funds waits to be retried
+CREATE TABLE scheduled_transfers
+(
+    ID              UUID PRIMARY KEY,
+    user_id         UUID REFERENCES users (ID)  NOT NULL,
+    target_user_id  UUID REFERENCES users (ID)  NOT NULL,
+    amount          BIGINT                      NOT NULL CHECK (amount > 0),
+    frequency       TEXT                        NOT NULL
+        CHECK (frequency IN ('once', 'daily', 'weekly', 'monthly')),
+    -- only set for monthly transfers
+    day_of_month    INT                         NOT NULL DEFAULT 0 CHECK (day_of_month BETWEEN 0 AND 31),
+    status          TEXT                        NOT NULL
+        CHECK (status IN ('active', 'completed', 'cancelled', 'failed')),
+    next_run_at     TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    next_attempt_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    attempts        INT                         NOT NULL DEFAULT 0,
+    created_at      TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    updated_at      TIMESTAMP WITHOUT TIME ZONE NOT NULL
+);
+
+CREATE INDEX scheduled_transfers_user_id_idx ON scheduled_transfers (user_id, created_at);
+CREATE INDEX scheduled_transfers_due_idx ON scheduled_transfers (next_attempt_at) WHERE status = 'active';
+
+-- the history of the attempts of running the scheduled transfers
+CREATE TABLE schedule_executions
+(
+    ID             UUID PRIMARY KEY,
+    schedule_id    UUID REFERENCES scheduled_transfers (ID) NOT NULL,
+    run_at         TIMESTAMP WITHOUT TIME ZONE              NOT NULL,
+    attempt        INT                                      NOT NULL,
+    status         TEXT                                     NOT NULL
+        CHECK (status IN ('succeeded', 'retrying', 'failed')),
+    transaction_id UUID REFERENCES transactions (ID),
+    error_code     TEXT                                     NOT NULL DEFAULT '',
+    executed_at    TIMESTAMP WITHOUT TIME ZONE              NOT NULL
+);
+
+CREATE INDEX schedule_executions_schedule_id_idx ON schedule_executions (schedule_id, executed_at);
+`
+
+const scheduledTransfersDown = `
+DROP TABLE schedule_executions;
+DROP TABLE scheduled_transfers;
+`
+
+const currenciesUp = `
+-- the balances of the users per ISO 4217 currency, in the minor units of the currency. They replace users.balance,
+-- which becomes the wallet in BRL, the currency of every amount from before, and cache the sum of the postings of each
+-- user in the currency
+CREATE TABLE wallets
+(
+    user_id  UUID REFERENCES users (ID) NOT NULL,
+    currency CHAR(3)                    NOT NULL,
+    balance  BIGINT                     NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, currency)
+);
+
+INSERT INTO wallets (user_id, currency, balance)
+SELECT ID, 'BRL', balance
+FROM users;
+
+ALTER TABLE users DROP COLUMN balance;
+
+ALTER TABLE transactions ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'BRL';
+ALTER TABLE postings ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'BRL';
+ALTER TABLE claims ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'BRL';
+ALTER TABLE holds ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'BRL';
+ALTER TABLE scheduled_transfers ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'BRL';
+
+ALTER TABLE transactions ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE postings ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE claims ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE holds ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE scheduled_transfers ALTER COLUMN currency DROP DEFAULT;
+
+-- the active holds are summed per user and currency
+DROP INDEX holds_user_id_active_idx;
+CREATE INDEX holds_user_id_active_idx ON holds (user_id, currency, expires_at) WHERE status = 'active';
+`
+
+// currenciesDown keeps only the balances in BRL, the amounts in other currencies are lost
+const currenciesDown = `
+DROP INDEX holds_user_id_active_idx;
+CREATE INDEX holds_user_id_active_idx ON holds (user_id, expires_at) WHERE status = 'active';
+
+ALTER TABLE scheduled_transfers DROP COLUMN currency;
+ALTER TABLE holds DROP COLUMN currency;
+ALTER TABLE claims DROP COLUMN currency;
+ALTER TABLE postings DROP COLUMN currency;
+ALTER TABLE transactions DROP COLUMN currency;
+
+ALTER TABLE users ADD COLUMN balance BIGINT NOT NULL DEFAULT 0;
+
+UPDATE users u
+SET balance = w.balance
+FROM wallets w
+WHERE w.user_id = u.ID AND w.currency = 'BRL';
+
+DROP TABLE wallets;
+`
+
+const holdsExpirationUp = `
+-- the active holds past their expiration are looked up by the job that expires them
+CREATE INDEX holds_expires_at_active_idx ON holds (expires_at) WHERE status = 'active';
+`
+
+const holdsExpirationDown = `
+DROP INDEX holds_expires_at_active_idx;
+`
+
+// seedSQL creates the demo users with the opening balances of their wallets, funded by the external account. It's safe
+// to run it more than once, the users that exist already are skipped.
+const seedSQL = `
+-- provides crypt() and gen_salt(), used to hash the passwords of the seeded users with bcrypt
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+WITH seeded AS (
+    INSERT INTO users (ID, username, password)
+    VALUES ('256bea59-c9a7-44d0-bcd8-d710aad69676', 'breno', crypt('1234', gen_salt('bf', 10))),
+           ('c66af437-8536-4ac9-918c-5e73ef95578a', 'bruno', crypt('4321', gen_salt('bf', 10))),
+           ('9e321e7b-918b-4bef-9c85-81b1729b31d9', 'brono', crypt('abcd', gen_salt('bf', 10))),
+           ('007dcaec-6963-4d4c-a40d-9b5eda420f10', 'brano', crypt('abcdef', gen_salt('bf', 10)))
+    ON CONFLICT DO NOTHING
+    RETURNING ID
+),
+seeded_wallets AS (
+    INSERT INTO wallets (user_id, currency, balance)
+    SELECT w.user_id, w.currency, w.balance
+    FROM (
+        VALUES ('256bea59-c9a7-44d0-bcd8-d710aad69676'::UUID, 'BRL', 1000),
+               ('256bea59-c9a7-44d0-bcd8-d710aad69676'::UUID, 'USD', 2000),
+               ('c66af437-8536-4ac9-918c-5e73ef95578a'::UUID, 'BRL', 10000),
+               ('c66af437-8536-4ac9-918c-5e73ef95578a'::UUID, 'USD', 500),
+               ('9e321e7b-918b-4bef-9c85-81b1729b31d9'::UUID, 'BRL', 100000),
+               ('007dcaec-6963-4d4c-a40d-9b5eda420f10'::UUID, 'BRL', 1000000)
+    ) w (user_id, currency, balance)
+    JOIN seeded s ON s.ID = w.user_id
+    RETURNING user_id, currency, balance
+)
+-- the user ID is used as the entry ID of the opening balances, which are balanced in each currency
+INSERT INTO postings (entry_id, account_id, amount, currency)
+SELECT user_id, '00000000-0000-0000-0000-000000000001', -balance, currency
+FROM seeded_wallets
+UNION ALL
+SELECT user_id, user_id, balance, currency
+FROM seeded_wallets;
+`
+
+// Seed creates the demo users, it's meant for development databases only
+func Seed(ctx context.Context, queryer pqutil.Queryer) error {
+	if _, err := queryer.ExecContext(ctx, seedSQL); err != nil {
+		return fmt.Errorf("error seeding the database: %w", err)
+	}
+
+	return nil
+}
@@ -3,11 +3,16 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 
 	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
 	"api-demo/pkg/pqutil"
 )
 
@@ -15,11 +20,83 @@ import (
 type AccountRepository struct {
 	queryer pqutil.Queryer
 	txer    pqutil.Transactioner
+	txOpts  txOptions
+
+	// replica serves the read-only queries that tolerate replication lag, when set
+	replica pqutil.Queryer
+}
+
+// txOptions configures how transactions are started and retried by WithTx
+type txOptions struct {
+	isolation    sql.IsolationLevel
+	maxAttempts  int
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
+}
+
+// Opt is an option that can be passed to NewAccountRepository to configure the repository
+type Opt func(*AccountRepository)
+
+// WithIsolationLevel returns an Opt that sets the isolation level of the transactions started by WithTx
+func WithIsolationLevel(level sql.IsolationLevel) Opt {
+	return func(repo *AccountRepository) {
+		repo.txOpts.isolation = level
+	}
+}
+
+// WithMaxAttempts returns an Opt that sets how many times WithTx runs a transaction that keeps failing with deadlocks
+// or serialization failures
+func WithMaxAttempts(attempts int) Opt {
+	return func(repo *AccountRepository) {
+		repo.txOpts.maxAttempts = attempts
+	}
+}
+
+// WithRetryBackoff returns an Opt that sets the backoff between transaction attempts, it doubles after every attempt
+// until reaching max
+func WithRetryBackoff(initial time.Duration, max time.Duration) Opt {
+	return func(repo *AccountRepository) {
+		repo.txOpts.retryBackoff = initial
+		repo.txOpts.maxBackoff = max
+	}
+}
+
+// WithReadReplica returns an Opt that sends the read-only queries of reports, that tolerate replication lag, like
+// FindUserByIDForReport and ListTransactionsByUserID, to a read replica. Queries within transactions, and the ones that
+// authenticate users or change their data, always go to the primary.
+func WithReadReplica(db *sql.DB) Opt {
+	return func(repo *AccountRepository) {
+		repo.replica = db
+	}
 }
 
 // NewAccountRepository creates a postgres repository for accounts
-func NewAccountRepository(db *sql.DB) *AccountRepository {
-	return &AccountRepository{queryer: db, txer: db}
+func NewAccountRepository(db *sql.DB, opts ...Opt) *AccountRepository {
+	repo := &AccountRepository{
+		queryer: db,
+		txer:    db,
+		txOpts: txOptions{
+			isolation:    sql.LevelReadCommitted,
+			maxAttempts:  5,
+			retryBackoff: 10 * time.Millisecond,
+			maxBackoff:   500 * time.Millisecond,
+		},
+	}
+
+	for _, opt := range opts {
+		opt(repo)
+	}
+
+	return repo
+}
+
+// reader returns the queryer of the read-only queries that tolerate replication lag
+func (repo *AccountRepository) reader() pqutil.Queryer {
+	if repo.replica != nil {
+		return repo.replica
+	}
+
+	return repo.queryer
 }
 
 func (repo *AccountRepository) FindUserByID(ctx context.Context, userID uuid.UUID) (*service.User, error) {
@@ -27,82 +104,634 @@ func (repo *AccountRepository) FindUserByID(ctx context.Context, userID uuid.UUI
 	return scanUser(repo.queryer.QueryRowContext(ctx, query, userID))
 }
 
-func (repo *AccountRepository) ListTransactionsByUserID(ctx context.Context, userID uuid.UUID) ([]service.Transaction, error) {
-	const query = `SELECT ` + transactionFields + ` FROM transactions WHERE source_user_id = $1`
+func (repo *AccountRepository) FindUserByIDForReport(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+	const query = `SELECT ` + userFields + ` FROM users WHERE id = $1`
+	return scanUser(repo.reader().QueryRowContext(ctx, query, userID))
+}
 
-	rows, err := repo.queryer.QueryContext(ctx, query,
-		userID,
-	)
+func (repo *AccountRepository) ListTransactionsByUserID(ctx context.Context, userID uuid.UUID, filter service.TransactionFilter) ([]service.StatementEntry, error) {
+	where, args := transactionFilterConditions(userID, filter)
+
+	// the running balance is the sum of the postings of the user in the currency in the order they were written to the
+	// ledger, it's calculated over the whole history so it's correct regardless of the filters. The transactions that
+	// didn't move money, like the failed ones, have no postings and no running balance.
+	query := `SELECT ` + statementEntryFields + `
+		FROM transactions t
+		JOIN users c ON c.id = CASE WHEN t.source_user_id = $1 THEN t.target_user_id ELSE t.source_user_id END
+		LEFT JOIN (
+			SELECT entry_id, (SUM(amount) OVER (PARTITION BY currency ORDER BY id))::BIGINT AS balance_after
+			FROM postings
+			WHERE account_id = $1
+		) b ON b.entry_id = t.id
+		WHERE ` + where + `
+		ORDER BY t.created_at, t.id
+		LIMIT ` + strconv.Itoa(filter.Limit)
+
+	rows, err := repo.reader().QueryContext(ctx, query, args...)
 
 	if err != nil {
-		return nil, fmt.Errorf("unexpected error listing transactions: %v", err)
+		return nil, fmt.Errorf("unexpected error listing transactions: %w", err)
 	}
 
 	defer rows.Close()
-	transactions, err := collectTransactions(rows)
+	entries, err := collectStatementEntries(rows)
 	if err != nil {
 		return nil, err
 	}
 
-	return transactions, nil
+	return entries, nil
 }
 
 func (repo *AccountRepository) CreateTransaction(ctx context.Context, transaction *service.Transaction) error {
 
-	const insertQuery = `INSERT INTO transactions (` + transactionFields + `) VALUES ($1, $2, $3, $4, $5)`
+	const insertQuery = `INSERT INTO transactions (` + transactionFields + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
 
 	_, err := repo.queryer.ExecContext(ctx, insertQuery,
 		transaction.ID,
 		transaction.SourceUserID,
 		transaction.TargetUserID,
 		transaction.Amount,
+		transaction.Currency,
 		transaction.CreatedAt,
+		transaction.ReversalOf,
+		transaction.Status,
+		transaction.FailureReason,
+		transaction.UpdatedAt,
+	)
+
+	return err
+}
+
+func (repo *AccountRepository) FindTransactionByID(ctx context.Context, transactionID uuid.UUID) (*service.Transaction, error) {
+	const query = `SELECT ` + transactionFields + ` FROM transactions WHERE id = $1`
+	return scanTransaction(repo.queryer.QueryRowContext(ctx, query, transactionID))
+}
+
+func (repo *AccountRepository) SumReversals(ctx context.Context, transactionID uuid.UUID) (money.Amount, error) {
+	const query = `SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE reversal_of = $1`
+
+	var sum money.Amount
+	if err := repo.queryer.QueryRowContext(ctx, query, transactionID).Scan(&sum); err != nil {
+		return 0, fmt.Errorf("unexpected error summing reversals: %w", err)
+	}
+
+	return sum, nil
+}
+
+func (repo *AccountRepository) CreateClaim(ctx context.Context, claim *service.Claim) error {
+
+	const insertQuery = `INSERT INTO claims (` + claimFields + `) VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := repo.queryer.ExecContext(ctx, insertQuery,
+		claim.ID,
+		claim.UserID,
+		claim.TransactionID,
+		claim.Amount,
+		claim.Currency,
+		claim.CreatedAt,
+	)
+
+	return err
+}
+
+func (repo *AccountRepository) RecordStatusChange(ctx context.Context, change *service.StatusChange) error {
+
+	const updateQuery = `UPDATE transactions
+		SET status = $2, updated_at = $3, failure_reason = CASE WHEN $2 = 'failed' THEN $4 ELSE failure_reason END
+		WHERE id = $1`
+
+	result, err := repo.queryer.ExecContext(ctx, updateQuery,
+		change.TransactionID,
+		change.Status,
+		change.ChangedAt,
+		change.Reason,
+	)
+
+	if err != nil {
+		return fmt.Errorf("unexpected error updating the transaction status: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return service.ErrTransactionNotFound
+	}
+
+	const insertQuery = `INSERT INTO transaction_status_changes (` + statusChangeFields + `) VALUES ($1, $2, $3, $4)`
+
+	_, err = repo.queryer.ExecContext(ctx, insertQuery,
+		change.TransactionID,
+		change.Status,
+		change.Reason,
+		change.ChangedAt,
+	)
+
+	return err
+}
+
+func (repo *AccountRepository) ListStatusChanges(ctx context.Context, transactionID uuid.UUID) ([]service.StatusChange, error) {
+	const query = `SELECT ` + statusChangeFields + ` FROM transaction_status_changes WHERE transaction_id = $1 ORDER BY id`
+
+	rows, err := repo.queryer.QueryContext(ctx, query, transactionID)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error listing status changes: %w", err)
+	}
+
+	defer rows.Close()
+	return collectStatusChanges(rows)
+}
+
+func (repo *AccountRepository) CreateHold(ctx context.Context, hold *service.Hold) error {
+
+	const insertQuery = `INSERT INTO holds (` + holdFields + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	_, err := repo.queryer.ExecContext(ctx, insertQuery,
+		hold.ID,
+		hold.UserID,
+		hold.TargetUserID,
+		hold.Amount,
+		hold.Currency,
+		hold.Status,
+		hold.CapturedAmount,
+		hold.TransactionID,
+		hold.ExpiresAt,
+		hold.CreatedAt,
+		hold.UpdatedAt,
+	)
+
+	return err
+}
+
+func (repo *AccountRepository) FindHoldByID(ctx context.Context, holdID uuid.UUID) (*service.Hold, error) {
+	const query = `SELECT ` + holdFields + ` FROM holds WHERE id = $1`
+	return scanHold(repo.queryer.QueryRowContext(ctx, query, holdID))
+}
+
+func (repo *AccountRepository) UpdateHold(ctx context.Context, hold *service.Hold) error {
+
+	const updateQuery = `UPDATE holds SET status = $2, captured_amount = $3, transaction_id = $4, updated_at = $5
+		WHERE id = $1`
+
+	result, err := repo.queryer.ExecContext(ctx, updateQuery,
+		hold.ID,
+		hold.Status,
+		hold.CapturedAmount,
+		hold.TransactionID,
+		hold.UpdatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("unexpected error updating the hold: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return service.ErrHoldNotFound
+	}
+
+	return nil
+}
+
+func (repo *AccountRepository) ExpireHolds(ctx context.Context, at time.Time) error {
+	const query = `UPDATE holds SET status = 'expired', updated_at = expires_at
+		WHERE status = 'active' AND expires_at <= $1`
+
+	if _, err := repo.queryer.ExecContext(ctx, query, at); err != nil {
+		return fmt.Errorf("unexpected error expiring holds: %w", err)
+	}
+
+	return nil
+}
+
+func (repo *AccountRepository) SumActiveHolds(ctx context.Context, userID uuid.UUID, currency money.Currency, at time.Time) (money.Amount, error) {
+	const query = `SELECT COALESCE(SUM(amount), 0) FROM holds
+		WHERE user_id = $1 AND currency = $2 AND status = 'active' AND expires_at > $3`
+
+	var sum money.Amount
+	if err := repo.queryer.QueryRowContext(ctx, query, userID, currency, at).Scan(&sum); err != nil {
+		return 0, fmt.Errorf("unexpected error summing active holds: %w", err)
+	}
+
+	return sum, nil
+}
+
+func (repo *AccountRepository) CreateScheduledTransfer(ctx context.Context, schedule *service.ScheduledTransfer) error {
+
+	const insertQuery = `INSERT INTO scheduled_transfers (` + scheduledTransferFields + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+
+	_, err := repo.queryer.ExecContext(ctx, insertQuery,
+		schedule.ID,
+		schedule.UserID,
+		schedule.TargetUserID,
+		schedule.Amount,
+		schedule.Currency,
+		schedule.Recurrence.Frequency,
+		schedule.Recurrence.DayOfMonth,
+		schedule.Status,
+		schedule.NextRunAt,
+		schedule.NextAttemptAt,
+		schedule.Attempts,
+		schedule.CreatedAt,
+		schedule.UpdatedAt,
+	)
+
+	return err
+}
+
+func (repo *AccountRepository) FindScheduledTransferByID(ctx context.Context, scheduleID uuid.UUID) (*service.ScheduledTransfer, error) {
+	const query = `SELECT ` + scheduledTransferFields + ` FROM scheduled_transfers WHERE id = $1`
+	return scanScheduledTransfer(repo.queryer.QueryRowContext(ctx, query, scheduleID))
+}
+
+func (repo *AccountRepository) FindAndLockScheduledTransferByID(ctx context.Context, scheduleID uuid.UUID) (*service.ScheduledTransfer, error) {
+	const query = `SELECT ` + scheduledTransferFields + ` FROM scheduled_transfers WHERE id = $1 FOR UPDATE`
+	return scanScheduledTransfer(repo.queryer.QueryRowContext(ctx, query, scheduleID))
+}
+
+func (repo *AccountRepository) ListScheduledTransfersByUserID(ctx context.Context, userID uuid.UUID) ([]service.ScheduledTransfer, error) {
+	const query = `SELECT ` + scheduledTransferFields + ` FROM scheduled_transfers WHERE user_id = $1
+		ORDER BY created_at, id`
+
+	rows, err := repo.queryer.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error listing scheduled transfers: %w", err)
+	}
+
+	defer rows.Close()
+	return collectScheduledTransfers(rows)
+}
+
+func (repo *AccountRepository) ClaimDueScheduledTransfer(ctx context.Context, at time.Time) (*service.ScheduledTransfer, error) {
+	// the schedules being run by other instances are skipped instead of waited for, so each one is run by a single
+	// instance at a time
+	const query = `SELECT ` + scheduledTransferFields + ` FROM scheduled_transfers
+		WHERE status = 'active' AND next_attempt_at <= $1
+		ORDER BY next_attempt_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`
+
+	schedule, err := scanScheduledTransfer(repo.queryer.QueryRowContext(ctx, query, at))
+	if errors.Is(err, service.ErrScheduledTransferNotFound) {
+		return nil, nil
+	}
+
+	return schedule, err
+}
+
+func (repo *AccountRepository) UpdateScheduledTransfer(ctx context.Context, schedule *service.ScheduledTransfer) error {
+
+	const updateQuery = `UPDATE scheduled_transfers
+		SET status = $2, next_run_at = $3, next_attempt_at = $4, attempts = $5, updated_at = $6
+		WHERE id = $1`
+
+	result, err := repo.queryer.ExecContext(ctx, updateQuery,
+		schedule.ID,
+		schedule.Status,
+		schedule.NextRunAt,
+		schedule.NextAttemptAt,
+		schedule.Attempts,
+		schedule.UpdatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("unexpected error updating the scheduled transfer: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return service.ErrScheduledTransferNotFound
+	}
+
+	return nil
+}
+
+func (repo *AccountRepository) CreateScheduleExecution(ctx context.Context, execution *service.ScheduleExecution) error {
+
+	const insertQuery = `INSERT INTO schedule_executions (` + scheduleExecutionFields + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := repo.queryer.ExecContext(ctx, insertQuery,
+		execution.ID,
+		execution.ScheduleID,
+		execution.RunAt,
+		execution.Attempt,
+		execution.Status,
+		execution.TransactionID,
+		execution.ErrorCode,
+		execution.ExecutedAt,
 	)
 
 	return err
 }
 
+func (repo *AccountRepository) ListScheduleExecutions(ctx context.Context, scheduleID uuid.UUID) ([]service.ScheduleExecution, error) {
+	const query = `SELECT ` + scheduleExecutionFields + ` FROM schedule_executions WHERE schedule_id = $1
+		ORDER BY executed_at, attempt`
+
+	rows, err := repo.queryer.QueryContext(ctx, query, scheduleID)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error listing schedule executions: %w", err)
+	}
+
+	defer rows.Close()
+	return collectScheduleExecutions(rows)
+}
+
 func (repo *AccountRepository) FindAndLockUserByID(ctx context.Context, userID uuid.UUID) (*service.User, error) {
 	const query = `SELECT ` + userFields + ` FROM users WHERE id = $1 FOR UPDATE`
 	return scanUser(repo.queryer.QueryRowContext(ctx, query, userID))
 }
 
-func (repo *AccountRepository) UpdateUserBalance(ctx context.Context, userID uuid.UUID, newBalance float64) error {
+func (repo *AccountRepository) CreatePostings(ctx context.Context, postings []service.Posting) error {
+
+	const insertQuery = `INSERT INTO postings (` + postingFields + `) VALUES ($1, $2, $3, $4, $5)`
+
+	for _, posting := range postings {
+		_, err := repo.queryer.ExecContext(ctx, insertQuery,
+			posting.EntryID,
+			posting.AccountID,
+			posting.Amount,
+			posting.Currency,
+			posting.CreatedAt,
+		)
+
+		if err != nil {
+			return fmt.Errorf("unexpected error creating posting: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (repo *AccountRepository) CreateWallet(ctx context.Context, wallet *service.Wallet) error {
+
+	const insertQuery = `INSERT INTO wallets (` + walletFields + `) VALUES ($1, $2, $3)`
+
+	_, err := repo.queryer.ExecContext(ctx, insertQuery,
+		wallet.UserID,
+		wallet.Currency,
+		wallet.Balance,
+	)
+
+	return mapWalletError(err)
+}
+
+func (repo *AccountRepository) FindWallet(ctx context.Context, userID uuid.UUID, currency money.Currency) (*service.Wallet, error) {
+	const query = `SELECT ` + walletFields + ` FROM wallets WHERE user_id = $1 AND currency = $2`
+	return scanWallet(repo.queryer.QueryRowContext(ctx, query, userID, currency))
+}
+
+func (repo *AccountRepository) ListWalletsByUserID(ctx context.Context, userID uuid.UUID) ([]service.Wallet, error) {
+	const query = `SELECT ` + walletFields + ` FROM wallets WHERE user_id = $1 ORDER BY currency`
+
+	rows, err := repo.reader().QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error listing wallets: %w", err)
+	}
+
+	defer rows.Close()
+	return collectWallets(rows)
+}
+
+func (repo *AccountRepository) UpdateWalletBalance(ctx context.Context, userID uuid.UUID, currency money.Currency, newBalance money.Amount) error {
 
-	const updateQuery = `UPDATE users SET balance = $2 WHERE ID = $1`
+	const updateQuery = `UPDATE wallets SET balance = $3 WHERE user_id = $1 AND currency = $2`
 
 	_, err := repo.queryer.ExecContext(ctx, updateQuery,
 		userID,
+		currency,
 		newBalance,
 	)
 
 	return err
 }
 
+func (repo *AccountRepository) FindIdempotencyRecord(ctx context.Context, userID uuid.UUID, key string) (*service.IdempotencyRecord, error) {
+	const query = `SELECT ` + idempotencyRecordFields + ` FROM idempotency_keys WHERE user_id = $1 AND key = $2`
+
+	record, err := scanIdempotencyRecord(repo.queryer.QueryRowContext(ctx, query, userID, key))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return record, err
+}
+
+func (repo *AccountRepository) CreateIdempotencyRecord(ctx context.Context, record *service.IdempotencyRecord) error {
+
+	const insertQuery = `INSERT INTO idempotency_keys (` + idempotencyRecordFields + `) VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := repo.queryer.ExecContext(ctx, insertQuery,
+		record.UserID,
+		record.Key,
+		record.RequestHash,
+		record.ResponseStatus,
+		record.ResponseBody,
+		record.CreatedAt,
+	)
+
+	if pqutil.IsUniqueViolation(err) {
+		return service.ErrIdempotencyKeyInProgress
+	}
+
+	return err
+}
+
+// WithTx runs the function inside a transaction, retrying it with backoff when Postgres aborts it because of a deadlock
+// or a serialization failure, so the function must be safe to be executed more than once
 func (repo *AccountRepository) WithTx(ctx context.Context, transactionedFunction func(repository service.AccountRepository) error) error {
-	tx, err := repo.txer.BeginTx(ctx, nil)
+	return repo.withTx(ctx, func(txRepo *AccountRepository) error {
+		return transactionedFunction(txRepo)
+	})
+}
+
+// withTx implements WithTx, it's shared by the repositories built on AccountRepository
+func (repo *AccountRepository) withTx(ctx context.Context, transactionedFunction func(repository *AccountRepository) error) error {
+
+	// the repository is already transactioned, so the function simply joins the ongoing transaction
+	if repo.txer == nil {
+		return transactionedFunction(repo)
+	}
+
+	backoff := repo.txOpts.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := repo.runTx(ctx, transactionedFunction)
+		if err == nil || !pqutil.IsRetryable(err) || attempt >= repo.txOpts.maxAttempts {
+			return err
+		}
+
+		// the jitter avoids that the transactions that conflicted retry at the same time and conflict again
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		if backoff *= 2; backoff > repo.txOpts.maxBackoff {
+			backoff = repo.txOpts.maxBackoff
+		}
+	}
+}
+
+// runTx runs a single attempt of a transaction
+func (repo *AccountRepository) runTx(ctx context.Context, transactionedFunction func(repository *AccountRepository) error) error {
+	tx, err := repo.txer.BeginTx(ctx, &sql.TxOptions{Isolation: repo.txOpts.isolation})
 	if err != nil {
-		return fmt.Errorf("error beginning transaction: %v", err)
+		return fmt.Errorf("error beginning transaction: %w", err)
 	}
 
 	// creates a new version of the repo but transactioned
-	err = transactionedFunction(&AccountRepository{queryer: tx, txer: nil})
+	err = transactionedFunction(&AccountRepository{queryer: tx, txer: nil, txOpts: repo.txOpts})
 	if err != nil {
 		if txErr := tx.Rollback(); txErr != nil {
-			panic(fmt.Sprintf("failed to rollback transaction: %v", txErr))
+			return fmt.Errorf("%w (failed to rollback transaction: %v)", err, txErr)
 		}
 
 		return err
 	}
 
 	if err := tx.Commit(); err != nil {
-		panic(fmt.Sprintf("failed to commit transaction: %v", err))
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
-func (repo *AccountRepository) FindUserByCredentials(ctx context.Context, userName string, password string) (*service.User, error) {
-	const query = `SELECT ` + userFields + ` FROM users WHERE username = $1 AND password = $2`
-	return scanUser(repo.queryer.QueryRowContext(ctx, query, userName, password))
+func (repo *AccountRepository) FindUserByUserName(ctx context.Context, userName string) (*service.User, error) {
+	const query = `SELECT ` + userFields + ` FROM users WHERE username = $1`
+	return scanUser(repo.queryer.QueryRowContext(ctx, query, userName))
+}
+
+func (repo *AccountRepository) UpdateUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+
+	const updateQuery = `UPDATE users SET password = $2 WHERE ID = $1`
+
+	result, err := repo.queryer.ExecContext(ctx, updateQuery,
+		userID,
+		passwordHash,
+	)
+
+	if err != nil {
+		return fmt.Errorf("unexpected error updating the password: %w", err)
+	}
+
+	return requireUserUpdated(result)
+}
+
+func (repo *AccountRepository) CreateToken(ctx context.Context, token *service.Token) error {
+
+	const insertQuery = `INSERT INTO auth_tokens (` + tokenFields + `) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := repo.queryer.ExecContext(ctx, insertQuery,
+		token.Hash,
+		token.SessionID,
+		token.UserID,
+		token.Kind,
+		token.CreatedAt,
+		token.ExpiresAt,
+		token.RevokedAt,
+	)
+
+	return err
+}
+
+func (repo *AccountRepository) FindTokenByHash(ctx context.Context, tokenHash string) (*service.Token, error) {
+	const query = `SELECT ` + tokenFields + ` FROM auth_tokens WHERE token_hash = $1`
+	return scanToken(repo.queryer.QueryRowContext(ctx, query, tokenHash))
+}
+
+func (repo *AccountRepository) RevokeToken(ctx context.Context, tokenHash string, revokedAt time.Time) error {
+
+	const updateQuery = `UPDATE auth_tokens SET revoked_at = $2 WHERE token_hash = $1 AND revoked_at IS NULL`
+
+	result, err := repo.queryer.ExecContext(ctx, updateQuery,
+		tokenHash,
+		revokedAt,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return service.ErrInvalidToken
+	}
+
+	return nil
+}
+
+func (repo *AccountRepository) RevokeSession(ctx context.Context, sessionID uuid.UUID, revokedAt time.Time) error {
+
+	const updateQuery = `UPDATE auth_tokens SET revoked_at = $2 WHERE session_id = $1 AND revoked_at IS NULL`
+
+	_, err := repo.queryer.ExecContext(ctx, updateQuery,
+		sessionID,
+		revokedAt,
+	)
+
+	return err
+}
+
+func (repo *AccountRepository) CreateUser(ctx context.Context, user *service.User) error {
+
+	const insertQuery = `INSERT INTO users (` + userFields + `) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := repo.queryer.ExecContext(ctx, insertQuery,
+		user.ID,
+		user.UserName,
+		user.PasswordHash,
+		user.DisplayName,
+		user.Email,
+	)
+
+	return mapUserError(err)
+}
+
+func (repo *AccountRepository) UpdateUserProfile(ctx context.Context, user *service.User) error {
+
+	const updateQuery = `UPDATE users SET username = $2, display_name = $3, email = $4 WHERE ID = $1`
+
+	result, err := repo.queryer.ExecContext(ctx, updateQuery,
+		user.ID,
+		user.UserName,
+		user.DisplayName,
+		user.Email,
+	)
+
+	if err != nil {
+		return mapUserError(err)
+	}
+
+	return requireUserUpdated(result)
+}
+
+func (repo *AccountRepository) RevokeUserTokens(ctx context.Context, userID uuid.UUID, revokedAt time.Time) error {
+
+	const updateQuery = `UPDATE auth_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`
+
+	_, err := repo.queryer.ExecContext(ctx, updateQuery,
+		userID,
+		revokedAt,
+	)
+
+	return err
 }
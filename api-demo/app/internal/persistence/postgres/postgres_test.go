@@ -0,0 +1,98 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	// register the pg driver
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/app/internal/persistence/persistencetest"
+	"api-demo/app/internal/persistence/postgres"
+	"api-demo/app/internal/service"
+	"api-demo/pkg/migrate"
+	"api-demo/pkg/money"
+)
+
+// testDSNEnv is the env var with the DSN of the database the tests run on, they're skipped when it isn't set. The
+// database is migrated and its data is deleted by the tests.
+const testDSNEnv = "API_DEMO_TEST_POSTGRES_DSN"
+
+// newTestDB connects to the migrated test database, skipping the test when it isn't configured
+func newTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s isn't set", testDSNEnv)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+
+	migrator, err := migrate.NewMigrator(db, postgres.Migrations)
+	require.NoError(t, err)
+
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+
+	return db
+}
+
+// truncate deletes the data of the test database
+func truncate(t *testing.T, db *sql.DB) {
+	// CASCADE also empties the tables referencing these, so a table left out of the list can't fail the tests
+	_, err := db.Exec(`TRUNCATE users, wallets, transactions, transaction_status_changes, postings, claims, holds,
+		scheduled_transfers, schedule_executions, auth_tokens, idempotency_keys CASCADE`)
+	require.NoError(t, err)
+}
+
+func TestRepositories(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	persistencetest.RunRepositoryTests(t, func(t *testing.T) (persistencetest.Repository, service.LedgerRepository) {
+		truncate(t, db)
+		return postgres.NewAccountRepository(db), postgres.NewLedgerRepository(db)
+	})
+}
+
+func TestUserRepository_WithTx(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	truncate(t, db)
+
+	ctx := context.Background()
+	repo := postgres.NewUserRepository(postgres.NewAccountRepository(db))
+
+	// the user is rolled back along with its wallet when opening the second wallet in the same currency fails
+	user := &service.User{ID: uuid.New(), UserName: "breno", DisplayName: "Breno"}
+	err := repo.WithTx(ctx, func(txRepo service.UserRepository) error {
+		if err := txRepo.CreateUser(ctx, user); err != nil {
+			return err
+		}
+
+		if err := txRepo.CreateWallet(ctx, &service.Wallet{UserID: user.ID, Currency: money.BRL}); err != nil {
+			return err
+		}
+
+		return txRepo.CreateWallet(ctx, &service.Wallet{UserID: user.ID, Currency: money.BRL})
+	})
+
+	require.True(t, errors.Is(err, service.ErrWalletExists))
+
+	_, err = repo.FindUserByID(ctx, user.ID)
+	require.True(t, errors.Is(err, service.ErrUserNotFound))
+
+	require.NoError(t, repo.WithTx(ctx, func(txRepo service.UserRepository) error {
+		return txRepo.CreateUser(ctx, user)
+	}))
+
+	found, err := repo.FindUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, user, found)
+}
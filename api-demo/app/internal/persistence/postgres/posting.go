@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"fmt"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/pqutil"
+)
+
+const postingFields = `entry_id, account_id, amount, currency, created_at`
+
+func scanBalanceMismatch(scanner pqutil.Scanner) (*service.BalanceMismatch, error) {
+	var out service.BalanceMismatch
+	err := scanner.Scan(&out.UserID, &out.Currency, &out.CachedBalance, &out.LedgerBalance)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error scanning balance mismatch: %w", err)
+	}
+	return &out, nil
+}
+
+func collectBalanceMismatches(scanner pqutil.ScannerIter) ([]service.BalanceMismatch, error) {
+	var mismatches []service.BalanceMismatch
+	for scanner.Next() {
+		mismatch, err := scanBalanceMismatch(scanner)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, *mismatch)
+	}
+	return mismatches, scanner.Err()
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/pqutil"
+)
+
+const scheduledTransferFields = `id, user_id, target_user_id, amount, currency, frequency, day_of_month, status,
+	next_run_at, next_attempt_at, attempts, created_at, updated_at`
+
+const scheduleExecutionFields = `id, schedule_id, run_at, attempt, status, transaction_id, error_code, executed_at`
+
+func collectScheduledTransfers(scanner pqutil.ScannerIter) ([]service.ScheduledTransfer, error) {
+	var schedules []service.ScheduledTransfer
+	for scanner.Next() {
+		schedule, err := scanScheduledTransfer(scanner)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+	return schedules, scanner.Err()
+}
+
+func scanScheduledTransfer(scanner pqutil.Scanner) (*service.ScheduledTransfer, error) {
+	var out service.ScheduledTransfer
+	err := scanner.Scan(&out.ID, &out.UserID, &out.TargetUserID, &out.Amount, &out.Currency, &out.Recurrence.Frequency,
+		&out.Recurrence.DayOfMonth, &out.Status, &out.NextRunAt, &out.NextAttemptAt, &out.Attempts, &out.CreatedAt,
+		&out.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrScheduledTransferNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error scanning scheduled transfer: %w", err)
+	}
+	return &out, nil
+}
+
+func collectScheduleExecutions(scanner pqutil.ScannerIter) ([]service.ScheduleExecution, error) {
+	var executions []service.ScheduleExecution
+	for scanner.Next() {
+		execution, err := scanScheduleExecution(scanner)
+		if err != nil {
+			return nil, err
+		}
+		executions = append(executions, *execution)
+	}
+	return executions, scanner.Err()
+}
+
+func scanScheduleExecution(scanner pqutil.Scanner) (*service.ScheduleExecution, error) {
+	var out service.ScheduleExecution
+	err := scanner.Scan(&out.ID, &out.ScheduleID, &out.RunAt, &out.Attempt, &out.Status, &out.TransactionID,
+		&out.ErrorCode, &out.ExecutedAt)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error scanning schedule execution: %w", err)
+	}
+	return &out, nil
+}
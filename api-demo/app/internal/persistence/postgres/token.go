@@ -0,0 +1,23 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/pqutil"
+)
+
+const tokenFields = `token_hash, session_id, user_id, kind, created_at, expires_at, revoked_at`
+
+func scanToken(scanner pqutil.Scanner) (*service.Token, error) {
+	var out service.Token
+	err := scanner.Scan(&out.Hash, &out.SessionID, &out.UserID, &out.Kind, &out.CreatedAt, &out.ExpiresAt, &out.RevokedAt)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error scanning token: %w", err)
+	}
+	return &out, nil
+}
@@ -2,35 +2,139 @@ package postgres
 
 import (
 	"database/sql"
-	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
 
 	"api-demo/app/internal/service"
 	"api-demo/pkg/pqutil"
 )
 
-const transactionFields = `id, source_user_id, target_user_id, amount, created_at`
+const transactionFields = `id, source_user_id, target_user_id, amount, currency, created_at, reversal_of, status,
+	failure_reason, updated_at`
+
+const claimFields = `id, user_id, transaction_id, amount, currency, created_at`
+
+const statusChangeFields = `transaction_id, status, reason, changed_at`
+
+func scanStatusChange(scanner pqutil.Scanner) (*service.StatusChange, error) {
+	var out service.StatusChange
+	err := scanner.Scan(&out.TransactionID, &out.Status, &out.Reason, &out.ChangedAt)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error scanning status change: %w", err)
+	}
+	return &out, nil
+}
+
+func collectStatusChanges(scanner pqutil.ScannerIter) ([]service.StatusChange, error) {
+	var changes []service.StatusChange
+	for scanner.Next() {
+		change, err := scanStatusChange(scanner)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+	return changes, scanner.Err()
+}
 
 func scanTransaction(scanner pqutil.Scanner) (*service.Transaction, error) {
 	var out service.Transaction
-	err := scanner.Scan(&out.ID, &out.SourceUserID, &out.TargetUserID, &out.Amount, &out.CreatedAt)
+	err := scanner.Scan(&out.ID, &out.SourceUserID, &out.TargetUserID, &out.Amount, &out.Currency, &out.CreatedAt,
+		&out.ReversalOf, &out.Status, &out.FailureReason, &out.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error scanning transactions: %w", err)
+	}
+	return &out, nil
+}
+
+// statementEntryFields are the fields of a transaction seen by the user $1, they're selected from the transactions t
+// joined with the counterparty user c and the running balance b of the postings of the user, if there are any
+const statementEntryFields = `t.id, t.source_user_id, t.target_user_id, t.amount, t.currency, t.created_at,
+	t.reversal_of, t.status, t.failure_reason, t.updated_at,
+	CASE WHEN t.source_user_id = $1 THEN 'outgoing' ELSE 'incoming' END, c.id, c.username, b.balance_after`
+
+func scanStatementEntry(scanner pqutil.Scanner) (*service.StatementEntry, error) {
+	var out service.StatementEntry
+	err := scanner.Scan(&out.ID, &out.SourceUserID, &out.TargetUserID, &out.Amount, &out.Currency, &out.CreatedAt,
+		&out.ReversalOf, &out.Status, &out.FailureReason, &out.UpdatedAt, &out.Direction, &out.CounterpartyID,
+		&out.CounterpartyUserName, &out.BalanceAfter)
 	if err == sql.ErrNoRows {
-		return nil, errors.New("no such transaction")
+		return nil, service.ErrTransactionNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("unexpected error scanning transactions: %v", err)
+		return nil, fmt.Errorf("unexpected error scanning statement entry: %w", err)
 	}
 	return &out, nil
 }
 
-func collectTransactions(scanner pqutil.ScannerIter) ([]service.Transaction, error) {
-	var transactions []service.Transaction
+func collectStatementEntries(scanner pqutil.ScannerIter) ([]service.StatementEntry, error) {
+	var entries []service.StatementEntry
 	for scanner.Next() {
-		user, err := scanTransaction(scanner)
+		entry, err := scanStatementEntry(scanner)
 		if err != nil {
 			return nil, err
 		}
-		transactions = append(transactions, *user)
+		entries = append(entries, *entry)
+	}
+	return entries, scanner.Err()
+}
+
+// transactionFilterConditions builds the WHERE conditions, and their arguments, that apply the filter to the
+// transactions t of the user
+func transactionFilterConditions(userID uuid.UUID, filter service.TransactionFilter) (string, []interface{}) {
+	var conditions []string
+	args := []interface{}{userID}
+
+	// adds an argument returning its placeholder
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	switch filter.Direction {
+	case service.DirectionIncoming:
+		conditions = append(conditions, "t.target_user_id = $1")
+	case service.DirectionAll:
+		conditions = append(conditions, "(t.source_user_id = $1 OR t.target_user_id = $1)")
+	default:
+		conditions = append(conditions, "t.source_user_id = $1")
+	}
+
+	if filter.CounterpartyID != uuid.Nil {
+		// the user is always one of the sides and can't transfer to itself, so the counterparty is the other side
+		placeholder := arg(filter.CounterpartyID)
+		conditions = append(conditions, "(t.source_user_id = "+placeholder+" OR t.target_user_id = "+placeholder+")")
+	}
+
+	if filter.After != nil {
+		conditions = append(conditions, "(t.created_at, t.id) > ("+arg(filter.After.CreatedAt)+", "+arg(filter.After.ID)+")")
 	}
-	return transactions, scanner.Err()
+
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "t.created_at >= "+arg(filter.From))
+	}
+
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "t.created_at < "+arg(filter.To))
+	}
+
+	if filter.Currency != "" {
+		conditions = append(conditions, "t.currency = "+arg(filter.Currency))
+	}
+
+	if filter.MinAmount != nil {
+		conditions = append(conditions, "t.amount >= "+arg(*filter.MinAmount))
+	}
+
+	if filter.MaxAmount != nil {
+		conditions = append(conditions, "t.amount <= "+arg(*filter.MaxAmount))
+	}
+
+	return strings.Join(conditions, " AND "), args
 }
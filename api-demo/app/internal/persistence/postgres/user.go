@@ -1,24 +1,67 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 
 	"api-demo/app/internal/service"
 	"api-demo/pkg/pqutil"
 )
 
-const userFields = `id, username, password, balance`
+const userFields = `id, username, password, display_name, email`
 
 func scanUser(scanner pqutil.Scanner) (*service.User, error) {
 	var out service.User
-	err := scanner.Scan(&out.ID, &out.UserName, &out.Password, &out.Balance)
+	err := scanner.Scan(&out.ID, &out.UserName, &out.PasswordHash, &out.DisplayName, &out.Email)
 	if err == sql.ErrNoRows {
-		return nil, errors.New("user not found")
+		return nil, service.ErrUserNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("unexpected error scanning user: %v", err)
+		return nil, fmt.Errorf("unexpected error scanning user: %w", err)
 	}
 	return &out, nil
 }
+
+// userNameConstraint is the unique constraint of the users' usernames
+const userNameConstraint = "users_username_key"
+
+// mapUserError maps the violation of the unique username to service.ErrUserNameTaken
+func mapUserError(err error) error {
+	if constraint, ok := pqutil.UniqueViolationConstraint(err); ok && constraint == userNameConstraint {
+		return service.ErrUserNameTaken
+	}
+
+	return err
+}
+
+// requireUserUpdated fails with service.ErrUserNotFound when an UPDATE of a user matched no rows
+func requireUserUpdated(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return service.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UserRepository is the repository of the users, it shares the connection and the transactions of an AccountRepository
+type UserRepository struct {
+	*AccountRepository
+}
+
+// NewUserRepository creates a postgres repository for the users on top of the account repository
+func NewUserRepository(accounts *AccountRepository) *UserRepository {
+	return &UserRepository{AccountRepository: accounts}
+}
+
+// WithTx runs the function inside a transaction, retrying it like AccountRepository.WithTx
+func (repo *UserRepository) WithTx(ctx context.Context, transactionedFunction func(repository service.UserRepository) error) error {
+	return repo.withTx(ctx, func(txRepo *AccountRepository) error {
+		return transactionedFunction(NewUserRepository(txRepo))
+	})
+}
@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/pqutil"
+)
+
+const walletFields = `user_id, currency, balance`
+
+func scanWallet(scanner pqutil.Scanner) (*service.Wallet, error) {
+	var out service.Wallet
+	err := scanner.Scan(&out.UserID, &out.Currency, &out.Balance)
+	if err == sql.ErrNoRows {
+		return nil, service.ErrWalletNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected error scanning wallet: %w", err)
+	}
+	return &out, nil
+}
+
+func collectWallets(scanner pqutil.ScannerIter) ([]service.Wallet, error) {
+	var wallets []service.Wallet
+	for scanner.Next() {
+		wallet, err := scanWallet(scanner)
+		if err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, *wallet)
+	}
+	return wallets, scanner.Err()
+}
+
+// walletConstraint is the primary key of the wallets, a user holds at most one wallet per currency
+const walletConstraint = "wallets_pkey"
+
+// mapWalletError maps the violation of the primary key of the wallets to service.ErrWalletExists
+func mapWalletError(err error) error {
+	if constraint, ok := pqutil.UniqueViolationConstraint(err); ok && constraint == walletConstraint {
+		return service.ErrWalletExists
+	}
+
+	return err
+}
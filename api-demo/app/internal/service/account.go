@@ -1,12 +1,29 @@
 package service
 
 import (
+	"bytes"
 	"context"
-	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
+
+	"api-demo/pkg/money"
+)
+
+var (
+	// ErrUserNotFound is returned when a user doesn't exist
+	ErrUserNotFound = NewError(KindNotFound, "user_not_found", "user not found")
+
+	// ErrTransactionNotFound is returned when a transaction doesn't exist
+	ErrTransactionNotFound = NewError(KindNotFound, "transaction_not_found", "transaction not found")
+
+	// ErrInsufficientFunds is returned when the balance of the source user can't cover a transfer
+	ErrInsufficientFunds = NewError(KindInsufficientFunds, "insufficient_funds", "insufficient balance for the transaction")
+
+	// ErrUserIDNotProvided is returned when an operation is requested without a user
+	ErrUserIDNotProvided = NewError(KindValidation, "user_id_missing", "userID not provided")
 )
 
 // AccountRepository defines features that should be provided to the service regarding storage
@@ -15,18 +32,104 @@ type AccountRepository interface {
 	// FindUserByID looks up for a User with the given ID
 	FindUserByID(ctx context.Context, userID uuid.UUID) (*User, error)
 
-	// ListTransactionsByUserID lists all the transactions that a given user was the Source
-	ListTransactionsByUserID(ctx context.Context, userID uuid.UUID) ([]Transaction, error)
+	// FindUserByIDForReport looks up for a User with the given ID for reports, like the balances, that tolerate it being
+	// a little behind the latest writes. It shouldn't be used to authenticate users or to change their data.
+	FindUserByIDForReport(ctx context.Context, userID uuid.UUID) (*User, error)
+
+	// ListTransactionsByUserID lists up to filter.Limit transactions of a given user that match the filter, ordered by
+	// their creation time and ID, as entries of the statement of the user
+	ListTransactionsByUserID(ctx context.Context, userID uuid.UUID, filter TransactionFilter) ([]StatementEntry, error)
 
 	// CreateTransaction creates a transaction between 2 users
 	CreateTransaction(ctx context.Context, transaction *Transaction) error
 
+	// FindTransactionByID looks up for a Transaction with the given ID
+	FindTransactionByID(ctx context.Context, transactionID uuid.UUID) (*Transaction, error)
+
+	// SumReversals returns the sum of the amounts of the reversals of a transaction
+	SumReversals(ctx context.Context, transactionID uuid.UUID) (money.Amount, error)
+
+	// CreateClaim records the money a user owes after a reversal took more than their balance
+	CreateClaim(ctx context.Context, claim *Claim) error
+
+	// RecordStatusChange sets the status of a transaction, keeping the reason as its failure reason when it failed, and
+	// appends the change to its history
+	RecordStatusChange(ctx context.Context, change *StatusChange) error
+
+	// ListStatusChanges lists the status history of a transaction, oldest change first
+	ListStatusChanges(ctx context.Context, transactionID uuid.UUID) ([]StatusChange, error)
+
+	// CreateHold stores a new hold
+	CreateHold(ctx context.Context, hold *Hold) error
+
+	// FindHoldByID looks up for a Hold with the given ID
+	FindHoldByID(ctx context.Context, holdID uuid.UUID) (*Hold, error)
+
+	// UpdateHold stores the status, the captured amount, the transaction and the update time of a hold
+	UpdateHold(ctx context.Context, hold *Hold) error
+
+	// ExpireHolds marks the holds that are active past their expiration at the given time as expired, updated at their
+	// expiration
+	ExpireHolds(ctx context.Context, at time.Time) error
+
+	// SumActiveHolds returns the sum of the amounts of the holds of a user in a currency that are active and not expired
+	// at the given time
+	SumActiveHolds(ctx context.Context, userID uuid.UUID, currency money.Currency, at time.Time) (money.Amount, error)
+
+	// CreateScheduledTransfer stores a new scheduled transfer
+	CreateScheduledTransfer(ctx context.Context, schedule *ScheduledTransfer) error
+
+	// FindScheduledTransferByID looks up for a ScheduledTransfer with the given ID
+	FindScheduledTransferByID(ctx context.Context, scheduleID uuid.UUID) (*ScheduledTransfer, error)
+
+	// FindAndLockScheduledTransferByID looks up for a ScheduledTransfer with the given ID and locks it until the
+	// transaction is finished
+	FindAndLockScheduledTransferByID(ctx context.Context, scheduleID uuid.UUID) (*ScheduledTransfer, error)
+
+	// ListScheduledTransfersByUserID lists the transfers scheduled by a user, oldest first
+	ListScheduledTransfersByUserID(ctx context.Context, userID uuid.UUID) ([]ScheduledTransfer, error)
+
+	// ClaimDueScheduledTransfer locks the active scheduled transfer with the earliest attempt due at the given time,
+	// skipping the ones locked by other transactions. It returns nil when none is due.
+	ClaimDueScheduledTransfer(ctx context.Context, at time.Time) (*ScheduledTransfer, error)
+
+	// UpdateScheduledTransfer stores the status, the next run and attempt, the attempts and the update time of a
+	// scheduled transfer
+	UpdateScheduledTransfer(ctx context.Context, schedule *ScheduledTransfer) error
+
+	// CreateScheduleExecution appends an attempt to the execution history of a scheduled transfer
+	CreateScheduleExecution(ctx context.Context, execution *ScheduleExecution) error
+
+	// ListScheduleExecutions lists the execution history of a scheduled transfer, oldest attempt first
+	ListScheduleExecutions(ctx context.Context, scheduleID uuid.UUID) ([]ScheduleExecution, error)
+
 	// FindAndLockUserByID looks up for a User with the given ID and locks it, not allowing other processes to observe
 	// this user while the transaction is not finished
 	FindAndLockUserByID(ctx context.Context, userID uuid.UUID) (*User, error)
 
-	// UpdateUserBalance updates the user balance to the given amount
-	UpdateUserBalance(ctx context.Context, userID uuid.UUID, newBalance float64) error
+	// CreatePostings writes the balanced postings of a ledger entry
+	CreatePostings(ctx context.Context, postings []Posting) error
+
+	// CreateWallet opens a wallet for a user, failing with ErrWalletExists if the user has a wallet in its currency
+	CreateWallet(ctx context.Context, wallet *Wallet) error
+
+	// FindWallet looks up for the Wallet of a user in a currency, failing with ErrWalletNotFound if there's none
+	FindWallet(ctx context.Context, userID uuid.UUID, currency money.Currency) (*Wallet, error)
+
+	// ListWalletsByUserID lists the wallets of a user ordered by currency
+	ListWalletsByUserID(ctx context.Context, userID uuid.UUID) ([]Wallet, error)
+
+	// UpdateWalletBalance updates the cached balance of the wallet of a user in a currency to the given amount, it must
+	// always match the sum of the postings of the user in the currency
+	UpdateWalletBalance(ctx context.Context, userID uuid.UUID, currency money.Currency, newBalance money.Amount) error
+
+	// FindIdempotencyRecord looks up for the record stored for the idempotency key of a user, returning nil if the key
+	// was never used
+	FindIdempotencyRecord(ctx context.Context, userID uuid.UUID, key string) (*IdempotencyRecord, error)
+
+	// CreateIdempotencyRecord stores the record of a request made with an idempotency key, failing with
+	// ErrIdempotencyKeyInProgress if another request stored a record for the same key concurrently
+	CreateIdempotencyRecord(ctx context.Context, record *IdempotencyRecord) error
 
 	// WithTx starts a transactioned version of the repository that'll be either commited if no errors are returned or
 	// rolled back
@@ -42,84 +145,227 @@ func NewAccount(repository AccountRepository) *Account {
 	return &Account{repository: repository}
 }
 
+// CreateTransaction transfers amount from the wallet of sourceUserID in the currency to the wallet of targetUserID in
+// the same currency
 func (service *Account) CreateTransaction(ctx context.Context, sourceUserID uuid.UUID, targetUserID uuid.UUID,
-	amount float64) (*Transaction, error) {
+	amount money.Amount, currency money.Currency) (*Transaction, error) {
 
 	var transaction *Transaction
 	err := service.repository.WithTx(ctx, func(txRepo AccountRepository) error {
+		var err error
+		transaction, err = service.createTransaction(ctx, txRepo, sourceUserID, targetUserID, amount, currency)
+		return err
+	})
 
-		if sourceUserID == targetUserID {
-			return errors.New("the target user should be different than the source user")
-		}
+	if err != nil {
+		return nil, err
+	}
 
-		sourceUser, err := service.repository.FindAndLockUserByID(ctx, sourceUserID)
-		if err != nil {
-			return err
-		}
+	return transaction, nil
+}
+
+// CreateIdempotentTransaction creates a transaction just like CreateTransaction, but at most once per idempotency key.
+// The response rendered for the created transaction is stored in the same DB transaction as the transfer, so retries
+// with the same key get the stored response back instead of moving money again.
+func (service *Account) CreateIdempotentTransaction(ctx context.Context, idempotencyKey string, sourceUserID uuid.UUID,
+	targetUserID uuid.UUID, amount money.Amount, currency money.Currency, render ResponseRenderer) (*IdempotencyRecord,
+	error) {
 
-		targetUser, err := service.repository.FindAndLockUserByID(ctx, targetUserID)
+	if idempotencyKey == "" || len(idempotencyKey) > maxIdempotencyKeyLength {
+		return nil, NewFieldError("Idempotency-Key", "invalid_idempotency_key",
+			"the idempotency key should have between 1 and %d characters", maxIdempotencyKeyLength)
+	}
+
+	requestHash := hashRequest("create_transaction", sourceUserID.String(), targetUserID.String(), amount.String(),
+		string(currency))
+
+	var record *IdempotencyRecord
+	err := service.repository.WithTx(ctx, func(txRepo AccountRepository) error {
+
+		existing, err := txRepo.FindIdempotencyRecord(ctx, sourceUserID, idempotencyKey)
 		if err != nil {
 			return err
 		}
 
-		if amount <= 0 {
-			return errors.New("transfer amount should be greater than zero")
-		}
+		if existing != nil {
+			if existing.RequestHash != requestHash {
+				return ErrIdempotencyKeyReused
+			}
 
-		if sourceUser.Balance < amount {
-			return errors.New("insufficient balance for the transaction")
+			existing.Replayed = true
+			record = existing
+			return nil
 		}
 
-		sourceUser.Balance -= amount
-		if err := txRepo.UpdateUserBalance(ctx, sourceUser.ID, sourceUser.Balance); err != nil {
+		transaction, err := service.createTransaction(ctx, txRepo, sourceUserID, targetUserID, amount, currency)
+		if err != nil {
 			return err
 		}
 
-		targetUser.Balance += amount
-		if err := txRepo.UpdateUserBalance(ctx, targetUser.ID, targetUser.Balance); err != nil {
-			return err
+		status, body, err := render(transaction)
+		if err != nil {
+			return fmt.Errorf("failed to render the transaction response: %v", err)
 		}
 
-		transaction = &Transaction{
-			ID:           uuid.New(),
-			SourceUserID: sourceUserID,
-			TargetUserID: targetUserID,
-			Amount:       amount,
-			CreatedAt:    time.Now(),
+		record = &IdempotencyRecord{
+			UserID:         sourceUserID,
+			Key:            idempotencyKey,
+			RequestHash:    requestHash,
+			ResponseStatus: status,
+			ResponseBody:   body,
+			CreatedAt:      time.Now(),
 		}
 
-		return txRepo.CreateTransaction(ctx, transaction)
+		return txRepo.CreateIdempotencyRecord(ctx, record)
 	})
 
-	return transaction, err
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
 }
 
-func (service *Account) GetBalance(ctx context.Context, userID uuid.UUID) (float64, error) {
-	if userID == uuid.Nil {
-		return 0, fmt.Errorf("userID not provided")
+// createTransaction transfers amount in the currency from sourceUserID to targetUserID using an already transactioned
+// repository
+func (service *Account) createTransaction(ctx context.Context, txRepo AccountRepository, sourceUserID uuid.UUID,
+	targetUserID uuid.UUID, amount money.Amount, currency money.Currency) (*Transaction, error) {
+
+	if sourceUserID == targetUserID {
+		return nil, NewFieldError("target_user_id", "same_source_and_target",
+			"the target user should be different than the source user")
+	}
+
+	if err := validateCurrency(currency); err != nil {
+		return nil, err
+	}
+
+	wallets, err := lockWallets(ctx, txRepo, currency, sourceUserID, targetUserID)
+	if err != nil {
+		return nil, err
 	}
 
-	user, err := service.repository.FindUserByID(ctx, userID)
+	source, target := wallets[sourceUserID], wallets[targetUserID]
+
+	if amount <= 0 {
+		return nil, NewFieldError("amount", "invalid_amount", "transfer amount should be greater than zero")
+	}
+
+	now := time.Now()
+	available, err := availableBalance(ctx, txRepo, source, now)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return user.Balance, nil
+	if available < amount {
+		return nil, ErrInsufficientFunds
+	}
+
+	transaction := &Transaction{
+		ID:           uuid.New(),
+		SourceUserID: sourceUserID,
+		TargetUserID: targetUserID,
+		Amount:       amount,
+		Currency:     currency,
+		CreatedAt:    now,
+	}
+
+	if err := moveMoney(ctx, txRepo, source, target, transaction); err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
 }
 
-func (service *Account) ListTransactions(ctx context.Context, userID uuid.UUID) ([]Transaction, error) {
+// moveMoney writes a transaction between the wallets of users locked by the transactioned repository, in the currency
+// of the transaction, updating their balances and writing its postings to the ledger, and completes it. The balance of
+// the source wallet isn't checked, callers decide whether it can go negative.
+func moveMoney(ctx context.Context, txRepo AccountRepository, source *Wallet, target *Wallet,
+	transaction *Transaction) error {
+
+	var err error
+	if source.Balance, err = source.Balance.Sub(transaction.Amount); err != nil {
+		return err
+	}
+
+	if err := txRepo.UpdateWalletBalance(ctx, source.UserID, source.Currency, source.Balance); err != nil {
+		return err
+	}
+
+	if target.Balance, err = target.Balance.Add(transaction.Amount); err != nil {
+		return err
+	}
+
+	if err := txRepo.UpdateWalletBalance(ctx, target.UserID, target.Currency, target.Balance); err != nil {
+		return err
+	}
+
+	if err := startTransaction(ctx, txRepo, transaction); err != nil {
+		return err
+	}
+
+	postings := transferPostings(transaction)
+	if err := checkBalanced(postings); err != nil {
+		return err
+	}
+
+	if err := txRepo.CreatePostings(ctx, postings); err != nil {
+		return err
+	}
+
+	return transition(ctx, txRepo, transaction, TransactionCompleted, "")
+}
+
+func (service *Account) ListTransactions(ctx context.Context, userID uuid.UUID, filter TransactionFilter) (*TransactionPage, error) {
 	if userID == uuid.Nil {
-		return nil, fmt.Errorf("userID not provided")
+		return nil, ErrUserIDNotProvided
+	}
+
+	if err := filter.normalize(); err != nil {
+		return nil, err
 	}
 
-	transactions, err := service.repository.ListTransactionsByUserID(ctx, userID)
+	// fetches an extra transaction to know whether there's a next page
+	limit := filter.Limit
+	filter.Limit++
+
+	transactions, err := service.repository.ListTransactionsByUserID(ctx, userID, filter)
 	if err != nil {
 		return nil, err
 	}
 
-	if transactions == nil {
-		transactions = []Transaction{}
+	page := &TransactionPage{Transactions: transactions}
+	if len(transactions) > limit {
+		page.Transactions = transactions[:limit]
+
+		last := page.Transactions[limit-1]
+		page.NextCursor = TransactionCursor{CreatedAt: last.CreatedAt, ID: last.ID}.String()
+	}
+
+	if page.Transactions == nil {
+		page.Transactions = []StatementEntry{}
+	}
+
+	return page, nil
+}
+
+// lockUsers locks the users always ordered by their IDs, regardless of their roles in a transfer, so concurrent transfers
+// between the same users in opposite directions can't deadlock waiting for each other
+func lockUsers(ctx context.Context, txRepo AccountRepository, userIDs ...uuid.UUID) (map[uuid.UUID]*User, error) {
+	ordered := append([]uuid.UUID(nil), userIDs...)
+	sort.Slice(ordered, func(i, j int) bool {
+		return bytes.Compare(ordered[i][:], ordered[j][:]) < 0
+	})
+
+	users := make(map[uuid.UUID]*User, len(ordered))
+	for _, userID := range ordered {
+		user, err := txRepo.FindAndLockUserByID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		users[userID] = user
 	}
 
-	return transactions, nil
+	return users, nil
 }
@@ -10,29 +10,62 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
 )
 
-func TestAccount_GetBalance(t *testing.T) {
+func TestAccount_GetBalances(t *testing.T) {
 
 	ctx := context.Background()
 
-	successCheck := func(t *testing.T, user *service.User, balance float64, err error) {
-		require.NoError(t, err)
-		require.Equal(t, user.Balance, balance)
-	}
-
-	failCheck := func(t *testing.T, user *service.User, balance float64, err error) {
+	failCheck := func(t *testing.T, balances []service.Balance, err error) {
 		require.Error(t, err)
-		require.Zero(t, balance)
+		require.Nil(t, balances)
 	}
 
 	tests := map[string]struct {
-		mutateUser    func(*service.User)
+		userID        *uuid.UUID
 		mutateMock    func(*accountRepositoryMock)
-		checkFunction func(*testing.T, *service.User, float64, error)
+		checkFunction func(*testing.T, []service.Balance, error)
 	}{
-		"should succeed to get a user's balance": {
-			checkFunction: successCheck,
+		"should succeed to get the balance of every wallet of a user": {
+			checkFunction: func(t *testing.T, balances []service.Balance, err error) {
+				require.NoError(t, err)
+				require.Equal(t, []service.Balance{
+					{Currency: money.BRL, Current: money.MustParse("100"), Available: money.MustParse("100")},
+					{Currency: money.USD, Current: money.MustParse("5"), Available: money.MustParse("5")},
+				}, balances)
+			},
+		},
+		"should not make the amount of the active holds available": {
+			mutateMock: func(mock *accountRepositoryMock) {
+				mock.SumActiveHoldsFunc = func(ctx context.Context, userID uuid.UUID, currency money.Currency,
+					at time.Time) (money.Amount, error) {
+
+					if currency != money.BRL {
+						return 0, nil
+					}
+					return money.MustParse("30"), nil
+				}
+			},
+			checkFunction: func(t *testing.T, balances []service.Balance, err error) {
+				require.NoError(t, err)
+				require.Equal(t, []service.Balance{
+					{Currency: money.BRL, Current: money.MustParse("100"), Available: money.MustParse("70")},
+					{Currency: money.USD, Current: money.MustParse("5"), Available: money.MustParse("5")},
+				}, balances)
+			},
+		},
+		"should return an empty list when the user has no wallets": {
+			mutateMock: func(mock *accountRepositoryMock) {
+				mock.ListWalletsByUserIDFunc = func(ctx context.Context, userID uuid.UUID) ([]service.Wallet, error) {
+					return nil, nil
+				}
+			},
+			checkFunction: func(t *testing.T, balances []service.Balance, err error) {
+				require.NoError(t, err)
+				require.NotNil(t, balances)
+				require.Empty(t, balances)
+			},
 		},
 		"should return an error when the DB layer returns an error": {
 			mutateMock: func(mock *accountRepositoryMock) {
@@ -43,9 +76,7 @@ func TestAccount_GetBalance(t *testing.T) {
 			checkFunction: failCheck,
 		},
 		"should return an error when the userID is nil": {
-			mutateUser: func(user *service.User) {
-				user.ID = uuid.Nil
-			},
+			userID:        &uuid.Nil,
 			checkFunction: failCheck,
 		},
 	}
@@ -56,19 +87,21 @@ func TestAccount_GetBalance(t *testing.T) {
 			repo := newAccountRepositoryMock()
 
 			userID := uuid.New()
-			user := &service.User{
-				ID:       userID,
-				UserName: userID.String(),
-				Password: userID.String(),
-				Balance:  100,
+			user := &service.User{ID: userID, UserName: userID.String(), PasswordHash: userID.String()}
+
+			repo.FindUserByIDFunc = func(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+				return user, nil
 			}
 
-			if test.mutateUser != nil {
-				test.mutateUser(user)
+			repo.ListWalletsByUserIDFunc = func(ctx context.Context, userID uuid.UUID) ([]service.Wallet, error) {
+				return []service.Wallet{
+					{UserID: userID, Currency: money.BRL, Balance: money.MustParse("100")},
+					{UserID: userID, Currency: money.USD, Balance: money.MustParse("5")},
+				}, nil
 			}
 
-			repo.FindUserByIDFunc = func(ctx context.Context, userID uuid.UUID) (*service.User, error) {
-				return user, nil
+			if test.userID != nil {
+				userID = *test.userID
 			}
 
 			if test.mutateMock != nil {
@@ -77,43 +110,191 @@ func TestAccount_GetBalance(t *testing.T) {
 
 			accountService := service.NewAccount(repo)
 
-			balance, err := accountService.GetBalance(ctx, user.ID)
-			test.checkFunction(t, user, balance, err)
+			balances, err := accountService.GetBalances(ctx, userID)
+			test.checkFunction(t, balances, err)
 
 		})
 	}
 }
 
+func TestAccount_OpenWallet(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		currency      money.Currency
+		mutateMock    func(*accountRepositoryMock)
+		checkFunction func(t *testing.T, created []service.Wallet, balance *service.Balance, err error)
+	}{
+		"should open an empty wallet in the currency": {
+			currency: money.USD,
+			checkFunction: func(t *testing.T, created []service.Wallet, balance *service.Balance, err error) {
+				require.NoError(t, err)
+				require.Equal(t, &service.Balance{Currency: money.USD}, balance)
+				require.Len(t, created, 1)
+				require.Equal(t, money.USD, created[0].Currency)
+				require.Zero(t, created[0].Balance)
+			},
+		},
+		"should return a conflict when the user already has a wallet in the currency": {
+			currency: money.BRL,
+			mutateMock: func(mock *accountRepositoryMock) {
+				mock.CreateWalletFunc = func(ctx context.Context, wallet *service.Wallet) error {
+					return service.ErrWalletExists
+				}
+			},
+			checkFunction: func(t *testing.T, created []service.Wallet, balance *service.Balance, err error) {
+				require.True(t, errors.Is(err, service.ErrWalletExists))
+				require.Nil(t, balance)
+			},
+		},
+		"should return an error when the currency isn't supported": {
+			currency: "XYZ",
+			checkFunction: func(t *testing.T, created []service.Wallet, balance *service.Balance, err error) {
+				var serviceErr *service.Error
+				require.True(t, errors.As(err, &serviceErr))
+				require.Equal(t, "invalid_currency", serviceErr.Code)
+				require.Empty(t, created)
+			},
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+
+			repo := newAccountRepositoryMock()
+
+			var created []service.Wallet
+			repo.CreateWalletFunc = func(ctx context.Context, wallet *service.Wallet) error {
+				created = append(created, *wallet)
+				return nil
+			}
+
+			if test.mutateMock != nil {
+				test.mutateMock(repo)
+			}
+
+			accountService := service.NewAccount(repo)
+
+			balance, err := accountService.OpenWallet(ctx, uuid.New(), test.currency)
+			test.checkFunction(t, created, balance, err)
+		})
+	}
+}
+
 func TestAccount_ListTransactions(t *testing.T) {
 
 	ctx := context.Background()
 
-	successCheck := func(t *testing.T, user *service.User, transactions []service.Transaction, err error) {
+	successCheck := func(t *testing.T, user *service.User, page *service.TransactionPage, err error) {
 		require.NoError(t, err)
-		require.NotEmpty(t, transactions)
-
-		for _, transaction := range transactions {
-			require.Equal(t, user.ID, transaction.SourceUserID)
-			require.NotEqual(t, user.ID, transaction.TargetUserID)
+		require.NotEmpty(t, page.Transactions)
+		require.Empty(t, page.NextCursor)
+
+		for _, entry := range page.Transactions {
+			require.NotEqual(t, user.ID, entry.CounterpartyID)
+
+			switch entry.Direction {
+			case service.DirectionOutgoing:
+				require.Equal(t, user.ID, entry.SourceUserID)
+				require.Equal(t, entry.CounterpartyID, entry.TargetUserID)
+			case service.DirectionIncoming:
+				require.Equal(t, user.ID, entry.TargetUserID)
+				require.Equal(t, entry.CounterpartyID, entry.SourceUserID)
+			default:
+				require.Fail(t, "unexpected direction", entry.Direction)
+			}
 		}
 	}
 
-	failCheck := func(t *testing.T, user *service.User, transactions []service.Transaction, err error) {
+	failCheck := func(t *testing.T, user *service.User, page *service.TransactionPage, err error) {
 		require.Error(t, err)
-		require.Nil(t, transactions)
+		require.Nil(t, page)
 	}
 
 	tests := map[string]struct {
 		mutateUser    func(*service.User)
 		mutateMock    func(*accountRepositoryMock)
-		checkFunction func(*testing.T, *service.User, []service.Transaction, error)
+		filter        service.TransactionFilter
+		available     int
+		checkFunction func(*testing.T, *service.User, *service.TransactionPage, error)
 	}{
 		"should succeed to get a user's transaction": {
+			available:     2,
 			checkFunction: successCheck,
 		},
+		"should return a cursor to the next page when there are more transactions than the limit": {
+			filter:    service.TransactionFilter{Limit: 2},
+			available: 5,
+			checkFunction: func(t *testing.T, user *service.User, page *service.TransactionPage, err error) {
+				require.NoError(t, err)
+				require.Len(t, page.Transactions, 2)
+
+				cursor, err := service.ParseTransactionCursor(page.NextCursor)
+				require.NoError(t, err)
+				require.Equal(t, page.Transactions[1].ID, cursor.ID)
+				require.True(t, page.Transactions[1].CreatedAt.Equal(cursor.CreatedAt))
+			},
+		},
+		"should not return a cursor on the last page": {
+			filter:    service.TransactionFilter{Limit: 2},
+			available: 2,
+			checkFunction: func(t *testing.T, user *service.User, page *service.TransactionPage, err error) {
+				require.NoError(t, err)
+				require.Len(t, page.Transactions, 2)
+				require.Empty(t, page.NextCursor)
+			},
+		},
+		"should return an empty list when there are no transactions": {
+			available: 0,
+			checkFunction: func(t *testing.T, user *service.User, page *service.TransactionPage, err error) {
+				require.NoError(t, err)
+				require.NotNil(t, page.Transactions)
+				require.Empty(t, page.Transactions)
+			},
+		},
+		"should list transactions in both directions with the default limit when no filter is given": {
+			available: 3,
+			mutateMock: func(mock *accountRepositoryMock) {
+				list := mock.ListTransactionsByUserIDFunc
+				mock.ListTransactionsByUserIDFunc = func(ctx context.Context, userID uuid.UUID, filter service.TransactionFilter) ([]service.StatementEntry, error) {
+					if filter.Direction != service.DirectionAll || filter.Limit != service.DefaultTransactionsLimit+1 {
+						return nil, errors.New("unexpected filter")
+					}
+					return list(ctx, userID, filter)
+				}
+			},
+			checkFunction: successCheck,
+		},
+		"should return an error when the limit is too big": {
+			filter:        service.TransactionFilter{Limit: service.MaxTransactionsLimit + 1},
+			checkFunction: failCheck,
+		},
+		"should return an error when the direction is invalid": {
+			filter:        service.TransactionFilter{Direction: "sideways"},
+			checkFunction: failCheck,
+		},
+		"should return an error when the amount range is inverted": {
+			filter: service.TransactionFilter{
+				Currency:  money.BRL,
+				MinAmount: func() *money.Amount { a := money.MustParse("10"); return &a }(),
+				MaxAmount: func() *money.Amount { a := money.MustParse("5"); return &a }(),
+			},
+			checkFunction: failCheck,
+		},
+		"should return an error when an amount is filtered without a currency": {
+			filter: service.TransactionFilter{
+				MinAmount: func() *money.Amount { a := money.MustParse("10"); return &a }(),
+			},
+			checkFunction: failCheck,
+		},
+		"should return an error when the currency of the filter isn't supported": {
+			filter:        service.TransactionFilter{Currency: "XYZ"},
+			checkFunction: failCheck,
+		},
 		"should return an error when the DB layer returns an error": {
 			mutateMock: func(mock *accountRepositoryMock) {
-				mock.ListTransactionsByUserIDFunc = func(ctx context.Context, userID uuid.UUID) ([]service.Transaction, error) {
+				mock.ListTransactionsByUserIDFunc = func(ctx context.Context, userID uuid.UUID, filter service.TransactionFilter) ([]service.StatementEntry, error) {
 					return nil, errors.New("not found")
 				}
 			},
@@ -134,26 +315,42 @@ func TestAccount_ListTransactions(t *testing.T) {
 
 			userID := uuid.New()
 			user := &service.User{
-				ID:       userID,
-				UserName: userID.String(),
-				Password: userID.String(),
-				Balance:  100,
+				ID:           userID,
+				UserName:     userID.String(),
+				PasswordHash: userID.String(),
 			}
 
 			if test.mutateUser != nil {
 				test.mutateUser(user)
 			}
 
-			repo.ListTransactionsByUserIDFunc = func(ctx context.Context, userID uuid.UUID) ([]service.Transaction, error) {
-				return []service.Transaction{
-					{
-						ID:           uuid.New(),
-						SourceUserID: userID,
-						TargetUserID: uuid.New(),
-						Amount:       10,
-						CreatedAt:    time.Now(),
-					},
-				}, nil
+			repo.ListTransactionsByUserIDFunc = func(ctx context.Context, userID uuid.UUID, filter service.TransactionFilter) ([]service.StatementEntry, error) {
+				var entries []service.StatementEntry
+				for i := 0; i < test.available && i < filter.Limit; i++ {
+					counterpartyID := uuid.New()
+
+					// alternates between money sent and received by the user
+					entry := service.StatementEntry{
+						Transaction: service.Transaction{
+							ID:           uuid.New(),
+							SourceUserID: userID,
+							TargetUserID: counterpartyID,
+							Amount:       10,
+							Currency:     money.BRL,
+							CreatedAt:    time.Now().Add(time.Duration(i) * time.Second),
+						},
+						Direction:      service.DirectionOutgoing,
+						CounterpartyID: counterpartyID,
+					}
+
+					if i%2 == 1 {
+						entry.SourceUserID, entry.TargetUserID = counterpartyID, userID
+						entry.Direction = service.DirectionIncoming
+					}
+
+					entries = append(entries, entry)
+				}
+				return entries, nil
 			}
 
 			if test.mutateMock != nil {
@@ -162,8 +359,8 @@ func TestAccount_ListTransactions(t *testing.T) {
 
 			accountService := service.NewAccount(repo)
 
-			transactions, err := accountService.ListTransactions(ctx, user.ID)
-			test.checkFunction(t, user, transactions, err)
+			page, err := accountService.ListTransactions(ctx, user.ID, test.filter)
+			test.checkFunction(t, user, page, err)
 
 		})
 	}
@@ -173,46 +370,60 @@ func TestAccount_CreateTransaction(t *testing.T) {
 
 	ctx := context.Background()
 
-	successCheck := func(t *testing.T, sourceUser *service.User, targetUser *service.User, sourceOriginalBalance float64,
-		targetOriginalBalance float64, transaction *service.Transaction, err error) {
+	successCheck := func(t *testing.T, source *service.Wallet, target *service.Wallet, sourceOriginalBalance money.Amount,
+		targetOriginalBalance money.Amount, transaction *service.Transaction, err error) {
 		require.NoError(t, err)
 		require.NotNil(t, transaction)
 
 		// guarantees that the balance is correct calculated and that the amount is also correct on the transaction
-		require.Equal(t, transaction.Amount, sourceOriginalBalance-sourceUser.Balance, "invalid new balance for source user")
-		require.Equal(t, transaction.Amount, targetUser.Balance-targetOriginalBalance, "invalid new balance for target user")
+		require.Equal(t, transaction.Amount, sourceOriginalBalance-source.Balance, "invalid new balance for source user")
+		require.Equal(t, transaction.Amount, target.Balance-targetOriginalBalance, "invalid new balance for target user")
 
-		require.Equal(t, sourceUser.ID, transaction.SourceUserID)
-		require.Equal(t, targetUser.ID, transaction.TargetUserID)
+		require.Equal(t, source.UserID, transaction.SourceUserID)
+		require.Equal(t, target.UserID, transaction.TargetUserID)
+		require.Equal(t, money.BRL, transaction.Currency)
+		require.Equal(t, service.TransactionCompleted, transaction.Status)
 	}
 
-	failCheck := func(t *testing.T, sourceUser *service.User, targetUser *service.User, sourceOriginalBalance float64,
-		targetOriginalBalance float64, transaction *service.Transaction, err error) {
+	failCheck := func(t *testing.T, source *service.Wallet, target *service.Wallet, sourceOriginalBalance money.Amount,
+		targetOriginalBalance money.Amount, transaction *service.Transaction, err error) {
 		require.Error(t, err)
 		require.Nil(t, transaction)
 	}
 
 	tests := map[string]struct {
-		mutateSourceUser func(*service.User)
-		mutateTargetUser func(*service.User)
-		mutateMock       func(*accountRepositoryMock)
-		amount           float64
-		checkFunction    func(*testing.T, *service.User, *service.User, float64, float64, *service.Transaction, error)
+		mutateSource  func(*service.Wallet)
+		mutateTarget  func(*service.Wallet)
+		mutateMock    func(*accountRepositoryMock)
+		amount        money.Amount
+		currency      money.Currency
+		checkFunction func(*testing.T, *service.Wallet, *service.Wallet, money.Amount, money.Amount, *service.Transaction, error)
 	}{
 		"should succeed creating a transaction when a user has more than the amount as balance": {
 			amount: 5,
-			mutateSourceUser: func(user *service.User) {
-				user.Balance = 10
+			mutateSource: func(wallet *service.Wallet) {
+				wallet.Balance = 10
 			},
 			checkFunction: successCheck,
 		},
 		"should succeed creating a transaction when a user has more the same  amount as balance": {
 			amount: 10,
-			mutateSourceUser: func(user *service.User) {
-				user.Balance = 10
+			mutateSource: func(wallet *service.Wallet) {
+				wallet.Balance = 10
 			},
 			checkFunction: successCheck,
 		},
+		"should keep balances exact when transferring fractional amounts": {
+			amount: money.MustParse("0.1"),
+			mutateSource: func(wallet *service.Wallet) {
+				wallet.Balance = money.MustParse("0.3")
+			},
+			checkFunction: func(t *testing.T, source *service.Wallet, target *service.Wallet, a money.Amount, a2 money.Amount, transaction *service.Transaction, err error) {
+				require.NoError(t, err)
+				require.Equal(t, money.MustParse("0.2"), source.Balance)
+				require.Equal(t, money.MustParse("100.1"), target.Balance)
+			},
+		},
 		"should return an error when the DB doesn't find a user": {
 			mutateMock: func(mock *accountRepositoryMock) {
 				mock.FindAndLockUserByIDFunc = func(ctx context.Context, userID uuid.UUID) (*service.User, error) {
@@ -221,38 +432,80 @@ func TestAccount_CreateTransaction(t *testing.T) {
 			},
 			checkFunction: failCheck,
 		},
+		"should return a currency mismatch when the target user has no wallet in the currency": {
+			amount:   5,
+			currency: money.USD,
+			mutateMock: func(mock *accountRepositoryMock) {
+				findWallet := mock.FindWalletFunc
+				mock.FindWalletFunc = func(ctx context.Context, userID uuid.UUID, currency money.Currency) (*service.Wallet, error) {
+					if currency == money.USD {
+						return nil, service.ErrWalletNotFound
+					}
+					return findWallet(ctx, userID, currency)
+				}
+			},
+			checkFunction: func(t *testing.T, source *service.Wallet, target *service.Wallet, a money.Amount, a2 money.Amount, transaction *service.Transaction, err error) {
+				require.True(t, errors.Is(err, service.ErrCurrencyMismatch))
+				require.Nil(t, transaction)
+			},
+		},
+		"should return an error when the currency isn't supported": {
+			amount:   5,
+			currency: "XYZ",
+			checkFunction: func(t *testing.T, source *service.Wallet, target *service.Wallet, a money.Amount, a2 money.Amount, transaction *service.Transaction, err error) {
+				var serviceErr *service.Error
+				require.True(t, errors.As(err, &serviceErr))
+				require.Equal(t, "invalid_currency", serviceErr.Code)
+			},
+		},
 		"should return an error when the source user have insufficient funds": {
 			amount: 100,
-			mutateSourceUser: func(user *service.User) {
-				user.Balance = 5
+			mutateSource: func(wallet *service.Wallet) {
+				wallet.Balance = 5
 			},
-			checkFunction: func(t *testing.T, user *service.User, user2 *service.User, f float64, f2 float64, transaction *service.Transaction, err error) {
+			checkFunction: func(t *testing.T, source *service.Wallet, target *service.Wallet, a money.Amount, a2 money.Amount, transaction *service.Transaction, err error) {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), "insufficient balance")
+				require.True(t, errors.Is(err, service.ErrInsufficientFunds))
+			},
+		},
+		"should return an error when the active holds of the source user reserve its balance": {
+			amount: 10,
+			mutateSource: func(wallet *service.Wallet) {
+				wallet.Balance = 15
+			},
+			mutateMock: func(mock *accountRepositoryMock) {
+				mock.SumActiveHoldsFunc = func(ctx context.Context, userID uuid.UUID, currency money.Currency, at time.Time) (money.Amount, error) {
+					return 10, nil
+				}
+			},
+			checkFunction: func(t *testing.T, source *service.Wallet, target *service.Wallet, a money.Amount, a2 money.Amount, transaction *service.Transaction, err error) {
+				require.True(t, errors.Is(err, service.ErrInsufficientFunds))
+				require.Nil(t, transaction)
 			},
 		},
 		"should return an error when the source and the target are the same": {
-			mutateSourceUser: func(user *service.User) {
-				user.ID = uuid.MustParse("c398a537-123c-4918-9081-eccc0e6ea0ad")
+			mutateSource: func(wallet *service.Wallet) {
+				wallet.UserID = uuid.MustParse("c398a537-123c-4918-9081-eccc0e6ea0ad")
 			},
-			mutateTargetUser: func(user *service.User) {
-				user.ID = uuid.MustParse("c398a537-123c-4918-9081-eccc0e6ea0ad")
+			mutateTarget: func(wallet *service.Wallet) {
+				wallet.UserID = uuid.MustParse("c398a537-123c-4918-9081-eccc0e6ea0ad")
 			},
-			checkFunction: func(t *testing.T, user *service.User, user2 *service.User, f float64, f2 float64, transaction *service.Transaction, err error) {
+			checkFunction: func(t *testing.T, source *service.Wallet, target *service.Wallet, a money.Amount, a2 money.Amount, transaction *service.Transaction, err error) {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), "the target user should be different than the source user")
 			},
 		},
 		"should return an error when the amount is zero": {
 			amount: 0,
-			checkFunction: func(t *testing.T, user *service.User, user2 *service.User, f float64, f2 float64, transaction *service.Transaction, err error) {
+			checkFunction: func(t *testing.T, source *service.Wallet, target *service.Wallet, a money.Amount, a2 money.Amount, transaction *service.Transaction, err error) {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), "transfer amount should be greater than zero")
 			},
 		},
 		"should return an error when the amount is negative": {
 			amount: -1234,
-			checkFunction: func(t *testing.T, user *service.User, user2 *service.User, f float64, f2 float64, transaction *service.Transaction, err error) {
+			checkFunction: func(t *testing.T, source *service.Wallet, target *service.Wallet, a money.Amount, a2 money.Amount, transaction *service.Transaction, err error) {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), "transfer amount should be greater than zero")
 			},
@@ -264,40 +517,147 @@ func TestAccount_CreateTransaction(t *testing.T) {
 
 			repo := newAccountRepositoryMock()
 
-			sourceUserID := uuid.New()
-			sourceUser := &service.User{
-				ID:       sourceUserID,
-				UserName: sourceUserID.String(),
-				Password: sourceUserID.String(),
-				Balance:  100,
+			source := &service.Wallet{UserID: uuid.New(), Currency: money.BRL, Balance: money.MustParse("100")}
+			target := &service.Wallet{UserID: uuid.New(), Currency: money.BRL, Balance: money.MustParse("100")}
+
+			if test.mutateSource != nil {
+				test.mutateSource(source)
 			}
 
-			targetUserID := uuid.New()
-			targetUser := &service.User{
-				ID:       targetUserID,
-				UserName: targetUserID.String(),
-				Password: targetUserID.String(),
-				Balance:  100,
+			if test.mutateTarget != nil {
+				test.mutateTarget(target)
 			}
 
-			if test.mutateSourceUser != nil {
-				test.mutateSourceUser(sourceUser)
+			sourceBalance := source.Balance
+			targetBalance := target.Balance
+
+			wallets := map[uuid.UUID]*service.Wallet{
+				source.UserID: source,
+				target.UserID: target,
 			}
 
-			if test.mutateTargetUser != nil {
-				test.mutateTargetUser(targetUser)
+			repo.FindAndLockUserByIDFunc = func(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+				return &service.User{ID: userID}, nil
 			}
 
-			sourceUserBalance := sourceUser.Balance
-			targetUserBalance := targetUser.Balance
+			repo.FindWalletFunc = func(ctx context.Context, userID uuid.UUID, currency money.Currency) (*service.Wallet, error) {
+				if currency != money.BRL {
+					return nil, service.ErrWalletNotFound
+				}
+				return wallets[userID], nil
+			}
 
-			users := map[uuid.UUID]*service.User{
-				sourceUser.ID: sourceUser,
-				targetUser.ID: targetUser,
+			// guarantees that every transfer is written to the ledger as a balanced entry
+			repo.CreatePostingsFunc = func(ctx context.Context, postings []service.Posting) error {
+				require.Len(t, postings, 2)
+				require.Equal(t, source.UserID, postings[0].AccountID)
+				require.Equal(t, -test.amount, postings[0].Amount)
+				require.Equal(t, target.UserID, postings[1].AccountID)
+				require.Equal(t, test.amount, postings[1].Amount)
+				return nil
 			}
 
-			repo.FindAndLockUserByIDFunc = func(ctx context.Context, userID uuid.UUID) (*service.User, error) {
-				return users[userID], nil
+			if test.mutateMock != nil {
+				test.mutateMock(repo)
+			}
+
+			currency := test.currency
+			if currency == "" {
+				currency = money.BRL
+			}
+
+			accountService := service.NewAccount(repo)
+
+			transaction, err := accountService.CreateTransaction(ctx, source.UserID, target.UserID, test.amount, currency)
+			test.checkFunction(t, source, target, sourceBalance, targetBalance, transaction, err)
+		})
+	}
+}
+
+func TestAccount_CreateIdempotentTransaction(t *testing.T) {
+
+	ctx := context.Background()
+
+	render := func(transaction *service.Transaction) (int, []byte, error) {
+		return 200, []byte(transaction.ID.String()), nil
+	}
+
+	tests := map[string]struct {
+		idempotencyKey string
+		calls          int
+		mutateMock     func(*accountRepositoryMock)
+		checkFunction  func(t *testing.T, transactionsCreated int, record *service.IdempotencyRecord, err error)
+	}{
+		"should create the transaction and store the response when the key is new": {
+			idempotencyKey: "new-key",
+			calls:          1,
+			checkFunction: func(t *testing.T, transactionsCreated int, record *service.IdempotencyRecord, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 1, transactionsCreated)
+				require.False(t, record.Replayed)
+				require.Equal(t, "new-key", record.Key)
+				require.Equal(t, 200, record.ResponseStatus)
+				require.NotEmpty(t, record.ResponseBody)
+			},
+		},
+		"should replay the stored response when the key is retried with the same payload": {
+			idempotencyKey: "retried-key",
+			calls:          2,
+			checkFunction: func(t *testing.T, transactionsCreated int, record *service.IdempotencyRecord, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 1, transactionsCreated)
+				require.True(t, record.Replayed)
+			},
+		},
+		"should return a conflict when the key was used with a different payload": {
+			idempotencyKey: "used-key",
+			calls:          1,
+			mutateMock: func(mock *accountRepositoryMock) {
+				mock.FindIdempotencyRecordFunc = func(ctx context.Context, userID uuid.UUID, key string) (*service.IdempotencyRecord, error) {
+					return &service.IdempotencyRecord{UserID: userID, Key: key, RequestHash: "another payload"}, nil
+				}
+			},
+			checkFunction: func(t *testing.T, transactionsCreated int, record *service.IdempotencyRecord, err error) {
+				require.True(t, errors.Is(err, service.ErrIdempotencyKeyReused))
+				require.Nil(t, record)
+				require.Zero(t, transactionsCreated)
+			},
+		},
+		"should return an error when the key is empty": {
+			idempotencyKey: "",
+			calls:          1,
+			checkFunction: func(t *testing.T, transactionsCreated int, record *service.IdempotencyRecord, err error) {
+				require.Error(t, err)
+				require.Zero(t, transactionsCreated)
+			},
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+
+			repo := newAccountRepositoryMock()
+
+			sourceUser := &service.User{ID: uuid.New()}
+			targetUser := &service.User{ID: uuid.New()}
+
+			repo.FindWalletFunc = func(ctx context.Context, userID uuid.UUID, currency money.Currency) (*service.Wallet, error) {
+				return &service.Wallet{UserID: userID, Currency: currency, Balance: money.MustParse("100")}, nil
+			}
+
+			transactionsCreated := 0
+			repo.CreateTransactionFunc = func(ctx context.Context, transaction *service.Transaction) error {
+				transactionsCreated++
+				return nil
+			}
+
+			records := map[string]*service.IdempotencyRecord{}
+			repo.FindIdempotencyRecordFunc = func(ctx context.Context, userID uuid.UUID, key string) (*service.IdempotencyRecord, error) {
+				return records[key], nil
+			}
+			repo.CreateIdempotencyRecordFunc = func(ctx context.Context, record *service.IdempotencyRecord) error {
+				records[record.Key] = record
+				return nil
 			}
 
 			if test.mutateMock != nil {
@@ -306,8 +666,46 @@ func TestAccount_CreateTransaction(t *testing.T) {
 
 			accountService := service.NewAccount(repo)
 
-			transaction, err := accountService.CreateTransaction(ctx, sourceUser.ID, targetUser.ID, test.amount)
-			test.checkFunction(t, sourceUser, targetUser, sourceUserBalance, targetUserBalance, transaction, err)
+			var record *service.IdempotencyRecord
+			var err error
+			for i := 0; i < test.calls; i++ {
+				record, err = accountService.CreateIdempotentTransaction(ctx, test.idempotencyKey, sourceUser.ID,
+					targetUser.ID, money.MustParse("10"), money.BRL, render)
+			}
+
+			test.checkFunction(t, transactionsCreated, record, err)
 		})
 	}
 }
+
+func TestAccount_CreateTransaction_LockOrder(t *testing.T) {
+
+	ctx := context.Background()
+
+	repo := newAccountRepositoryMock()
+
+	firstUser := &service.User{ID: uuid.New()}
+	secondUser := &service.User{ID: uuid.New()}
+
+	var lockOrder []uuid.UUID
+	repo.FindAndLockUserByIDFunc = func(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+		lockOrder = append(lockOrder, userID)
+		return &service.User{ID: userID}, nil
+	}
+
+	repo.FindWalletFunc = func(ctx context.Context, userID uuid.UUID, currency money.Currency) (*service.Wallet, error) {
+		return &service.Wallet{UserID: userID, Currency: currency, Balance: money.MustParse("100")}, nil
+	}
+
+	accountService := service.NewAccount(repo)
+
+	// transfers in opposite directions must lock the users in the same order, otherwise they could deadlock
+	_, err := accountService.CreateTransaction(ctx, firstUser.ID, secondUser.ID, money.MustParse("10"), money.BRL)
+	require.NoError(t, err)
+
+	_, err = accountService.CreateTransaction(ctx, secondUser.ID, firstUser.ID, money.MustParse("10"), money.BRL)
+	require.NoError(t, err)
+
+	require.Len(t, lockOrder, 4)
+	require.Equal(t, lockOrder[:2], lockOrder[2:])
+}
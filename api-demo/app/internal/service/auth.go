@@ -2,24 +2,119 @@ package service
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
 )
 
+// ErrInvalidCredentials is returned when the username doesn't exist or the password doesn't match it, the cases aren't
+// told apart so the users of the service can't be enumerated
+var ErrInvalidCredentials = NewError(KindUnauthorized, "invalid_credentials", "invalid username or password")
+
 // AuthenticationRepository defines a repository that is able to fetch and authenticate users
 type AuthenticationRepository interface {
 
-	// FindUserByCredentials looks up in the DB for a user that matches the userName and password combination
-	FindUserByCredentials(ctx context.Context, userName string, password string) (*User, error)
+	// FindUserByUserName looks up for a User with the given userName, failing with ErrUserNotFound if there's none
+	FindUserByUserName(ctx context.Context, userName string) (*User, error)
+
+	// UpdateUserPassword replaces the password hash of a user, failing with ErrUserNotFound if it doesn't exist
+	UpdateUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error
+
+	// FindUserByID looks up for a User with the given ID
+	FindUserByID(ctx context.Context, userID uuid.UUID) (*User, error)
+
+	// CreateToken stores an issued token
+	CreateToken(ctx context.Context, token *Token) error
+
+	// FindTokenByHash looks up for the token with the given hash, failing with ErrInvalidToken if there's none
+	FindTokenByHash(ctx context.Context, tokenHash string) (*Token, error)
+
+	// RevokeToken revokes a single token, failing with ErrInvalidToken if it was already revoked
+	RevokeToken(ctx context.Context, tokenHash string, revokedAt time.Time) error
+
+	// RevokeSession revokes all the tokens of a session that aren't revoked yet
+	RevokeSession(ctx context.Context, sessionID uuid.UUID, revokedAt time.Time) error
 }
 
 // Authentication provides implementation of Authentication
 type Authentication struct {
-	repository AuthenticationRepository
+	repository      AuthenticationRepository
+	hasher          PasswordHasher
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+
+	// dummyHash is verified when a user isn't found, so it takes as long as verifying the password of a real user
+	dummyHash     string
+	dummyHashOnce sync.Once
+}
+
+// AuthenticationOpt is an option that can be passed to NewAuthentication to configure the service
+type AuthenticationOpt func(*Authentication)
+
+// WithAccessTokenTTL returns an AuthenticationOpt that sets for how long access tokens are valid
+func WithAccessTokenTTL(ttl time.Duration) AuthenticationOpt {
+	return func(a *Authentication) {
+		a.accessTokenTTL = ttl
+	}
+}
+
+// WithRefreshTokenTTL returns an AuthenticationOpt that sets for how long refresh tokens are valid
+func WithRefreshTokenTTL(ttl time.Duration) AuthenticationOpt {
+	return func(a *Authentication) {
+		a.refreshTokenTTL = ttl
+	}
 }
 
-func NewAuthentication(repository AuthenticationRepository) *Authentication {
-	return &Authentication{repository: repository}
+func NewAuthentication(repository AuthenticationRepository, hasher PasswordHasher, opts ...AuthenticationOpt) *Authentication {
+	a := &Authentication{
+		repository:      repository,
+		hasher:          hasher,
+		accessTokenTTL:  DefaultAccessTokenTTL,
+		refreshTokenTTL: DefaultRefreshTokenTTL,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
 }
 
 func (a *Authentication) Authenticate(ctx context.Context, userName string, password string) (*User, error) {
-	return a.repository.FindUserByCredentials(ctx, userName, password)
+	user, err := a.repository.FindUserByUserName(ctx, userName)
+	if errors.Is(err, ErrUserNotFound) {
+		a.dummyHashOnce.Do(func() {
+			a.dummyHash, _ = a.hasher.Hash("dummy password")
+		})
+
+		_, _ = a.hasher.Verify(a.dummyHash, password)
+		return nil, ErrInvalidCredentials
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := a.hasher.Verify(user.PasswordHash, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if !matches {
+		return nil, ErrInvalidCredentials
+	}
+
+	// upgrades the hash when the hashing parameters changed, it's the only moment the plaintext password is known.
+	// Failing to do so isn't a reason to deny the login, it'll be attempted again on the next one.
+	if a.hasher.NeedsRehash(user.PasswordHash) {
+		if hash, err := a.hasher.Hash(password); err == nil {
+			if err := a.repository.UpdateUserPassword(ctx, user.ID, hash); err == nil {
+				user.PasswordHash = hash
+			}
+		}
+	}
+
+	return user, nil
 }
@@ -0,0 +1,221 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"api-demo/app/internal/service"
+)
+
+func TestAuthentication_Authenticate(t *testing.T) {
+
+	ctx := context.Background()
+
+	// the minimum cost keeps the tests fast, a different cost is used to create hashes that need to be upgraded
+	hasher, err := service.NewBcryptHasher(bcrypt.MinCost)
+	require.NoError(t, err)
+
+	oldHasher, err := service.NewBcryptHasher(bcrypt.MinCost + 1)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		password      string
+		hasher        service.PasswordHasher
+		mutateMock    func(*authenticationRepositoryMock)
+		checkFunction func(t *testing.T, user *service.User, rehashed string, err error)
+	}{
+		"should authenticate a user with the right password": {
+			password: "1234",
+			hasher:   hasher,
+			checkFunction: func(t *testing.T, user *service.User, rehashed string, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "breno", user.UserName)
+				require.Empty(t, rehashed)
+			},
+		},
+		"should reject a wrong password": {
+			password: "4321",
+			hasher:   hasher,
+			checkFunction: func(t *testing.T, user *service.User, rehashed string, err error) {
+				require.True(t, errors.Is(err, service.ErrInvalidCredentials))
+				require.Nil(t, user)
+			},
+		},
+		"should reject an unknown user with the same error as a wrong password": {
+			password: "1234",
+			hasher:   hasher,
+			mutateMock: func(mock *authenticationRepositoryMock) {
+				mock.FindUserByUserNameFunc = func(ctx context.Context, userName string) (*service.User, error) {
+					return nil, service.ErrUserNotFound
+				}
+			},
+			checkFunction: func(t *testing.T, user *service.User, rehashed string, err error) {
+				require.True(t, errors.Is(err, service.ErrInvalidCredentials))
+				require.Nil(t, user)
+			},
+		},
+		"should reject passwords stored in plaintext": {
+			password: "1234",
+			hasher:   hasher,
+			mutateMock: func(mock *authenticationRepositoryMock) {
+				mock.FindUserByUserNameFunc = func(ctx context.Context, userName string) (*service.User, error) {
+					return &service.User{ID: uuid.New(), UserName: userName, PasswordHash: "1234"}, nil
+				}
+			},
+			checkFunction: func(t *testing.T, user *service.User, rehashed string, err error) {
+				require.Error(t, err)
+				require.Nil(t, user)
+			},
+		},
+		"should rehash the password when the hashing cost changed": {
+			password: "1234",
+			hasher:   oldHasher,
+			checkFunction: func(t *testing.T, user *service.User, rehashed string, err error) {
+				require.NoError(t, err)
+				require.NotEmpty(t, rehashed)
+
+				cost, err := bcrypt.Cost([]byte(rehashed))
+				require.NoError(t, err)
+				require.Equal(t, bcrypt.MinCost, cost)
+				require.Equal(t, rehashed, user.PasswordHash)
+			},
+		},
+		"should return an error when the DB layer returns an error": {
+			password: "1234",
+			hasher:   hasher,
+			mutateMock: func(mock *authenticationRepositoryMock) {
+				mock.FindUserByUserNameFunc = func(ctx context.Context, userName string) (*service.User, error) {
+					return nil, errors.New("connection refused")
+				}
+			},
+			checkFunction: func(t *testing.T, user *service.User, rehashed string, err error) {
+				require.Error(t, err)
+				require.False(t, errors.Is(err, service.ErrInvalidCredentials))
+			},
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+
+			repo := newAuthenticationRepositoryMock()
+
+			hash, err := test.hasher.Hash("1234")
+			require.NoError(t, err)
+
+			repo.FindUserByUserNameFunc = func(ctx context.Context, userName string) (*service.User, error) {
+				return &service.User{ID: uuid.New(), UserName: userName, PasswordHash: hash}, nil
+			}
+
+			var rehashed string
+			repo.UpdateUserPasswordFunc = func(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+				rehashed = passwordHash
+				return nil
+			}
+
+			if test.mutateMock != nil {
+				test.mutateMock(repo)
+			}
+
+			user, err := service.NewAuthentication(repo, hasher).Authenticate(ctx, "breno", test.password)
+			test.checkFunction(t, user, rehashed, err)
+		})
+	}
+}
+
+func TestAuthentication_Tokens(t *testing.T) {
+
+	ctx := context.Background()
+
+	hasher, err := service.NewBcryptHasher(bcrypt.MinCost)
+	require.NoError(t, err)
+
+	hash, err := hasher.Hash("1234")
+	require.NoError(t, err)
+
+	user := &service.User{ID: uuid.New(), UserName: "breno", PasswordHash: hash}
+
+	newService := func(opts ...service.AuthenticationOpt) *service.Authentication {
+		repo := newAuthenticationRepositoryMock()
+		repo.withTokenStore()
+		repo.FindUserByUserNameFunc = func(ctx context.Context, userName string) (*service.User, error) {
+			return user, nil
+		}
+		repo.FindUserByIDFunc = func(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+			return user, nil
+		}
+
+		return service.NewAuthentication(repo, hasher, opts...)
+	}
+
+	t.Run("should authenticate with the access token issued by a login", func(t *testing.T) {
+		auth := newService()
+
+		pair, err := auth.Login(ctx, "breno", "1234")
+		require.NoError(t, err)
+		require.NotEqual(t, pair.AccessToken, pair.RefreshToken)
+
+		authenticated, err := auth.AuthenticateToken(ctx, pair.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, user.ID, authenticated.ID)
+
+		_, err = auth.AuthenticateToken(ctx, pair.RefreshToken)
+		require.True(t, errors.Is(err, service.ErrInvalidToken), "refresh tokens can't authenticate requests")
+	})
+
+	t.Run("should not issue tokens for invalid credentials", func(t *testing.T) {
+		_, err := newService().Login(ctx, "breno", "4321")
+		require.True(t, errors.Is(err, service.ErrInvalidCredentials))
+	})
+
+	t.Run("should reject expired access tokens", func(t *testing.T) {
+		auth := newService(service.WithAccessTokenTTL(-time.Second))
+
+		pair, err := auth.Login(ctx, "breno", "1234")
+		require.NoError(t, err)
+
+		_, err = auth.AuthenticateToken(ctx, pair.AccessToken)
+		require.True(t, errors.Is(err, service.ErrInvalidToken))
+	})
+
+	t.Run("should rotate the refresh token", func(t *testing.T) {
+		auth := newService()
+
+		pair, err := auth.Login(ctx, "breno", "1234")
+		require.NoError(t, err)
+
+		refreshed, err := auth.Refresh(ctx, pair.RefreshToken)
+		require.NoError(t, err)
+
+		_, err = auth.AuthenticateToken(ctx, refreshed.AccessToken)
+		require.NoError(t, err)
+
+		// reusing a refresh token means it leaked, so the whole session is revoked
+		_, err = auth.Refresh(ctx, pair.RefreshToken)
+		require.True(t, errors.Is(err, service.ErrInvalidToken))
+
+		_, err = auth.AuthenticateToken(ctx, refreshed.AccessToken)
+		require.True(t, errors.Is(err, service.ErrInvalidToken))
+	})
+
+	t.Run("should revoke the session on logout", func(t *testing.T) {
+		auth := newService()
+
+		pair, err := auth.Login(ctx, "breno", "1234")
+		require.NoError(t, err)
+
+		require.NoError(t, auth.Logout(ctx, pair.AccessToken))
+
+		_, err = auth.AuthenticateToken(ctx, pair.AccessToken)
+		require.True(t, errors.Is(err, service.ErrInvalidToken))
+
+		_, err = auth.Refresh(ctx, pair.RefreshToken)
+		require.True(t, errors.Is(err, service.ErrInvalidToken))
+	})
+}
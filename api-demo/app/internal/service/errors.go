@@ -0,0 +1,158 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorKind classifies errors by what went wrong, so callers can react to them without inspecting messages
+type ErrorKind string
+
+const (
+	// KindValidation is the kind of errors caused by invalid input
+	KindValidation ErrorKind = "validation"
+
+	// KindNotFound is the kind of errors caused by missing resources
+	KindNotFound ErrorKind = "not_found"
+
+	// KindConflict is the kind of errors caused by a conflict with the current state of a resource
+	KindConflict ErrorKind = "conflict"
+
+	// KindInsufficientFunds is the kind of errors caused by balances that can't cover an operation
+	KindInsufficientFunds ErrorKind = "insufficient_funds"
+
+	// KindUnauthorized is the kind of errors caused by missing or invalid credentials
+	KindUnauthorized ErrorKind = "unauthorized"
+
+	// KindForbidden is the kind of errors caused by authenticated users that aren't allowed to do an operation
+	KindForbidden ErrorKind = "forbidden"
+
+	// KindInternal is the kind of unexpected errors, their details shouldn't be exposed to clients
+	KindInternal ErrorKind = "internal"
+)
+
+// Error is an error of the services, with a kind and a machine-readable code. Errors with the same code are
+// considered the same by errors.Is, regardless of their message and cause.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+
+	// Field is the input field that caused a validation error, if any
+	Field string
+
+	// Fields are the errors of each invalid field when the input has many of them
+	Fields []*Error
+
+	// Err is the underlying cause, if any
+	Err error
+}
+
+// NewError creates an Error with a message formatted according to the format specifier
+func NewError(kind ErrorKind, code string, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewFieldError creates a validation Error caused by a field of the input
+func NewFieldError(field string, code string, format string, args ...interface{}) *Error {
+	err := NewError(KindValidation, code, format, args...)
+	err.Field = field
+	return err
+}
+
+// JoinFieldErrors combines the errors of many fields in a single validation Error. It returns nil when there are no
+// errors and the error itself when there's only one.
+func JoinFieldErrors(errs ...*Error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	joined := NewError(KindValidation, "invalid_input", "%d fields are invalid", len(errs))
+	joined.Fields = errs
+	return joined
+}
+
+// Wrap returns a copy of the error caused by err
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) > 0 {
+		messages := make([]string, 0, len(e.Fields))
+		for _, field := range e.Fields {
+			messages = append(messages, field.Error())
+		}
+
+		return e.Message + ": " + strings.Join(messages, "; ")
+	}
+
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error, or the error of one of its fields, has the same code as target
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+
+	if t.Code == e.Code {
+		return true
+	}
+
+	for _, field := range e.Fields {
+		if field.Is(target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ErrorKind returns the kind of the error
+func (e *Error) ErrorKind() string {
+	return string(e.Kind)
+}
+
+// ErrorCode returns the machine-readable code of the error
+func (e *Error) ErrorCode() string {
+	return e.Code
+}
+
+// ErrorField returns the input field that caused the error, if any
+func (e *Error) ErrorField() string {
+	return e.Field
+}
+
+// FieldErrors returns the errors of each invalid field
+func (e *Error) FieldErrors() []error {
+	errs := make([]error, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		errs = append(errs, field)
+	}
+
+	return errs
+}
+
+// PublicMessage returns the message of the error without its cause, which may have details clients shouldn't see
+func (e *Error) PublicMessage() string {
+	if e.Kind == KindInternal {
+		return "internal error"
+	}
+
+	return e.Message
+}
@@ -0,0 +1,62 @@
+package service_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"api-demo/app/internal/service"
+)
+
+func TestError(t *testing.T) {
+
+	tests := map[string]struct {
+		err           error
+		target        error
+		is            bool
+		kind          service.ErrorKind
+		publicMessage string
+	}{
+		"should match the sentinel it was created from": {
+			err:           service.ErrUserNotFound,
+			target:        service.ErrUserNotFound,
+			is:            true,
+			kind:          service.KindNotFound,
+			publicMessage: "user not found",
+		},
+		"should match errors with the same code when wrapped": {
+			err:           fmt.Errorf("failed to find user: %w", service.ErrUserNotFound.Wrap(errors.New("sql: no rows"))),
+			target:        service.ErrUserNotFound,
+			is:            true,
+			kind:          service.KindNotFound,
+			publicMessage: "user not found",
+		},
+		"should not match errors with a different code": {
+			err:           service.ErrInsufficientFunds,
+			target:        service.ErrUserNotFound,
+			is:            false,
+			kind:          service.KindInsufficientFunds,
+			publicMessage: "insufficient balance for the transaction",
+		},
+		"should hide the message of internal errors": {
+			err:           service.NewError(service.KindInternal, "db_down", "connection refused to %s", "10.0.0.1"),
+			target:        service.ErrUserNotFound,
+			is:            false,
+			kind:          service.KindInternal,
+			publicMessage: "internal error",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, test.is, errors.Is(test.err, test.target))
+
+			var serviceErr *service.Error
+			require.True(t, errors.As(test.err, &serviceErr))
+			require.Equal(t, test.kind, serviceErr.Kind)
+			require.Equal(t, test.publicMessage, serviceErr.PublicMessage())
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+
+	"api-demo/pkg/money"
+)
+
+const (
+	// DefaultHoldTTL is how long a hold reserves the funds when no TTL is given
+	DefaultHoldTTL = 24 * time.Hour
+
+	// MaxHoldTTL is the longest a hold can reserve the funds
+	MaxHoldTTL = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrHoldNotFound is returned when a hold doesn't exist
+	ErrHoldNotFound = NewError(KindNotFound, "hold_not_found", "hold not found")
+
+	// ErrHoldNotAllowed is returned when the user whose funds are held tries to capture or void the hold
+	ErrHoldNotAllowed = NewError(KindForbidden, "hold_not_allowed",
+		"only the target of a hold can capture or void it")
+
+	// ErrHoldNotActive is returned when capturing or voiding a hold that was captured or voided already
+	ErrHoldNotActive = NewError(KindConflict, "hold_not_active", "the hold was captured or voided already")
+
+	// ErrHoldExpired is returned when capturing or voiding a hold after its expiration
+	ErrHoldExpired = NewError(KindConflict, "hold_expired", "the hold expired")
+
+	// ErrCaptureExceedsHold is returned when capturing more than the held amount
+	ErrCaptureExceedsHold = NewFieldError("amount", "capture_exceeds_hold", "the amount exceeds the held amount")
+)
+
+// HoldStatus is the state of a hold, only active holds reserve funds
+type HoldStatus string
+
+const (
+	// HoldActive means the funds are reserved until the hold is captured, voided or expires
+	HoldActive HoldStatus = "active"
+
+	// HoldCaptured means the held funds, or part of them, were transferred to the target
+	HoldCaptured HoldStatus = "captured"
+
+	// HoldVoided means the held funds were released without a transfer
+	HoldVoided HoldStatus = "voided"
+
+	// HoldExpired means the held funds were released because the hold wasn't captured in time
+	HoldExpired HoldStatus = "expired"
+)
+
+// Hold reserves an amount of the balance of a user to be transferred to the target user later, the reserved amount
+// isn't available for other transfers but it's still part of the current balance until captured
+type Hold struct {
+	ID           uuid.UUID      `json:"id"`
+	UserID       uuid.UUID      `json:"user_id"`
+	TargetUserID uuid.UUID      `json:"target_user_id"`
+	Amount       money.Amount   `json:"amount"`
+	Currency     money.Currency `json:"currency"`
+	Status       HoldStatus     `json:"status"`
+
+	// CapturedAmount and TransactionID are the amount and the transaction of the capture, once captured
+	CapturedAmount money.Amount `json:"captured_amount,omitempty"`
+	TransactionID  *uuid.UUID   `json:"transaction_id,omitempty"`
+
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// holdFields has the fields of a Hold without its MarshalJSON method
+type holdFields Hold
+
+// MarshalJSON encodes the hold with its amounts formatted in its currency
+func (h Hold) MarshalJSON() ([]byte, error) {
+	view := struct {
+		holdFields
+		Amount         money.Money  `json:"amount"`
+		CapturedAmount *money.Money `json:"captured_amount,omitempty"`
+	}{holdFields: holdFields(h), Amount: money.Money{Amount: h.Amount, Currency: h.Currency}}
+
+	if h.CapturedAmount != 0 {
+		view.CapturedAmount = &money.Money{Amount: h.CapturedAmount, Currency: h.Currency}
+	}
+
+	return json.Marshal(view)
+}
+
+// expired tells whether the hold is active past its expiration at the given time
+func (h *Hold) expired(at time.Time) bool {
+	return h.Status == HoldActive && !at.Before(h.ExpiresAt)
+}
+
+// PlaceHold reserves an amount of the balance of userID in the currency to be captured by targetUserID within the TTL,
+// or DefaultHoldTTL when it's zero. The available balance of the user should cover the hold.
+func (service *Account) PlaceHold(ctx context.Context, userID uuid.UUID, targetUserID uuid.UUID,
+	amount money.Amount, currency money.Currency, ttl time.Duration) (*Hold, error) {
+
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	if userID == targetUserID {
+		return nil, NewFieldError("target_user_id", "same_source_and_target",
+			"the target user should be different than the source user")
+	}
+
+	if amount <= 0 {
+		return nil, NewFieldError("amount", "invalid_amount", "hold amount should be greater than zero")
+	}
+
+	if err := validateCurrency(currency); err != nil {
+		return nil, err
+	}
+
+	if ttl == 0 {
+		ttl = DefaultHoldTTL
+	}
+
+	if ttl < 0 || ttl > MaxHoldTTL {
+		return nil, NewFieldError("ttl_seconds", "invalid_ttl", "the TTL should be positive and at most %s",
+			MaxHoldTTL)
+	}
+
+	var hold *Hold
+	err := service.repository.WithTx(ctx, func(txRepo AccountRepository) error {
+		// the target is locked as well only to guarantee that it can receive the currency
+		wallets, err := lockWallets(ctx, txRepo, currency, userID, targetUserID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		available, err := availableBalance(ctx, txRepo, wallets[userID], now)
+		if err != nil {
+			return err
+		}
+
+		if available < amount {
+			return ErrInsufficientFunds
+		}
+
+		hold = &Hold{
+			ID:           uuid.New(),
+			UserID:       userID,
+			TargetUserID: targetUserID,
+			Amount:       amount,
+			Currency:     currency,
+			Status:       HoldActive,
+			ExpiresAt:    now.Add(ttl),
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+
+		return txRepo.CreateHold(ctx, hold)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return hold, nil
+}
+
+// CaptureHold transfers an amount of a hold targeting userID, or the whole held amount when amount is nil, releasing
+// what wasn't captured. A hold is captured at most once.
+func (service *Account) CaptureHold(ctx context.Context, userID uuid.UUID, holdID uuid.UUID,
+	amount *money.Amount) (*Hold, error) {
+
+	return service.settleHold(ctx, userID, holdID, func(txRepo AccountRepository, hold *Hold,
+		wallets map[uuid.UUID]*Wallet, now time.Time) error {
+
+		if amount == nil {
+			amount = &hold.Amount
+		}
+
+		if *amount <= 0 {
+			return NewFieldError("amount", "invalid_amount", "capture amount should be greater than zero")
+		}
+
+		if *amount > hold.Amount {
+			return NewFieldError("amount", ErrCaptureExceedsHold.Code, "the amount exceeds the held %s",
+				money.Money{Amount: hold.Amount, Currency: hold.Currency})
+		}
+
+		// the hold stops reserving the funds before they're transferred, so they're available to its own capture
+		hold.Status = HoldCaptured
+		hold.CapturedAmount = *amount
+		hold.UpdatedAt = now
+		if err := txRepo.UpdateHold(ctx, hold); err != nil {
+			return err
+		}
+
+		source, target := wallets[hold.UserID], wallets[hold.TargetUserID]
+		available, err := availableBalance(ctx, txRepo, source, now)
+		if err != nil {
+			return err
+		}
+
+		// the balance only falls short of an active hold when a reversal took money from the user meanwhile
+		if available < *amount {
+			return ErrInsufficientFunds
+		}
+
+		transaction := &Transaction{
+			ID:           uuid.New(),
+			SourceUserID: hold.UserID,
+			TargetUserID: hold.TargetUserID,
+			Amount:       *amount,
+			Currency:     hold.Currency,
+			CreatedAt:    now,
+		}
+
+		if err := moveMoney(ctx, txRepo, source, target, transaction); err != nil {
+			return err
+		}
+
+		hold.TransactionID = &transaction.ID
+		return txRepo.UpdateHold(ctx, hold)
+	})
+}
+
+// VoidHold releases the funds reserved by a hold targeting userID without transferring them
+func (service *Account) VoidHold(ctx context.Context, userID uuid.UUID, holdID uuid.UUID) (*Hold, error) {
+	return service.settleHold(ctx, userID, holdID, func(txRepo AccountRepository, hold *Hold,
+		_ map[uuid.UUID]*Wallet, now time.Time) error {
+
+		hold.Status = HoldVoided
+		hold.UpdatedAt = now
+		return txRepo.UpdateHold(ctx, hold)
+	})
+}
+
+// settleHold runs settle on an active hold targeting userID, with both of its users and their wallets in the currency
+// of the hold locked. A hold found past its expiration is marked as expired instead, failing with ErrHoldExpired.
+func (service *Account) settleHold(ctx context.Context, userID uuid.UUID, holdID uuid.UUID,
+	settle func(txRepo AccountRepository, hold *Hold, wallets map[uuid.UUID]*Wallet, now time.Time) error) (*Hold,
+	error) {
+
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	var hold *Hold
+	expired := false
+	err := service.repository.WithTx(ctx, func(txRepo AccountRepository) error {
+		found, err := txRepo.FindHoldByID(ctx, holdID)
+		if err != nil {
+			return err
+		}
+
+		// the holds of other users aren't disclosed
+		if found.TargetUserID != userID {
+			if found.UserID == userID {
+				return ErrHoldNotAllowed
+			}
+
+			return ErrHoldNotFound
+		}
+
+		// every change of a hold locks its users, so it's read again once nothing else can change it
+		wallets, err := lockWallets(ctx, txRepo, found.Currency, found.UserID, found.TargetUserID)
+		if err != nil {
+			return err
+		}
+
+		if hold, err = txRepo.FindHoldByID(ctx, holdID); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if hold.expired(now) {
+			// the expiration is committed, it's only reported to the caller afterwards
+			expired = true
+			hold.Status = HoldExpired
+			hold.UpdatedAt = now
+			return txRepo.UpdateHold(ctx, hold)
+		}
+
+		if hold.Status != HoldActive {
+			if hold.Status == HoldExpired {
+				return ErrHoldExpired
+			}
+
+			return ErrHoldNotActive
+		}
+
+		return settle(txRepo, hold, wallets, now)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if expired {
+		return nil, ErrHoldExpired
+	}
+
+	return hold, nil
+}
+
+// GetHold retrieves a hold placed by or targeting userID, holds past their expiration are reported as expired
+func (service *Account) GetHold(ctx context.Context, userID uuid.UUID, holdID uuid.UUID) (*Hold, error) {
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	hold, err := service.repository.FindHoldByID(ctx, holdID)
+	if err != nil {
+		return nil, err
+	}
+
+	if hold.UserID != userID && hold.TargetUserID != userID {
+		return nil, ErrHoldNotFound
+	}
+
+	if hold.expired(time.Now()) {
+		hold.Status = HoldExpired
+		hold.UpdatedAt = hold.ExpiresAt
+	}
+
+	return hold, nil
+}
+
+// ExpireHolds marks the active holds past their expiration as expired, so they don't stay active until they're read.
+// Holds stop reserving the funds once their TTL is over regardless, this only settles their status.
+func (service *Account) ExpireHolds(ctx context.Context) error {
+	return service.repository.ExpireHolds(ctx, time.Now())
+}
+
+// availableBalance returns the balance of the wallet minus the amount reserved by the active holds of its user in its
+// currency at the given time
+func availableBalance(ctx context.Context, repo AccountRepository, wallet *Wallet, at time.Time) (money.Amount,
+	error) {
+
+	held, err := repo.SumActiveHolds(ctx, wallet.UserID, wallet.Currency, at)
+	if err != nil {
+		return 0, err
+	}
+
+	return wallet.Balance.Sub(held)
+}
@@ -0,0 +1,427 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+// holdFixture is an active hold of 100 on the BRL balance of 100 of the user, to be captured by the target
+type holdFixture struct {
+	*transferFixture
+	hold *service.Hold
+
+	updates []service.Hold
+}
+
+func newHoldFixture(t *testing.T) *holdFixture {
+	now := time.Now()
+	f := &holdFixture{transferFixture: newTransferFixture(100, 0)}
+
+	f.hold = &service.Hold{ID: uuid.New(), UserID: f.user.ID, TargetUserID: f.target.ID, Amount: 100,
+		Currency: money.BRL, Status: service.HoldActive, ExpiresAt: now.Add(time.Hour), CreatedAt: now, UpdatedAt: now}
+
+	f.repo.FindHoldByIDFunc = func(ctx context.Context, holdID uuid.UUID) (*service.Hold, error) {
+		if holdID != f.hold.ID {
+			return nil, service.ErrHoldNotFound
+		}
+
+		hold := *f.hold
+		return &hold, nil
+	}
+
+	f.repo.UpdateHoldFunc = func(ctx context.Context, hold *service.Hold) error {
+		f.updates = append(f.updates, *hold)
+		return nil
+	}
+
+	// the latest update of the hold tells whether it still reserves its amount
+	f.repo.SumActiveHoldsFunc = func(ctx context.Context, userID uuid.UUID, currency money.Currency,
+		at time.Time) (money.Amount, error) {
+
+		status := f.hold.Status
+		if len(f.updates) > 0 {
+			status = f.updates[len(f.updates)-1].Status
+		}
+
+		if userID != f.user.ID || status != service.HoldActive || !at.Before(f.hold.ExpiresAt) {
+			return 0, nil
+		}
+
+		return f.hold.Amount, nil
+	}
+
+	return f
+}
+
+func TestAccount_PlaceHold(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		mutate      func(*holdFixture)
+		held        money.Amount
+		amount      money.Amount
+		ttl         time.Duration
+		expectedTTL time.Duration
+		expectedErr error
+	}{
+		"should place a hold with the default TTL": {
+			amount:      30,
+			expectedTTL: service.DefaultHoldTTL,
+		},
+		"should place a hold with the given TTL": {
+			amount:      30,
+			ttl:         time.Minute,
+			expectedTTL: time.Minute,
+		},
+		"should place a hold of the whole available balance": {
+			held:        40,
+			amount:      60,
+			expectedTTL: service.DefaultHoldTTL,
+		},
+		"should not place a hold over what the active holds left available": {
+			held:        40,
+			amount:      61,
+			expectedErr: service.ErrInsufficientFunds,
+		},
+		"should not count the expired holds": {
+			held: 100,
+			mutate: func(f *holdFixture) {
+				f.hold.ExpiresAt = time.Now().Add(-time.Minute)
+			},
+			amount:      100,
+			expectedTTL: service.DefaultHoldTTL,
+		},
+		"should not place a hold of a non positive amount": {
+			amount:      0,
+			expectedErr: service.NewFieldError("amount", "invalid_amount", ""),
+		},
+		"should not place a hold longer than the max TTL": {
+			amount:      30,
+			ttl:         service.MaxHoldTTL + time.Second,
+			expectedErr: service.NewFieldError("ttl_seconds", "invalid_ttl", ""),
+		},
+		"should not place a hold with a negative TTL": {
+			amount:      30,
+			ttl:         -time.Second,
+			expectedErr: service.NewFieldError("ttl_seconds", "invalid_ttl", ""),
+		},
+		"should not place a hold targeting the user": {
+			mutate: func(f *holdFixture) {
+				f.target.ID = f.user.ID
+			},
+			amount:      30,
+			expectedErr: service.NewFieldError("target_user_id", "same_source_and_target", ""),
+		},
+		"should not place a hold in a currency the target has no wallet in": {
+			mutate: func(f *holdFixture) {
+				f.targetWallet.Currency = money.USD
+			},
+			amount:      30,
+			expectedErr: service.ErrCurrencyMismatch,
+		},
+		"should not place a hold targeting a user that doesn't exist": {
+			mutate: func(f *holdFixture) {
+				f.target.ID = uuid.New()
+			},
+			amount:      30,
+			expectedErr: service.ErrUserNotFound,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			f := newHoldFixture(t)
+			f.hold.Amount = test.held
+			if test.mutate != nil {
+				test.mutate(f)
+			}
+
+			var created *service.Hold
+			f.repo.CreateHoldFunc = func(ctx context.Context, hold *service.Hold) error {
+				created = hold
+				return nil
+			}
+
+			hold, err := service.NewAccount(f.repo).PlaceHold(ctx, f.user.ID, f.target.ID, test.amount, money.BRL,
+				test.ttl)
+			if test.expectedErr != nil {
+				require.True(t, errors.Is(err, test.expectedErr), "unexpected error %v", err)
+				require.Nil(t, hold)
+				require.Nil(t, created)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, created, hold)
+			require.Equal(t, service.HoldActive, hold.Status)
+			require.Equal(t, f.user.ID, hold.UserID)
+			require.Equal(t, f.target.ID, hold.TargetUserID)
+			require.Equal(t, money.BRL, hold.Currency)
+			require.Equal(t, test.amount, hold.Amount)
+			require.Equal(t, test.expectedTTL, hold.ExpiresAt.Sub(hold.CreatedAt))
+
+			// the funds are reserved, not moved
+			require.Equal(t, money.Amount(100), f.userWallet.Balance)
+			require.Empty(t, f.created)
+		})
+	}
+}
+
+func TestAccount_CaptureHold(t *testing.T) {
+
+	ctx := context.Background()
+
+	amount := func(amount money.Amount) *money.Amount {
+		return &amount
+	}
+
+	tests := map[string]struct {
+		mutate         func(*holdFixture)
+		capturer       func(*holdFixture) uuid.UUID
+		amount         *money.Amount
+		expectedAmount money.Amount
+		expectedErr    error
+		expectedStatus service.HoldStatus
+	}{
+		"should capture the whole hold when no amount is given": {
+			expectedAmount: 100,
+		},
+		"should capture part of the hold": {
+			amount:         amount(30),
+			expectedAmount: 30,
+		},
+		"should not capture more than the hold": {
+			amount:      amount(101),
+			expectedErr: service.ErrCaptureExceedsHold,
+		},
+		"should not capture a non positive amount": {
+			amount:      amount(0),
+			expectedErr: service.NewFieldError("amount", "invalid_amount", ""),
+		},
+		"should not capture when a reversal took the balance meanwhile": {
+			mutate: func(f *holdFixture) {
+				f.userWallet.Balance = 20
+			},
+			amount:      amount(30),
+			expectedErr: service.ErrInsufficientFunds,
+		},
+		"should not capture a voided hold": {
+			mutate: func(f *holdFixture) {
+				f.hold.Status = service.HoldVoided
+			},
+			expectedErr: service.ErrHoldNotActive,
+		},
+		"should not capture a captured hold": {
+			mutate: func(f *holdFixture) {
+				f.hold.Status = service.HoldCaptured
+			},
+			expectedErr: service.ErrHoldNotActive,
+		},
+		"should not capture an expired hold": {
+			mutate: func(f *holdFixture) {
+				f.hold.Status = service.HoldExpired
+			},
+			expectedErr: service.ErrHoldExpired,
+		},
+		"should expire a hold found past its expiration": {
+			mutate: func(f *holdFixture) {
+				f.hold.ExpiresAt = time.Now().Add(-time.Minute)
+			},
+			expectedErr:    service.ErrHoldExpired,
+			expectedStatus: service.HoldExpired,
+		},
+		"should not let the user whose funds are held capture the hold": {
+			capturer: func(f *holdFixture) uuid.UUID {
+				return f.user.ID
+			},
+			expectedErr: service.ErrHoldNotAllowed,
+		},
+		"should not disclose the holds of other users": {
+			capturer: func(f *holdFixture) uuid.UUID {
+				return uuid.New()
+			},
+			expectedErr: service.ErrHoldNotFound,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			f := newHoldFixture(t)
+			if test.mutate != nil {
+				test.mutate(f)
+			}
+
+			capturer := f.target.ID
+			if test.capturer != nil {
+				capturer = test.capturer(f)
+			}
+
+			hold, err := service.NewAccount(f.repo).CaptureHold(ctx, capturer, f.hold.ID, test.amount)
+			if test.expectedErr != nil {
+				require.True(t, errors.Is(err, test.expectedErr), "unexpected error %v", err)
+				require.Nil(t, hold)
+				require.Empty(t, f.created)
+
+				if test.expectedStatus != "" {
+					require.Len(t, f.updates, 1)
+					require.Equal(t, test.expectedStatus, f.updates[0].Status)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, f.created, 1)
+
+			transaction := f.created[0]
+			require.Equal(t, test.expectedAmount, transaction.Amount)
+			require.Equal(t, f.user.ID, transaction.SourceUserID)
+			require.Equal(t, f.target.ID, transaction.TargetUserID)
+
+			require.Equal(t, service.HoldCaptured, hold.Status)
+			require.Equal(t, test.expectedAmount, hold.CapturedAmount)
+			require.Equal(t, &transaction.ID, hold.TransactionID)
+			require.Equal(t, *hold, f.updates[len(f.updates)-1])
+
+			require.Equal(t, 100-test.expectedAmount, f.userWallet.Balance)
+			require.Equal(t, test.expectedAmount, f.targetWallet.Balance)
+		})
+	}
+}
+
+func TestAccount_VoidHold(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		mutate      func(*holdFixture)
+		voider      func(*holdFixture) uuid.UUID
+		expectedErr error
+	}{
+		"should void an active hold": {},
+		"should not void a captured hold": {
+			mutate: func(f *holdFixture) {
+				f.hold.Status = service.HoldCaptured
+			},
+			expectedErr: service.ErrHoldNotActive,
+		},
+		"should not void a hold found past its expiration": {
+			mutate: func(f *holdFixture) {
+				f.hold.ExpiresAt = time.Now().Add(-time.Minute)
+			},
+			expectedErr: service.ErrHoldExpired,
+		},
+		"should not let the user whose funds are held void the hold": {
+			voider: func(f *holdFixture) uuid.UUID {
+				return f.user.ID
+			},
+			expectedErr: service.ErrHoldNotAllowed,
+		},
+		"should fail when the hold doesn't exist": {
+			mutate: func(f *holdFixture) {
+				f.hold.ID = uuid.New()
+				f.repo.FindHoldByIDFunc = func(ctx context.Context, holdID uuid.UUID) (*service.Hold, error) {
+					return nil, service.ErrHoldNotFound
+				}
+			},
+			expectedErr: service.ErrHoldNotFound,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			f := newHoldFixture(t)
+			if test.mutate != nil {
+				test.mutate(f)
+			}
+
+			voider := f.target.ID
+			if test.voider != nil {
+				voider = test.voider(f)
+			}
+
+			hold, err := service.NewAccount(f.repo).VoidHold(ctx, voider, f.hold.ID)
+			if test.expectedErr != nil {
+				require.True(t, errors.Is(err, test.expectedErr), "unexpected error %v", err)
+				require.Nil(t, hold)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, service.HoldVoided, hold.Status)
+			require.Equal(t, []service.Hold{*hold}, f.updates)
+			require.Empty(t, f.created)
+			require.Equal(t, money.Amount(100), f.userWallet.Balance)
+		})
+	}
+}
+
+func TestAccount_GetHold(t *testing.T) {
+
+	ctx := context.Background()
+
+	t.Run("should get a hold of either of its users", func(t *testing.T) {
+		f := newHoldFixture(t)
+
+		for _, userID := range []uuid.UUID{f.user.ID, f.target.ID} {
+			hold, err := service.NewAccount(f.repo).GetHold(ctx, userID, f.hold.ID)
+			require.NoError(t, err)
+			require.Equal(t, f.hold, hold)
+		}
+	})
+
+	t.Run("should report a hold past its expiration as expired", func(t *testing.T) {
+		f := newHoldFixture(t)
+		f.hold.ExpiresAt = time.Now().Add(-time.Minute)
+
+		hold, err := service.NewAccount(f.repo).GetHold(ctx, f.user.ID, f.hold.ID)
+		require.NoError(t, err)
+		require.Equal(t, service.HoldExpired, hold.Status)
+		require.Equal(t, f.hold.ExpiresAt, hold.UpdatedAt)
+	})
+
+	t.Run("should not disclose the holds of other users", func(t *testing.T) {
+		f := newHoldFixture(t)
+
+		hold, err := service.NewAccount(f.repo).GetHold(ctx, uuid.New(), f.hold.ID)
+		require.True(t, errors.Is(err, service.ErrHoldNotFound))
+		require.Nil(t, hold)
+	})
+}
+
+func TestAccount_ExpireHolds(t *testing.T) {
+
+	ctx := context.Background()
+
+	t.Run("should expire the holds past their expiration by now", func(t *testing.T) {
+		f := newHoldFixture(t)
+
+		var expiredAt time.Time
+		f.repo.ExpireHoldsFunc = func(ctx context.Context, at time.Time) error {
+			expiredAt = at
+			return nil
+		}
+
+		before := time.Now()
+		require.NoError(t, service.NewAccount(f.repo).ExpireHolds(ctx))
+		require.False(t, expiredAt.Before(before))
+		require.False(t, expiredAt.After(time.Now()))
+	})
+
+	t.Run("should fail when the holds can't be expired", func(t *testing.T) {
+		f := newHoldFixture(t)
+		f.repo.ExpireHoldsFunc = func(ctx context.Context, at time.Time) error {
+			return errors.New("unexpected")
+		}
+
+		require.EqualError(t, service.NewAccount(f.repo).ExpireHolds(ctx), "unexpected")
+	})
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxIdempotencyKeyLength is the maximum length accepted for an idempotency key
+const maxIdempotencyKeyLength = 255
+
+var (
+	// ErrIdempotencyKeyReused is returned when an idempotency key is reused with a different payload
+	ErrIdempotencyKeyReused = NewError(KindConflict, "idempotency_key_reused",
+		"the idempotency key was already used with a different payload")
+
+	// ErrIdempotencyKeyInProgress is returned when a concurrent request with the same idempotency key won the race
+	ErrIdempotencyKeyInProgress = NewError(KindConflict, "idempotency_key_in_progress",
+		"a request with the same idempotency key is being processed, try again later")
+)
+
+// IdempotencyRecord is the stored result of a request made with an idempotency key
+type IdempotencyRecord struct {
+	UserID         uuid.UUID
+	Key            string
+	RequestHash    string
+	ResponseStatus int
+	ResponseBody   []byte
+	CreatedAt      time.Time
+
+	// Replayed is true when the record was previously stored and the request wasn't executed again
+	Replayed bool
+}
+
+// ResponseRenderer renders the response of a created transaction, so it can be stored along with the transaction and
+// replayed later
+type ResponseRenderer func(transaction *Transaction) (status int, body []byte, err error)
+
+// hashRequest fingerprints the operation and parameters of a request, so reusing a key for a different payload can be
+// detected
+func hashRequest(operation string, params ...string) string {
+	digest := sha256.Sum256([]byte(operation + "|" + strings.Join(params, "|")))
+	return hex.EncodeToString(digest[:])
+}
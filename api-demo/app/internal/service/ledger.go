@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"api-demo/pkg/money"
+)
+
+// ExternalAccountID is the ledger account money enters the system from (e.g. opening balances), it's the counterpart
+// of every posting that isn't a transfer between users, so its balance is minus the money held by all the users
+var ExternalAccountID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// Posting is a single debit (negative amount) or credit (positive amount) on a ledger account in a currency. Postings
+// are grouped by entries, and the postings of every entry must sum to zero in every currency.
+type Posting struct {
+	EntryID   uuid.UUID      `json:"entry_id"`
+	AccountID uuid.UUID      `json:"account_id"`
+	Amount    money.Amount   `json:"amount"`
+	Currency  money.Currency `json:"currency"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// postingFields has the fields of a Posting without its MarshalJSON method
+type postingFields Posting
+
+// MarshalJSON encodes the posting with its amount formatted in its currency
+func (p Posting) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		postingFields
+		Amount money.Money `json:"amount"`
+	}{postingFields(p), money.Money{Amount: p.Amount, Currency: p.Currency}})
+}
+
+// BalanceMismatch describes a wallet whose cached balance differs from the sum of the postings of its user in its
+// currency
+type BalanceMismatch struct {
+	UserID        uuid.UUID      `json:"user_id"`
+	Currency      money.Currency `json:"currency"`
+	CachedBalance money.Amount   `json:"cached_balance"`
+	LedgerBalance money.Amount   `json:"ledger_balance"`
+}
+
+// balanceMismatchFields has the fields of a BalanceMismatch without its MarshalJSON method
+type balanceMismatchFields BalanceMismatch
+
+// MarshalJSON encodes the mismatch with its balances formatted in its currency
+func (m BalanceMismatch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		balanceMismatchFields
+		CachedBalance money.Money `json:"cached_balance"`
+		LedgerBalance money.Money `json:"ledger_balance"`
+	}{
+		balanceMismatchFields(m),
+		money.Money{Amount: m.CachedBalance, Currency: m.Currency},
+		money.Money{Amount: m.LedgerBalance, Currency: m.Currency},
+	})
+}
+
+// ConsistencyReport is the result of checking the ledger
+type ConsistencyReport struct {
+	PostingsSums      map[money.Currency]money.Amount `json:"postings_sums"`
+	UnbalancedEntries []uuid.UUID                     `json:"unbalanced_entries"`
+	BalanceMismatches []BalanceMismatch               `json:"balance_mismatches"`
+}
+
+// consistencyReportFields has the fields of a ConsistencyReport without its MarshalJSON method
+type consistencyReportFields ConsistencyReport
+
+// MarshalJSON encodes the report with the sums of the postings formatted in their currencies
+func (r ConsistencyReport) MarshalJSON() ([]byte, error) {
+	sums := make(map[money.Currency]money.Money, len(r.PostingsSums))
+	for currency, sum := range r.PostingsSums {
+		sums[currency] = money.Money{Amount: sum, Currency: currency}
+	}
+
+	return json.Marshal(struct {
+		consistencyReportFields
+		PostingsSums map[money.Currency]money.Money `json:"postings_sums"`
+	}{consistencyReportFields(r), sums})
+}
+
+// Consistent returns whether the ledger has no problems
+func (r *ConsistencyReport) Consistent() bool {
+	for _, sum := range r.PostingsSums {
+		if sum != 0 {
+			return false
+		}
+	}
+
+	return len(r.UnbalancedEntries) == 0 && len(r.BalanceMismatches) == 0
+}
+
+// LedgerRepository defines features that should be provided to the ledger regarding storage
+type LedgerRepository interface {
+
+	// SumPostings returns the sum of every posting in the ledger by currency
+	SumPostings(ctx context.Context) (map[money.Currency]money.Amount, error)
+
+	// ListUnbalancedEntries lists the IDs of the entries whose postings don't sum to zero in any of their currencies
+	ListUnbalancedEntries(ctx context.Context) ([]uuid.UUID, error)
+
+	// ListBalanceMismatches lists the wallets whose cached balance differs from the sum of the postings of their user in
+	// their currency
+	ListBalanceMismatches(ctx context.Context) ([]BalanceMismatch, error)
+}
+
+// Ledger provides services to verify the ledger that backs the balances of the users
+type Ledger struct {
+	repository LedgerRepository
+}
+
+func NewLedger(repository LedgerRepository) *Ledger {
+	return &Ledger{repository: repository}
+}
+
+// CheckConsistency asserts that all the postings sum to zero in every currency, that every entry is balanced and that
+// every cached balance matches the postings of its user in its currency
+func (ledger *Ledger) CheckConsistency(ctx context.Context) (*ConsistencyReport, error) {
+
+	sums, err := ledger.repository.SumPostings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	unbalancedEntries, err := ledger.repository.ListUnbalancedEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches, err := ledger.repository.ListBalanceMismatches(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsistencyReport{
+		PostingsSums:      sums,
+		UnbalancedEntries: unbalancedEntries,
+		BalanceMismatches: mismatches,
+	}, nil
+}
+
+// transferPostings creates the balanced postings that move the amount of a transaction between its users
+func transferPostings(transaction *Transaction) []Posting {
+	return []Posting{
+		{
+			EntryID:   transaction.ID,
+			AccountID: transaction.SourceUserID,
+			Amount:    -transaction.Amount,
+			Currency:  transaction.Currency,
+			CreatedAt: transaction.CreatedAt,
+		},
+		{
+			EntryID:   transaction.ID,
+			AccountID: transaction.TargetUserID,
+			Amount:    transaction.Amount,
+			Currency:  transaction.Currency,
+			CreatedAt: transaction.CreatedAt,
+		},
+	}
+}
+
+// checkBalanced guarantees that postings belong to the same entry and currency and sum to zero before they're written
+func checkBalanced(postings []Posting) error {
+	if len(postings) < 2 {
+		return errors.New("an entry needs at least 2 postings")
+	}
+
+	var sum money.Amount
+	for _, posting := range postings {
+		if posting.EntryID != postings[0].EntryID {
+			return errors.New("postings of an entry should share the same entry ID")
+		}
+
+		if posting.Currency != postings[0].Currency {
+			return errors.New("postings of an entry should share the same currency")
+		}
+
+		var err error
+		if sum, err = sum.Add(posting.Amount); err != nil {
+			return err
+		}
+	}
+
+	if sum != 0 {
+		return fmt.Errorf("unbalanced entry %s, postings sum to %s", postings[0].EntryID, sum)
+	}
+
+	return nil
+}
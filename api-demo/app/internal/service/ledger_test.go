@@ -0,0 +1,127 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+func TestLedger_CheckConsistency(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		mutateMock    func(*ledgerRepositoryMock)
+		checkFunction func(*testing.T, *service.ConsistencyReport, error)
+	}{
+		"should report a consistent ledger": {
+			checkFunction: func(t *testing.T, report *service.ConsistencyReport, err error) {
+				require.NoError(t, err)
+				require.True(t, report.Consistent())
+			},
+		},
+		"should report postings that don't sum to zero": {
+			mutateMock: func(mock *ledgerRepositoryMock) {
+				mock.SumPostingsFunc = func(context.Context) (map[money.Currency]money.Amount, error) {
+					return map[money.Currency]money.Amount{money.BRL: 0, money.USD: money.MustParse("0.01")}, nil
+				}
+			},
+			checkFunction: func(t *testing.T, report *service.ConsistencyReport, err error) {
+				require.NoError(t, err)
+				require.False(t, report.Consistent())
+				require.Equal(t, money.MustParse("0.01"), report.PostingsSums[money.USD])
+			},
+		},
+		"should report unbalanced entries": {
+			mutateMock: func(mock *ledgerRepositoryMock) {
+				mock.ListUnbalancedEntriesFunc = func(context.Context) ([]uuid.UUID, error) {
+					return []uuid.UUID{uuid.New()}, nil
+				}
+			},
+			checkFunction: func(t *testing.T, report *service.ConsistencyReport, err error) {
+				require.NoError(t, err)
+				require.False(t, report.Consistent())
+				require.Len(t, report.UnbalancedEntries, 1)
+			},
+		},
+		"should report cached balances that don't match the postings": {
+			mutateMock: func(mock *ledgerRepositoryMock) {
+				mock.ListBalanceMismatchesFunc = func(context.Context) ([]service.BalanceMismatch, error) {
+					return []service.BalanceMismatch{
+						{UserID: uuid.New(), Currency: money.BRL, CachedBalance: 1000, LedgerBalance: 900},
+					}, nil
+				}
+			},
+			checkFunction: func(t *testing.T, report *service.ConsistencyReport, err error) {
+				require.NoError(t, err)
+				require.False(t, report.Consistent())
+				require.Len(t, report.BalanceMismatches, 1)
+			},
+		},
+		"should return an error when the DB layer returns an error": {
+			mutateMock: func(mock *ledgerRepositoryMock) {
+				mock.SumPostingsFunc = func(context.Context) (map[money.Currency]money.Amount, error) {
+					return nil, errors.New("connection refused")
+				}
+			},
+			checkFunction: func(t *testing.T, report *service.ConsistencyReport, err error) {
+				require.Error(t, err)
+				require.Nil(t, report)
+			},
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+
+			repo := newLedgerRepositoryMock()
+			if test.mutateMock != nil {
+				test.mutateMock(repo)
+			}
+
+			report, err := service.NewLedger(repo).CheckConsistency(ctx)
+			test.checkFunction(t, report, err)
+		})
+	}
+}
+
+func TestConsistencyReport_MarshalJSON(t *testing.T) {
+
+	userID := uuid.MustParse("6a3d1e3a-4cbc-4d43-a1e1-2b2b7c9d3d4f")
+	entryID := uuid.MustParse("0b8b5c43-3f7e-4b55-9a2c-1a8e1f6c4a10")
+	report := service.ConsistencyReport{
+		PostingsSums:      map[money.Currency]money.Amount{money.BRL: 0, money.JPY: 1500, money.KWD: -1005},
+		UnbalancedEntries: []uuid.UUID{entryID},
+		BalanceMismatches: []service.BalanceMismatch{
+			{UserID: userID, Currency: money.JPY, CachedBalance: 1000, LedgerBalance: 900},
+			{UserID: userID, Currency: money.KWD, CachedBalance: 1500, LedgerBalance: 1005},
+		},
+	}
+
+	// the amounts are formatted with the minor units of their currency, not with 2 decimals
+	expected := `{
+		"postings_sums": {"BRL": 0.00, "JPY": 1500, "KWD": -1.005},
+		"unbalanced_entries": ["` + entryID.String() + `"],
+		"balance_mismatches": [
+			{"user_id": "` + userID.String() + `", "currency": "JPY", "cached_balance": 1000, "ledger_balance": 900},
+			{"user_id": "` + userID.String() + `", "currency": "KWD", "cached_balance": 1.500, "ledger_balance": 1.005}
+		]
+	}`
+
+	encoded, err := json.Marshal(&report)
+	require.NoError(t, err)
+	require.JSONEq(t, expected, string(encoded))
+	require.Contains(t, string(encoded), `"KWD":-1.005`)
+
+	posting := service.Posting{EntryID: entryID, AccountID: userID, Amount: -1005, Currency: money.KWD}
+	encoded, err = json.Marshal(posting)
+	require.NoError(t, err)
+	require.Contains(t, string(encoded), `"amount":-1.005`)
+}
@@ -2,18 +2,44 @@ package service_test
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 
 	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
 )
 
 type accountRepositoryMock struct {
-	FindUserByIDFunc             func(ctx context.Context, userID uuid.UUID) (*service.User, error)
-	ListTransactionsByUserIDFunc func(ctx context.Context, userID uuid.UUID) ([]service.Transaction, error)
-	CreateTransactionFunc        func(ctx context.Context, transaction *service.Transaction) error
-	FindAndLockUserByIDFunc      func(ctx context.Context, userID uuid.UUID) (*service.User, error)
-	UpdateUserBalanceFunc        func(ctx context.Context, userID uuid.UUID, newBalance float64) error
+	FindUserByIDFunc                     func(ctx context.Context, userID uuid.UUID) (*service.User, error)
+	ListTransactionsByUserIDFunc         func(ctx context.Context, userID uuid.UUID, filter service.TransactionFilter) ([]service.StatementEntry, error)
+	CreateTransactionFunc                func(ctx context.Context, transaction *service.Transaction) error
+	FindTransactionByIDFunc              func(ctx context.Context, transactionID uuid.UUID) (*service.Transaction, error)
+	SumReversalsFunc                     func(ctx context.Context, transactionID uuid.UUID) (money.Amount, error)
+	CreateClaimFunc                      func(ctx context.Context, claim *service.Claim) error
+	RecordStatusChangeFunc               func(ctx context.Context, change *service.StatusChange) error
+	ListStatusChangesFunc                func(ctx context.Context, transactionID uuid.UUID) ([]service.StatusChange, error)
+	CreateHoldFunc                       func(ctx context.Context, hold *service.Hold) error
+	FindHoldByIDFunc                     func(ctx context.Context, holdID uuid.UUID) (*service.Hold, error)
+	UpdateHoldFunc                       func(ctx context.Context, hold *service.Hold) error
+	ExpireHoldsFunc                      func(ctx context.Context, at time.Time) error
+	SumActiveHoldsFunc                   func(ctx context.Context, userID uuid.UUID, currency money.Currency, at time.Time) (money.Amount, error)
+	CreateScheduledTransferFunc          func(ctx context.Context, schedule *service.ScheduledTransfer) error
+	FindScheduledTransferByIDFunc        func(ctx context.Context, scheduleID uuid.UUID) (*service.ScheduledTransfer, error)
+	FindAndLockScheduledTransferByIDFunc func(ctx context.Context, scheduleID uuid.UUID) (*service.ScheduledTransfer, error)
+	ListScheduledTransfersByUserIDFunc   func(ctx context.Context, userID uuid.UUID) ([]service.ScheduledTransfer, error)
+	ClaimDueScheduledTransferFunc        func(ctx context.Context, at time.Time) (*service.ScheduledTransfer, error)
+	UpdateScheduledTransferFunc          func(ctx context.Context, schedule *service.ScheduledTransfer) error
+	CreateScheduleExecutionFunc          func(ctx context.Context, execution *service.ScheduleExecution) error
+	ListScheduleExecutionsFunc           func(ctx context.Context, scheduleID uuid.UUID) ([]service.ScheduleExecution, error)
+	FindAndLockUserByIDFunc              func(ctx context.Context, userID uuid.UUID) (*service.User, error)
+	CreatePostingsFunc                   func(ctx context.Context, postings []service.Posting) error
+	CreateWalletFunc                     func(ctx context.Context, wallet *service.Wallet) error
+	FindWalletFunc                       func(ctx context.Context, userID uuid.UUID, currency money.Currency) (*service.Wallet, error)
+	ListWalletsByUserIDFunc              func(ctx context.Context, userID uuid.UUID) ([]service.Wallet, error)
+	UpdateWalletBalanceFunc              func(ctx context.Context, userID uuid.UUID, currency money.Currency, newBalance money.Amount) error
+	FindIdempotencyRecordFunc            func(ctx context.Context, userID uuid.UUID, key string) (*service.IdempotencyRecord, error)
+	CreateIdempotencyRecordFunc          func(ctx context.Context, record *service.IdempotencyRecord) error
 }
 
 func newAccountRepositoryMock() *accountRepositoryMock {
@@ -21,16 +47,88 @@ func newAccountRepositoryMock() *accountRepositoryMock {
 		FindUserByIDFunc: func(context.Context, uuid.UUID) (*service.User, error) {
 			return nil, nil
 		},
-		ListTransactionsByUserIDFunc: func(context.Context, uuid.UUID) ([]service.Transaction, error) {
+		ListTransactionsByUserIDFunc: func(context.Context, uuid.UUID, service.TransactionFilter) ([]service.StatementEntry, error) {
 			return nil, nil
 		},
 		CreateTransactionFunc: func(context.Context, *service.Transaction) error {
 			return nil
 		},
+		FindTransactionByIDFunc: func(context.Context, uuid.UUID) (*service.Transaction, error) {
+			return nil, service.ErrTransactionNotFound
+		},
+		SumReversalsFunc: func(context.Context, uuid.UUID) (money.Amount, error) {
+			return 0, nil
+		},
+		CreateClaimFunc: func(context.Context, *service.Claim) error {
+			return nil
+		},
+		RecordStatusChangeFunc: func(context.Context, *service.StatusChange) error {
+			return nil
+		},
+		ListStatusChangesFunc: func(context.Context, uuid.UUID) ([]service.StatusChange, error) {
+			return nil, nil
+		},
+		CreateHoldFunc: func(context.Context, *service.Hold) error {
+			return nil
+		},
+		FindHoldByIDFunc: func(context.Context, uuid.UUID) (*service.Hold, error) {
+			return nil, service.ErrHoldNotFound
+		},
+		UpdateHoldFunc: func(context.Context, *service.Hold) error {
+			return nil
+		},
+		ExpireHoldsFunc: func(context.Context, time.Time) error {
+			return nil
+		},
+		SumActiveHoldsFunc: func(context.Context, uuid.UUID, money.Currency, time.Time) (money.Amount, error) {
+			return 0, nil
+		},
+		CreateScheduledTransferFunc: func(context.Context, *service.ScheduledTransfer) error {
+			return nil
+		},
+		FindScheduledTransferByIDFunc: func(context.Context, uuid.UUID) (*service.ScheduledTransfer, error) {
+			return nil, service.ErrScheduledTransferNotFound
+		},
+		FindAndLockScheduledTransferByIDFunc: func(context.Context, uuid.UUID) (*service.ScheduledTransfer, error) {
+			return nil, service.ErrScheduledTransferNotFound
+		},
+		ListScheduledTransfersByUserIDFunc: func(context.Context, uuid.UUID) ([]service.ScheduledTransfer, error) {
+			return nil, nil
+		},
+		ClaimDueScheduledTransferFunc: func(context.Context, time.Time) (*service.ScheduledTransfer, error) {
+			return nil, nil
+		},
+		UpdateScheduledTransferFunc: func(context.Context, *service.ScheduledTransfer) error {
+			return nil
+		},
+		CreateScheduleExecutionFunc: func(context.Context, *service.ScheduleExecution) error {
+			return nil
+		},
+		ListScheduleExecutionsFunc: func(context.Context, uuid.UUID) ([]service.ScheduleExecution, error) {
+			return nil, nil
+		},
 		FindAndLockUserByIDFunc: func(context.Context, uuid.UUID) (*service.User, error) {
 			return nil, nil
 		},
-		UpdateUserBalanceFunc: func(context.Context, uuid.UUID, float64) error {
+		CreatePostingsFunc: func(context.Context, []service.Posting) error {
+			return nil
+		},
+		CreateWalletFunc: func(context.Context, *service.Wallet) error {
+			return nil
+		},
+		FindWalletFunc: func(ctx context.Context, userID uuid.UUID, currency money.Currency) (*service.Wallet, error) {
+			return &service.Wallet{UserID: userID, Currency: currency}, nil
+		},
+		ListWalletsByUserIDFunc: func(context.Context, uuid.UUID) ([]service.Wallet, error) {
+			return nil, nil
+		},
+		UpdateWalletBalanceFunc: func(context.Context, uuid.UUID, money.Currency, money.Amount) error {
+			return nil
+		},
+		FindIdempotencyRecordFunc: func(context.Context, uuid.UUID, string) (*service.IdempotencyRecord, error) {
+			return nil, nil
+		},
+		CreateIdempotencyRecordFunc: func(context.Context, *service.IdempotencyRecord) error {
 			return nil
 		},
 	}
@@ -38,26 +136,394 @@ func newAccountRepositoryMock() *accountRepositoryMock {
 	return mock
 }
 
+// stubUsers makes the mock find and lock the users, and find their wallets by user and currency, the others aren't
+// found. The wallets themselves are returned, so the tests can check the balances the services set on them.
+func (a *accountRepositoryMock) stubUsers(users []*service.User, wallets ...*service.Wallet) {
+	byID := make(map[uuid.UUID]*service.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	findUser := func(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+		user, ok := byID[userID]
+		if !ok {
+			return nil, service.ErrUserNotFound
+		}
+
+		return user, nil
+	}
+
+	a.FindUserByIDFunc = findUser
+	a.FindAndLockUserByIDFunc = findUser
+
+	walletsByUser := map[uuid.UUID][]*service.Wallet{}
+	for _, wallet := range wallets {
+		walletsByUser[wallet.UserID] = append(walletsByUser[wallet.UserID], wallet)
+	}
+
+	a.FindWalletFunc = func(ctx context.Context, userID uuid.UUID, currency money.Currency) (*service.Wallet, error) {
+		for _, wallet := range walletsByUser[userID] {
+			if wallet.Currency == currency {
+				return wallet, nil
+			}
+		}
+
+		return nil, service.ErrWalletNotFound
+	}
+}
+
+// transferFixture is a mock repository stubbing a user and a target user, who both have BRL wallets, and recording the
+// transactions created on it. The fixtures of the services moving money between two users build on it.
+type transferFixture struct {
+	repo   *accountRepositoryMock
+	user   *service.User
+	target *service.User
+
+	// userWallet and targetWallet are the BRL wallets of the user and the target
+	userWallet   *service.Wallet
+	targetWallet *service.Wallet
+
+	created []*service.Transaction
+}
+
+func newTransferFixture(userBalance money.Amount, targetBalance money.Amount) *transferFixture {
+	f := &transferFixture{
+		repo:   newAccountRepositoryMock(),
+		user:   &service.User{ID: uuid.New()},
+		target: &service.User{ID: uuid.New()},
+	}
+
+	f.userWallet = &service.Wallet{UserID: f.user.ID, Currency: money.BRL, Balance: userBalance}
+	f.targetWallet = &service.Wallet{UserID: f.target.ID, Currency: money.BRL, Balance: targetBalance}
+
+	f.repo.stubUsers([]*service.User{f.user, f.target}, f.userWallet, f.targetWallet)
+
+	f.repo.CreateTransactionFunc = func(ctx context.Context, transaction *service.Transaction) error {
+		f.created = append(f.created, transaction)
+		return nil
+	}
+
+	return f
+}
+
 func (a *accountRepositoryMock) FindUserByID(ctx context.Context, userID uuid.UUID) (*service.User, error) {
 	return a.FindUserByIDFunc(ctx, userID)
 }
 
-func (a *accountRepositoryMock) ListTransactionsByUserID(ctx context.Context, userID uuid.UUID) ([]service.Transaction, error) {
-	return a.ListTransactionsByUserIDFunc(ctx, userID)
+// FindUserByIDForReport is served by FindUserByIDFunc, the tests don't tell the replica apart from the primary
+func (a *accountRepositoryMock) FindUserByIDForReport(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+	return a.FindUserByIDFunc(ctx, userID)
+}
+
+func (a *accountRepositoryMock) ListTransactionsByUserID(ctx context.Context, userID uuid.UUID, filter service.TransactionFilter) ([]service.StatementEntry, error) {
+	return a.ListTransactionsByUserIDFunc(ctx, userID, filter)
 }
 
 func (a *accountRepositoryMock) CreateTransaction(ctx context.Context, transaction *service.Transaction) error {
 	return a.CreateTransactionFunc(ctx, transaction)
 }
 
+func (a *accountRepositoryMock) FindTransactionByID(ctx context.Context, transactionID uuid.UUID) (*service.Transaction, error) {
+	return a.FindTransactionByIDFunc(ctx, transactionID)
+}
+
+func (a *accountRepositoryMock) SumReversals(ctx context.Context, transactionID uuid.UUID) (money.Amount, error) {
+	return a.SumReversalsFunc(ctx, transactionID)
+}
+
+func (a *accountRepositoryMock) CreateClaim(ctx context.Context, claim *service.Claim) error {
+	return a.CreateClaimFunc(ctx, claim)
+}
+
+func (a *accountRepositoryMock) RecordStatusChange(ctx context.Context, change *service.StatusChange) error {
+	return a.RecordStatusChangeFunc(ctx, change)
+}
+
+func (a *accountRepositoryMock) ListStatusChanges(ctx context.Context, transactionID uuid.UUID) ([]service.StatusChange, error) {
+	return a.ListStatusChangesFunc(ctx, transactionID)
+}
+
+func (a *accountRepositoryMock) CreateHold(ctx context.Context, hold *service.Hold) error {
+	return a.CreateHoldFunc(ctx, hold)
+}
+
+func (a *accountRepositoryMock) FindHoldByID(ctx context.Context, holdID uuid.UUID) (*service.Hold, error) {
+	return a.FindHoldByIDFunc(ctx, holdID)
+}
+
+func (a *accountRepositoryMock) UpdateHold(ctx context.Context, hold *service.Hold) error {
+	return a.UpdateHoldFunc(ctx, hold)
+}
+
+func (a *accountRepositoryMock) ExpireHolds(ctx context.Context, at time.Time) error {
+	return a.ExpireHoldsFunc(ctx, at)
+}
+
+func (a *accountRepositoryMock) SumActiveHolds(ctx context.Context, userID uuid.UUID, currency money.Currency, at time.Time) (money.Amount, error) {
+	return a.SumActiveHoldsFunc(ctx, userID, currency, at)
+}
+
+func (a *accountRepositoryMock) CreateScheduledTransfer(ctx context.Context, schedule *service.ScheduledTransfer) error {
+	return a.CreateScheduledTransferFunc(ctx, schedule)
+}
+
+func (a *accountRepositoryMock) FindScheduledTransferByID(ctx context.Context, scheduleID uuid.UUID) (*service.ScheduledTransfer, error) {
+	return a.FindScheduledTransferByIDFunc(ctx, scheduleID)
+}
+
+func (a *accountRepositoryMock) FindAndLockScheduledTransferByID(ctx context.Context, scheduleID uuid.UUID) (*service.ScheduledTransfer, error) {
+	return a.FindAndLockScheduledTransferByIDFunc(ctx, scheduleID)
+}
+
+func (a *accountRepositoryMock) ListScheduledTransfersByUserID(ctx context.Context, userID uuid.UUID) ([]service.ScheduledTransfer, error) {
+	return a.ListScheduledTransfersByUserIDFunc(ctx, userID)
+}
+
+func (a *accountRepositoryMock) ClaimDueScheduledTransfer(ctx context.Context, at time.Time) (*service.ScheduledTransfer, error) {
+	return a.ClaimDueScheduledTransferFunc(ctx, at)
+}
+
+func (a *accountRepositoryMock) UpdateScheduledTransfer(ctx context.Context, schedule *service.ScheduledTransfer) error {
+	return a.UpdateScheduledTransferFunc(ctx, schedule)
+}
+
+func (a *accountRepositoryMock) CreateScheduleExecution(ctx context.Context, execution *service.ScheduleExecution) error {
+	return a.CreateScheduleExecutionFunc(ctx, execution)
+}
+
+func (a *accountRepositoryMock) ListScheduleExecutions(ctx context.Context, scheduleID uuid.UUID) ([]service.ScheduleExecution, error) {
+	return a.ListScheduleExecutionsFunc(ctx, scheduleID)
+}
+
 func (a *accountRepositoryMock) FindAndLockUserByID(ctx context.Context, userID uuid.UUID) (*service.User, error) {
 	return a.FindAndLockUserByIDFunc(ctx, userID)
 }
 
-func (a *accountRepositoryMock) UpdateUserBalance(ctx context.Context, userID uuid.UUID, newBalance float64) error {
-	return a.UpdateUserBalanceFunc(ctx, userID, newBalance)
+func (a *accountRepositoryMock) CreatePostings(ctx context.Context, postings []service.Posting) error {
+	return a.CreatePostingsFunc(ctx, postings)
+}
+
+func (a *accountRepositoryMock) CreateWallet(ctx context.Context, wallet *service.Wallet) error {
+	return a.CreateWalletFunc(ctx, wallet)
+}
+
+func (a *accountRepositoryMock) FindWallet(ctx context.Context, userID uuid.UUID, currency money.Currency) (*service.Wallet, error) {
+	return a.FindWalletFunc(ctx, userID, currency)
+}
+
+func (a *accountRepositoryMock) ListWalletsByUserID(ctx context.Context, userID uuid.UUID) ([]service.Wallet, error) {
+	return a.ListWalletsByUserIDFunc(ctx, userID)
+}
+
+func (a *accountRepositoryMock) UpdateWalletBalance(ctx context.Context, userID uuid.UUID, currency money.Currency, newBalance money.Amount) error {
+	return a.UpdateWalletBalanceFunc(ctx, userID, currency, newBalance)
+}
+
+func (a *accountRepositoryMock) FindIdempotencyRecord(ctx context.Context, userID uuid.UUID, key string) (*service.IdempotencyRecord, error) {
+	return a.FindIdempotencyRecordFunc(ctx, userID, key)
+}
+
+func (a *accountRepositoryMock) CreateIdempotencyRecord(ctx context.Context, record *service.IdempotencyRecord) error {
+	return a.CreateIdempotencyRecordFunc(ctx, record)
 }
 
 func (a *accountRepositoryMock) WithTx(ctx context.Context, f func(repository service.AccountRepository) error) error {
 	return f(a)
 }
+
+type ledgerRepositoryMock struct {
+	SumPostingsFunc           func(ctx context.Context) (map[money.Currency]money.Amount, error)
+	ListUnbalancedEntriesFunc func(ctx context.Context) ([]uuid.UUID, error)
+	ListBalanceMismatchesFunc func(ctx context.Context) ([]service.BalanceMismatch, error)
+}
+
+func newLedgerRepositoryMock() *ledgerRepositoryMock {
+	return &ledgerRepositoryMock{
+		SumPostingsFunc: func(context.Context) (map[money.Currency]money.Amount, error) {
+			return nil, nil
+		},
+		ListUnbalancedEntriesFunc: func(context.Context) ([]uuid.UUID, error) {
+			return nil, nil
+		},
+		ListBalanceMismatchesFunc: func(context.Context) ([]service.BalanceMismatch, error) {
+			return nil, nil
+		},
+	}
+}
+
+func (l *ledgerRepositoryMock) SumPostings(ctx context.Context) (map[money.Currency]money.Amount, error) {
+	return l.SumPostingsFunc(ctx)
+}
+
+func (l *ledgerRepositoryMock) ListUnbalancedEntries(ctx context.Context) ([]uuid.UUID, error) {
+	return l.ListUnbalancedEntriesFunc(ctx)
+}
+
+func (l *ledgerRepositoryMock) ListBalanceMismatches(ctx context.Context) ([]service.BalanceMismatch, error) {
+	return l.ListBalanceMismatchesFunc(ctx)
+}
+
+type authenticationRepositoryMock struct {
+	FindUserByUserNameFunc func(ctx context.Context, userName string) (*service.User, error)
+	UpdateUserPasswordFunc func(ctx context.Context, userID uuid.UUID, passwordHash string) error
+	FindUserByIDFunc       func(ctx context.Context, userID uuid.UUID) (*service.User, error)
+	CreateTokenFunc        func(ctx context.Context, token *service.Token) error
+	FindTokenByHashFunc    func(ctx context.Context, tokenHash string) (*service.Token, error)
+	RevokeTokenFunc        func(ctx context.Context, tokenHash string, revokedAt time.Time) error
+	RevokeSessionFunc      func(ctx context.Context, sessionID uuid.UUID, revokedAt time.Time) error
+}
+
+func newAuthenticationRepositoryMock() *authenticationRepositoryMock {
+	return &authenticationRepositoryMock{
+		FindUserByUserNameFunc: func(context.Context, string) (*service.User, error) {
+			return nil, service.ErrUserNotFound
+		},
+		UpdateUserPasswordFunc: func(context.Context, uuid.UUID, string) error {
+			return nil
+		},
+		FindUserByIDFunc: func(context.Context, uuid.UUID) (*service.User, error) {
+			return nil, service.ErrUserNotFound
+		},
+		CreateTokenFunc: func(context.Context, *service.Token) error {
+			return nil
+		},
+		FindTokenByHashFunc: func(context.Context, string) (*service.Token, error) {
+			return nil, service.ErrInvalidToken
+		},
+		RevokeTokenFunc: func(context.Context, string, time.Time) error {
+			return nil
+		},
+		RevokeSessionFunc: func(context.Context, uuid.UUID, time.Time) error {
+			return nil
+		},
+	}
+}
+
+// withTokenStore makes the mock keep the tokens in memory, like a real repository would
+func (a *authenticationRepositoryMock) withTokenStore() {
+	tokens := map[string]*service.Token{}
+
+	a.CreateTokenFunc = func(ctx context.Context, token *service.Token) error {
+		stored := *token
+		tokens[token.Hash] = &stored
+		return nil
+	}
+	a.FindTokenByHashFunc = func(ctx context.Context, tokenHash string) (*service.Token, error) {
+		token, ok := tokens[tokenHash]
+		if !ok {
+			return nil, service.ErrInvalidToken
+		}
+		found := *token
+		return &found, nil
+	}
+	a.RevokeTokenFunc = func(ctx context.Context, tokenHash string, revokedAt time.Time) error {
+		token, ok := tokens[tokenHash]
+		if !ok || token.RevokedAt != nil {
+			return service.ErrInvalidToken
+		}
+		token.RevokedAt = &revokedAt
+		return nil
+	}
+	a.RevokeSessionFunc = func(ctx context.Context, sessionID uuid.UUID, revokedAt time.Time) error {
+		for _, token := range tokens {
+			if token.SessionID == sessionID && token.RevokedAt == nil {
+				token.RevokedAt = &revokedAt
+			}
+		}
+		return nil
+	}
+}
+
+func (a *authenticationRepositoryMock) FindUserByUserName(ctx context.Context, userName string) (*service.User, error) {
+	return a.FindUserByUserNameFunc(ctx, userName)
+}
+
+func (a *authenticationRepositoryMock) UpdateUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	return a.UpdateUserPasswordFunc(ctx, userID, passwordHash)
+}
+
+func (a *authenticationRepositoryMock) FindUserByID(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+	return a.FindUserByIDFunc(ctx, userID)
+}
+
+func (a *authenticationRepositoryMock) CreateToken(ctx context.Context, token *service.Token) error {
+	return a.CreateTokenFunc(ctx, token)
+}
+
+func (a *authenticationRepositoryMock) FindTokenByHash(ctx context.Context, tokenHash string) (*service.Token, error) {
+	return a.FindTokenByHashFunc(ctx, tokenHash)
+}
+
+func (a *authenticationRepositoryMock) RevokeToken(ctx context.Context, tokenHash string, revokedAt time.Time) error {
+	return a.RevokeTokenFunc(ctx, tokenHash, revokedAt)
+}
+
+func (a *authenticationRepositoryMock) RevokeSession(ctx context.Context, sessionID uuid.UUID, revokedAt time.Time) error {
+	return a.RevokeSessionFunc(ctx, sessionID, revokedAt)
+}
+
+type userRepositoryMock struct {
+	CreateUserFunc         func(ctx context.Context, user *service.User) error
+	CreateWalletFunc       func(ctx context.Context, wallet *service.Wallet) error
+	FindUserByIDFunc       func(ctx context.Context, userID uuid.UUID) (*service.User, error)
+	UpdateUserProfileFunc  func(ctx context.Context, user *service.User) error
+	UpdateUserPasswordFunc func(ctx context.Context, userID uuid.UUID, passwordHash string) error
+	RevokeUserTokensFunc   func(ctx context.Context, userID uuid.UUID, revokedAt time.Time) error
+	WithTxFunc             func(ctx context.Context, f func(repository service.UserRepository) error) error
+}
+
+func newUserRepositoryMock() *userRepositoryMock {
+	mock := &userRepositoryMock{
+		CreateUserFunc: func(context.Context, *service.User) error {
+			return nil
+		},
+		CreateWalletFunc: func(context.Context, *service.Wallet) error {
+			return nil
+		},
+		FindUserByIDFunc: func(context.Context, uuid.UUID) (*service.User, error) {
+			return nil, service.ErrUserNotFound
+		},
+		UpdateUserProfileFunc: func(context.Context, *service.User) error {
+			return nil
+		},
+		UpdateUserPasswordFunc: func(context.Context, uuid.UUID, string) error {
+			return nil
+		},
+		RevokeUserTokensFunc: func(context.Context, uuid.UUID, time.Time) error {
+			return nil
+		},
+	}
+	mock.WithTxFunc = func(ctx context.Context, f func(repository service.UserRepository) error) error {
+		return f(mock)
+	}
+
+	return mock
+}
+
+func (u *userRepositoryMock) CreateUser(ctx context.Context, user *service.User) error {
+	return u.CreateUserFunc(ctx, user)
+}
+
+func (u *userRepositoryMock) CreateWallet(ctx context.Context, wallet *service.Wallet) error {
+	return u.CreateWalletFunc(ctx, wallet)
+}
+
+func (u *userRepositoryMock) FindUserByID(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+	return u.FindUserByIDFunc(ctx, userID)
+}
+
+func (u *userRepositoryMock) UpdateUserProfile(ctx context.Context, user *service.User) error {
+	return u.UpdateUserProfileFunc(ctx, user)
+}
+
+func (u *userRepositoryMock) UpdateUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	return u.UpdateUserPasswordFunc(ctx, userID, passwordHash)
+}
+
+func (u *userRepositoryMock) RevokeUserTokens(ctx context.Context, userID uuid.UUID, revokedAt time.Time) error {
+	return u.RevokeUserTokensFunc(ctx, userID, revokedAt)
+}
+
+func (u *userRepositoryMock) WithTx(ctx context.Context, f func(repository service.UserRepository) error) error {
+	return u.WithTxFunc(ctx, f)
+}
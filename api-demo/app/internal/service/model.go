@@ -1,22 +1,87 @@
 package service
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
+
+	"api-demo/pkg/money"
 )
 
 type User struct {
-	ID       uuid.UUID `json:"id"`
-	UserName string    `json:"user_name"`
-	Password string    `json:"password"`
-	Balance  float64   `json:"balance"`
+	ID           uuid.UUID `json:"id"`
+	UserName     string    `json:"user_name"`
+	PasswordHash string    `json:"-"`
+	DisplayName  string    `json:"display_name"`
+	Email        string    `json:"email"`
 }
 
 type Transaction struct {
-	ID           uuid.UUID `json:"id"`
-	SourceUserID uuid.UUID `json:"source_user_id"`
-	TargetUserID uuid.UUID `json:"target_user_id"`
-	Amount       float64   `json:"amount"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           uuid.UUID    `json:"id"`
+	SourceUserID uuid.UUID    `json:"source_user_id"`
+	TargetUserID uuid.UUID    `json:"target_user_id"`
+	Amount       money.Amount `json:"amount"`
+
+	// Currency is the currency of the amount, both users move money from and to their wallets in it
+	Currency  money.Currency `json:"currency"`
+	CreatedAt time.Time      `json:"created_at"`
+
+	// ReversalOf is the transaction this one reverses, if it's a reversal
+	ReversalOf *uuid.UUID `json:"reversal_of,omitempty"`
+
+	// Status is the state of the transaction since UpdatedAt, FailureReason tells why it failed
+	Status        TransactionStatus `json:"status"`
+	FailureReason string            `json:"failure_reason,omitempty"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// StatementEntry is a transaction seen by one of its users, like a line of a bank statement
+type StatementEntry struct {
+	Transaction
+
+	// Direction tells whether the user sent (outgoing) or received (incoming) the money
+	Direction Direction `json:"direction"`
+
+	// CounterpartyID and CounterpartyUserName identify the other user of the transaction
+	CounterpartyID       uuid.UUID `json:"counterparty_id"`
+	CounterpartyUserName string    `json:"counterparty_user_name"`
+
+	// BalanceAfter is the balance of the user right after the transaction, it's nil when the transaction didn't move
+	// money, like the failed ones
+	BalanceAfter *money.Amount `json:"balance_after"`
+}
+
+// transactionFields has the fields of a Transaction without its MarshalJSON method
+type transactionFields Transaction
+
+// transactionJSON is the JSON representation of a Transaction, having its amount formatted in its currency
+type transactionJSON struct {
+	transactionFields
+	Amount money.Money `json:"amount"`
+}
+
+// MarshalJSON encodes the transaction with its amount formatted in its currency
+func (t Transaction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.toJSON())
+}
+
+func (t Transaction) toJSON() transactionJSON {
+	return transactionJSON{transactionFields(t), money.Money{Amount: t.Amount, Currency: t.Currency}}
+}
+
+// MarshalJSON encodes the entry with its amounts formatted in the currency of its transaction
+func (e StatementEntry) MarshalJSON() ([]byte, error) {
+	var balanceAfter *money.Money
+	if e.BalanceAfter != nil {
+		balanceAfter = &money.Money{Amount: *e.BalanceAfter, Currency: e.Currency}
+	}
+
+	return json.Marshal(struct {
+		transactionJSON
+		Direction            Direction    `json:"direction"`
+		CounterpartyID       uuid.UUID    `json:"counterparty_id"`
+		CounterpartyUserName string       `json:"counterparty_user_name"`
+		BalanceAfter         *money.Money `json:"balance_after"`
+	}{e.Transaction.toJSON(), e.Direction, e.CounterpartyID, e.CounterpartyUserName, balanceAfter})
 }
@@ -0,0 +1,142 @@
+package service_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+func TestMarshalJSON_AmountsInTheirCurrency(t *testing.T) {
+
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	transaction := service.Transaction{
+		ID:           uuid.MustParse("3f2b5b8e-6b0a-4d8b-9a43-8f0f5a6a1c11"),
+		SourceUserID: uuid.MustParse("256bea59-c9a7-44d0-bcd8-d710aad69676"),
+		TargetUserID: uuid.MustParse("c66af437-8536-4ac9-918c-5e73ef95578a"),
+		Amount:       1050,
+		Currency:     money.JPY,
+		Status:       service.TransactionCompleted,
+		CreatedAt:    createdAt,
+		UpdatedAt:    createdAt,
+	}
+
+	transactionJSON := `{
+		"id": "3f2b5b8e-6b0a-4d8b-9a43-8f0f5a6a1c11",
+		"source_user_id": "256bea59-c9a7-44d0-bcd8-d710aad69676",
+		"target_user_id": "c66af437-8536-4ac9-918c-5e73ef95578a",
+		"amount": 1050,
+		"currency": "JPY",
+		"status": "completed",
+		"created_at": "2021-03-01T12:00:00Z",
+		"updated_at": "2021-03-01T12:00:00Z"
+	}`
+
+	tests := map[string]struct {
+		value    interface{}
+		expected string
+	}{
+		"should format the amount of a transaction": {
+			value:    transaction,
+			expected: transactionJSON,
+		},
+		"should format the amounts of a statement entry": {
+			value: service.StatementEntry{
+				Transaction:          transaction,
+				Direction:            service.DirectionOutgoing,
+				CounterpartyID:       transaction.TargetUserID,
+				CounterpartyUserName: "bruno",
+				BalanceAfter:         func() *money.Amount { a := money.Amount(2000); return &a }(),
+			},
+			expected: `{
+				"id": "3f2b5b8e-6b0a-4d8b-9a43-8f0f5a6a1c11",
+				"source_user_id": "256bea59-c9a7-44d0-bcd8-d710aad69676",
+				"target_user_id": "c66af437-8536-4ac9-918c-5e73ef95578a",
+				"amount": 1050,
+				"currency": "JPY",
+				"status": "completed",
+				"created_at": "2021-03-01T12:00:00Z",
+				"updated_at": "2021-03-01T12:00:00Z",
+				"direction": "outgoing",
+				"counterparty_id": "c66af437-8536-4ac9-918c-5e73ef95578a",
+				"counterparty_user_name": "bruno",
+				"balance_after": 2000
+			}`,
+		},
+		"should leave out the balance of a statement entry that didn't move money": {
+			value: service.StatementEntry{
+				Transaction:          transaction,
+				Direction:            service.DirectionIncoming,
+				CounterpartyID:       transaction.SourceUserID,
+				CounterpartyUserName: "breno",
+			},
+			expected: `{
+				"id": "3f2b5b8e-6b0a-4d8b-9a43-8f0f5a6a1c11",
+				"source_user_id": "256bea59-c9a7-44d0-bcd8-d710aad69676",
+				"target_user_id": "c66af437-8536-4ac9-918c-5e73ef95578a",
+				"amount": 1050,
+				"currency": "JPY",
+				"status": "completed",
+				"created_at": "2021-03-01T12:00:00Z",
+				"updated_at": "2021-03-01T12:00:00Z",
+				"direction": "incoming",
+				"counterparty_id": "256bea59-c9a7-44d0-bcd8-d710aad69676",
+				"counterparty_user_name": "breno",
+				"balance_after": null
+			}`,
+		},
+		"should format the amount of a transaction along with its history": {
+			value: service.TransactionDetails{Transaction: transaction, History: []service.StatusChange{}},
+			expected: `{
+				"id": "3f2b5b8e-6b0a-4d8b-9a43-8f0f5a6a1c11",
+				"source_user_id": "256bea59-c9a7-44d0-bcd8-d710aad69676",
+				"target_user_id": "c66af437-8536-4ac9-918c-5e73ef95578a",
+				"amount": 1050,
+				"currency": "JPY",
+				"status": "completed",
+				"created_at": "2021-03-01T12:00:00Z",
+				"updated_at": "2021-03-01T12:00:00Z",
+				"history": []
+			}`,
+		},
+		"should format the amounts of a hold": {
+			value: service.Hold{
+				ID:             transaction.ID,
+				UserID:         transaction.SourceUserID,
+				TargetUserID:   transaction.TargetUserID,
+				Amount:         1500,
+				Currency:       money.KWD,
+				Status:         service.HoldCaptured,
+				CapturedAmount: 500,
+				ExpiresAt:      createdAt,
+				CreatedAt:      createdAt,
+				UpdatedAt:      createdAt,
+			},
+			expected: `{
+				"id": "3f2b5b8e-6b0a-4d8b-9a43-8f0f5a6a1c11",
+				"user_id": "256bea59-c9a7-44d0-bcd8-d710aad69676",
+				"target_user_id": "c66af437-8536-4ac9-918c-5e73ef95578a",
+				"amount": 1.5,
+				"currency": "KWD",
+				"status": "captured",
+				"captured_amount": 0.5,
+				"expires_at": "2021-03-01T12:00:00Z",
+				"created_at": "2021-03-01T12:00:00Z",
+				"updated_at": "2021-03-01T12:00:00Z"
+			}`,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			encoded, err := json.Marshal(test.value)
+			require.NoError(t, err)
+			require.JSONEq(t, test.expected, string(encoded))
+		})
+	}
+}
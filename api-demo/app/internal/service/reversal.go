@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+
+	"api-demo/pkg/money"
+)
+
+var (
+	// ErrRefundNotAllowed is returned when a user that didn't receive a transaction tries to refund it
+	ErrRefundNotAllowed = NewError(KindForbidden, "refund_not_allowed", "only the recipient of a transaction can refund it")
+
+	// ErrTransactionNotReversible is returned when reversing a reversal or a transaction that isn't completed
+	ErrTransactionNotReversible = NewError(KindConflict, "transaction_not_reversible",
+		"only completed transactions that aren't reversals can be reversed")
+
+	// ErrTransactionAlreadyReversed is returned when the whole amount of a transaction was reversed already
+	ErrTransactionAlreadyReversed = NewError(KindConflict, "transaction_already_reversed",
+		"the transaction was fully reversed already")
+
+	// ErrReversalExceedsAmount is returned when a reversal would take back more than what's left of the transaction
+	ErrReversalExceedsAmount = NewFieldError("amount", "reversal_exceeds_amount",
+		"the amount exceeds what's left to be reversed")
+)
+
+// Claim is the money a user owes after a reversal took more than their balance, which went negative
+type Claim struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+
+	// TransactionID is the reversal that left the balance of the user negative
+	TransactionID uuid.UUID      `json:"transaction_id"`
+	Amount        money.Amount   `json:"amount"`
+	Currency      money.Currency `json:"currency"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// claimFields has the fields of a Claim without its MarshalJSON method
+type claimFields Claim
+
+// MarshalJSON encodes the claim with its amount formatted in its currency
+func (c Claim) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		claimFields
+		Amount money.Money `json:"amount"`
+	}{claimFields(c), money.Money{Amount: c.Amount, Currency: c.Currency}})
+}
+
+// Reversal is the result of reversing a transaction, a compensating transaction linked to the reversed one and the claim
+// on the recipient, if their balance couldn't cover it
+type Reversal struct {
+	Transaction *Transaction `json:"transaction"`
+	Claim       *Claim       `json:"claim,omitempty"`
+}
+
+// RefundTransaction sends back to the source user an amount of a transaction received by userID, or what's left to be
+// refunded when amount is nil. The balance of the recipient should cover the refund.
+func (service *Account) RefundTransaction(ctx context.Context, userID uuid.UUID, transactionID uuid.UUID,
+	amount *money.Amount) (*Transaction, error) {
+
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	var reversal *Reversal
+	err := service.repository.WithTx(ctx, func(txRepo AccountRepository) error {
+		original, err := txRepo.FindTransactionByID(ctx, transactionID)
+		if err != nil {
+			return err
+		}
+
+		// the transactions of other users aren't disclosed
+		if original.TargetUserID != userID {
+			if original.SourceUserID == userID {
+				return ErrRefundNotAllowed
+			}
+
+			return ErrTransactionNotFound
+		}
+
+		reversal, err = service.reverse(ctx, txRepo, original, amount, false)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return reversal.Transaction, nil
+}
+
+// ReverseTransaction reverses an amount of a transaction on behalf of the operators, e.g. a transfer made by mistake,
+// or what's left to be reversed when amount is nil. Unlike a refund it doesn't depend on the balance of the recipient,
+// when it can't cover the reversal it goes negative and a claim records how much the recipient owes.
+func (service *Account) ReverseTransaction(ctx context.Context, transactionID uuid.UUID,
+	amount *money.Amount) (*Reversal, error) {
+
+	var reversal *Reversal
+	err := service.repository.WithTx(ctx, func(txRepo AccountRepository) error {
+		original, err := txRepo.FindTransactionByID(ctx, transactionID)
+		if err != nil {
+			return err
+		}
+
+		reversal, err = service.reverse(ctx, txRepo, original, amount, true)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return reversal, nil
+}
+
+// reverse moves an amount of the original transaction back from its recipient to its sender using an already
+// transactioned repository, the recipient's balance can only go negative when allowNegative is set
+func (service *Account) reverse(ctx context.Context, txRepo AccountRepository, original *Transaction,
+	amount *money.Amount, allowNegative bool) (*Reversal, error) {
+
+	if original.Status == TransactionReversed {
+		return nil, ErrTransactionAlreadyReversed
+	}
+
+	if original.ReversalOf != nil || original.Status != TransactionCompleted {
+		return nil, ErrTransactionNotReversible
+	}
+
+	// every reversal of the transaction locks the same users, so the reversed amount can't change meanwhile
+	wallets, err := lockWallets(ctx, txRepo, original.Currency, original.SourceUserID, original.TargetUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, sender := wallets[original.TargetUserID], wallets[original.SourceUserID]
+
+	reversed, err := txRepo.SumReversals(ctx, original.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining, err := original.Amount.Sub(reversed)
+	if err != nil {
+		return nil, err
+	}
+
+	if remaining <= 0 {
+		return nil, ErrTransactionAlreadyReversed
+	}
+
+	if amount == nil {
+		amount = &remaining
+	}
+
+	if *amount <= 0 {
+		return nil, NewFieldError("amount", "invalid_amount", "reversal amount should be greater than zero")
+	}
+
+	if *amount > remaining {
+		return nil, NewFieldError("amount", ErrReversalExceedsAmount.Code,
+			"the amount exceeds the %s left to be reversed", money.Money{Amount: remaining, Currency: original.Currency})
+	}
+
+	now := time.Now()
+	if !allowNegative {
+		available, err := availableBalance(ctx, txRepo, recipient, now)
+		if err != nil {
+			return nil, err
+		}
+
+		if available < *amount {
+			return nil, ErrInsufficientFunds
+		}
+	}
+
+	transaction := &Transaction{
+		ID:           uuid.New(),
+		SourceUserID: original.TargetUserID,
+		TargetUserID: original.SourceUserID,
+		Amount:       *amount,
+		Currency:     original.Currency,
+		CreatedAt:    now,
+		ReversalOf:   &original.ID,
+	}
+
+	if err := moveMoney(ctx, txRepo, recipient, sender, transaction); err != nil {
+		return nil, err
+	}
+
+	// the transaction is reversed once nothing is left to be reversed
+	if *amount == remaining {
+		reason := "fully reversed by " + transaction.ID.String()
+		if err := transition(ctx, txRepo, original, TransactionReversed, reason); err != nil {
+			return nil, err
+		}
+	}
+
+	reversal := &Reversal{Transaction: transaction}
+	if recipient.Balance >= 0 {
+		return reversal, nil
+	}
+
+	// the recipient only owes the part of the reversal their balance couldn't cover
+	owed := -recipient.Balance
+	if owed > *amount {
+		owed = *amount
+	}
+
+	reversal.Claim = &Claim{
+		ID:            uuid.New(),
+		UserID:        recipient.UserID,
+		TransactionID: transaction.ID,
+		Amount:        owed,
+		Currency:      recipient.Currency,
+		CreatedAt:     transaction.CreatedAt,
+	}
+
+	if err := txRepo.CreateClaim(ctx, reversal.Claim); err != nil {
+		return nil, err
+	}
+
+	return reversal, nil
+}
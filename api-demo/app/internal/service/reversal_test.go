@@ -0,0 +1,256 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+// reversalFixture is a BRL transaction of 100 from the user to the target, who both have a balance of 100 afterwards
+type reversalFixture struct {
+	*transferFixture
+	original *service.Transaction
+
+	claims  []*service.Claim
+	changes []*service.StatusChange
+}
+
+func newReversalFixture(t *testing.T) *reversalFixture {
+	f := &reversalFixture{transferFixture: newTransferFixture(100, 100)}
+
+	f.original = &service.Transaction{ID: uuid.New(), SourceUserID: f.user.ID, TargetUserID: f.target.ID,
+		Amount: 100, Currency: money.BRL, Status: service.TransactionCompleted, CreatedAt: time.Now()}
+
+	f.repo.FindTransactionByIDFunc = func(ctx context.Context, transactionID uuid.UUID) (*service.Transaction, error) {
+		if transactionID != f.original.ID {
+			return nil, service.ErrTransactionNotFound
+		}
+
+		return f.original, nil
+	}
+
+	// guarantees that every reversal is written to the ledger as a balanced entry
+	f.repo.CreatePostingsFunc = func(ctx context.Context, postings []service.Posting) error {
+		require.Len(t, postings, 2)
+		require.Equal(t, f.target.ID, postings[0].AccountID)
+		require.Equal(t, f.user.ID, postings[1].AccountID)
+		return nil
+	}
+
+	f.repo.RecordStatusChangeFunc = func(ctx context.Context, change *service.StatusChange) error {
+		f.changes = append(f.changes, change)
+		return nil
+	}
+
+	f.repo.CreateClaimFunc = func(ctx context.Context, claim *service.Claim) error {
+		f.claims = append(f.claims, claim)
+		return nil
+	}
+
+	return f
+}
+
+func TestAccount_RefundTransaction(t *testing.T) {
+
+	ctx := context.Background()
+
+	amount := func(amount money.Amount) *money.Amount {
+		return &amount
+	}
+
+	tests := map[string]struct {
+		mutate           func(*reversalFixture)
+		refunder         func(*reversalFixture) uuid.UUID
+		amount           *money.Amount
+		expectedAmount   money.Amount
+		expectedErr      error
+		expectedBalances [2]money.Amount
+		expectedStatus   service.TransactionStatus
+	}{
+		"should refund the whole transaction when no amount is given": {
+			expectedAmount:   100,
+			expectedBalances: [2]money.Amount{200, 0},
+			expectedStatus:   service.TransactionReversed,
+		},
+		"should refund part of the transaction": {
+			amount:           amount(30),
+			expectedAmount:   30,
+			expectedBalances: [2]money.Amount{130, 70},
+			expectedStatus:   service.TransactionCompleted,
+		},
+		"should refund what's left when the transaction was partially refunded": {
+			mutate: func(f *reversalFixture) {
+				f.repo.SumReversalsFunc = func(context.Context, uuid.UUID) (money.Amount, error) {
+					return 60, nil
+				}
+			},
+			expectedAmount:   40,
+			expectedBalances: [2]money.Amount{140, 60},
+			expectedStatus:   service.TransactionReversed,
+		},
+		"should not refund more than what's left": {
+			mutate: func(f *reversalFixture) {
+				f.repo.SumReversalsFunc = func(context.Context, uuid.UUID) (money.Amount, error) {
+					return 60, nil
+				}
+			},
+			amount:      amount(50),
+			expectedErr: service.ErrReversalExceedsAmount,
+		},
+		"should not refund a transaction fully reversed": {
+			mutate: func(f *reversalFixture) {
+				f.repo.SumReversalsFunc = func(context.Context, uuid.UUID) (money.Amount, error) {
+					return 100, nil
+				}
+			},
+			expectedErr: service.ErrTransactionAlreadyReversed,
+		},
+		"should not refund a transaction with the reversed status": {
+			mutate: func(f *reversalFixture) {
+				f.original.Status = service.TransactionReversed
+			},
+			expectedErr: service.ErrTransactionAlreadyReversed,
+		},
+		"should not refund a pending transaction": {
+			mutate: func(f *reversalFixture) {
+				f.original.Status = service.TransactionPending
+			},
+			expectedErr: service.ErrTransactionNotReversible,
+		},
+		"should not refund a failed transaction": {
+			mutate: func(f *reversalFixture) {
+				f.original.Status = service.TransactionFailed
+			},
+			expectedErr: service.ErrTransactionNotReversible,
+		},
+		"should not refund a non positive amount": {
+			amount:      amount(0),
+			expectedErr: service.NewFieldError("amount", "invalid_amount", ""),
+		},
+		"should not refund when the recipient has insufficient funds": {
+			mutate: func(f *reversalFixture) {
+				f.targetWallet.Balance = 20
+			},
+			amount:      amount(30),
+			expectedErr: service.ErrInsufficientFunds,
+		},
+		"should not let the sender refund the transaction": {
+			refunder: func(f *reversalFixture) uuid.UUID {
+				return f.user.ID
+			},
+			expectedErr: service.ErrRefundNotAllowed,
+		},
+		"should not disclose the transactions of other users": {
+			refunder: func(f *reversalFixture) uuid.UUID {
+				return uuid.New()
+			},
+			expectedErr: service.ErrTransactionNotFound,
+		},
+		"should not refund a reversal": {
+			mutate: func(f *reversalFixture) {
+				reversed := uuid.New()
+				f.original.ReversalOf = &reversed
+			},
+			expectedErr: service.ErrTransactionNotReversible,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			f := newReversalFixture(t)
+			if test.mutate != nil {
+				test.mutate(f)
+			}
+
+			refunder := f.target.ID
+			if test.refunder != nil {
+				refunder = test.refunder(f)
+			}
+
+			refund, err := service.NewAccount(f.repo).RefundTransaction(ctx, refunder, f.original.ID, test.amount)
+			if test.expectedErr != nil {
+				require.True(t, errors.Is(err, test.expectedErr), "unexpected error %v", err)
+				require.Nil(t, refund)
+				require.Empty(t, f.created)
+				require.Empty(t, f.changes)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, []*service.Transaction{refund}, f.created)
+			require.Equal(t, test.expectedAmount, refund.Amount)
+			require.Equal(t, f.target.ID, refund.SourceUserID)
+			require.Equal(t, f.user.ID, refund.TargetUserID)
+			require.Equal(t, &f.original.ID, refund.ReversalOf)
+			require.Equal(t, service.TransactionCompleted, refund.Status)
+			require.Equal(t, test.expectedStatus, f.original.Status)
+			require.Equal(t, test.expectedBalances, [2]money.Amount{f.userWallet.Balance, f.targetWallet.Balance})
+			require.Empty(t, f.claims)
+		})
+	}
+}
+
+func TestAccount_ReverseTransaction(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		recipientBalance money.Amount
+		expectedBalance  money.Amount
+		expectedClaim    money.Amount
+	}{
+		"should not record a claim when the recipient has the balance": {
+			recipientBalance: 100,
+			expectedBalance:  0,
+		},
+		"should record a claim of what the balance couldn't cover": {
+			recipientBalance: 30,
+			expectedBalance:  -70,
+			expectedClaim:    70,
+		},
+		"should record a claim of the whole amount when the balance was negative already": {
+			recipientBalance: -10,
+			expectedBalance:  -110,
+			expectedClaim:    100,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			f := newReversalFixture(t)
+			f.targetWallet.Balance = test.recipientBalance
+
+			reversal, err := service.NewAccount(f.repo).ReverseTransaction(ctx, f.original.ID, nil)
+			require.NoError(t, err)
+			require.Equal(t, money.Amount(100), reversal.Transaction.Amount)
+			require.Equal(t, test.expectedBalance, f.targetWallet.Balance)
+			require.Equal(t, money.Amount(200), f.userWallet.Balance)
+
+			if test.expectedClaim == 0 {
+				require.Nil(t, reversal.Claim)
+				require.Empty(t, f.claims)
+				return
+			}
+
+			require.Equal(t, []*service.Claim{reversal.Claim}, f.claims)
+			require.Equal(t, f.target.ID, reversal.Claim.UserID)
+			require.Equal(t, reversal.Transaction.ID, reversal.Claim.TransactionID)
+			require.Equal(t, test.expectedClaim, reversal.Claim.Amount)
+		})
+	}
+
+	t.Run("should fail when the transaction doesn't exist", func(t *testing.T) {
+		f := newReversalFixture(t)
+
+		reversal, err := service.NewAccount(f.repo).ReverseTransaction(ctx, uuid.New(), nil)
+		require.True(t, errors.Is(err, service.ErrTransactionNotFound))
+		require.Nil(t, reversal)
+	})
+}
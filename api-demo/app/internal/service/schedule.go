@@ -0,0 +1,650 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"api-demo/pkg/money"
+)
+
+const (
+	// DefaultScheduleRetryBackoff is how long a scheduled transfer waits to be retried after its first failure for lack
+	// of funds, the wait doubles on every failure
+	DefaultScheduleRetryBackoff = time.Minute
+
+	// DefaultScheduleMaxAttempts is how many times a scheduled transfer is tried before the run is given up
+	DefaultScheduleMaxAttempts = 5
+)
+
+var (
+	// ErrScheduledTransferNotFound is returned when a scheduled transfer doesn't exist
+	ErrScheduledTransferNotFound = NewError(KindNotFound, "scheduled_transfer_not_found",
+		"scheduled transfer not found")
+
+	// ErrScheduledTransferNotActive is returned when cancelling a scheduled transfer that isn't active anymore
+	ErrScheduledTransferNotActive = NewError(KindConflict, "scheduled_transfer_not_active",
+		"the scheduled transfer was completed, cancelled or failed already")
+)
+
+// Frequency is how often a scheduled transfer recurs
+type Frequency string
+
+const (
+	// FrequencyOnce runs the transfer a single time
+	FrequencyOnce Frequency = "once"
+
+	// FrequencyDaily runs the transfer every day at the same time
+	FrequencyDaily Frequency = "daily"
+
+	// FrequencyWeekly runs the transfer every week on the same weekday and time
+	FrequencyWeekly Frequency = "weekly"
+
+	// FrequencyMonthly runs the transfer every month on a day of the month at the same time
+	FrequencyMonthly Frequency = "monthly"
+)
+
+// Recurrence is the rule of the runs of a scheduled transfer, their times are in UTC
+type Recurrence struct {
+	Frequency Frequency `json:"frequency"`
+
+	// DayOfMonth is the day of the monthly runs, the months without that day run on their last day
+	DayOfMonth int `json:"day_of_month,omitempty"`
+}
+
+// validate checks the rule, setting the day of the month of monthly runs from the first run when it's not set
+func (r *Recurrence) validate(firstRun time.Time) *Error {
+	switch r.Frequency {
+	case FrequencyOnce, FrequencyDaily, FrequencyWeekly:
+		if r.DayOfMonth != 0 {
+			return NewFieldError("recurrence.day_of_month", "invalid_day_of_month",
+				"the day of the month is only set for monthly transfers")
+		}
+	case FrequencyMonthly:
+		if r.DayOfMonth == 0 {
+			r.DayOfMonth = firstRun.UTC().Day()
+		}
+
+		if r.DayOfMonth < 1 || r.DayOfMonth > 31 {
+			return NewFieldError("recurrence.day_of_month", "invalid_day_of_month",
+				"the day of the month should be between 1 and 31")
+		}
+	default:
+		return NewFieldError("recurrence.frequency", "invalid_frequency",
+			"invalid frequency %q, it should be once, daily, weekly or monthly", r.Frequency)
+	}
+
+	return nil
+}
+
+// First returns the first run at or after start, which is start itself unless monthly runs are on another day
+func (r Recurrence) First(start time.Time) time.Time {
+	start = start.UTC()
+	if r.Frequency != FrequencyMonthly {
+		return start
+	}
+
+	first := dayOfMonth(start, 0, r.DayOfMonth)
+	if first.Before(start) {
+		first = dayOfMonth(start, 1, r.DayOfMonth)
+	}
+
+	return first
+}
+
+// Next returns the run following the given one, or the zero time when the transfer runs once
+func (r Recurrence) Next(run time.Time) time.Time {
+	run = run.UTC()
+	switch r.Frequency {
+	case FrequencyDaily:
+		return run.AddDate(0, 0, 1)
+	case FrequencyWeekly:
+		return run.AddDate(0, 0, 7)
+	case FrequencyMonthly:
+		return dayOfMonth(run, 1, r.DayOfMonth)
+	default:
+		return time.Time{}
+	}
+}
+
+// dayOfMonth returns the day of the month months after the one of t at the same time, clamped to the last day of the
+// month
+func dayOfMonth(t time.Time, months int, day int) time.Time {
+	firstOfMonth := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(),
+		t.Nanosecond(), time.UTC)
+
+	if lastDay := firstOfMonth.AddDate(0, 1, -1).Day(); day > lastDay {
+		day = lastDay
+	}
+
+	return firstOfMonth.AddDate(0, 0, day-1)
+}
+
+// ScheduleStatus is the state of a scheduled transfer, only active ones run
+type ScheduleStatus string
+
+const (
+	// ScheduleActive means the transfer has runs ahead
+	ScheduleActive ScheduleStatus = "active"
+
+	// ScheduleCompleted means the transfer ran once and doesn't recur
+	ScheduleCompleted ScheduleStatus = "completed"
+
+	// ScheduleCancelled means the user cancelled the runs ahead
+	ScheduleCancelled ScheduleStatus = "cancelled"
+
+	// ScheduleFailed means the transfer can't run anymore, e.g. its target doesn't exist, or it doesn't recur and its
+	// run was given up
+	ScheduleFailed ScheduleStatus = "failed"
+)
+
+// ScheduledTransfer is a transfer from the user to the target user run on the future dates of its recurrence
+type ScheduledTransfer struct {
+	ID           uuid.UUID      `json:"id"`
+	UserID       uuid.UUID      `json:"user_id"`
+	TargetUserID uuid.UUID      `json:"target_user_id"`
+	Amount       money.Amount   `json:"amount"`
+	Currency     money.Currency `json:"currency"`
+	Recurrence   Recurrence     `json:"recurrence"`
+	Status       ScheduleStatus `json:"status"`
+
+	// NextRunAt is when the next run is due, NextAttemptAt is when it's tried next, later than NextRunAt once Attempts
+	// failed for lack of funds
+	NextRunAt     time.Time `json:"next_run_at"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	Attempts      int       `json:"attempts"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// scheduledTransferFields has the fields of a ScheduledTransfer without its MarshalJSON method
+type scheduledTransferFields ScheduledTransfer
+
+// scheduledTransferJSON is the JSON representation of a ScheduledTransfer, having its amount formatted in its currency
+type scheduledTransferJSON struct {
+	scheduledTransferFields
+	Amount money.Money `json:"amount"`
+}
+
+// MarshalJSON encodes the scheduled transfer with its amount formatted in its currency
+func (s ScheduledTransfer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.toJSON())
+}
+
+func (s ScheduledTransfer) toJSON() scheduledTransferJSON {
+	return scheduledTransferJSON{scheduledTransferFields(s), money.Money{Amount: s.Amount, Currency: s.Currency}}
+}
+
+// ExecutionStatus is the result of an attempt of running a scheduled transfer
+type ExecutionStatus string
+
+const (
+	// ExecutionSucceeded means the transfer was made
+	ExecutionSucceeded ExecutionStatus = "succeeded"
+
+	// ExecutionRetrying means the attempt failed for lack of funds and the run will be tried again
+	ExecutionRetrying ExecutionStatus = "retrying"
+
+	// ExecutionFailed means the attempt failed and the run was given up
+	ExecutionFailed ExecutionStatus = "failed"
+)
+
+// ScheduleExecution is an attempt of running a scheduled transfer, an entry of its execution history
+type ScheduleExecution struct {
+	ID         uuid.UUID       `json:"id"`
+	ScheduleID uuid.UUID       `json:"-"`
+	RunAt      time.Time       `json:"run_at"`
+	Attempt    int             `json:"attempt"`
+	Status     ExecutionStatus `json:"status"`
+
+	// TransactionID is the transfer of the attempt, failed when the attempt failed, ErrorCode is the code of the error
+	// of a failed attempt
+	TransactionID *uuid.UUID `json:"transaction_id,omitempty"`
+	ErrorCode     string     `json:"error_code,omitempty"`
+
+	ExecutedAt time.Time `json:"executed_at"`
+}
+
+// ScheduledTransferDetails is a scheduled transfer along with its execution history, oldest attempt first
+type ScheduledTransferDetails struct {
+	ScheduledTransfer
+	Executions []ScheduleExecution `json:"executions"`
+}
+
+// MarshalJSON encodes the details with the amount of the scheduled transfer formatted in its currency
+func (d ScheduledTransferDetails) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		scheduledTransferJSON
+		Executions []ScheduleExecution `json:"executions"`
+	}{d.ScheduledTransfer.toJSON(), d.Executions})
+}
+
+// ScheduledTransfers provides the transfers scheduled by users and runs them when they're due, making the transfers
+// just like Account.CreateTransaction does
+type ScheduledTransfers struct {
+	accounts     *Account
+	retryBackoff time.Duration
+	maxAttempts  int
+	observer     TransferObserver
+}
+
+// TransferObserver is notified of the results of the runs of the scheduled transfers, once they're committed, e.g. to
+// collect metrics of them
+type TransferObserver interface {
+	// TransferCreated is called when a run made its transfer
+	TransferCreated(amount money.Amount, currency money.Currency)
+
+	// TransferFailed is called with the error code of a failed attempt of a run
+	TransferFailed(code string)
+}
+
+// ScheduledTransfersOpt is an option that can be passed to NewScheduledTransfers to configure the service
+type ScheduledTransfersOpt func(*ScheduledTransfers)
+
+// WithScheduleRetryBackoff returns a ScheduledTransfersOpt that sets the wait before retrying a transfer that failed
+// for lack of funds the first time, it doubles on every failure
+func WithScheduleRetryBackoff(backoff time.Duration) ScheduledTransfersOpt {
+	return func(s *ScheduledTransfers) {
+		s.retryBackoff = backoff
+	}
+}
+
+// WithScheduleMaxAttempts returns a ScheduledTransfersOpt that sets how many times a run is tried before it's given up
+func WithScheduleMaxAttempts(attempts int) ScheduledTransfersOpt {
+	return func(s *ScheduledTransfers) {
+		s.maxAttempts = attempts
+	}
+}
+
+// WithScheduleObserver returns a ScheduledTransfersOpt that sets the observer notified of the results of the runs
+func WithScheduleObserver(observer TransferObserver) ScheduledTransfersOpt {
+	return func(s *ScheduledTransfers) {
+		s.observer = observer
+	}
+}
+
+func NewScheduledTransfers(accounts *Account, opts ...ScheduledTransfersOpt) *ScheduledTransfers {
+	s := &ScheduledTransfers{
+		accounts:     accounts,
+		retryBackoff: DefaultScheduleRetryBackoff,
+		maxAttempts:  DefaultScheduleMaxAttempts,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Schedule schedules a transfer of amount in the currency from userID to targetUserID, first run at start or, for
+// monthly transfers, on the first day of the recurrence from start on
+func (s *ScheduledTransfers) Schedule(ctx context.Context, userID uuid.UUID, targetUserID uuid.UUID,
+	amount money.Amount, currency money.Currency, start time.Time, recurrence Recurrence) (*ScheduledTransfer, error) {
+
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	var errs []*Error
+	if userID == targetUserID {
+		errs = append(errs, NewFieldError("target_user_id", "same_source_and_target",
+			"the target user should be different than the source user"))
+	}
+
+	if amount <= 0 {
+		errs = append(errs, NewFieldError("amount", "invalid_amount", "transfer amount should be greater than zero"))
+	}
+
+	if err := validateCurrency(currency); err != nil {
+		errs = append(errs, err)
+	}
+
+	now := time.Now()
+	if !start.After(now) {
+		errs = append(errs, NewFieldError("start_at", "invalid_start_at", "the first run should be in the future"))
+	}
+
+	if err := recurrence.validate(start); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := JoinFieldErrors(errs...); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.accounts.repository.FindUserByID(ctx, targetUserID); err != nil {
+		return nil, err
+	}
+
+	// the wallets are checked again on every run, this only rejects the transfers that couldn't run at all
+	for _, walletUserID := range []uuid.UUID{userID, targetUserID} {
+		_, err := s.accounts.repository.FindWallet(ctx, walletUserID, currency)
+		if errors.Is(err, ErrWalletNotFound) {
+			return nil, ErrCurrencyMismatch
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	firstRun := recurrence.First(start)
+	schedule := &ScheduledTransfer{
+		ID:            uuid.New(),
+		UserID:        userID,
+		TargetUserID:  targetUserID,
+		Amount:        amount,
+		Currency:      currency,
+		Recurrence:    recurrence,
+		Status:        ScheduleActive,
+		NextRunAt:     firstRun,
+		NextAttemptAt: firstRun,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if err := s.accounts.repository.CreateScheduledTransfer(ctx, schedule); err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// List lists the transfers scheduled by userID, oldest first
+func (s *ScheduledTransfers) List(ctx context.Context, userID uuid.UUID) ([]ScheduledTransfer, error) {
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	schedules, err := s.accounts.repository.ListScheduledTransfersByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if schedules == nil {
+		schedules = []ScheduledTransfer{}
+	}
+
+	return schedules, nil
+}
+
+// Get retrieves a transfer scheduled by userID along with its execution history
+func (s *ScheduledTransfers) Get(ctx context.Context, userID uuid.UUID,
+	scheduleID uuid.UUID) (*ScheduledTransferDetails, error) {
+
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	schedule, err := s.accounts.repository.FindScheduledTransferByID(ctx, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the schedules of other users aren't disclosed
+	if schedule.UserID != userID {
+		return nil, ErrScheduledTransferNotFound
+	}
+
+	executions, err := s.accounts.repository.ListScheduleExecutions(ctx, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if executions == nil {
+		executions = []ScheduleExecution{}
+	}
+
+	return &ScheduledTransferDetails{ScheduledTransfer: *schedule, Executions: executions}, nil
+}
+
+// Cancel cancels the runs ahead of an active transfer scheduled by userID, a run in progress finishes first
+func (s *ScheduledTransfers) Cancel(ctx context.Context, userID uuid.UUID, scheduleID uuid.UUID) (*ScheduledTransfer,
+	error) {
+
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	var schedule *ScheduledTransfer
+	err := s.accounts.repository.WithTx(ctx, func(txRepo AccountRepository) error {
+		var err error
+		if schedule, err = txRepo.FindAndLockScheduledTransferByID(ctx, scheduleID); err != nil {
+			return err
+		}
+
+		if schedule.UserID != userID {
+			return ErrScheduledTransferNotFound
+		}
+
+		if schedule.Status != ScheduleActive {
+			return ErrScheduledTransferNotActive
+		}
+
+		schedule.Status = ScheduleCancelled
+		schedule.UpdatedAt = time.Now()
+		return txRepo.UpdateScheduledTransfer(ctx, schedule)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// RunDue runs the scheduled transfers that are due, each one in its own DB transaction holding the lock of its
+// schedule, so many instances can run them concurrently without running a transfer twice. A transfer that fails
+// unexpectedly has the failure recorded and is retried later, like the ones the balance can't cover, so it doesn't
+// hold back the others. It returns once there are no due transfers left or ctx is done, with the first unexpected
+// error found meanwhile.
+func (s *ScheduledTransfers) RunDue(ctx context.Context) error {
+	var firstErr error
+	for ctx.Err() == nil {
+		var claimed *ScheduledTransfer
+		var execution *ScheduleExecution
+		err := s.accounts.repository.WithTx(ctx, func(txRepo AccountRepository) error {
+			schedule, err := txRepo.ClaimDueScheduledTransfer(ctx, time.Now())
+			if err != nil || schedule == nil {
+				return err
+			}
+
+			claimed = schedule
+			execution, err = s.run(ctx, txRepo, schedule)
+			return err
+		})
+
+		// the DB is unreachable or the runs can't be claimed at all, there's nothing to move on to
+		if err != nil && (claimed == nil || ctx.Err() != nil) {
+			return err
+		}
+
+		if err != nil {
+			if recordErr := s.recordFailure(ctx, claimed.ID); recordErr != nil {
+				return fmt.Errorf("failed to record the failure of scheduled transfer %s: %v: %w", claimed.ID,
+					recordErr, err)
+			}
+
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to run scheduled transfer %s: %w", claimed.ID, err)
+			}
+
+			s.notify(claimed, &ScheduleExecution{ErrorCode: string(KindInternal)})
+		} else if claimed != nil {
+			s.notify(claimed, execution)
+		}
+
+		if claimed == nil {
+			break
+		}
+	}
+
+	return firstErr
+}
+
+// notify notifies the observer of the result of an attempt of running a scheduled transfer
+func (s *ScheduledTransfers) notify(schedule *ScheduledTransfer, execution *ScheduleExecution) {
+	if s.observer == nil {
+		return
+	}
+
+	if execution.ErrorCode != "" {
+		s.observer.TransferFailed(execution.ErrorCode)
+		return
+	}
+
+	s.observer.TransferCreated(schedule.Amount, schedule.Currency)
+}
+
+// recordFailure records an unexpected failure of an attempt of running a scheduled transfer in a DB transaction of
+// its own, the one of the attempt being rolled back, moving the schedule to its next attempt or run
+func (s *ScheduledTransfers) recordFailure(ctx context.Context, scheduleID uuid.UUID) error {
+	return s.accounts.repository.WithTx(ctx, func(txRepo AccountRepository) error {
+		schedule, err := txRepo.FindAndLockScheduledTransferByID(ctx, scheduleID)
+		if err != nil {
+			return err
+		}
+
+		// another instance may have run it meanwhile
+		if schedule.Status != ScheduleActive {
+			return nil
+		}
+
+		now := time.Now()
+		execution := &ScheduleExecution{
+			ID:         uuid.New(),
+			ScheduleID: schedule.ID,
+			RunAt:      schedule.NextRunAt,
+			Attempt:    schedule.Attempts + 1,
+			ErrorCode:  string(KindInternal),
+			ExecutedAt: now,
+		}
+
+		s.retry(schedule, execution, now)
+
+		schedule.UpdatedAt = now
+		if err := txRepo.UpdateScheduledTransfer(ctx, schedule); err != nil {
+			return err
+		}
+
+		return txRepo.CreateScheduleExecution(ctx, execution)
+	})
+}
+
+// run tries to make the transfer of a due schedule locked by the transactioned repository, recording the attempt and
+// moving the schedule to its next attempt or run. It returns the recorded attempt.
+func (s *ScheduledTransfers) run(ctx context.Context, txRepo AccountRepository,
+	schedule *ScheduledTransfer) (*ScheduleExecution, error) {
+
+	now := time.Now()
+	execution := &ScheduleExecution{
+		ID:         uuid.New(),
+		ScheduleID: schedule.ID,
+		RunAt:      schedule.NextRunAt,
+		Attempt:    schedule.Attempts + 1,
+		Status:     ExecutionSucceeded,
+		ExecutedAt: now,
+	}
+
+	transaction, err := s.accounts.createTransaction(ctx, txRepo, schedule.UserID, schedule.TargetUserID,
+		schedule.Amount, schedule.Currency)
+
+	var serviceErr *Error
+	if err != nil && (!errors.As(err, &serviceErr) || serviceErr.Kind == KindInternal) {
+		return nil, err
+	}
+
+	switch {
+	case err == nil:
+		s.advance(schedule, now)
+	case errors.Is(err, ErrInsufficientFunds):
+		// the balance may be enough later
+		s.retry(schedule, execution, now)
+	default:
+		// e.g. the target user doesn't exist anymore, it won't succeed on the next runs either
+		execution.Status = ExecutionFailed
+		schedule.Status = ScheduleFailed
+	}
+
+	// a failed attempt is recorded as a failed transaction, that's polled like the transfers that are made, unless a
+	// user is missing and there's nobody to record it for
+	if err != nil {
+		execution.ErrorCode = serviceErr.Code
+		if !errors.Is(err, ErrUserNotFound) {
+			transaction = &Transaction{
+				ID:           uuid.New(),
+				SourceUserID: schedule.UserID,
+				TargetUserID: schedule.TargetUserID,
+				Amount:       schedule.Amount,
+				Currency:     schedule.Currency,
+				CreatedAt:    now,
+			}
+
+			if err := failTransaction(ctx, txRepo, transaction, serviceErr.Message); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if transaction != nil {
+		execution.TransactionID = &transaction.ID
+	}
+
+	schedule.UpdatedAt = now
+	if err := txRepo.UpdateScheduledTransfer(ctx, schedule); err != nil {
+		return nil, err
+	}
+
+	if err := txRepo.CreateScheduleExecution(ctx, execution); err != nil {
+		return nil, err
+	}
+
+	return execution, nil
+}
+
+// retry moves a schedule whose attempt failed to its next attempt, backing off more on every failure, until the run is
+// given up or the next run is due
+func (s *ScheduledTransfers) retry(schedule *ScheduledTransfer, execution *ScheduleExecution, now time.Time) {
+	retryAt := now.Add(s.retryBackoff << uint(schedule.Attempts))
+	next := schedule.Recurrence.Next(schedule.NextRunAt)
+	if execution.Attempt >= s.maxAttempts || (!next.IsZero() && !retryAt.Before(next)) {
+		execution.Status = ExecutionFailed
+		s.advance(schedule, now)
+
+		// a run that doesn't recur and is given up fails the schedule
+		if schedule.Status == ScheduleCompleted {
+			schedule.Status = ScheduleFailed
+		}
+
+		return
+	}
+
+	execution.Status = ExecutionRetrying
+	schedule.Attempts++
+	schedule.NextAttemptAt = retryAt
+}
+
+// advance moves a schedule past its current run, to the next one after now so the runs missed meanwhile are skipped, or
+// completes it when it doesn't recur
+func (s *ScheduledTransfers) advance(schedule *ScheduledTransfer, now time.Time) {
+	schedule.Attempts = 0
+
+	next := schedule.Recurrence.Next(schedule.NextRunAt)
+	if next.IsZero() {
+		schedule.Status = ScheduleCompleted
+		return
+	}
+
+	for !next.After(now) {
+		next = schedule.Recurrence.Next(next)
+	}
+
+	schedule.NextRunAt = next
+	schedule.NextAttemptAt = next
+}
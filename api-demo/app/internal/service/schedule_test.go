@@ -0,0 +1,597 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+func TestRecurrence_Next(t *testing.T) {
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+
+	tests := map[string]struct {
+		recurrence service.Recurrence
+		run        time.Time
+		expected   time.Time
+	}{
+		"should not run again a transfer that runs once": {
+			recurrence: service.Recurrence{Frequency: service.FrequencyOnce},
+			run:        date(2021, time.January, 31),
+			expected:   time.Time{},
+		},
+		"should run a daily transfer on the next day": {
+			recurrence: service.Recurrence{Frequency: service.FrequencyDaily},
+			run:        date(2021, time.December, 31),
+			expected:   date(2022, time.January, 1),
+		},
+		"should run a weekly transfer on the same weekday": {
+			recurrence: service.Recurrence{Frequency: service.FrequencyWeekly},
+			run:        date(2021, time.February, 25),
+			expected:   date(2021, time.March, 4),
+		},
+		"should run a monthly transfer on its day": {
+			recurrence: service.Recurrence{Frequency: service.FrequencyMonthly, DayOfMonth: 15},
+			run:        date(2021, time.January, 15),
+			expected:   date(2021, time.February, 15),
+		},
+		"should run a monthly transfer on the last day of shorter months": {
+			recurrence: service.Recurrence{Frequency: service.FrequencyMonthly, DayOfMonth: 31},
+			run:        date(2021, time.January, 31),
+			expected:   date(2021, time.February, 28),
+		},
+		"should run a monthly transfer on the last day of february in leap years": {
+			recurrence: service.Recurrence{Frequency: service.FrequencyMonthly, DayOfMonth: 30},
+			run:        date(2024, time.January, 30),
+			expected:   date(2024, time.February, 29),
+		},
+		"should run a monthly transfer on its day again after a shorter month": {
+			recurrence: service.Recurrence{Frequency: service.FrequencyMonthly, DayOfMonth: 31},
+			run:        date(2021, time.February, 28),
+			expected:   date(2021, time.March, 31),
+		},
+		"should run a monthly transfer on the next year after december": {
+			recurrence: service.Recurrence{Frequency: service.FrequencyMonthly, DayOfMonth: 31},
+			run:        date(2021, time.December, 31),
+			expected:   date(2022, time.January, 31),
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			require.Equal(t, test.expected, test.recurrence.Next(test.run))
+		})
+	}
+}
+
+func TestRecurrence_First(t *testing.T) {
+
+	start := time.Date(2021, time.January, 20, 9, 30, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		recurrence service.Recurrence
+		expected   time.Time
+	}{
+		"should run a daily transfer first at the start": {
+			recurrence: service.Recurrence{Frequency: service.FrequencyDaily},
+			expected:   start,
+		},
+		"should run a monthly transfer first on its day in the month of the start": {
+			recurrence: service.Recurrence{Frequency: service.FrequencyMonthly, DayOfMonth: 25},
+			expected:   time.Date(2021, time.January, 25, 9, 30, 0, 0, time.UTC),
+		},
+		"should run a monthly transfer first on the next month when its day is before the start": {
+			recurrence: service.Recurrence{Frequency: service.FrequencyMonthly, DayOfMonth: 5},
+			expected:   time.Date(2021, time.February, 5, 9, 30, 0, 0, time.UTC),
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			require.Equal(t, test.expected, test.recurrence.First(start))
+		})
+	}
+}
+
+// scheduleFixture is an active BRL transfer of 100 scheduled by a user with a balance of 100 to the target, due a
+// minute ago
+type scheduleFixture struct {
+	*transferFixture
+	schedule *service.ScheduledTransfer
+
+	executions []service.ScheduleExecution
+}
+
+func newScheduleFixture(t *testing.T) *scheduleFixture {
+	now := time.Now()
+	f := &scheduleFixture{transferFixture: newTransferFixture(100, 0)}
+
+	f.schedule = &service.ScheduledTransfer{ID: uuid.New(), UserID: f.user.ID, TargetUserID: f.target.ID, Amount: 100,
+		Currency: money.BRL, Recurrence: service.Recurrence{Frequency: service.FrequencyOnce}, Status: service.ScheduleActive,
+		NextRunAt: now.Add(-time.Minute), NextAttemptAt: now.Add(-time.Minute), CreatedAt: now.Add(-time.Hour),
+		UpdatedAt: now.Add(-time.Hour)}
+
+	findSchedule := func(ctx context.Context, scheduleID uuid.UUID) (*service.ScheduledTransfer, error) {
+		if scheduleID != f.schedule.ID {
+			return nil, service.ErrScheduledTransferNotFound
+		}
+
+		schedule := *f.schedule
+		return &schedule, nil
+	}
+
+	f.repo.FindScheduledTransferByIDFunc = findSchedule
+	f.repo.FindAndLockScheduledTransferByIDFunc = findSchedule
+
+	// the schedule is claimed while it's active and its next attempt is due
+	f.repo.ClaimDueScheduledTransferFunc = func(ctx context.Context, at time.Time) (*service.ScheduledTransfer, error) {
+		if f.schedule.Status != service.ScheduleActive || f.schedule.NextAttemptAt.After(at) {
+			return nil, nil
+		}
+
+		return findSchedule(ctx, f.schedule.ID)
+	}
+
+	f.repo.UpdateScheduledTransferFunc = func(ctx context.Context, schedule *service.ScheduledTransfer) error {
+		updated := *schedule
+		f.schedule = &updated
+		return nil
+	}
+
+	f.repo.CreateScheduleExecutionFunc = func(ctx context.Context, execution *service.ScheduleExecution) error {
+		f.executions = append(f.executions, *execution)
+		return nil
+	}
+
+	return f
+}
+
+func TestScheduledTransfers_Schedule(t *testing.T) {
+
+	ctx := context.Background()
+	start := time.Now().Add(time.Hour)
+
+	tests := map[string]struct {
+		mutate             func(*scheduleFixture)
+		amount             money.Amount
+		start              time.Time
+		recurrence         service.Recurrence
+		expectedRecurrence service.Recurrence
+		expectedErr        error
+	}{
+		"should schedule a transfer": {
+			amount:             30,
+			start:              start,
+			recurrence:         service.Recurrence{Frequency: service.FrequencyWeekly},
+			expectedRecurrence: service.Recurrence{Frequency: service.FrequencyWeekly},
+		},
+		"should schedule a monthly transfer on the day of the start by default": {
+			amount:     30,
+			start:      start,
+			recurrence: service.Recurrence{Frequency: service.FrequencyMonthly},
+			expectedRecurrence: service.Recurrence{Frequency: service.FrequencyMonthly,
+				DayOfMonth: start.UTC().Day()},
+		},
+		"should not schedule a transfer starting in the past": {
+			amount:      30,
+			start:       time.Now().Add(-time.Minute),
+			recurrence:  service.Recurrence{Frequency: service.FrequencyOnce},
+			expectedErr: service.NewFieldError("start_at", "invalid_start_at", ""),
+		},
+		"should not schedule a transfer of a non positive amount": {
+			amount:      -1,
+			start:       start,
+			recurrence:  service.Recurrence{Frequency: service.FrequencyOnce},
+			expectedErr: service.NewFieldError("amount", "invalid_amount", ""),
+		},
+		"should not schedule a transfer with an unknown frequency": {
+			amount:      30,
+			start:       start,
+			recurrence:  service.Recurrence{Frequency: "yearly"},
+			expectedErr: service.NewFieldError("recurrence.frequency", "invalid_frequency", ""),
+		},
+		"should not schedule a monthly transfer on an invalid day": {
+			amount:      30,
+			start:       start,
+			recurrence:  service.Recurrence{Frequency: service.FrequencyMonthly, DayOfMonth: 32},
+			expectedErr: service.NewFieldError("recurrence.day_of_month", "invalid_day_of_month", ""),
+		},
+		"should not set the day of the month of transfers that aren't monthly": {
+			amount:      30,
+			start:       start,
+			recurrence:  service.Recurrence{Frequency: service.FrequencyDaily, DayOfMonth: 5},
+			expectedErr: service.NewFieldError("recurrence.day_of_month", "invalid_day_of_month", ""),
+		},
+		"should not schedule a transfer targeting the user": {
+			mutate: func(f *scheduleFixture) {
+				f.target.ID = f.user.ID
+			},
+			amount:      30,
+			start:       start,
+			recurrence:  service.Recurrence{Frequency: service.FrequencyOnce},
+			expectedErr: service.NewFieldError("target_user_id", "same_source_and_target", ""),
+		},
+		"should not schedule a transfer in a currency the target has no wallet in": {
+			mutate: func(f *scheduleFixture) {
+				f.targetWallet.Currency = money.USD
+			},
+			amount:      30,
+			start:       start,
+			recurrence:  service.Recurrence{Frequency: service.FrequencyOnce},
+			expectedErr: service.ErrCurrencyMismatch,
+		},
+		"should not schedule a transfer targeting a user that doesn't exist": {
+			mutate: func(f *scheduleFixture) {
+				f.target.ID = uuid.New()
+			},
+			amount:      30,
+			start:       start,
+			recurrence:  service.Recurrence{Frequency: service.FrequencyOnce},
+			expectedErr: service.ErrUserNotFound,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			f := newScheduleFixture(t)
+			if test.mutate != nil {
+				test.mutate(f)
+			}
+
+			var created *service.ScheduledTransfer
+			f.repo.CreateScheduledTransferFunc = func(ctx context.Context, schedule *service.ScheduledTransfer) error {
+				created = schedule
+				return nil
+			}
+
+			schedule, err := service.NewScheduledTransfers(service.NewAccount(f.repo)).Schedule(ctx, f.user.ID,
+				f.target.ID, test.amount, money.BRL, test.start, test.recurrence)
+
+			if test.expectedErr != nil {
+				require.True(t, errors.Is(err, test.expectedErr), "unexpected error %v", err)
+				require.Nil(t, schedule)
+				require.Nil(t, created)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, created, schedule)
+			require.Equal(t, service.ScheduleActive, schedule.Status)
+			require.Equal(t, test.expectedRecurrence, schedule.Recurrence)
+			require.Equal(t, test.amount, schedule.Amount)
+			require.Equal(t, money.BRL, schedule.Currency)
+			require.True(t, schedule.NextRunAt.Equal(test.start), "first run at %s", schedule.NextRunAt)
+			require.Equal(t, schedule.NextRunAt, schedule.NextAttemptAt)
+			require.Zero(t, schedule.Attempts)
+			require.Empty(t, f.created)
+		})
+	}
+}
+
+var errUnexpected = errors.New("unexpected")
+
+// transferObserverMock records the results of the runs it's notified of
+type transferObserverMock struct {
+	created  []money.Amount
+	failures []string
+}
+
+func (o *transferObserverMock) TransferCreated(amount money.Amount, currency money.Currency) {
+	o.created = append(o.created, amount)
+}
+
+func (o *transferObserverMock) TransferFailed(code string) {
+	o.failures = append(o.failures, code)
+}
+
+func TestScheduledTransfers_RunDue(t *testing.T) {
+
+	ctx := context.Background()
+	backoff := time.Minute
+
+	tests := map[string]struct {
+		mutate              func(*scheduleFixture)
+		expectedExecutions  []service.ExecutionStatus
+		expectedErrorCode   string
+		expectedTransaction service.TransactionStatus
+		expectedStatus      service.ScheduleStatus
+		expectedAttempts    int
+		expectedNextRun     func(previous time.Time) time.Time
+		expectedBalance     money.Amount
+		expectedErr         error
+	}{
+		"should complete a transfer that runs once": {
+			expectedExecutions:  []service.ExecutionStatus{service.ExecutionSucceeded},
+			expectedTransaction: service.TransactionCompleted,
+			expectedStatus:      service.ScheduleCompleted,
+			expectedNextRun:     func(previous time.Time) time.Time { return previous },
+			expectedBalance:     0,
+		},
+		"should move a recurring transfer to its next run": {
+			mutate: func(f *scheduleFixture) {
+				f.schedule.Recurrence.Frequency = service.FrequencyDaily
+			},
+			expectedExecutions:  []service.ExecutionStatus{service.ExecutionSucceeded},
+			expectedTransaction: service.TransactionCompleted,
+			expectedStatus:      service.ScheduleActive,
+			expectedNextRun:     func(previous time.Time) time.Time { return previous.AddDate(0, 0, 1) },
+			expectedBalance:     0,
+		},
+		"should skip the runs missed while the worker wasn't running": {
+			mutate: func(f *scheduleFixture) {
+				f.schedule.Recurrence.Frequency = service.FrequencyDaily
+				f.schedule.NextRunAt = f.schedule.NextRunAt.AddDate(0, 0, -3)
+				f.schedule.NextAttemptAt = f.schedule.NextRunAt
+			},
+			expectedExecutions:  []service.ExecutionStatus{service.ExecutionSucceeded},
+			expectedTransaction: service.TransactionCompleted,
+			expectedStatus:      service.ScheduleActive,
+			expectedNextRun:     func(previous time.Time) time.Time { return previous.AddDate(0, 0, 4) },
+			expectedBalance:     0,
+		},
+		"should retry a transfer the balance can't cover": {
+			mutate: func(f *scheduleFixture) {
+				f.userWallet.Balance = 50
+			},
+			expectedExecutions:  []service.ExecutionStatus{service.ExecutionRetrying},
+			expectedErrorCode:   "insufficient_funds",
+			expectedTransaction: service.TransactionFailed,
+			expectedStatus:      service.ScheduleActive,
+			expectedAttempts:    1,
+			expectedNextRun:     func(previous time.Time) time.Time { return previous },
+			expectedBalance:     50,
+		},
+		"should fail a transfer that runs once once the attempts are exhausted": {
+			mutate: func(f *scheduleFixture) {
+				f.userWallet.Balance = 50
+				f.schedule.Attempts = service.DefaultScheduleMaxAttempts - 1
+			},
+			expectedExecutions:  []service.ExecutionStatus{service.ExecutionFailed},
+			expectedErrorCode:   "insufficient_funds",
+			expectedTransaction: service.TransactionFailed,
+			expectedStatus:      service.ScheduleFailed,
+			expectedNextRun:     func(previous time.Time) time.Time { return previous },
+			expectedBalance:     50,
+		},
+		"should give up the run of a recurring transfer that would be retried after its next run": {
+			mutate: func(f *scheduleFixture) {
+				f.userWallet.Balance = 50
+				f.schedule.Recurrence.Frequency = service.FrequencyDaily
+				f.schedule.Attempts = 10
+			},
+			expectedExecutions:  []service.ExecutionStatus{service.ExecutionFailed},
+			expectedErrorCode:   "insufficient_funds",
+			expectedTransaction: service.TransactionFailed,
+			expectedStatus:      service.ScheduleActive,
+			expectedNextRun:     func(previous time.Time) time.Time { return previous.AddDate(0, 0, 1) },
+			expectedBalance:     50,
+		},
+		"should fail a transfer whose target doesn't exist anymore": {
+			mutate: func(f *scheduleFixture) {
+				f.schedule.Recurrence.Frequency = service.FrequencyDaily
+				f.schedule.TargetUserID = uuid.New()
+			},
+			expectedExecutions: []service.ExecutionStatus{service.ExecutionFailed},
+			expectedErrorCode:  "user_not_found",
+			expectedStatus:     service.ScheduleFailed,
+			expectedNextRun:    func(previous time.Time) time.Time { return previous },
+			expectedBalance:    100,
+		},
+		"should fail a transfer whose target doesn't have a wallet in its currency anymore": {
+			mutate: func(f *scheduleFixture) {
+				f.targetWallet.Currency = money.USD
+			},
+			expectedExecutions:  []service.ExecutionStatus{service.ExecutionFailed},
+			expectedErrorCode:   "currency_mismatch",
+			expectedTransaction: service.TransactionFailed,
+			expectedStatus:      service.ScheduleFailed,
+			expectedNextRun:     func(previous time.Time) time.Time { return previous },
+			expectedBalance:     100,
+		},
+		"should not run a transfer that isn't due": {
+			mutate: func(f *scheduleFixture) {
+				f.schedule.NextRunAt = time.Now().Add(time.Hour)
+				f.schedule.NextAttemptAt = f.schedule.NextRunAt
+			},
+			expectedStatus:  service.ScheduleActive,
+			expectedNextRun: func(previous time.Time) time.Time { return previous },
+			expectedBalance: 100,
+		},
+		"should record unexpected errors, retrying the transfer later": {
+			mutate: func(f *scheduleFixture) {
+				f.repo.CreatePostingsFunc = func(context.Context, []service.Posting) error {
+					return errUnexpected
+				}
+			},
+			expectedExecutions: []service.ExecutionStatus{service.ExecutionRetrying},
+			expectedErrorCode:  "internal",
+			expectedStatus:     service.ScheduleActive,
+			expectedAttempts:   1,
+			expectedNextRun:    func(previous time.Time) time.Time { return previous },
+			expectedErr:        errUnexpected,
+		},
+		"should give up a transfer that keeps failing unexpectedly once the attempts are exhausted": {
+			mutate: func(f *scheduleFixture) {
+				f.schedule.Attempts = service.DefaultScheduleMaxAttempts - 1
+				f.repo.CreatePostingsFunc = func(context.Context, []service.Posting) error {
+					return errUnexpected
+				}
+			},
+			expectedExecutions: []service.ExecutionStatus{service.ExecutionFailed},
+			expectedErrorCode:  "internal",
+			expectedStatus:     service.ScheduleFailed,
+			expectedNextRun:    func(previous time.Time) time.Time { return previous },
+			expectedErr:        errUnexpected,
+		},
+		"should fail when the due transfers can't be claimed": {
+			mutate: func(f *scheduleFixture) {
+				f.repo.ClaimDueScheduledTransferFunc = func(context.Context, time.Time) (*service.ScheduledTransfer,
+					error) {
+
+					return nil, errUnexpected
+				}
+			},
+			expectedStatus:  service.ScheduleActive,
+			expectedNextRun: func(previous time.Time) time.Time { return previous },
+			expectedBalance: 100,
+			expectedErr:     errUnexpected,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			f := newScheduleFixture(t)
+			if test.mutate != nil {
+				test.mutate(f)
+			}
+
+			previous := *f.schedule
+			start := time.Now()
+			observer := &transferObserverMock{}
+			err := service.NewScheduledTransfers(service.NewAccount(f.repo),
+				service.WithScheduleRetryBackoff(backoff), service.WithScheduleObserver(observer)).RunDue(ctx)
+
+			if test.expectedErr != nil {
+				require.True(t, errors.Is(err, test.expectedErr), "unexpected error %v", err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Len(t, f.executions, len(test.expectedExecutions))
+			require.Len(t, observer.created, len(test.expectedExecutions)-len(observer.failures))
+			if test.expectedErrorCode != "" {
+				require.Equal(t, []string{test.expectedErrorCode}, observer.failures)
+			}
+
+			for i, status := range test.expectedExecutions {
+				execution := f.executions[i]
+				require.Equal(t, status, execution.Status)
+				require.Equal(t, previous.ID, execution.ScheduleID)
+				require.Equal(t, previous.NextRunAt, execution.RunAt)
+				require.Equal(t, previous.Attempts+1, execution.Attempt)
+				require.Equal(t, test.expectedErrorCode, execution.ErrorCode)
+
+				// every attempt is recorded as a transaction, unless there's no target to record it for
+				if test.expectedTransaction == "" {
+					require.Nil(t, execution.TransactionID)
+					continue
+				}
+
+				require.NotNil(t, execution.TransactionID)
+				require.Len(t, f.created, 1)
+				require.Equal(t, *execution.TransactionID, f.created[0].ID)
+				require.Equal(t, test.expectedTransaction, f.created[0].Status)
+				require.Equal(t, test.expectedTransaction == service.TransactionFailed,
+					f.created[0].FailureReason != "")
+			}
+
+			require.Equal(t, test.expectedStatus, f.schedule.Status)
+			require.Equal(t, test.expectedAttempts, f.schedule.Attempts)
+			require.Equal(t, test.expectedNextRun(previous.NextRunAt.UTC()), f.schedule.NextRunAt.UTC())
+			// the mock doesn't roll back the balances of an attempt that failed unexpectedly
+			if test.expectedErr == nil || test.expectedExecutions == nil {
+				require.Equal(t, test.expectedBalance, f.userWallet.Balance)
+			}
+
+			if test.expectedAttempts > 0 {
+				// the run is retried after the backoff, not on the next tick
+				require.False(t, f.schedule.NextAttemptAt.Before(start.Add(backoff)))
+			} else {
+				require.Equal(t, f.schedule.NextRunAt, f.schedule.NextAttemptAt)
+			}
+		})
+	}
+}
+
+func TestScheduledTransfers_Cancel(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := map[string]struct {
+		mutate      func(*scheduleFixture)
+		userID      func(*scheduleFixture) uuid.UUID
+		expectedErr error
+	}{
+		"should cancel an active transfer": {},
+		"should not cancel a transfer that isn't active": {
+			mutate: func(f *scheduleFixture) {
+				f.schedule.Status = service.ScheduleCompleted
+			},
+			expectedErr: service.ErrScheduledTransferNotActive,
+		},
+		"should not cancel the transfers of other users": {
+			userID: func(f *scheduleFixture) uuid.UUID {
+				return f.target.ID
+			},
+			expectedErr: service.ErrScheduledTransferNotFound,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			f := newScheduleFixture(t)
+			if test.mutate != nil {
+				test.mutate(f)
+			}
+
+			userID := f.user.ID
+			if test.userID != nil {
+				userID = test.userID(f)
+			}
+
+			previous := *f.schedule
+			schedule, err := service.NewScheduledTransfers(service.NewAccount(f.repo)).Cancel(ctx, userID,
+				f.schedule.ID)
+
+			if test.expectedErr != nil {
+				require.True(t, errors.Is(err, test.expectedErr), "unexpected error %v", err)
+				require.Nil(t, schedule)
+				require.Equal(t, previous, *f.schedule)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, service.ScheduleCancelled, schedule.Status)
+			require.Equal(t, schedule, f.schedule)
+		})
+	}
+}
+
+func TestScheduledTransfers_Get(t *testing.T) {
+
+	ctx := context.Background()
+
+	f := newScheduleFixture(t)
+	execution := service.ScheduleExecution{ID: uuid.New(), ScheduleID: f.schedule.ID, RunAt: f.schedule.NextRunAt,
+		Attempt: 1, Status: service.ExecutionRetrying, ErrorCode: "insufficient_funds", ExecutedAt: time.Now()}
+
+	f.repo.ListScheduleExecutionsFunc = func(ctx context.Context, scheduleID uuid.UUID) ([]service.ScheduleExecution,
+		error) {
+
+		require.Equal(t, f.schedule.ID, scheduleID)
+		return []service.ScheduleExecution{execution}, nil
+	}
+
+	scheduled := service.NewScheduledTransfers(service.NewAccount(f.repo))
+
+	details, err := scheduled.Get(ctx, f.user.ID, f.schedule.ID)
+	require.NoError(t, err)
+	require.Equal(t, *f.schedule, details.ScheduledTransfer)
+	require.Equal(t, []service.ScheduleExecution{execution}, details.Executions)
+
+	// the transfers of other users aren't disclosed, not even to their targets
+	_, err = scheduled.Get(ctx, f.target.ID, f.schedule.ID)
+	require.True(t, errors.Is(err, service.ErrScheduledTransferNotFound), "unexpected error %v", err)
+
+	_, err = scheduled.Get(ctx, f.user.ID, uuid.New())
+	require.True(t, errors.Is(err, service.ErrScheduledTransferNotFound), "unexpected error %v", err)
+}
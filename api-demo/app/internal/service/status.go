@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TransactionStatus is the state of a transaction in its lifecycle
+type TransactionStatus string
+
+const (
+	// TransactionPending means the transaction was created but the money wasn't moved yet
+	TransactionPending TransactionStatus = "pending"
+
+	// TransactionCompleted means the money was moved
+	TransactionCompleted TransactionStatus = "completed"
+
+	// TransactionFailed means the money couldn't be moved, the failure reason tells why
+	TransactionFailed TransactionStatus = "failed"
+
+	// TransactionReversed means the whole amount of the transaction was moved back by reversals
+	TransactionReversed TransactionStatus = "reversed"
+)
+
+// statusTransitions are the statuses a transaction can go to from each status, the ones missing are final
+var statusTransitions = map[TransactionStatus][]TransactionStatus{
+	TransactionPending:   {TransactionCompleted, TransactionFailed},
+	TransactionCompleted: {TransactionReversed},
+}
+
+// ErrInvalidStatusTransition is returned when a transaction can't go from its status to the requested one
+var ErrInvalidStatusTransition = NewError(KindConflict, "invalid_status_transition",
+	"the transaction can't change to the requested status")
+
+// CanTransitionTo tells whether a transaction can go from the status to next
+func (s TransactionStatus) CanTransitionTo(next TransactionStatus) bool {
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// StatusChange is an entry of the status history of a transaction
+type StatusChange struct {
+	TransactionID uuid.UUID         `json:"-"`
+	Status        TransactionStatus `json:"status"`
+	Reason        string            `json:"reason,omitempty"`
+	ChangedAt     time.Time         `json:"changed_at"`
+}
+
+// TransactionDetails is a transaction along with the history of its status, oldest change first
+type TransactionDetails struct {
+	Transaction
+	History []StatusChange `json:"history"`
+}
+
+// MarshalJSON encodes the details with the amount of the transaction formatted in its currency
+func (d TransactionDetails) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		transactionJSON
+		History []StatusChange `json:"history"`
+	}{d.Transaction.toJSON(), d.History})
+}
+
+// startTransaction creates a pending transaction using an already transactioned repository, recording the first entry
+// of its history
+func startTransaction(ctx context.Context, txRepo AccountRepository, transaction *Transaction) error {
+	transaction.Status = TransactionPending
+	transaction.UpdatedAt = transaction.CreatedAt
+
+	if err := txRepo.CreateTransaction(ctx, transaction); err != nil {
+		return err
+	}
+
+	return txRepo.RecordStatusChange(ctx, &StatusChange{
+		TransactionID: transaction.ID,
+		Status:        TransactionPending,
+		ChangedAt:     transaction.CreatedAt,
+	})
+}
+
+// transition moves a transaction to the next status using an already transactioned repository, recording the change
+// in its history. The reason is kept as the failure reason of failed transactions.
+func transition(ctx context.Context, txRepo AccountRepository, transaction *Transaction, next TransactionStatus,
+	reason string) error {
+
+	if !transaction.Status.CanTransitionTo(next) {
+		return NewError(KindConflict, ErrInvalidStatusTransition.Code, "the transaction can't change from %s to %s",
+			transaction.Status, next)
+	}
+
+	change := &StatusChange{
+		TransactionID: transaction.ID,
+		Status:        next,
+		Reason:        reason,
+		ChangedAt:     time.Now(),
+	}
+
+	if err := txRepo.RecordStatusChange(ctx, change); err != nil {
+		return err
+	}
+
+	transaction.Status = next
+	transaction.UpdatedAt = change.ChangedAt
+	if next == TransactionFailed {
+		transaction.FailureReason = reason
+	}
+
+	return nil
+}
+
+// failTransaction records a transfer that couldn't be made using an already transactioned repository, the transaction
+// is created pending and fails right away for the reason, without moving any money
+func failTransaction(ctx context.Context, txRepo AccountRepository, transaction *Transaction, reason string) error {
+	if err := startTransaction(ctx, txRepo, transaction); err != nil {
+		return err
+	}
+
+	return transition(ctx, txRepo, transaction, TransactionFailed, reason)
+}
+
+// GetTransaction retrieves a transaction sent or received by userID along with its status history, so the status of
+// transfers can be polled
+func (service *Account) GetTransaction(ctx context.Context, userID uuid.UUID,
+	transactionID uuid.UUID) (*TransactionDetails, error) {
+
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	transaction, err := service.repository.FindTransactionByID(ctx, transactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the transactions of other users aren't disclosed
+	if transaction.SourceUserID != userID && transaction.TargetUserID != userID {
+		return nil, ErrTransactionNotFound
+	}
+
+	history, err := service.repository.ListStatusChanges(ctx, transactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransactionDetails{Transaction: *transaction, History: history}, nil
+}
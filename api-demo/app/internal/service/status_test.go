@@ -0,0 +1,170 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/app/internal/service"
+	"api-demo/pkg/money"
+)
+
+func TestTransactionStatus_CanTransitionTo(t *testing.T) {
+
+	tests := map[string]struct {
+		from     service.TransactionStatus
+		to       service.TransactionStatus
+		expected bool
+	}{
+		"should complete a pending transaction": {
+			from:     service.TransactionPending,
+			to:       service.TransactionCompleted,
+			expected: true,
+		},
+		"should fail a pending transaction": {
+			from:     service.TransactionPending,
+			to:       service.TransactionFailed,
+			expected: true,
+		},
+		"should reverse a completed transaction": {
+			from:     service.TransactionCompleted,
+			to:       service.TransactionReversed,
+			expected: true,
+		},
+		"should not reverse a pending transaction": {
+			from: service.TransactionPending,
+			to:   service.TransactionReversed,
+		},
+		"should not fail a completed transaction": {
+			from: service.TransactionCompleted,
+			to:   service.TransactionFailed,
+		},
+		"should not change a failed transaction": {
+			from: service.TransactionFailed,
+			to:   service.TransactionCompleted,
+		},
+		"should not change a reversed transaction": {
+			from: service.TransactionReversed,
+			to:   service.TransactionCompleted,
+		},
+		"should not go back to pending": {
+			from: service.TransactionCompleted,
+			to:   service.TransactionPending,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			require.Equal(t, test.expected, test.from.CanTransitionTo(test.to))
+		})
+	}
+}
+
+func TestAccount_CreateTransaction_StatusHistory(t *testing.T) {
+
+	ctx := context.Background()
+	repo := newAccountRepositoryMock()
+
+	sourceUser := &service.User{ID: uuid.New()}
+	targetUser := &service.User{ID: uuid.New()}
+	repo.stubUsers([]*service.User{sourceUser, targetUser},
+		&service.Wallet{UserID: sourceUser.ID, Currency: money.BRL, Balance: 100},
+		&service.Wallet{UserID: targetUser.ID, Currency: money.BRL})
+
+	var created *service.Transaction
+	repo.CreateTransactionFunc = func(ctx context.Context, transaction *service.Transaction) error {
+		// the transaction is stored as pending before the money is moved
+		require.Equal(t, service.TransactionPending, transaction.Status)
+		created = transaction
+		return nil
+	}
+
+	var changes []service.TransactionStatus
+	repo.RecordStatusChangeFunc = func(ctx context.Context, change *service.StatusChange) error {
+		require.Equal(t, created.ID, change.TransactionID)
+		changes = append(changes, change.Status)
+		return nil
+	}
+
+	transaction, err := service.NewAccount(repo).CreateTransaction(ctx, sourceUser.ID, targetUser.ID, 10, money.BRL)
+	require.NoError(t, err)
+	require.Equal(t, service.TransactionCompleted, transaction.Status)
+	require.Equal(t, []service.TransactionStatus{service.TransactionPending, service.TransactionCompleted}, changes)
+	require.False(t, transaction.UpdatedAt.Before(transaction.CreatedAt))
+}
+
+func TestAccount_GetTransaction(t *testing.T) {
+
+	ctx := context.Background()
+
+	sourceUserID, targetUserID := uuid.New(), uuid.New()
+	transaction := &service.Transaction{ID: uuid.New(), SourceUserID: sourceUserID, TargetUserID: targetUserID,
+		Amount: 10, Status: service.TransactionCompleted, CreatedAt: time.Now()}
+
+	history := []service.StatusChange{
+		{TransactionID: transaction.ID, Status: service.TransactionPending, ChangedAt: transaction.CreatedAt},
+		{TransactionID: transaction.ID, Status: service.TransactionCompleted, ChangedAt: transaction.CreatedAt},
+	}
+
+	tests := map[string]struct {
+		userID        uuid.UUID
+		transactionID uuid.UUID
+		expectedErr   error
+	}{
+		"should get a transaction sent by the user": {
+			userID:        sourceUserID,
+			transactionID: transaction.ID,
+		},
+		"should get a transaction received by the user": {
+			userID:        targetUserID,
+			transactionID: transaction.ID,
+		},
+		"should not disclose the transactions of other users": {
+			userID:        uuid.New(),
+			transactionID: transaction.ID,
+			expectedErr:   service.ErrTransactionNotFound,
+		},
+		"should fail when the transaction doesn't exist": {
+			userID:        sourceUserID,
+			transactionID: uuid.New(),
+			expectedErr:   service.ErrTransactionNotFound,
+		},
+		"should fail when the user id isn't provided": {
+			transactionID: transaction.ID,
+			expectedErr:   service.ErrUserIDNotProvided,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			repo := newAccountRepositoryMock()
+
+			repo.FindTransactionByIDFunc = func(ctx context.Context, transactionID uuid.UUID) (*service.Transaction, error) {
+				if transactionID != transaction.ID {
+					return nil, service.ErrTransactionNotFound
+				}
+
+				return transaction, nil
+			}
+
+			repo.ListStatusChangesFunc = func(ctx context.Context, transactionID uuid.UUID) ([]service.StatusChange, error) {
+				return history, nil
+			}
+
+			details, err := service.NewAccount(repo).GetTransaction(ctx, test.userID, test.transactionID)
+			if test.expectedErr != nil {
+				require.True(t, errors.Is(err, test.expectedErr), "unexpected error %v", err)
+				require.Nil(t, details)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, *transaction, details.Transaction)
+			require.Equal(t, history, details.History)
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultAccessTokenTTL is for how long access tokens are valid when no TTL is configured
+	DefaultAccessTokenTTL = 15 * time.Minute
+
+	// DefaultRefreshTokenTTL is for how long refresh tokens are valid when no TTL is configured
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	// tokenBytes is the amount of random bytes of a token
+	tokenBytes = 32
+)
+
+// ErrInvalidToken is returned when a token doesn't exist, expired, was revoked or has the wrong kind
+var ErrInvalidToken = NewError(KindUnauthorized, "invalid_token", "invalid or expired token")
+
+// TokenKind tells what a token can be used for
+type TokenKind string
+
+const (
+	// TokenKindAccess tokens authenticate requests
+	TokenKindAccess TokenKind = "access"
+
+	// TokenKindRefresh tokens are exchanged for a new pair of tokens
+	TokenKindRefresh TokenKind = "refresh"
+)
+
+// Token is an issued opaque token, only the hash of the token is stored so a leaked DB can't be used to authenticate.
+// Tokens issued by a login and by the refreshes that followed it share the same SessionID.
+type Token struct {
+	Hash      string
+	SessionID uuid.UUID
+	UserID    uuid.UUID
+	Kind      TokenKind
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// valid tells whether the token can be used at the given time
+func (t *Token) valid(kind TokenKind, now time.Time) bool {
+	return t.Kind == kind && t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// TokenPair is the pair of tokens handed to a user that logged in
+type TokenPair struct {
+	AccessToken           string
+	AccessTokenExpiresAt  time.Time
+	RefreshToken          string
+	RefreshTokenExpiresAt time.Time
+}
+
+// Login authenticates a user by its credentials and starts a session, issuing a pair of tokens
+func (a *Authentication) Login(ctx context.Context, userName string, password string) (*TokenPair, error) {
+	user, err := a.Authenticate(ctx, userName, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.issueTokens(ctx, user.ID, uuid.New())
+}
+
+// AuthenticateToken returns the user that owns the access token
+func (a *Authentication) AuthenticateToken(ctx context.Context, accessToken string) (*User, error) {
+	token, err := a.repository.FindTokenByHash(ctx, hashToken(accessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.valid(TokenKindAccess, time.Now().UTC()) {
+		return nil, ErrInvalidToken
+	}
+
+	return a.repository.FindUserByID(ctx, token.UserID)
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens of the same session, the refresh token can't be used again.
+// Reusing a refresh token means that it leaked, so the whole session is revoked.
+func (a *Authentication) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	now := time.Now().UTC()
+
+	token, err := a.repository.FindTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Kind == TokenKindRefresh && token.RevokedAt != nil {
+		if err := a.repository.RevokeSession(ctx, token.SessionID, now); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrInvalidToken
+	}
+
+	if !token.valid(TokenKindRefresh, now) {
+		return nil, ErrInvalidToken
+	}
+
+	// revoking fails if a concurrent refresh won the race, so a refresh token is exchanged only once
+	if err := a.repository.RevokeToken(ctx, token.Hash, now); err != nil {
+		return nil, err
+	}
+
+	return a.issueTokens(ctx, token.UserID, token.SessionID)
+}
+
+// Logout revokes every token of the session the access token belongs to
+func (a *Authentication) Logout(ctx context.Context, accessToken string) error {
+	token, err := a.repository.FindTokenByHash(ctx, hashToken(accessToken))
+	if err != nil {
+		return err
+	}
+
+	if !token.valid(TokenKindAccess, time.Now().UTC()) {
+		return ErrInvalidToken
+	}
+
+	return a.repository.RevokeSession(ctx, token.SessionID, time.Now().UTC())
+}
+
+// issueTokens creates and stores a pair of tokens for the session
+func (a *Authentication) issueTokens(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID) (*TokenPair, error) {
+	now := time.Now().UTC()
+	pair := &TokenPair{
+		AccessTokenExpiresAt:  now.Add(a.accessTokenTTL),
+		RefreshTokenExpiresAt: now.Add(a.refreshTokenTTL),
+	}
+
+	var err error
+	if pair.AccessToken, err = a.issueToken(ctx, userID, sessionID, TokenKindAccess, now, pair.AccessTokenExpiresAt); err != nil {
+		return nil, err
+	}
+
+	if pair.RefreshToken, err = a.issueToken(ctx, userID, sessionID, TokenKindRefresh, now, pair.RefreshTokenExpiresAt); err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// issueToken generates a random token, storing its hash
+func (a *Authentication) issueToken(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID, kind TokenKind,
+	now time.Time, expiresAt time.Time) (string, error) {
+
+	random := make([]byte, tokenBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+
+	value := base64.RawURLEncoding.EncodeToString(random)
+
+	err := a.repository.CreateToken(ctx, &Token{
+		Hash:      hashToken(value),
+		SessionID: sessionID,
+		UserID:    userID,
+		Kind:      kind,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// hashToken hashes a token for storage, tokens have enough entropy for a fast hash like SHA-256 to be safe
+func hashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
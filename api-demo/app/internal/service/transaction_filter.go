@@ -0,0 +1,148 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"api-demo/pkg/money"
+)
+
+const (
+	// DefaultTransactionsLimit is the page size used when a filter doesn't set one
+	DefaultTransactionsLimit = 50
+
+	// MaxTransactionsLimit is the biggest page size that can be requested
+	MaxTransactionsLimit = 200
+)
+
+// Direction tells whether a transaction moved money out of or into the account of a user
+type Direction string
+
+const (
+	DirectionOutgoing Direction = "outgoing"
+	DirectionIncoming Direction = "incoming"
+	DirectionAll      Direction = "all"
+)
+
+// ErrInvalidCursor is returned when a cursor wasn't returned by a previous page
+var ErrInvalidCursor = NewFieldError("cursor", "invalid_cursor", "invalid cursor")
+
+// TransactionCursor points to the last transaction of a page, the next page starts right after it following the
+// ordering by creation time and ID
+type TransactionCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// ParseTransactionCursor parses a cursor previously returned in a TransactionPage
+func ParseTransactionCursor(s string) (*TransactionCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(decoded), "|", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &TransactionCursor{CreatedAt: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+// String encodes the cursor in an opaque format, to be parsed by ParseTransactionCursor
+func (c TransactionCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d|%s", c.CreatedAt.UnixNano(), c.ID)))
+}
+
+// TransactionFilter filters and paginates the transactions of a user, the zero value of each field means no filtering
+type TransactionFilter struct {
+
+	// Limit is the maximum number of transactions returned
+	Limit int
+
+	// After continues the listing after a previous page
+	After *TransactionCursor
+
+	// From and To limit the creation time of the transactions, From is inclusive and To is exclusive
+	From time.Time
+	To   time.Time
+
+	// Currency limits the transactions to the ones in a currency. It's required to filter by amount, as the amounts are
+	// in the minor units of their currency.
+	Currency money.Currency
+
+	// MinAmount and MaxAmount limit the amounts of the transactions, both are inclusive
+	MinAmount *money.Amount
+	MaxAmount *money.Amount
+
+	// CounterpartyID limits the transactions to the ones made with a certain user
+	CounterpartyID uuid.UUID
+
+	// Direction limits the transactions to the ones where the user sent or received money, all of them by default
+	Direction Direction
+}
+
+// normalize validates the filter and fills its defaults
+func (f *TransactionFilter) normalize() error {
+	var errs []*Error
+
+	switch {
+	case f.Limit == 0:
+		f.Limit = DefaultTransactionsLimit
+	case f.Limit < 0 || f.Limit > MaxTransactionsLimit:
+		errs = append(errs, NewFieldError("limit", "invalid_limit", "the limit should be between 1 and %d",
+			MaxTransactionsLimit))
+	}
+
+	switch f.Direction {
+	case "":
+		f.Direction = DirectionAll
+	case DirectionOutgoing, DirectionIncoming, DirectionAll:
+	default:
+		errs = append(errs, NewFieldError("direction", "invalid_direction",
+			"invalid direction %q, it should be %s, %s or %s", f.Direction, DirectionOutgoing, DirectionIncoming,
+			DirectionAll))
+	}
+
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		errs = append(errs, NewFieldError("from", "invalid_date_range",
+			"the start of the date range should be before its end"))
+	}
+
+	if f.Currency != "" {
+		if err := validateCurrency(f.Currency); err != nil {
+			errs = append(errs, err)
+		}
+	} else if f.MinAmount != nil || f.MaxAmount != nil {
+		errs = append(errs, NewFieldError("currency", "missing_currency",
+			"the currency is required to filter by amount"))
+	}
+
+	if f.MinAmount != nil && f.MaxAmount != nil && *f.MinAmount > *f.MaxAmount {
+		errs = append(errs, NewFieldError("min_amount", "invalid_amount_range",
+			"the minimum amount should not be greater than the maximum amount"))
+	}
+
+	return JoinFieldErrors(errs...)
+}
+
+// TransactionPage is a page of the statement of a user, NextCursor is empty when there are no more pages
+type TransactionPage struct {
+	Transactions []StatementEntry
+	NextCursor   string
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// minPasswordLength is the minimum length of new passwords
+	minPasswordLength = 8
+
+	// maxPasswordLength is the maximum length of new passwords, bcrypt ignores anything after 72 bytes
+	maxPasswordLength = 72
+
+	// maxDisplayNameLength is the maximum length of display names
+	maxDisplayNameLength = 100
+)
+
+var (
+	// ErrUserNameTaken is returned when creating or renaming a user to a username that's already in use
+	ErrUserNameTaken = NewError(KindConflict, "username_taken", "the username is already taken")
+
+	// ErrWrongPassword is returned when the current password given to change it doesn't match
+	ErrWrongPassword = NewError(KindForbidden, "wrong_password", "the current password doesn't match")
+
+	// userNamePattern allows lowercase usernames starting with a letter, having from 3 to 32 characters
+	userNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_.-]{2,31}$`)
+)
+
+// UserRepository defines features that should be provided to the users service regarding storage
+type UserRepository interface {
+
+	// CreateUser creates a user, failing with ErrUserNameTaken if its username is already in use
+	CreateUser(ctx context.Context, user *User) error
+
+	// CreateWallet opens a wallet for a user, failing with ErrWalletExists if the user has a wallet in its currency
+	CreateWallet(ctx context.Context, wallet *Wallet) error
+
+	// FindUserByID looks up for a User with the given ID
+	FindUserByID(ctx context.Context, userID uuid.UUID) (*User, error)
+
+	// UpdateUserProfile updates the username, display name and email of a user, failing with ErrUserNotFound if it
+	// doesn't exist or with ErrUserNameTaken if the new username is already in use
+	UpdateUserProfile(ctx context.Context, user *User) error
+
+	// UpdateUserPassword replaces the password hash of a user, failing with ErrUserNotFound if it doesn't exist
+	UpdateUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error
+
+	// RevokeUserTokens revokes all the tokens of a user that aren't revoked yet
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID, revokedAt time.Time) error
+
+	// WithTx starts a transactioned version of the repository that'll be either commited if no errors are returned or
+	// rolled back
+	WithTx(context.Context, func(repository UserRepository) error) error
+}
+
+// Registration has the data needed to sign up a user
+type Registration struct {
+	UserName    string
+	Password    string
+	DisplayName string
+	Email       string
+}
+
+// ProfileUpdate has the profile fields to update, nil fields are kept untouched
+type ProfileUpdate struct {
+	UserName    *string
+	DisplayName *string
+	Email       *string
+}
+
+// Users provides services to sign up users and manage their profiles
+type Users struct {
+	repository UserRepository
+	hasher     PasswordHasher
+}
+
+func NewUsers(repository UserRepository, hasher PasswordHasher) *Users {
+	return &Users{repository: repository, hasher: hasher}
+}
+
+// Register signs up a new user, with an empty wallet in DefaultCurrency
+func (service *Users) Register(ctx context.Context, registration Registration) (*User, error) {
+
+	user := &User{
+		ID:          uuid.New(),
+		UserName:    registration.UserName,
+		DisplayName: strings.TrimSpace(registration.DisplayName),
+		Email:       strings.TrimSpace(registration.Email),
+	}
+
+	errs := validateProfile(user)
+	if err := validatePassword("password", registration.Password); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := JoinFieldErrors(errs...); err != nil {
+		return nil, err
+	}
+
+	hash, err := service.hasher.Hash(registration.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	user.PasswordHash = hash
+
+	// the user is created along with its wallet, or not at all
+	err = service.repository.WithTx(ctx, func(txRepo UserRepository) error {
+		if err := txRepo.CreateUser(ctx, user); err != nil {
+			return err
+		}
+
+		return txRepo.CreateWallet(ctx, &Wallet{UserID: user.ID, Currency: DefaultCurrency})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetProfile retrieves the user with its profile
+func (service *Users) GetProfile(ctx context.Context, userID uuid.UUID) (*User, error) {
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	return service.repository.FindUserByID(ctx, userID)
+}
+
+// UpdateProfile updates the profile fields that are set on the update
+func (service *Users) UpdateProfile(ctx context.Context, userID uuid.UUID, update ProfileUpdate) (*User, error) {
+
+	user, err := service.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.UserName != nil {
+		user.UserName = *update.UserName
+	}
+
+	if update.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*update.DisplayName)
+	}
+
+	if update.Email != nil {
+		user.Email = strings.TrimSpace(*update.Email)
+	}
+
+	if err := JoinFieldErrors(validateProfile(user)...); err != nil {
+		return nil, err
+	}
+
+	if err := service.repository.UpdateUserProfile(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ChangePassword replaces the password of a user once the current one is confirmed, revoking all the tokens of the
+// user so other sessions need to login again
+func (service *Users) ChangePassword(ctx context.Context, userID uuid.UUID, currentPassword string, newPassword string) error {
+
+	user, err := service.GetProfile(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	matches, err := service.hasher.Verify(user.PasswordHash, currentPassword)
+	if err != nil {
+		return err
+	}
+
+	if !matches {
+		return ErrWrongPassword
+	}
+
+	if err := validatePassword("new_password", newPassword); err != nil {
+		return err
+	}
+
+	hash, err := service.hasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	// the password isn't changed unless the other sessions are ended with it
+	return service.repository.WithTx(ctx, func(txRepo UserRepository) error {
+		if err := txRepo.UpdateUserPassword(ctx, userID, hash); err != nil {
+			return err
+		}
+
+		return txRepo.RevokeUserTokens(ctx, userID, time.Now().UTC())
+	})
+}
+
+// validateProfile checks the profile fields of a user, returning the errors of the invalid ones
+func validateProfile(user *User) []*Error {
+	var errs []*Error
+
+	if !userNamePattern.MatchString(user.UserName) {
+		errs = append(errs, NewFieldError("username", "invalid_username",
+			"the username should have from 3 to 32 lowercase letters, digits, '_', '.' or '-', starting with a letter"))
+	}
+
+	if len(user.DisplayName) > maxDisplayNameLength {
+		errs = append(errs, NewFieldError("display_name", "invalid_display_name",
+			"the display name should have at most %d characters", maxDisplayNameLength))
+	}
+
+	if user.Email != "" {
+		at := strings.Index(user.Email, "@")
+		if at < 1 || at == len(user.Email)-1 || strings.ContainsAny(user.Email, " \t\n") {
+			errs = append(errs, NewFieldError("email", "invalid_email", "invalid email"))
+		}
+	}
+
+	return errs
+}
+
+// validatePassword checks the rules for new passwords given in the field
+func validatePassword(field string, password string) *Error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return NewFieldError(field, "invalid_password",
+			"the password should have from %d to %d characters", minPasswordLength, maxPasswordLength)
+	}
+
+	return nil
+}
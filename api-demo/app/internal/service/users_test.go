@@ -0,0 +1,284 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"api-demo/app/internal/service"
+)
+
+func TestUsers_Register(t *testing.T) {
+
+	ctx := context.Background()
+
+	hasher, err := service.NewBcryptHasher(bcrypt.MinCost)
+	require.NoError(t, err)
+
+	validRegistration := service.Registration{
+		UserName:    "breno",
+		Password:    "a long password",
+		DisplayName: " Breno ",
+		Email:       "breno@example.com",
+	}
+
+	failCheck := func(t *testing.T, user *service.User, wallets []service.Wallet, err error) {
+		require.Error(t, err)
+		require.Nil(t, user)
+	}
+
+	tests := map[string]struct {
+		mutateRegistration func(*service.Registration)
+		mutateMock         func(*userRepositoryMock)
+		checkFunction      func(*testing.T, *service.User, []service.Wallet, error)
+	}{
+		"should register a user with a hashed password and an empty wallet in the default currency": {
+			checkFunction: func(t *testing.T, user *service.User, wallets []service.Wallet, err error) {
+				require.NoError(t, err)
+				require.NotEqual(t, uuid.Nil, user.ID)
+				require.Equal(t, "Breno", user.DisplayName)
+				require.Equal(t, []service.Wallet{{UserID: user.ID, Currency: service.DefaultCurrency}}, wallets)
+
+				matches, err := hasher.Verify(user.PasswordHash, "a long password")
+				require.NoError(t, err)
+				require.True(t, matches)
+			},
+		},
+		"should return an error when the username has uppercase letters": {
+			mutateRegistration: func(registration *service.Registration) {
+				registration.UserName = "Breno"
+			},
+			checkFunction: failCheck,
+		},
+		"should return an error when the username is too short": {
+			mutateRegistration: func(registration *service.Registration) {
+				registration.UserName = "br"
+			},
+			checkFunction: failCheck,
+		},
+		"should return an error when the password is too short": {
+			mutateRegistration: func(registration *service.Registration) {
+				registration.Password = "1234"
+			},
+			checkFunction: failCheck,
+		},
+		"should return an error when the email is invalid": {
+			mutateRegistration: func(registration *service.Registration) {
+				registration.Email = "breno@"
+			},
+			checkFunction: failCheck,
+		},
+		"should return an error when the username is taken": {
+			mutateMock: func(mock *userRepositoryMock) {
+				mock.CreateUserFunc = func(ctx context.Context, user *service.User) error {
+					return service.ErrUserNameTaken
+				}
+			},
+			checkFunction: func(t *testing.T, user *service.User, wallets []service.Wallet, err error) {
+				require.True(t, errors.Is(err, service.ErrUserNameTaken))
+				require.Nil(t, user)
+				require.Empty(t, wallets)
+			},
+		},
+		"should return an error, rolling back the user, when the wallet can't be opened": {
+			mutateMock: func(mock *userRepositoryMock) {
+				mock.CreateWalletFunc = func(ctx context.Context, wallet *service.Wallet) error {
+					return errors.New("connection lost")
+				}
+			},
+			checkFunction: failCheck,
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+
+			repo := newUserRepositoryMock()
+
+			// the writes are only accepted inside a transaction, whose error is returned by WithTx to roll it back
+			inTx := false
+			repo.WithTxFunc = func(ctx context.Context, f func(repository service.UserRepository) error) error {
+				inTx = true
+				defer func() { inTx = false }()
+				return f(repo)
+			}
+
+			repo.CreateUserFunc = func(ctx context.Context, user *service.User) error {
+				require.True(t, inTx)
+				return nil
+			}
+
+			var wallets []service.Wallet
+			repo.CreateWalletFunc = func(ctx context.Context, wallet *service.Wallet) error {
+				require.True(t, inTx)
+				wallets = append(wallets, *wallet)
+				return nil
+			}
+
+			if test.mutateMock != nil {
+				test.mutateMock(repo)
+			}
+
+			registration := validRegistration
+			if test.mutateRegistration != nil {
+				test.mutateRegistration(&registration)
+			}
+
+			user, err := service.NewUsers(repo, hasher).Register(ctx, registration)
+			test.checkFunction(t, user, wallets, err)
+		})
+	}
+}
+
+func TestUsers_UpdateProfile(t *testing.T) {
+
+	ctx := context.Background()
+
+	hasher, err := service.NewBcryptHasher(bcrypt.MinCost)
+	require.NoError(t, err)
+
+	ptr := func(s string) *string { return &s }
+
+	tests := map[string]struct {
+		update        service.ProfileUpdate
+		checkFunction func(*testing.T, *service.User, error)
+	}{
+		"should update only the fields that are set": {
+			update: service.ProfileUpdate{DisplayName: ptr("Breno C")},
+			checkFunction: func(t *testing.T, user *service.User, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "breno", user.UserName)
+				require.Equal(t, "Breno C", user.DisplayName)
+				require.Equal(t, "breno@example.com", user.Email)
+			},
+		},
+		"should rename the user": {
+			update: service.ProfileUpdate{UserName: ptr("breno.c")},
+			checkFunction: func(t *testing.T, user *service.User, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "breno.c", user.UserName)
+			},
+		},
+		"should return an error when the new username is invalid": {
+			update: service.ProfileUpdate{UserName: ptr("b r e n o")},
+			checkFunction: func(t *testing.T, user *service.User, err error) {
+				require.Error(t, err)
+				require.Nil(t, user)
+			},
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+
+			repo := newUserRepositoryMock()
+			repo.FindUserByIDFunc = func(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+				return &service.User{ID: userID, UserName: "breno", DisplayName: "Breno", Email: "breno@example.com"}, nil
+			}
+
+			user, err := service.NewUsers(repo, hasher).UpdateProfile(ctx, uuid.New(), test.update)
+			test.checkFunction(t, user, err)
+		})
+	}
+}
+
+func TestUsers_ChangePassword(t *testing.T) {
+
+	ctx := context.Background()
+
+	hasher, err := service.NewBcryptHasher(bcrypt.MinCost)
+	require.NoError(t, err)
+
+	hash, err := hasher.Hash("current password")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		currentPassword string
+		newPassword     string
+		revokeErr       error
+		checkFunction   func(t *testing.T, newHash string, revoked bool, err error)
+	}{
+		"should change the password and revoke the tokens of the user": {
+			currentPassword: "current password",
+			newPassword:     "new password",
+			checkFunction: func(t *testing.T, newHash string, revoked bool, err error) {
+				require.NoError(t, err)
+				require.True(t, revoked)
+
+				matches, err := hasher.Verify(newHash, "new password")
+				require.NoError(t, err)
+				require.True(t, matches)
+			},
+		},
+		"should return an error when the current password is wrong": {
+			currentPassword: "wrong password",
+			newPassword:     "new password",
+			checkFunction: func(t *testing.T, newHash string, revoked bool, err error) {
+				require.True(t, errors.Is(err, service.ErrWrongPassword))
+				require.Empty(t, newHash)
+				require.False(t, revoked)
+			},
+		},
+		"should return an error when the new password is too short": {
+			currentPassword: "current password",
+			newPassword:     "new",
+			checkFunction: func(t *testing.T, newHash string, revoked bool, err error) {
+				require.Error(t, err)
+				require.Empty(t, newHash)
+			},
+		},
+		"should return an error, rolling back the password, when the tokens can't be revoked": {
+			currentPassword: "current password",
+			newPassword:     "new password",
+			revokeErr:       errors.New("connection lost"),
+			checkFunction: func(t *testing.T, newHash string, revoked bool, err error) {
+				require.Error(t, err)
+				require.False(t, revoked)
+			},
+		},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+
+			repo := newUserRepositoryMock()
+			repo.FindUserByIDFunc = func(ctx context.Context, userID uuid.UUID) (*service.User, error) {
+				return &service.User{ID: userID, UserName: "breno", PasswordHash: hash}, nil
+			}
+
+			// the writes are only accepted inside a transaction, whose error is returned by WithTx to roll it back
+			inTx := false
+			repo.WithTxFunc = func(ctx context.Context, f func(repository service.UserRepository) error) error {
+				inTx = true
+				defer func() { inTx = false }()
+				return f(repo)
+			}
+
+			var newHash string
+			repo.UpdateUserPasswordFunc = func(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+				require.True(t, inTx)
+				newHash = passwordHash
+				return nil
+			}
+
+			revoked := false
+			repo.RevokeUserTokensFunc = func(ctx context.Context, userID uuid.UUID, revokedAt time.Time) error {
+				require.True(t, inTx)
+				if test.revokeErr != nil {
+					return test.revokeErr
+				}
+
+				revoked = true
+				return nil
+			}
+
+			err := service.NewUsers(repo, hasher).ChangePassword(ctx, uuid.New(), test.currentPassword, test.newPassword)
+			test.checkFunction(t, newHash, revoked, err)
+		})
+	}
+}
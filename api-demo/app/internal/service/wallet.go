@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"api-demo/pkg/money"
+)
+
+// DefaultCurrency is the currency of the wallet users are signed up with, and of the existing balances, from before
+// users could hold many currencies
+const DefaultCurrency = money.BRL
+
+var (
+	// ErrWalletNotFound is returned when a user has no wallet in a currency
+	ErrWalletNotFound = NewError(KindNotFound, "wallet_not_found", "wallet not found")
+
+	// ErrWalletExists is returned when opening a wallet in a currency the user already holds
+	ErrWalletExists = NewError(KindConflict, "wallet_exists", "the user already has a wallet in the currency")
+
+	// ErrCurrencyMismatch is returned when a user of a transfer has no wallet in its currency
+	ErrCurrencyMismatch = NewError(KindConflict, "currency_mismatch",
+		"both users should have a wallet in the currency of the transfer")
+)
+
+// Wallet is the balance of a user in a currency, users hold at most one wallet per currency. The balance is a cache
+// that must always match the sum of the postings of the user in the currency.
+type Wallet struct {
+	UserID   uuid.UUID
+	Currency money.Currency
+	Balance  money.Amount
+}
+
+// Balance is the balance of a wallet, the current balance minus the active holds is what's available for transfers
+type Balance struct {
+	Currency  money.Currency
+	Current   money.Amount
+	Available money.Amount
+}
+
+// OpenWallet opens an empty wallet in the currency for the user, so they can send and receive money in it
+func (service *Account) OpenWallet(ctx context.Context, userID uuid.UUID, currency money.Currency) (*Balance, error) {
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	if err := validateCurrency(currency); err != nil {
+		return nil, err
+	}
+
+	wallet := &Wallet{UserID: userID, Currency: currency}
+	if err := service.repository.CreateWallet(ctx, wallet); err != nil {
+		return nil, err
+	}
+
+	return &Balance{Currency: currency}, nil
+}
+
+// GetBalances retrieves the current balance of every wallet of the user and what's available of it, not reserved by
+// holds, ordered by currency
+func (service *Account) GetBalances(ctx context.Context, userID uuid.UUID) ([]Balance, error) {
+	if userID == uuid.Nil {
+		return nil, ErrUserIDNotProvided
+	}
+
+	if _, err := service.repository.FindUserByIDForReport(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	wallets, err := service.repository.ListWalletsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	balances := make([]Balance, 0, len(wallets))
+	for i := range wallets {
+		available, err := availableBalance(ctx, service.repository, &wallets[i], now)
+		if err != nil {
+			return nil, err
+		}
+
+		balances = append(balances, Balance{
+			Currency:  wallets[i].Currency,
+			Current:   wallets[i].Balance,
+			Available: available,
+		})
+	}
+
+	return balances, nil
+}
+
+// lockWallets locks the users just like lockUsers does and returns their wallets in the currency, which can't change
+// while the users are locked. It fails with ErrCurrencyMismatch when any of them has no wallet in the currency.
+func lockWallets(ctx context.Context, txRepo AccountRepository, currency money.Currency,
+	userIDs ...uuid.UUID) (map[uuid.UUID]*Wallet, error) {
+
+	if _, err := lockUsers(ctx, txRepo, userIDs...); err != nil {
+		return nil, err
+	}
+
+	wallets := make(map[uuid.UUID]*Wallet, len(userIDs))
+	for _, userID := range userIDs {
+		wallet, err := txRepo.FindWallet(ctx, userID, currency)
+		if errors.Is(err, ErrWalletNotFound) {
+			return nil, ErrCurrencyMismatch
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		wallets[userID] = wallet
+	}
+
+	return wallets, nil
+}
+
+// validateCurrency guarantees that the currency is supported
+func validateCurrency(currency money.Currency) *Error {
+	if _, err := money.ParseCurrency(string(currency)); err != nil {
+		return NewFieldError("currency", "invalid_currency", "unsupported currency %q", currency)
+	}
+
+	return nil
+}
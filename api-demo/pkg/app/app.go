@@ -3,18 +3,24 @@ package app
 import (
 	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	gohttp "net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 
 	// register the pg driver
 	_ "github.com/lib/pq"
 
+	"api-demo/pkg/config"
+	"api-demo/pkg/health"
 	"api-demo/pkg/http"
 	"api-demo/pkg/log"
 )
@@ -24,8 +30,9 @@ import (
 // on a real environment, just changing the implementation of an App and a SetupResourcesProvider.
 type App interface {
 
-	// Run starts the app, starting all its subcomponents
-	Run()
+	// Run starts the app, starting all its subcomponents, and blocks until it stops, returning the exit code of the
+	// process
+	Run() int
 }
 
 // SetupResourcesProvider provides, during the Setup of the App, resources for the underlying app
@@ -34,7 +41,31 @@ type SetupResourcesProvider interface {
 	// WithHTTPAPI uses registers the given API on a HTTP server
 	WithHTTPAPI(api http.API)
 
-	WithPostgresConnection(db string) (*sql.DB, error)
+	// WithPostgresConnection connects to Postgres with the settings of the named connection profile, e.g.
+	// DefaultPostgresProfile, the connection is health-checked and closed when the app shuts down
+	WithPostgresConnection(profile string) (*sql.DB, error)
+
+	// WithMiddleware adds middlewares to the pipeline of the HTTP server of the APIs, they run after the standard ones
+	// (request ID, request logger, access log, metrics and panic recovery), in the given order
+	WithMiddleware(middlewares ...http.Middleware)
+
+	// MetricsRegisterer returns the registerer of the metrics exposed on the /metrics endpoint of the health server
+	MetricsRegisterer() prometheus.Registerer
+
+	// WithComponent registers a component started during the setup, like a background worker, to be shut down with the
+	// app. Components are shut down in the reverse order they were provided, before the resources they depend on.
+	WithComponent(name string, component Shutdowner)
+
+	// WithJob adds a job run in the background every interval while the app serves the APIs, the jobs are stopped
+	// after the servers and before the resources provided during the setup
+	WithJob(name string, interval time.Duration, job Job) error
+
+	// HealthChecks returns the registry of the checks run by the /readyz endpoint of the health server, resources
+	// provided here register their own checks
+	HealthChecks() *health.Registry
+
+	// Config returns the config of the app, the config of the service is loaded by the WithServiceConfig option
+	Config() Config
 }
 
 // Shutdowner defines something that can shutdown
@@ -42,52 +73,158 @@ type Shutdowner interface {
 	Shutdown(ctx context.Context) error
 }
 
+// ShutdownFunc is a function that is a Shutdowner
+type ShutdownFunc func(ctx context.Context) error
+
+func (f ShutdownFunc) Shutdown(ctx context.Context) error {
+	return f(ctx)
+}
+
 type SetupFunc func(context.Context, SetupResourcesProvider) error
 
+// CommandFunc runs a command instead of serving requests, e.g. "api-demo-service migrate up" runs the "migrate" command
+// with the "up" arg. The resources it uses are released once it returns.
+type CommandFunc func(ctx context.Context, resources SetupResourcesProvider, args []string) error
+
 // StandardApp is a real implementation of an App
 type StandardApp struct {
-	setupFunc  SetupFunc
-	router     *mux.Router
-	apis       []http.API
-	toShutdown []Shutdowner
+	setupFunc   SetupFunc
+	commands    map[string]CommandFunc
+	router      *mux.Router
+	apis        []http.API
+	middlewares []http.Middleware
+	lifecycle   *Lifecycle
+	jobs        *JobRunner
+	registry    *prometheus.Registry
+	httpMetrics *http.HTTPMetrics
+	health      *health.Registry
+
+	config        Config
+	serviceConfig interface{}
+	configLoader  *config.Loader
 }
 
 // New creates a Standard App ready to be configured using the setupFunc
-func New(setupFunc SetupFunc) *StandardApp {
-	return &StandardApp{setupFunc: setupFunc, router: basicRouter()}
+func New(setupFunc SetupFunc, opts ...Opt) *StandardApp {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+
+	// the registry is brand new, registering can't conflict
+	httpMetrics, _ := http.NewHTTPMetrics(registry)
+
+	app := &StandardApp{
+		setupFunc:    setupFunc,
+		commands:     map[string]CommandFunc{},
+		router:       basicRouter(),
+		registry:     registry,
+		httpMetrics:  httpMetrics,
+		lifecycle:    NewLifecycle(),
+		jobs:         NewJobRunner(),
+		health:       health.NewRegistry(),
+		config:       DefaultConfig(),
+		configLoader: config.NewLoader(config.WithEnvPrefix(EnvPrefix)),
+	}
+
+	for _, opt := range opts {
+		opt(app)
+	}
+
+	return app
 }
 
-func (app *StandardApp) Run() {
+// Opt is an option that can be passed to New to configure the app
+type Opt func(*StandardApp)
 
-	logger := log.New(
-		log.WithFormatter(log.DefaultFormatter),
-		log.WithLevel(logrus.InfoLevel),
-		log.WithOutput(os.Stderr))
+// WithServiceConfig returns an Opt that loads the config of the service into target, a pointer to a struct holding its
+// defaults, along with the config of the app. Its settings are under "service", e.g. the "-service.some_setting" flag.
+func WithServiceConfig(target interface{}) Opt {
+	return func(app *StandardApp) {
+		app.serviceConfig = target
+	}
+}
+
+// WithPostgresProfile returns an Opt that declares a Postgres connection profile, so its settings can be loaded under
+// "postgres_profiles.<name>". The settings the profile leaves unset are taken from the default profile.
+func WithPostgresProfile(name string, profile PostgresConfig) Opt {
+	return func(app *StandardApp) {
+		app.config.PostgresProfiles[name] = &profile
+	}
+}
+
+// WithCommand returns an Opt that adds a command, run when its name follows the flags
+func WithCommand(name string, command CommandFunc) Opt {
+	return func(app *StandardApp) {
+		app.commands[name] = command
+	}
+}
+
+// WithConfigLoader returns an Opt that sets the loader of the config
+func WithConfigLoader(loader *config.Loader) Opt {
+	return func(app *StandardApp) {
+		app.configLoader = loader
+	}
+}
+
+// Run loads the config, runs the setup and serves the APIs until a stop signal is received or a server fails, then
+// shuts down gracefully. When a command follows the flags it's run instead of serving the APIs.
+func (app *StandardApp) Run() int {
+
+	args, err := app.configLoader.Load(&loadedConfig{App: &app.config, Service: app.serviceConfig})
+	if err == flag.ErrHelp {
+		return 0
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	logger := app.newLogger()
+	logger.WithField("config", app.config).Info("config loaded")
 
 	// sets the logger in the context, the intention is to reuse it
 	ctx := log.ContextWithLogger(context.Background(), logger)
 
-	errChan := make(chan error, 1)
+	if len(args) > 0 {
+		return app.runCommand(ctx, args)
+	}
 
-	err := app.setupFunc(ctx, app)
+	err = app.setupFunc(ctx, app)
 	if err != nil {
 		logger.WithError(err).Error("failed to setup app")
-		os.Exit(1)
+		app.shutdown(ctx)
+		return 1
 	}
 
 	if len(app.apis) == 0 {
 		logger.Error("the app can only work having http apis for now")
-		os.Exit(1)
+		app.shutdown(ctx)
+		return 1
 	}
 
+	healthServer := app.newHealthServer()
+	apiServer := app.newAPIServer(logger)
+
+	// the components are stopped in the reverse order, so the API server drains first, then the health server, so probes
+	// can see the app isn't ready meanwhile, then the jobs and then the resources provided during the setup, like the db
+	// connections
+	app.WithComponent("jobs", app.jobs)
+	app.WithComponent("server:health", healthServer)
+	app.WithComponent("server:api", apiServer)
+
+	app.jobs.Start(ctx)
+
+	// every server can send its error without blocking, even after the other one failed
+	errChan := make(chan error, 2)
+
 	// starts the HTTP server that offers health-checking
-	go app.startHealthServer(ctx, errChan)
+	go app.startServer(ctx, "health", healthServer, errChan)
 
 	// starts the HTTP server to serve API requests
-	go app.startAPIServer(ctx, errChan)
+	go app.startServer(ctx, "api", apiServer, errChan)
 
 	// waits for a shutdown signal or an error in the current goroutine
-	app.waitForShutdown(ctx, errChan)
+	return app.waitForShutdown(ctx, errChan)
 }
 
 func (app *StandardApp) WithHTTPAPI(api http.API) {
@@ -96,82 +233,210 @@ func (app *StandardApp) WithHTTPAPI(api http.API) {
 	app.apis = append(app.apis, api)
 }
 
-func (app *StandardApp) WithPostgresConnection(db string) (*sql.DB, error) {
-	source := "sslmode=disable timezone=UTC user=postgres password=test dbname=" + db
+func (app *StandardApp) WithMiddleware(middlewares ...http.Middleware) {
+	app.middlewares = append(app.middlewares, middlewares...)
+}
 
-	conn, err := sql.Open("postgres", source)
+func (app *StandardApp) WithPostgresConnection(profile string) (*sql.DB, error) {
+	config, err := app.config.PostgresProfile(profile)
 	if err != nil {
-		return nil, fmt.Errorf("error connecting with PGUSER, PGPASSWORD to %q: %v", db, err)
+		return nil, err
 	}
 
+	conn, err := sql.Open("postgres", config.DSN(config.Database))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting as %q to %q: %v", config.User, config.Database, err)
+	}
+
+	conn.SetMaxOpenConns(config.MaxOpenConns)
+	conn.SetMaxIdleConns(config.MaxIdleConns)
+	conn.SetConnMaxLifetime(config.ConnMaxLifetime)
+
 	if err = conn.Ping(); err != nil {
-		return nil, fmt.Errorf("could not ping db: %v", err)
+		_ = conn.Close()
+		return nil, fmt.Errorf("could not ping db of profile %q: %v", profile, err)
+	}
+
+	statsCollector := newDBStatsCollector(profile, conn)
+	if err := app.registry.Register(statsCollector); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("could not register the metrics of profile %q: %v", profile, err)
+	}
+
+	if err := app.health.Register("postgres:"+profile, conn.PingContext); err != nil {
+		app.registry.Unregister(statsCollector)
+		_ = conn.Close()
+		return nil, fmt.Errorf("could not register the health check of profile %q: %v", profile, err)
+	}
+
+	err = app.lifecycle.Register("postgres:"+profile, ShutdownFunc(func(context.Context) error {
+		return conn.Close()
+	}))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
 	}
 
 	return conn, nil
 }
 
-// startHealthServer starts a Health providing server for e.g. kubernetes liveness/readiness probe
-func (app *StandardApp) startHealthServer(ctx context.Context, errChan chan error) {
+// WithComponent registers the component in the lifecycle of the app, a component provided once the shutdown started
+// is shut down right away, since nothing would do it later
+func (app *StandardApp) WithComponent(name string, component Shutdowner) {
+	if err := app.lifecycle.Register(name, component); err != nil {
+		_ = component.Shutdown(context.Background())
+	}
+}
 
-	router := basicRouter()
-	router.HandleFunc("/healthcheck", func(w gohttp.ResponseWriter, r *gohttp.Request) {
-		w.WriteHeader(gohttp.StatusOK)
-	})
+func (app *StandardApp) WithJob(name string, interval time.Duration, job Job) error {
+	return app.jobs.Add(name, interval, job)
+}
 
-	addr := ":8585"
-	httpServer := newHTTPServer(router, addr)
-	app.toShutdown = append(app.toShutdown, httpServer)
+func (app *StandardApp) Config() Config {
+	return app.config
+}
 
-	log.FromContext(ctx).
-		WithField("server", "health").
-		WithField("addr", addr).Info("starting server")
+func (app *StandardApp) MetricsRegisterer() prometheus.Registerer {
+	return app.registry
+}
 
-	errChan <- httpServer.Start(ctx)
+func (app *StandardApp) HealthChecks() *health.Registry {
+	return app.health
 }
 
-// startAPIServer starts the server to serve the registered API
-func (app *StandardApp) startAPIServer(ctx context.Context, errChan chan error) {
+// newHealthServer creates a server providing health checks, e.g. for kubernetes liveness/readiness probes, and metrics
+func (app *StandardApp) newHealthServer() *httpServer {
 
-	addr := ":8080"
-	httpServer := newHTTPServer(app.router, addr)
-	app.toShutdown = append(app.toShutdown, httpServer)
+	router := basicRouter()
+	router.Handle("/livez", app.health.LivenessHandler())
+	router.Handle("/readyz", app.health.ReadinessHandler())
+	router.Handle("/metrics", promhttp.HandlerFor(app.registry, promhttp.HandlerOpts{}))
+
+	// kept for the probes configured before /livez existed
+	router.Handle("/healthcheck", app.health.LivenessHandler())
+
+	return newHTTPServer(router, app.config.Health)
+}
+
+// newAPIServer creates a server to serve the registered APIs
+func (app *StandardApp) newAPIServer(logger logrus.FieldLogger) *httpServer {
+	return newHTTPServer(app.handler(logger), app.config.API)
+}
+
+// startServer starts a server, sending to errChan the error that made it stop
+func (app *StandardApp) startServer(ctx context.Context, name string, server *httpServer, errChan chan error) {
 
 	log.FromContext(ctx).
-		WithField("server", "api").
-		WithField("addr", addr).Info("starting server")
+		WithField("server", name).
+		WithField("addr", server.server.Addr).Info("starting server")
 
-	errChan <- httpServer.Start(ctx)
+	errChan <- server.Start(ctx)
 }
 
-// waitForShutdown blocks the current goroutine until a stop signal is received or a Server returns an error, also
-// handling the shutdown of the dependant components (http servers etc)
-func (app *StandardApp) waitForShutdown(ctx context.Context, errChan chan error) {
+// waitForShutdown blocks the current goroutine until a stop signal is received or a server returns an error, then
+// shuts down the components, returning the exit code of the process. After a stop signal the app is reported as not
+// ready and keeps serving for the configured delay, so load balancers stop sending requests before the servers drain
+// the in-flight ones. A second signal skips the delay.
+func (app *StandardApp) waitForShutdown(ctx context.Context, errChan chan error) int {
 	// set up signal handlers
-	signals := make(chan os.Signal, 1)
+	signals := make(chan os.Signal, 2)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	defer signal.Stop(signals)
 
 	logger := log.FromContext(ctx)
 
+	code := 0
 	select {
 	case err := <-errChan:
 		logger.WithError(err).Error("received error from one of the components")
-		os.Exit(1)
+		code = 1
 	case sig := <-signals:
-		logger.WithField("sig", sig).Info("signal received, stopping gracefully")
+		logger.WithField("sig", sig).WithField("delay", app.config.Shutdown.Delay.String()).
+			Info("signal received, stopping gracefully")
 	}
 
-	for _, shutdown := range app.toShutdown {
-		if err := shutdown.Shutdown(ctx); err != nil {
-			logger.WithError(err).Error("error stopping server")
+	app.health.StartShutdown()
+
+	// a failed server means the app is broken already, there's no point in waiting for the load balancers
+	if code == 0 {
+		select {
+		case <-time.After(app.config.Shutdown.Delay):
+		case sig := <-signals:
+			logger.WithField("sig", sig).Warn("signal received again, skipping the shutdown delay")
 		}
 	}
 
-	os.Exit(0)
+	if err := app.shutdown(ctx); err != nil {
+		return 1
+	}
+
+	logger.Info("app stopped")
+	return code
+}
+
+// shutdown stops the components of the lifecycle within the shutdown timeout
+func (app *StandardApp) shutdown(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, app.config.Shutdown.Timeout)
+	defer cancel()
+
+	return app.lifecycle.Shutdown(ctx)
+}
+
+// runCommand runs the command named by the first arg, returning the exit code of the process
+func (app *StandardApp) runCommand(ctx context.Context, args []string) int {
+	command, ok := app.commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		return 2
+	}
+
+	err := command(ctx, app, args[1:])
+	_ = app.shutdown(ctx)
+
+	if err != nil {
+		log.FromContext(ctx).WithError(err).WithField("command", args[0]).Error("command failed")
+		return 1
+	}
+
+	return 0
+}
+
+// newLogger creates the logger of the app according to the config, which is already validated
+func (app *StandardApp) newLogger() *logrus.Logger {
+	level, _ := logrus.ParseLevel(app.config.Log.Level)
+
+	var formatter logrus.Formatter = log.DefaultFormatter
+	if app.config.Log.Format == "text" {
+		formatter = &logrus.TextFormatter{FullTimestamp: true}
+	}
+
+	return log.New(
+		log.WithFormatter(formatter),
+		log.WithLevel(level),
+		log.WithOutput(os.Stderr))
+}
+
+// handler decorates the router of the APIs with the standard middlewares followed by the ones added during the setup
+func (app *StandardApp) handler(logger logrus.FieldLogger) gohttp.Handler {
+	return NewHandler(app.router, logger, app.httpMetrics, app.middlewares...)
+}
+
+// NewHandler decorates the router of the APIs with the standard middlewares (request ID, request logger, access log,
+// metrics and panic recovery) followed by the given ones, so every App serves the APIs the same way
+func NewHandler(router *mux.Router, logger logrus.FieldLogger, metrics *http.HTTPMetrics,
+	middlewares ...http.Middleware) gohttp.Handler {
+
+	standard := []http.Middleware{
+		http.RequestIDMiddleware(),
+		http.RequestLoggerMiddleware(logger),
+		http.AccessLogMiddleware(router),
+		metrics.Middleware(router),
+		http.RecoveryMiddleware(),
+	}
+
+	return http.Chain(router, append(standard, middlewares...)...)
 }
 
 func basicRouter() *mux.Router {
-	// a custom Router that traces requests could be added here for monitoring/instrumentation
 	return mux.NewRouter()
 }
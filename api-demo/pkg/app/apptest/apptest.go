@@ -0,0 +1,294 @@
+// Package apptest runs the Setup of an app in integration tests, serving its APIs with an httptest.Server and
+// connecting to throwaway Postgres schemas
+package apptest
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"api-demo/pkg/app"
+	"api-demo/pkg/health"
+	"api-demo/pkg/http"
+	"api-demo/pkg/log"
+)
+
+// DSNEnv is the env var with the DSN of the Postgres database where the throwaway schemas are created
+const DSNEnv = "API_DEMO_TEST_POSTGRES_DSN"
+
+// ErrNoPostgres is returned when connecting to Postgres while DSNEnv isn't set
+var ErrNoPostgres = errors.New(DSNEnv + " isn't set, there's no Postgres to connect to")
+
+// PostgresAvailable tells whether the tests can connect to Postgres, setups can fall back to in-memory repositories
+// otherwise
+func PostgresAvailable() bool {
+	return os.Getenv(DSNEnv) != ""
+}
+
+var (
+	_ app.App                    = (*TestApp)(nil)
+	_ app.SetupResourcesProvider = (*TestApp)(nil)
+)
+
+// TestApp is an App for integration tests, it runs the same Setup of the real app but serves the APIs with an
+// httptest.Server. Everything it creates is released when the test finishes.
+type TestApp struct {
+	t           *testing.T
+	setupFunc   app.SetupFunc
+	router      *mux.Router
+	middlewares []http.Middleware
+	registry    *prometheus.Registry
+	httpMetrics *http.HTTPMetrics
+	health      *health.Registry
+	config      app.Config
+	logger      *logrus.Logger
+	server      *httptest.Server
+
+	// jobs aren't run in the background, tests run them with RunJob
+	jobs map[string]app.Job
+
+	// schema is the throwaway schema shared by every Postgres connection of the app, created on the first one
+	schema      string
+	connections map[string]*sql.DB
+}
+
+// Opt is an option that can be passed to New to configure the app
+type Opt func(*TestApp)
+
+// WithConfig returns an Opt that changes the config of the app, which starts as app.DefaultConfig
+func WithConfig(configure func(config *app.Config)) Opt {
+	return func(a *TestApp) {
+		configure(&a.config)
+	}
+}
+
+// New creates a TestApp ready to be configured using the setupFunc
+func New(t *testing.T, setupFunc app.SetupFunc, opts ...Opt) *TestApp {
+	registry := prometheus.NewRegistry()
+
+	// the registry is brand new, registering can't conflict
+	httpMetrics, _ := http.NewHTTPMetrics(registry)
+
+	logger := log.New(log.WithOutput(testWriter{t}), log.WithFormatter(&logrus.TextFormatter{}),
+		log.WithLevel(logrus.DebugLevel))
+
+	a := &TestApp{
+		t:           t,
+		setupFunc:   setupFunc,
+		router:      mux.NewRouter(),
+		registry:    registry,
+		httpMetrics: httpMetrics,
+		health:      health.NewRegistry(),
+		config:      app.DefaultConfig(),
+		logger:      logger,
+		connections: map[string]*sql.DB{},
+		jobs:        map[string]app.Job{},
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// Run runs the setup and starts serving the APIs, failing the test if the setup fails. It returns right away, the app
+// is stopped when the test finishes, so the exit code is always 0.
+func (a *TestApp) Run() int {
+	a.t.Helper()
+
+	ctx := log.ContextWithLogger(context.Background(), a.logger)
+	if err := a.setupFunc(ctx, a); err != nil {
+		a.t.Fatalf("failed to setup app: %v", err)
+	}
+
+	a.server = httptest.NewServer(app.NewHandler(a.router, a.logger, a.httpMetrics, a.middlewares...))
+	a.t.Cleanup(a.server.Close)
+
+	return 0
+}
+
+// URL returns the base URL of the server of the APIs, available once the app runs
+func (a *TestApp) URL() string {
+	return a.server.URL
+}
+
+func (a *TestApp) WithHTTPAPI(api http.API) {
+	api.RegisterRoutes(a.router)
+}
+
+func (a *TestApp) WithMiddleware(middlewares ...http.Middleware) {
+	a.middlewares = append(a.middlewares, middlewares...)
+}
+
+// WithPostgresConnection connects to the throwaway schema of the app, every profile shares it. It fails with
+// ErrNoPostgres when DSNEnv isn't set.
+func (a *TestApp) WithPostgresConnection(profile string) (*sql.DB, error) {
+	if db, ok := a.connections[profile]; ok {
+		return db, nil
+	}
+
+	if !PostgresAvailable() {
+		return nil, ErrNoPostgres
+	}
+
+	dsn, err := testDSN()
+	if err != nil {
+		return nil, err
+	}
+
+	if a.schema == "" {
+		if err := a.createSchema(dsn); err != nil {
+			return nil, err
+		}
+	}
+
+	// public stays in the path for the extensions installed there, like pgcrypto
+	db, err := sql.Open("postgres", dsn+" search_path='"+a.schema+", public'")
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the schema %s: %v", a.schema, err)
+	}
+
+	// the schema is dropped after the connections are closed, cleanups run in the reverse order they were added
+	a.t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := a.health.Register("postgres:"+profile, db.PingContext); err != nil {
+		return nil, err
+	}
+
+	a.connections[profile] = db
+	return db, nil
+}
+
+// PostgresConnection returns the connection of a profile created during the setup, to prepare or check the data of a
+// test
+func (a *TestApp) PostgresConnection(profile string) *sql.DB {
+	a.t.Helper()
+
+	db, ok := a.connections[profile]
+	if !ok {
+		a.t.Fatalf("there's no connection of the postgres profile %q", profile)
+	}
+
+	return db
+}
+
+// createSchema creates the throwaway schema of the app, with a random name so tests can run concurrently
+func (a *TestApp) createSchema(dsn string) error {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	adminDB, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("error connecting to postgres: %v", err)
+	}
+
+	schema := "apptest_" + hex.EncodeToString(suffix)
+	if _, err := adminDB.Exec(`CREATE SCHEMA ` + pq.QuoteIdentifier(schema)); err != nil {
+		_ = adminDB.Close()
+		return fmt.Errorf("error creating the schema %s: %v", schema, err)
+	}
+
+	a.t.Cleanup(func() {
+		if _, err := adminDB.Exec(`DROP SCHEMA ` + pq.QuoteIdentifier(schema) + ` CASCADE`); err != nil {
+			a.t.Errorf("failed to drop the schema %s: %v", schema, err)
+		}
+
+		_ = adminDB.Close()
+	})
+
+	a.schema = schema
+	return nil
+}
+
+// WithComponent shuts the component down when the test finishes, cleanups run in the reverse order they were added so
+// it's stopped before the resources provided earlier, like the db connections
+func (a *TestApp) WithComponent(name string, component app.Shutdowner) {
+	a.t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(log.ContextWithLogger(context.Background(), a.logger),
+			a.config.Shutdown.Timeout)
+		defer cancel()
+
+		if err := component.Shutdown(ctx); err != nil {
+			a.t.Errorf("failed to stop %s: %v", name, err)
+		}
+	})
+}
+
+// WithJob keeps the job to be run by RunJob, jobs aren't run in the background so tests control when they run
+func (a *TestApp) WithJob(name string, interval time.Duration, job app.Job) error {
+	if _, ok := a.jobs[name]; ok {
+		return fmt.Errorf("there's a job named %q already", name)
+	}
+
+	a.jobs[name] = job
+	return nil
+}
+
+// RunJob runs a job added during the setup once, failing the test if it fails
+func (a *TestApp) RunJob(name string) {
+	a.t.Helper()
+
+	job, ok := a.jobs[name]
+	if !ok {
+		a.t.Fatalf("there's no job named %q", name)
+	}
+
+	if err := job(log.ContextWithLogger(context.Background(), a.logger)); err != nil {
+		a.t.Fatalf("job %s failed: %v", name, err)
+	}
+}
+
+func (a *TestApp) MetricsRegisterer() prometheus.Registerer {
+	return a.registry
+}
+
+func (a *TestApp) HealthChecks() *health.Registry {
+	return a.health
+}
+
+func (a *TestApp) Config() app.Config {
+	return a.config
+}
+
+// testDSN returns the DSN set in DSNEnv in the key=value format, so more settings can be appended to it
+func testDSN() (string, error) {
+	dsn := os.Getenv(DSNEnv)
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn, nil
+	}
+
+	parsed, err := pq.ParseURL(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %v", DSNEnv, err)
+	}
+
+	return parsed, nil
+}
+
+// testWriter writes the logs of the app to the log of the test, so they're only shown when it fails or runs verbosely
+type testWriter struct {
+	t *testing.T
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,98 @@
+package apptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	gohttp "net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// RequestOpt is an option that can be passed to TestApp.Do to change the request
+type RequestOpt func(*gohttp.Request)
+
+// WithBasicAuth returns a RequestOpt that authenticates the request with the credentials of a user
+func WithBasicAuth(userName string, password string) RequestOpt {
+	return func(r *gohttp.Request) {
+		r.SetBasicAuth(userName, password)
+	}
+}
+
+// WithBearerToken returns a RequestOpt that authenticates the request with an access token
+func WithBearerToken(token string) RequestOpt {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+// WithHeader returns a RequestOpt that sets a header of the request
+func WithHeader(key string, value string) RequestOpt {
+	return func(r *gohttp.Request) {
+		r.Header.Set(key, value)
+	}
+}
+
+// Response is a response of the app with its body already read
+type Response struct {
+	*gohttp.Response
+	t    *testing.T
+	body []byte
+}
+
+// Do sends a request to the app, failing the test if it can't be sent. The body, if not nil, is sent as JSON.
+func (a *TestApp) Do(method string, path string, body interface{}, opts ...RequestOpt) *Response {
+	a.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		require.NoError(a.t, err)
+		reader = bytes.NewReader(encoded)
+	}
+
+	request, err := gohttp.NewRequest(method, a.URL()+path, reader)
+	require.NoError(a.t, err)
+
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	for _, opt := range opts {
+		opt(request)
+	}
+
+	response, err := a.server.Client().Do(request)
+	require.NoError(a.t, err)
+	defer response.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	require.NoError(a.t, err)
+
+	return &Response{Response: response, t: a.t, body: responseBody}
+}
+
+// Body returns the body of the response
+func (r *Response) Body() []byte {
+	return r.body
+}
+
+// RequireStatus fails the test if the response doesn't have the status code, showing the body
+func (r *Response) RequireStatus(status int) *Response {
+	r.t.Helper()
+	require.Equal(r.t, status, r.StatusCode, "unexpected status, body: %s", r.body)
+	return r
+}
+
+// RequireJSON fails the test if the body isn't the expected JSON, regardless of formatting and the order of the keys
+func (r *Response) RequireJSON(expected string) *Response {
+	r.t.Helper()
+	require.JSONEq(r.t, expected, string(r.body))
+	return r
+}
+
+// Decode decodes the JSON body into v, failing the test if it can't
+func (r *Response) Decode(v interface{}) {
+	r.t.Helper()
+	require.NoError(r.t, json.Unmarshal(r.body, v), "invalid JSON body: %s", r.body)
+}
@@ -0,0 +1,281 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"api-demo/pkg/config"
+)
+
+// EnvPrefix prefixes the env vars of the config, e.g. API_DEMO_LOG_LEVEL
+const EnvPrefix = "API_DEMO_"
+
+// DefaultPostgresProfile is the name of the Postgres connection profile configured under "postgres"
+const DefaultPostgresProfile = "default"
+
+// Config is the configuration of a StandardApp
+type Config struct {
+	API      ServerConfig   `config:"api"`
+	Health   ServerConfig   `config:"health"`
+	Log      LogConfig      `config:"log"`
+	Postgres PostgresConfig `config:"postgres"`
+	Shutdown ShutdownConfig `config:"shutdown"`
+
+	// PostgresProfiles are other named Postgres connection profiles, like a read replica, their settings that aren't
+	// set are taken from the default profile. The profiles should be declared with the WithPostgresProfile option.
+	PostgresProfiles map[string]*PostgresConfig `config:"postgres_profiles"`
+}
+
+// ServerConfig configures a HTTP server
+type ServerConfig struct {
+	Addr         string        `config:"addr" help:"address the server listens on"`
+	ReadTimeout  time.Duration `config:"read_timeout" help:"maximum time to read a request"`
+	WriteTimeout time.Duration `config:"write_timeout" help:"maximum time to write a response"`
+	IdleTimeout  time.Duration `config:"idle_timeout" help:"maximum time to wait for the next request on keep-alive connections"`
+}
+
+// LogConfig configures the logger of the app
+type LogConfig struct {
+	Level  string `config:"level" help:"minimum level of the logs: debug, info, warning or error"`
+	Format string `config:"format" help:"format of the logs: json or text"`
+}
+
+// ShutdownConfig configures the graceful shutdown of the app
+type ShutdownConfig struct {
+	Delay   time.Duration `config:"delay" help:"time the app keeps serving while not ready, so load balancers stop sending requests"`
+	Timeout time.Duration `config:"timeout" help:"maximum time to drain the in-flight requests and stop the components"`
+}
+
+// PostgresConfig configures the connections to Postgres, it honours the standard PG* env vars
+type PostgresConfig struct {
+	Host     string        `config:"host" env:"PGHOST" help:"host of the Postgres server"`
+	Port     int           `config:"port" env:"PGPORT" help:"port of the Postgres server"`
+	User     string        `config:"user" env:"PGUSER" help:"user to connect as"`
+	Password config.Secret `config:"password" env:"PGPASSWORD" help:"password of the user"`
+	Database string        `config:"database" env:"PGDATABASE" help:"database used by the service"`
+	SSLMode  string        `config:"sslmode" env:"PGSSLMODE" help:"SSL mode of the connections"`
+
+	MaxOpenConns     int           `config:"max_open_conns" help:"maximum number of open connections"`
+	MaxIdleConns     int           `config:"max_idle_conns" help:"maximum number of idle connections kept in the pool"`
+	ConnMaxLifetime  time.Duration `config:"conn_max_lifetime" help:"maximum time a connection is reused"`
+	ConnectTimeout   time.Duration `config:"connect_timeout" help:"maximum time to establish a connection"`
+	StatementTimeout time.Duration `config:"statement_timeout" help:"maximum time a statement can run"`
+}
+
+// DefaultConfig returns the config used for the settings that aren't set by any source
+func DefaultConfig() Config {
+	return Config{
+		API: ServerConfig{
+			Addr:         ":8080",
+			ReadTimeout:  1 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		},
+		Health: ServerConfig{
+			Addr:         ":8585",
+			ReadTimeout:  1 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Postgres: PostgresConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "postgres",
+			Database: "postgres",
+			SSLMode:  "disable",
+
+			MaxOpenConns:     20,
+			MaxIdleConns:     5,
+			ConnMaxLifetime:  30 * time.Minute,
+			ConnectTimeout:   5 * time.Second,
+			StatementTimeout: 30 * time.Second,
+		},
+		Shutdown: ShutdownConfig{
+			Delay:   5 * time.Second,
+			Timeout: 30 * time.Second,
+		},
+		PostgresProfiles: map[string]*PostgresConfig{},
+	}
+}
+
+// validatePostgres checks the settings of the default Postgres profile that are required to connect, the password has
+// no default so it's never kept in the code
+func (c *Config) validatePostgres() error {
+	if c.Postgres.Host == "" || c.Postgres.User == "" || c.Postgres.Port == 0 || c.Postgres.Password == "" {
+		return errors.New("postgres: the host, the port, the user and the password should be set")
+	}
+
+	return nil
+}
+
+// PostgresProfile returns the Postgres connection profile with the given name, with the settings it doesn't set taken
+// from the default profile
+func (c *Config) PostgresProfile(name string) (PostgresConfig, error) {
+	if name == DefaultPostgresProfile {
+		return c.Postgres, nil
+	}
+
+	profile, ok := c.PostgresProfiles[name]
+	if !ok {
+		return PostgresConfig{}, fmt.Errorf("unknown postgres profile %q", name)
+	}
+
+	merged := c.Postgres
+	setString := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	setInt := func(dst *int, src int) {
+		if src != 0 {
+			*dst = src
+		}
+	}
+	setDuration := func(dst *time.Duration, src time.Duration) {
+		if src != 0 {
+			*dst = src
+		}
+	}
+
+	setString(&merged.Host, profile.Host)
+	setInt(&merged.Port, profile.Port)
+	setString(&merged.User, profile.User)
+	if profile.Password != "" {
+		merged.Password = profile.Password
+	}
+	setString(&merged.Database, profile.Database)
+	setString(&merged.SSLMode, profile.SSLMode)
+	setInt(&merged.MaxOpenConns, profile.MaxOpenConns)
+	setInt(&merged.MaxIdleConns, profile.MaxIdleConns)
+	setDuration(&merged.ConnMaxLifetime, profile.ConnMaxLifetime)
+	setDuration(&merged.ConnectTimeout, profile.ConnectTimeout)
+	setDuration(&merged.StatementTimeout, profile.StatementTimeout)
+
+	return merged, nil
+}
+
+func (c *ServerConfig) Validate() error {
+	if c.Addr == "" || !strings.Contains(c.Addr, ":") {
+		return fmt.Errorf("invalid addr %q, it should be in the host:port format", c.Addr)
+	}
+
+	if c.ReadTimeout <= 0 || c.WriteTimeout <= 0 || c.IdleTimeout <= 0 {
+		return errors.New("timeouts should be positive")
+	}
+
+	return nil
+}
+
+func (c *LogConfig) Validate() error {
+	if _, err := logrus.ParseLevel(c.Level); err != nil {
+		return fmt.Errorf("invalid level %q", c.Level)
+	}
+
+	if c.Format != "json" && c.Format != "text" {
+		return fmt.Errorf("invalid format %q, it should be json or text", c.Format)
+	}
+
+	return nil
+}
+
+func (c *ShutdownConfig) Validate() error {
+	if c.Delay < 0 {
+		return errors.New("the delay should not be negative")
+	}
+
+	if c.Timeout <= 0 {
+		return errors.New("the timeout should be positive")
+	}
+
+	return nil
+}
+
+// Validate checks the settings that are set, the required ones are checked by Config since profiles may leave them
+// unset to take them from the default profile
+func (c *PostgresConfig) Validate() error {
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port %d", c.Port)
+	}
+
+	if c.MaxOpenConns < 0 || c.MaxIdleConns < 0 {
+		return errors.New("the connection limits should not be negative")
+	}
+
+	if c.MaxOpenConns > 0 && c.MaxIdleConns > c.MaxOpenConns {
+		return errors.New("the idle connections should not be more than the open ones")
+	}
+
+	if c.ConnMaxLifetime < 0 || c.ConnectTimeout < 0 || c.StatementTimeout < 0 {
+		return errors.New("the timeouts should not be negative")
+	}
+
+	return nil
+}
+
+// DSN returns the data source name to connect to the database db
+func (c *PostgresConfig) DSN(db string) string {
+	params := []struct{ key, value string }{
+		{"host", c.Host},
+		{"port", fmt.Sprint(c.Port)},
+		{"user", c.User},
+		{"password", c.Password.Value()},
+		{"dbname", db},
+		{"sslmode", c.SSLMode},
+		{"timezone", "UTC"},
+		{"connect_timeout", durationParam(c.ConnectTimeout, time.Second)},
+		{"statement_timeout", durationParam(c.StatementTimeout, time.Millisecond)},
+	}
+
+	var dsn []string
+	for _, param := range params {
+		if param.value != "" {
+			dsn = append(dsn, param.key+"="+quoteDSNValue(param.value))
+		}
+	}
+
+	return strings.Join(dsn, " ")
+}
+
+// durationParam formats a duration as a DSN parameter in the given unit, rounding up so short durations aren't zero,
+// which means no timeout. It's empty for zero durations.
+func durationParam(d time.Duration, unit time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+
+	return fmt.Sprint(int64((d + unit - 1) / unit))
+}
+
+// quoteDSNValue quotes a value of a key=value DSN, escaping quotes and backslashes
+func quoteDSNValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// loadedConfig is the root of the config loaded by a StandardApp, with the config of the app at the top level and the
+// one of the service, if any, under "service"
+type loadedConfig struct {
+	App     *Config     `config:",squash"`
+	Service interface{} `config:"service"`
+}
+
+// PostgresUser is implemented by the configs of services that may run without Postgres, like when the data is kept in
+// memory. The settings to connect to Postgres are only required when UsesPostgres is true.
+type PostgresUser interface {
+	UsesPostgres() bool
+}
+
+func (c *loadedConfig) Validate() error {
+	if user, ok := c.Service.(PostgresUser); ok && !user.UsesPostgres() {
+		return nil
+	}
+
+	return c.App.validatePostgres()
+}
@@ -0,0 +1,124 @@
+package app_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"api-demo/pkg/app"
+	"api-demo/pkg/config"
+)
+
+func TestConfig_PostgresProfile(t *testing.T) {
+	config := app.DefaultConfig()
+	config.PostgresProfiles["replica"] = &app.PostgresConfig{Host: "replica", MaxOpenConns: 50}
+
+	tests := map[string]struct {
+		profile       string
+		expected      app.PostgresConfig
+		expectedError string
+	}{
+		"default profile": {
+			profile:  app.DefaultPostgresProfile,
+			expected: config.Postgres,
+		},
+		"profile inheriting the unset settings": {
+			profile: "replica",
+			expected: func() app.PostgresConfig {
+				expected := config.Postgres
+				expected.Host = "replica"
+				expected.MaxOpenConns = 50
+				return expected
+			}(),
+		},
+		"unknown profile": {
+			profile:       "unknown",
+			expectedError: `unknown postgres profile "unknown"`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			profile, err := config.PostgresProfile(tt.profile)
+			if tt.expectedError != "" {
+				require.EqualError(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, profile)
+		})
+	}
+}
+
+func TestPostgresConfig_DSN(t *testing.T) {
+	config := app.PostgresConfig{
+		Host:             "localhost",
+		Port:             5432,
+		User:             "postgres",
+		Password:         `it's`,
+		SSLMode:          "disable",
+		ConnectTimeout:   1500 * time.Millisecond,
+		StatementTimeout: 5 * time.Second,
+	}
+
+	require.Equal(t, `host='localhost' port='5432' user='postgres' password='it\'s' dbname='db' sslmode='disable' `+
+		`timezone='UTC' connect_timeout='2' statement_timeout='5000'`, config.DSN("db"))
+}
+
+// storageConfig is the config of a service that keeps its data in Postgres unless told otherwise
+type storageConfig struct {
+	Memory bool `config:"memory"`
+}
+
+func (c *storageConfig) UsesPostgres() bool {
+	return !c.Memory
+}
+
+func TestStandardApp_Run_postgresPassword(t *testing.T) {
+	tests := map[string]struct {
+		env      map[string]string
+		args     []string
+		expected int
+	}{
+		"should fail without the password": {
+			expected: 2,
+		},
+		"should load the password from the env": {
+			env:      map[string]string{"PGPASSWORD": "secret"},
+			expected: 0,
+		},
+		"should load the password from the flags": {
+			args:     []string{"-postgres.password=secret"},
+			expected: 0,
+		},
+		"should not require the password when the service doesn't use Postgres": {
+			args:     []string{"-service.memory"},
+			expected: 0,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			lookupEnv := func(key string) (string, bool) {
+				value, ok := tt.env[key]
+				return value, ok
+			}
+
+			// the command keeps the app from serving once the config is loaded
+			loader := config.NewLoader(config.WithEnvPrefix(app.EnvPrefix), config.WithLookupEnv(lookupEnv),
+				config.WithArgs(append(tt.args, "noop")))
+
+			code := app.New(nil,
+				app.WithConfigLoader(loader),
+				app.WithServiceConfig(&storageConfig{}),
+				app.WithCommand("noop", func(context.Context, app.SetupResourcesProvider, []string) error {
+					return nil
+				})).Run()
+
+			require.Equal(t, tt.expected, code)
+		})
+	}
+}
@@ -2,8 +2,8 @@ package app
 
 import (
 	"context"
+	"net"
 	"net/http"
-	"time"
 )
 
 // httpServer defines a HTTP server that is provided by the app
@@ -12,14 +12,15 @@ type httpServer struct {
 	server  *http.Server
 }
 
-// newHTTPServer creates a httpServer with a http.Handler
-func newHTTPServer(handler http.Handler, addr string) *httpServer {
+// newHTTPServer creates a httpServer with a http.Handler, listening and timing out according to the config
+func newHTTPServer(handler http.Handler, config ServerConfig) *httpServer {
 	s := &httpServer{
 		handler: handler,
 		server: &http.Server{
-			ReadTimeout:  1 * time.Second,
-			WriteTimeout: 10 * time.Second,
-			Addr:         addr,
+			ReadTimeout:  config.ReadTimeout,
+			WriteTimeout: config.WriteTimeout,
+			IdleTimeout:  config.IdleTimeout,
+			Addr:         config.Addr,
 			Handler:      handler,
 		},
 	}
@@ -27,11 +28,23 @@ func newHTTPServer(handler http.Handler, addr string) *httpServer {
 	return s
 }
 
-// Start listens to incoming requests and servers them
-func (s *httpServer) Start(context.Context) error {
+// Start listens to incoming requests and servers them, the contexts of the requests derive from ctx so they carry
+// its values, like the logger
+func (s *httpServer) Start(ctx context.Context) error {
+	s.server.BaseContext = func(net.Listener) context.Context {
+		return ctx
+	}
+
 	return s.server.ListenAndServe()
 }
 
+// Shutdown stops accepting connections and waits for the in-flight requests until ctx is done, then the connections
+// that are still active are closed
 func (s *httpServer) Shutdown(ctx context.Context) error {
-	return s.server.Shutdown(ctx)
+	if err := s.server.Shutdown(ctx); err != nil {
+		_ = s.server.Close()
+		return err
+	}
+
+	return nil
 }
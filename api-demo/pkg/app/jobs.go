@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"api-demo/pkg/log"
+)
+
+// Job is a unit of background work run periodically, it should return once there's nothing left to do or ctx is done.
+// A job that fails is run again on the next tick.
+type Job func(ctx context.Context) error
+
+// JobRunner runs jobs in the background, each one in its own goroutine right after the start and then every interval
+// of the job. The runs of a job never overlap, a run taking longer than the interval delays the next one.
+type JobRunner struct {
+	mu      sync.Mutex
+	jobs    []scheduledJob
+	started bool
+
+	stop   chan struct{}
+	done   sync.WaitGroup
+	cancel context.CancelFunc
+}
+
+// scheduledJob is a job added to a JobRunner, the name identifies it in the logs
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	job      Job
+}
+
+func NewJobRunner() *JobRunner {
+	return &JobRunner{stop: make(chan struct{})}
+}
+
+// Add adds a job run every interval, jobs should be added before the runner starts
+func (r *JobRunner) Add(name string, interval time.Duration, job Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.started {
+		return fmt.Errorf("could not add %q: the jobs are running already", name)
+	}
+
+	if interval <= 0 {
+		return fmt.Errorf("could not add %q: the interval should be positive", name)
+	}
+
+	r.jobs = append(r.jobs, scheduledJob{name: name, interval: interval, job: job})
+	return nil
+}
+
+// Start starts running the jobs with the logger of ctx, their runs are cancelled only when the shutdown times out. Only
+// the first call starts the jobs, and none does once the runner was shut down.
+func (r *JobRunner) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.started || r.stopped() {
+		return
+	}
+
+	r.started = true
+
+	ctx, r.cancel = context.WithCancel(ctx)
+	for _, job := range r.jobs {
+		r.done.Add(1)
+		go r.run(ctx, job)
+	}
+}
+
+// run runs a job until the runner stops
+func (r *JobRunner) run(ctx context.Context, job scheduledJob) {
+	defer r.done.Done()
+
+	logger := log.FromContext(ctx).WithField("job", job.name)
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if err := job.job(ctx); err != nil {
+			logger.WithError(err).Error("job failed")
+		} else {
+			logger.WithField("duration_ms", float64(time.Since(start).Microseconds())/1000).Debug("job run")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Shutdown stops running the jobs, waiting for the ongoing runs to finish. When ctx is done first their runs are
+// cancelled and it returns without waiting for them anymore.
+func (r *JobRunner) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped() {
+		return nil
+	}
+
+	close(r.stop)
+	if !r.started {
+		return nil
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		r.done.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		r.cancel()
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		return fmt.Errorf("the jobs didn't stop in time: %w", ctx.Err())
+	}
+}
+
+// stopped tells whether the runner was shut down
+func (r *JobRunner) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,82 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"api-demo/pkg/app"
+)
+
+func TestJobRunner(t *testing.T) {
+	runner := app.NewJobRunner()
+
+	var runs, failures int32
+	require.NoError(t, runner.Add("counter", time.Millisecond, func(context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}))
+
+	// a failing job keeps being run
+	require.NoError(t, runner.Add("failing", time.Millisecond, func(context.Context) error {
+		atomic.AddInt32(&failures, 1)
+		return errors.New("failed")
+	}))
+
+	runner.Start(testContext())
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&runs) > 2 && atomic.LoadInt32(&failures) > 2
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, runner.Shutdown(testContext()))
+
+	// nothing runs once it's shut down
+	stoppedAt := atomic.LoadInt32(&runs)
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, stoppedAt, atomic.LoadInt32(&runs))
+
+	require.Error(t, runner.Add("late", time.Millisecond, func(context.Context) error {
+		return nil
+	}))
+}
+
+func TestJobRunner_ShutdownDeadline(t *testing.T) {
+	runner := app.NewJobRunner()
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	require.NoError(t, runner.Add("slow", time.Hour, func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	}))
+
+	runner.Start(testContext())
+	<-started
+
+	ctx, cancel := context.WithTimeout(testContext(), 10*time.Millisecond)
+	defer cancel()
+
+	err := runner.Shutdown(ctx)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the run should be cancelled once the shutdown times out")
+	}
+}
+
+func TestJobRunner_InvalidInterval(t *testing.T) {
+	runner := app.NewJobRunner()
+
+	require.Error(t, runner.Add("never", 0, func(context.Context) error {
+		return nil
+	}))
+}
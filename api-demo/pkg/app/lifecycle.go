@@ -0,0 +1,84 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"api-demo/pkg/log"
+)
+
+// ErrShuttingDown is returned when registering a component once the shutdown started, the caller should stop it
+var ErrShuttingDown = errors.New("the app is shutting down")
+
+// Lifecycle keeps the components of an app, like servers, db pools and workers, and shuts them down in the reverse
+// order they were registered, so every component stops before the ones it depends on. Components can be registered
+// concurrently.
+type Lifecycle struct {
+	mu           sync.Mutex
+	components   []namedComponent
+	shuttingDown bool
+}
+
+// namedComponent is a component registered in a Lifecycle, the name identifies it in the logs
+type namedComponent struct {
+	name      string
+	component Shutdowner
+}
+
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+// Register adds a component to be shut down, it fails with ErrShuttingDown once the shutdown started
+func (l *Lifecycle) Register(name string, component Shutdowner) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.shuttingDown {
+		return fmt.Errorf("could not register %q: %w", name, ErrShuttingDown)
+	}
+
+	l.components = append(l.components, namedComponent{name: name, component: component})
+	return nil
+}
+
+// Shutdown stops the components in the reverse order they were registered, sharing the deadline of ctx. Every
+// component is stopped even if the previous ones fail or the deadline is exceeded, the errors are returned together.
+// Only the first call stops the components, the next ones return nil.
+func (l *Lifecycle) Shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	if l.shuttingDown {
+		l.mu.Unlock()
+		return nil
+	}
+
+	l.shuttingDown = true
+	components := l.components
+	l.mu.Unlock()
+
+	logger := log.FromContext(ctx)
+
+	var failures []string
+	for i := len(components) - 1; i >= 0; i-- {
+		start := time.Now()
+		logger := logger.WithField("component", components[i].name)
+
+		if err := components[i].component.Shutdown(ctx); err != nil {
+			logger.WithError(err).Error("error stopping component")
+			failures = append(failures, fmt.Sprintf("%s: %v", components[i].name, err))
+			continue
+		}
+
+		logger.WithField("duration_ms", float64(time.Since(start).Microseconds())/1000).Debug("component stopped")
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("error stopping %d components: %s", len(failures), strings.Join(failures, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"api-demo/pkg/app"
+	"api-demo/pkg/log"
+)
+
+func TestLifecycle_Shutdown(t *testing.T) {
+	tests := map[string]struct {
+		failing       map[string]bool
+		expectedError string
+	}{
+		"every component stops": {},
+		"failing components don't stop the next ones": {
+			failing:       map[string]bool{"worker": true},
+			expectedError: "error stopping 1 components: worker: failed",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			lifecycle := app.NewLifecycle()
+
+			var stopped []string
+			for _, name := range []string{"db", "worker", "server"} {
+				name := name
+				require.NoError(t, lifecycle.Register(name, app.ShutdownFunc(func(context.Context) error {
+					stopped = append(stopped, name)
+					if tt.failing[name] {
+						return errors.New("failed")
+					}
+
+					return nil
+				})))
+			}
+
+			err := lifecycle.Shutdown(testContext())
+			if tt.expectedError != "" {
+				require.EqualError(t, err, tt.expectedError)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, []string{"server", "worker", "db"}, stopped)
+
+			// the components are stopped only once
+			require.NoError(t, lifecycle.Shutdown(testContext()))
+			require.Len(t, stopped, 3)
+		})
+	}
+}
+
+func TestLifecycle_ShutdownDeadline(t *testing.T) {
+	lifecycle := app.NewLifecycle()
+
+	var dbStopped bool
+	require.NoError(t, lifecycle.Register("db", app.ShutdownFunc(func(context.Context) error {
+		dbStopped = true
+		return nil
+	})))
+
+	require.NoError(t, lifecycle.Register("server", app.ShutdownFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})))
+
+	ctx, cancel := context.WithTimeout(testContext(), 10*time.Millisecond)
+	defer cancel()
+
+	err := lifecycle.Shutdown(ctx)
+	require.EqualError(t, err, "error stopping 1 components: server: context deadline exceeded")
+	require.True(t, dbStopped, "the components after the one exceeding the deadline should be stopped")
+}
+
+func TestLifecycle_Register(t *testing.T) {
+	lifecycle := app.NewLifecycle()
+
+	var mu sync.Mutex
+	stopped := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			require.NoError(t, lifecycle.Register(fmt.Sprint("worker", i), app.ShutdownFunc(func(context.Context) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				stopped++
+				return nil
+			})))
+		}(i)
+	}
+
+	wg.Wait()
+	require.NoError(t, lifecycle.Shutdown(testContext()))
+	require.Equal(t, 20, stopped)
+
+	err := lifecycle.Register("late", app.ShutdownFunc(func(context.Context) error {
+		return nil
+	}))
+	require.True(t, errors.Is(err, app.ErrShuttingDown))
+}
+
+// testContext returns a context with a logger that discards the logs
+func testContext() context.Context {
+	return log.ContextWithLogger(context.Background(), log.New(log.WithOutput(ioutil.Discard)))
+}
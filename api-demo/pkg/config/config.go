@@ -0,0 +1,442 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileFlag is the flag that sets the path of the config file, it can also be set with the FileEnv var
+const FileFlag = "config"
+
+// FileEnv is the env var that sets the path of the config file
+const FileEnv = "CONFIG_FILE"
+
+// Validator is implemented by config structs that check their own values once they're loaded
+type Validator interface {
+	Validate() error
+}
+
+// Secret is a config value that's redacted when printed or encoded, so configs can be logged safely
+type Secret string
+
+const redacted = "[REDACTED]"
+
+// Value returns the actual value of the secret
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return redacted
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(s.String())), nil
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Loader loads a config struct from, by ascending precedence, the values it already has (the defaults), a YAML or JSON
+// file, env vars and command line flags.
+//
+// The fields of the struct are named by their `config` tag, nested structs having dotted names like "api.addr". The
+// env var of a field is its name in upper snake case with the env prefix, like "APP_API_ADDR", unless the `env` tag
+// names it, and its flag is its name, like "-api.addr". The `help` tag describes the field in the usage of the flags.
+type Loader struct {
+	envPrefix string
+	lookupEnv func(string) (string, bool)
+	args      []string
+}
+
+// Opt is an option that can be passed to NewLoader to configure the loader
+type Opt func(*Loader)
+
+// WithEnvPrefix returns an Opt that sets the prefix of the env vars
+func WithEnvPrefix(prefix string) Opt {
+	return func(l *Loader) {
+		l.envPrefix = prefix
+	}
+}
+
+// WithLookupEnv returns an Opt that sets the function used to read env vars, os.LookupEnv by default
+func WithLookupEnv(lookupEnv func(string) (string, bool)) Opt {
+	return func(l *Loader) {
+		l.lookupEnv = lookupEnv
+	}
+}
+
+// WithArgs returns an Opt that sets the command line arguments to parse, os.Args[1:] by default
+func WithArgs(args []string) Opt {
+	return func(l *Loader) {
+		l.args = args
+	}
+}
+
+func NewLoader(opts ...Opt) *Loader {
+	l := &Loader{lookupEnv: os.LookupEnv, args: os.Args[1:]}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// field is a settable field of a config struct
+type field struct {
+	name  string
+	env   string
+	help  string
+	value reflect.Value
+}
+
+// Load fills target, a pointer to a struct, from all the sources and validates it. It returns the command line
+// arguments left after the flags.
+func (l *Loader) Load(target interface{}) ([]string, error) {
+	root := reflect.ValueOf(target)
+	if root.Kind() != reflect.Ptr || root.Elem().Kind() != reflect.Struct {
+		return nil, errors.New("the config target should be a pointer to a struct")
+	}
+
+	fields, err := l.fields(root.Elem(), "", true)
+	if err != nil {
+		return nil, err
+	}
+
+	flags, flagValues := l.flagSet(fields)
+	if err := flags.Parse(l.args); err != nil {
+		return nil, err
+	}
+
+	file := flagValues[FileFlag]
+	if file == "" {
+		file, _ = l.lookupEnv(FileEnv)
+	}
+
+	values := map[string]string{}
+	if file != "" {
+		if err := readFile(file, values); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := l.lookupEnv(f.env); ok {
+			values[f.name] = value
+		}
+
+		if value, ok := flagValues[f.name]; ok {
+			values[f.name] = value
+		}
+	}
+
+	var errs []string
+	known := map[string]bool{}
+	for _, f := range fields {
+		known[f.name] = true
+
+		value, ok := values[f.name]
+		if !ok {
+			continue
+		}
+
+		if err := setValue(f.value, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", f.name, err))
+		}
+	}
+
+	for name := range values {
+		if !known[name] {
+			errs = append(errs, fmt.Sprintf("%s: unknown setting", name))
+		}
+	}
+
+	if len(errs) == 0 {
+		errs = validate(root, "")
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("invalid config: %s", strings.Join(errs, "; "))
+	}
+
+	return flags.Args(), nil
+}
+
+// fields lists the settable fields of a struct, recursively. The env tags are ignored in the entries of maps, since
+// they'd be shared by all the entries.
+func (l *Loader) fields(v reflect.Value, prefix string, envTags bool) ([]field, error) {
+	var fields []field
+
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		tag := structField.Tag.Get("config")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		if isNil(v.Field(i)) {
+			continue
+		}
+
+		if nested, ok := nestedStruct(v.Field(i)); ok {
+			nestedFields, err := l.fields(nested, nestedPrefix(prefix, tag), envTags)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, nestedFields...)
+			continue
+		}
+
+		if entries, ok := mapEntries(v.Field(i)); ok {
+			for _, key := range sortedKeys(entries) {
+				entryFields, err := l.fields(entries[key], prefix+tag+"."+key+".", false)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, entryFields...)
+			}
+			continue
+		}
+
+		name := prefix + tag
+		value := v.Field(i)
+
+		if !isScalar(value) {
+			return nil, fmt.Errorf("%s: unsupported config type %s", name, value.Type())
+		}
+
+		env := ""
+		if envTags {
+			env = structField.Tag.Get("env")
+		}
+
+		if env == "" {
+			env = l.envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+		}
+
+		fields = append(fields, field{name: name, env: env, help: structField.Tag.Get("help"), value: value})
+	}
+
+	return fields, nil
+}
+
+// nestedStruct returns the struct held by a field, either directly or through a non-nil pointer or interface
+func nestedStruct(v reflect.Value) (reflect.Value, bool) {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	return v, v.Kind() == reflect.Struct && v.CanAddr()
+}
+
+// isNil tells whether the field is a nil pointer, interface or map, like an optional nested config that isn't set
+func isNil(v reflect.Value) bool {
+	return (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface || v.Kind() == reflect.Map) && v.IsNil()
+}
+
+// mapEntries returns the structs held by a map with string keys and pointers to structs as values. Only the entries
+// already in the map are loaded, so the entries that can be configured are the ones having defaults.
+func mapEntries(v reflect.Value) (map[string]reflect.Value, bool) {
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String ||
+		v.Type().Elem().Kind() != reflect.Ptr || v.Type().Elem().Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	entries := map[string]reflect.Value{}
+	iter := v.MapRange()
+	for iter.Next() {
+		if !iter.Value().IsNil() {
+			entries[iter.Key().String()] = iter.Value().Elem()
+		}
+	}
+
+	return entries, true
+}
+
+func sortedKeys(entries map[string]reflect.Value) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// nestedPrefix returns the prefix of the fields of a nested struct, the ",squash" tag keeps them at the same level
+func nestedPrefix(prefix string, tag string) string {
+	if tag == squashTag {
+		return prefix
+	}
+
+	return prefix + tag + "."
+}
+
+// squashTag is the tag of nested structs whose fields are at the same level of the fields of the parent
+const squashTag = ",squash"
+
+// flagSet creates the flags of the fields, the values of the flags that are set are put in the returned map once the
+// flag set is parsed
+func (l *Loader) flagSet(fields []field) (*flag.FlagSet, map[string]string) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	values := map[string]string{}
+
+	flags.Var(&flagValue{name: FileFlag, values: values}, FileFlag,
+		"path of a YAML or JSON config file, also set by $"+FileEnv)
+
+	for _, f := range fields {
+		usage := fmt.Sprintf("%s (env $%s, default %q)", f.help, f.env, fmt.Sprint(f.value.Interface()))
+		isBool := f.value.Kind() == reflect.Bool
+		flags.Var(&flagValue{name: f.name, values: values, isBool: isBool}, f.name, strings.TrimSpace(usage))
+	}
+
+	return flags, values
+}
+
+// flagValue is a flag.Value that keeps the value of the flag, if it's set, in values
+type flagValue struct {
+	name   string
+	values map[string]string
+
+	// isBool allows boolean flags to be set without a value, like "-enabled"
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	if f.values == nil {
+		return ""
+	}
+
+	return f.values[f.name]
+}
+
+func (f *flagValue) Set(value string) error {
+	f.values[f.name] = value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// readFile reads a YAML or JSON (which is also YAML) file, flattening its values by their dotted names
+func readFile(path string, values map[string]string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return fmt.Errorf("failed to parse config file %q: %v", path, err)
+	}
+
+	flatten(tree, "", values)
+	return nil
+}
+
+func flatten(tree map[string]interface{}, prefix string, values map[string]string) {
+	for key, value := range tree {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flatten(nested, prefix+key+".", values)
+			continue
+		}
+
+		values[prefix+key] = fmt.Sprint(value)
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// isScalar tells whether the value can be set from a single string
+func isScalar(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// setValue parses s according to the type of v
+func setValue(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(i)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(f)
+	}
+
+	return nil
+}
+
+// validate calls Validate on the structs that implement Validator, nested ones first
+func validate(v reflect.Value, prefix string) []string {
+	var errs []string
+
+	elem := v.Elem()
+	for i := 0; i < elem.NumField(); i++ {
+		tag := elem.Type().Field(i).Tag.Get("config")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		if nested, ok := nestedStruct(elem.Field(i)); ok {
+			errs = append(errs, validate(nested.Addr(), nestedPrefix(prefix, tag))...)
+		}
+
+		if entries, ok := mapEntries(elem.Field(i)); ok {
+			for _, key := range sortedKeys(entries) {
+				errs = append(errs, validate(entries[key].Addr(), prefix+tag+"."+key+".")...)
+			}
+		}
+	}
+
+	if validator, ok := v.Interface().(Validator); ok {
+		if err := validator.Validate(); err != nil && prefix == "" {
+			errs = append(errs, err.Error())
+		} else if err != nil {
+			errs = append(errs, strings.TrimSuffix(prefix, ".")+": "+err.Error())
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,179 @@
+package config_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"api-demo/pkg/config"
+)
+
+type serverConfig struct {
+	Addr    string        `config:"addr"`
+	Timeout time.Duration `config:"timeout"`
+}
+
+func (c *serverConfig) Validate() error {
+	if c.Timeout <= 0 {
+		return errors.New("the timeout should be positive")
+	}
+
+	return nil
+}
+
+type testConfig struct {
+	Server   serverConfig             `config:"server"`
+	Debug    bool                     `config:"debug"`
+	Ratio    float64                  `config:"ratio"`
+	Password config.Secret            `config:"password" env:"TEST_PASSWORD"`
+	Profiles map[string]*serverConfig `config:"profiles"`
+	Ignored  string
+}
+
+func defaultTestConfig() testConfig {
+	return testConfig{
+		Server:   serverConfig{Addr: ":8080", Timeout: time.Second},
+		Ratio:    0.5,
+		Profiles: map[string]*serverConfig{"replica": {Addr: ":8081", Timeout: time.Second}},
+	}
+}
+
+func TestLoader_Load(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	yamlFile := writeFile("config.yaml", "server:\n  addr: ':9090'\n  timeout: 5s\nratio: 0.75\n")
+	jsonFile := writeFile("config.json", `{"server": {"addr": ":7070"}, "debug": true}`)
+	unknownFile := writeFile("unknown.yaml", "server:\n  port: 80\n")
+
+	tests := map[string]struct {
+		env            map[string]string
+		args           []string
+		expectedConfig testConfig
+		expectedArgs   []string
+		expectedErr    string
+	}{
+		"should keep the defaults when nothing is set": {
+			expectedConfig: defaultTestConfig(),
+		},
+		"should load a YAML file set by flag": {
+			args: []string{"-config", yamlFile},
+			expectedConfig: testConfig{
+				Profiles: defaultTestConfig().Profiles,
+				Server:   serverConfig{Addr: ":9090", Timeout: 5 * time.Second},
+				Ratio:    0.75,
+			},
+		},
+		"should load a JSON file set by env var": {
+			env: map[string]string{config.FileEnv: jsonFile},
+			expectedConfig: testConfig{
+				Profiles: defaultTestConfig().Profiles,
+				Server:   serverConfig{Addr: ":7070", Timeout: time.Second},
+				Debug:    true,
+				Ratio:    0.5,
+			},
+		},
+		"should prefer env vars over the file and flags over env vars": {
+			env: map[string]string{
+				"APP_SERVER_ADDR":    ":6060",
+				"APP_SERVER_TIMEOUT": "3s",
+				"TEST_PASSWORD":      "secret",
+			},
+			args: []string{"-config", yamlFile, "-server.addr=:5050", "-debug", "migrate", "up"},
+			expectedConfig: testConfig{
+				Profiles: defaultTestConfig().Profiles,
+				Server:   serverConfig{Addr: ":5050", Timeout: 3 * time.Second},
+				Debug:    true,
+				Ratio:    0.75,
+				Password: "secret",
+			},
+			expectedArgs: []string{"migrate", "up"},
+		},
+		"should load the entries of maps declared by the defaults": {
+			env:  map[string]string{"APP_PROFILES_REPLICA_ADDR": ":6061"},
+			args: []string{"-profiles.replica.timeout=2s"},
+			expectedConfig: func() testConfig {
+				cfg := defaultTestConfig()
+				cfg.Profiles["replica"] = &serverConfig{Addr: ":6061", Timeout: 2 * time.Second}
+				return cfg
+			}(),
+		},
+		"should validate the entries of maps": {
+			args:        []string{"-profiles.replica.timeout=0s"},
+			expectedErr: "invalid config: profiles.replica: the timeout should be positive",
+		},
+		"should report every invalid value": {
+			env:         map[string]string{"APP_RATIO": "half", "APP_SERVER_TIMEOUT": "soon"},
+			expectedErr: `invalid config: ratio: invalid number "half"; server.timeout: invalid duration "soon"`,
+		},
+		"should report unknown settings of the file": {
+			args:        []string{"-config", unknownFile},
+			expectedErr: "invalid config: server.port: unknown setting",
+		},
+		"should validate the loaded config": {
+			args:        []string{"-server.timeout", "0s"},
+			expectedErr: "invalid config: server: the timeout should be positive",
+		},
+		"should fail on unknown flags": {
+			args:        []string{"-nope"},
+			expectedErr: "flag provided but not defined: -nope",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			lookupEnv := func(key string) (string, bool) {
+				value, ok := test.env[key]
+				return value, ok
+			}
+
+			loader := config.NewLoader(config.WithEnvPrefix("APP_"), config.WithLookupEnv(lookupEnv),
+				config.WithArgs(test.args))
+
+			cfg := defaultTestConfig()
+			args, err := loader.Load(&cfg)
+
+			if test.expectedErr != "" {
+				require.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectedConfig, cfg)
+			if len(args) == 0 {
+				args = nil
+			}
+			require.Equal(t, test.expectedArgs, args)
+		})
+	}
+}
+
+func TestSecret(t *testing.T) {
+
+	cfg := struct {
+		User     string        `json:"user"`
+		Password config.Secret `json:"password"`
+	}{"postgres", "hunter2"}
+
+	encoded, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"user": "postgres", "password": "[REDACTED]"}`, string(encoded))
+
+	require.Equal(t, "{postgres [REDACTED]}", fmt.Sprint(cfg))
+	require.Equal(t, "hunter2", cfg.Password.Value())
+}
@@ -0,0 +1,202 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout is for how long a check can run when no timeout is configured for it
+const DefaultTimeout = 2 * time.Second
+
+const (
+	// StatusPass means the check, or all of them, succeeded
+	StatusPass = "pass"
+
+	// StatusFail means the check, or some of them, failed
+	StatusFail = "fail"
+)
+
+// Check probes a dependency, failing if it isn't able to serve requests
+type Check func(ctx context.Context) error
+
+// CheckOpt is an option that can be passed to Registry.Register to configure a check
+type CheckOpt func(*registeredCheck)
+
+// WithTimeout returns a CheckOpt that sets for how long the check can run before it's considered failed
+func WithTimeout(timeout time.Duration) CheckOpt {
+	return func(check *registeredCheck) {
+		check.timeout = timeout
+	}
+}
+
+// registeredCheck is a Check registered with a name
+type registeredCheck struct {
+	name    string
+	check   Check
+	timeout time.Duration
+}
+
+// Report is the detailed result of running the checks of a Registry
+type Report struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// CheckResult is the result of running a single check
+type CheckResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Registry keeps the checks that tell whether the app is ready to serve requests
+type Registry struct {
+	mu     sync.RWMutex
+	checks []*registeredCheck
+
+	// shuttingDown is set once the graceful shutdown starts, making the app not ready regardless of the checks
+	shuttingDown int32
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a check with a unique name to the registry
+func (r *Registry) Register(name string, check Check, opts ...CheckOpt) error {
+	registered := &registeredCheck{name: name, check: check, timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(registered)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.checks {
+		if existing.name == name {
+			return fmt.Errorf("a health check named %q is already registered", name)
+		}
+	}
+
+	r.checks = append(r.checks, registered)
+	return nil
+}
+
+// StartShutdown makes the app not ready from now on, so it stops receiving traffic while it shuts down
+func (r *Registry) StartShutdown() {
+	atomic.StoreInt32(&r.shuttingDown, 1)
+}
+
+// ShuttingDown tells whether StartShutdown was called
+func (r *Registry) ShuttingDown() bool {
+	return atomic.LoadInt32(&r.shuttingDown) == 1
+}
+
+// Check runs all the checks concurrently, each one limited by its timeout
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := make([]*registeredCheck, len(r.checks))
+	copy(checks, r.checks)
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusPass, Checks: make([]CheckResult, len(checks))}
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check *registeredCheck) {
+			defer wg.Done()
+			report.Checks[i] = runCheck(ctx, check)
+		}(i, check)
+	}
+
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusPass {
+			report.Status = StatusFail
+		}
+	}
+
+	if r.ShuttingDown() {
+		report.Status = StatusFail
+		report.Checks = append(report.Checks, CheckResult{Name: "shutdown", Status: StatusFail,
+			Error: "the app is shutting down"})
+	}
+
+	return report
+}
+
+// runCheck runs a check within its timeout, the check is considered failed once the timeout expires even if it
+// doesn't honour the context
+func runCheck(ctx context.Context, check *registeredCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, check.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- check.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errChan:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", check.timeout)
+	}
+
+	result := CheckResult{
+		Name:       check.name,
+		Status:     StatusPass,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// LivenessHandler returns a http.Handler that answers whether the process is alive, it doesn't run any check since
+// failing dependencies aren't fixed by restarting the app
+func (r *Registry) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, Report{Status: StatusPass, Checks: []CheckResult{}})
+	})
+}
+
+// ReadinessHandler returns a http.Handler that runs the checks, answering with the detailed Report and a
+// 503 Service Unavailable when the app isn't ready
+func (r *Registry) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Check(req.Context()))
+	})
+}
+
+// writeReport writes the report with a status code according to its status
+func writeReport(w http.ResponseWriter, report Report) {
+	status := http.StatusOK
+	if report.Status != StatusPass {
+		status = http.StatusServiceUnavailable
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(body, '\n'))
+}
@@ -0,0 +1,108 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"api-demo/pkg/health"
+)
+
+func TestRegistry_ReadinessHandler(t *testing.T) {
+
+	passing := func(context.Context) error { return nil }
+	failing := func(context.Context) error { return errors.New("connection refused") }
+	hanging := func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		return ctx.Err()
+	}
+
+	tests := map[string]struct {
+		setup          func(t *testing.T, registry *health.Registry)
+		expectedStatus int
+		expectedReport health.Report
+	}{
+		"should be ready when every check passes": {
+			setup: func(t *testing.T, registry *health.Registry) {
+				require.NoError(t, registry.Register("postgres:postgres", passing))
+				require.NoError(t, registry.Register("cache", passing))
+			},
+			expectedStatus: http.StatusOK,
+			expectedReport: health.Report{Status: health.StatusPass, Checks: []health.CheckResult{
+				{Name: "postgres:postgres", Status: health.StatusPass},
+				{Name: "cache", Status: health.StatusPass},
+			}},
+		},
+		"should not be ready when a check fails": {
+			setup: func(t *testing.T, registry *health.Registry) {
+				require.NoError(t, registry.Register("postgres:postgres", failing))
+				require.NoError(t, registry.Register("cache", passing))
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedReport: health.Report{Status: health.StatusFail, Checks: []health.CheckResult{
+				{Name: "postgres:postgres", Status: health.StatusFail, Error: "connection refused"},
+				{Name: "cache", Status: health.StatusPass},
+			}},
+		},
+		"should fail checks that time out": {
+			setup: func(t *testing.T, registry *health.Registry) {
+				require.NoError(t, registry.Register("slow", hanging, health.WithTimeout(10*time.Millisecond)))
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedReport: health.Report{Status: health.StatusFail, Checks: []health.CheckResult{
+				{Name: "slow", Status: health.StatusFail, Error: "timed out after 10ms"},
+			}},
+		},
+		"should not be ready once the shutdown starts": {
+			setup: func(t *testing.T, registry *health.Registry) {
+				require.NoError(t, registry.Register("postgres:postgres", passing))
+				registry.StartShutdown()
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedReport: health.Report{Status: health.StatusFail, Checks: []health.CheckResult{
+				{Name: "postgres:postgres", Status: health.StatusPass},
+				{Name: "shutdown", Status: health.StatusFail, Error: "the app is shutting down"},
+			}},
+		},
+		"should reject checks with the same name": {
+			setup: func(t *testing.T, registry *health.Registry) {
+				require.NoError(t, registry.Register("postgres:postgres", passing))
+				require.Error(t, registry.Register("postgres:postgres", failing))
+			},
+			expectedStatus: http.StatusOK,
+			expectedReport: health.Report{Status: health.StatusPass, Checks: []health.CheckResult{
+				{Name: "postgres:postgres", Status: health.StatusPass},
+			}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			registry := health.NewRegistry()
+			test.setup(t, registry)
+
+			w := httptest.NewRecorder()
+			registry.ReadinessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			require.Equal(t, test.expectedStatus, w.Code)
+
+			var report health.Report
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+			for i := range report.Checks {
+				report.Checks[i].DurationMs = 0
+			}
+			require.Equal(t, test.expectedReport, report)
+
+			w = httptest.NewRecorder()
+			registry.LivenessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+			require.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+}
@@ -2,32 +2,33 @@ package http
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
-)
 
-func WriteJSON(w http.ResponseWriter, payload interface{}) {
-	encoder := json.NewEncoder(w)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	"api-demo/pkg/log"
+)
 
-	if err := encoder.Encode(payload); err != nil {
-		panic(err)
+// WriteJSON writes the payload encoded as JSON with the 200 status code. The payload is encoded before anything is
+// written, when it can't be encoded an internal error problem is written instead.
+func WriteJSON(w http.ResponseWriter, r *http.Request, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		WriteError(w, r, fmt.Errorf("failed to encode response: %w", err))
+		return
 	}
+
+	WriteRawJSON(w, r, http.StatusOK, append(body, '\n'))
 }
 
-func WriteError(w http.ResponseWriter, err error, code int) {
-	encoder := json.NewEncoder(w)
+// WriteRawJSON writes an already encoded JSON body with the given status code. Failing to write it is only logged, the
+// status code is sent already and the client is likely gone.
+func WriteRawJSON(w http.ResponseWriter, r *http.Request, status int, body []byte) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-
-	payload := struct {
-		Error string `json:"error"`
-	}{
-		err.Error(),
-	}
+	w.WriteHeader(status)
 
-	// TODO: the error should be typed to figure if it was a server error or a user error
-	if err := encoder.Encode(payload); err != nil {
-		panic(err)
+	if _, err := w.Write(body); err != nil {
+		log.FromContext(r.Context()).
+			WithError(err).
+			WithField("request_id", RequestID(r)).Warn("failed to write response")
 	}
 }
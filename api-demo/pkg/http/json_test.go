@@ -0,0 +1,83 @@
+package http_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	customhttp "api-demo/pkg/http"
+	"api-demo/pkg/log"
+)
+
+// failingWriter is a http.ResponseWriter whose client is gone
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestWriteJSON(t *testing.T) {
+
+	tests := map[string]struct {
+		payload             interface{}
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        string
+		expectedLog         bool
+	}{
+		"should write the payload": {
+			payload:             map[string]int{"balance": 10},
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/json",
+			expectedBody:        `{"balance":10}` + "\n",
+		},
+		"should write an internal error when the payload can't be encoded": {
+			payload:             map[string]interface{}{"balance": make(chan int)},
+			expectedStatus:      http.StatusInternalServerError,
+			expectedContentType: customhttp.ProblemContentType,
+			expectedLog:         true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			logs := &bytes.Buffer{}
+			logger := log.New(log.WithOutput(logs), log.WithLevel(logrus.InfoLevel))
+
+			r := httptest.NewRequest(http.MethodGet, "/me", nil)
+			r = r.WithContext(log.ContextWithLogger(r.Context(), logger))
+			w := httptest.NewRecorder()
+
+			require.NotPanics(t, func() { customhttp.WriteJSON(w, r, test.payload) })
+
+			require.Equal(t, test.expectedStatus, w.Code)
+			require.Equal(t, test.expectedContentType, w.Header().Get("Content-Type"))
+			if test.expectedBody != "" {
+				require.Equal(t, test.expectedBody, w.Body.String())
+			}
+
+			require.Equal(t, test.expectedLog, logs.Len() > 0)
+		})
+	}
+}
+
+func TestWriteRawJSON_ClientGone(t *testing.T) {
+
+	logs := &bytes.Buffer{}
+	logger := log.New(log.WithOutput(logs), log.WithLevel(logrus.InfoLevel))
+
+	r := httptest.NewRequest(http.MethodPost, "/me/transactions", nil)
+	r = r.WithContext(log.ContextWithLogger(r.Context(), logger))
+	w := failingWriter{httptest.NewRecorder()}
+
+	require.NotPanics(t, func() { customhttp.WriteRawJSON(w, r, http.StatusCreated, []byte(`{}`)) })
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Contains(t, logs.String(), "broken pipe")
+}
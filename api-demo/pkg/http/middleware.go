@@ -0,0 +1,172 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"api-demo/pkg/log"
+)
+
+// Middleware decorates a http.Handler, doing something before and/or after it serves a request
+type Middleware func(next http.Handler) http.Handler
+
+// Chain decorates the handler with the middlewares, the first one being the outermost
+func Chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// RequestIDMiddleware returns a Middleware that puts the ID of the request in its context and in the response headers,
+// reusing the ID sent by the client when there's a valid one
+func RequestIDMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := RequestID(r)
+
+			w.Header().Set(RequestIDHeader, requestID)
+			next.ServeHTTP(w, r.WithContext(ContextWithRequestID(r.Context(), requestID)))
+		})
+	}
+}
+
+// RequestLoggerMiddleware returns a Middleware that puts in the context of the request a logger derived from the given
+// one, with the ID of the request, so everything logged while serving the request can be correlated
+func RequestLoggerMiddleware(logger logrus.FieldLogger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestLogger := logger.WithField("request_id", RequestID(r))
+			next.ServeHTTP(w, r.WithContext(log.ContextWithLogger(r.Context(), requestLogger)))
+		})
+	}
+}
+
+// AccessLogMiddleware returns a Middleware that logs every request served, with the logger of the request context.
+// The route template matched by the router is logged instead of the path, so requests of a route can be grouped.
+func AccessLogMiddleware(router *mux.Router) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := NewResponseRecorder(w)
+
+			next.ServeHTTP(recorder, r)
+
+			log.FromContext(r.Context()).
+				WithField("method", r.Method).
+				WithField("route", RouteTemplate(router, r)).
+				WithField("status", recorder.Status()).
+				WithField("latency_ms", float64(time.Since(start).Microseconds())/1000).
+				WithField("bytes", recorder.Bytes()).Info("request served")
+		})
+	}
+}
+
+// RecoveryMiddleware returns a Middleware that recovers from panics while serving a request, logging them and answering
+// with an internal error problem if nothing was written yet
+func RecoveryMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recorder := NewResponseRecorder(w)
+
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+
+				// the server aborts the response silently on this one, it's how handlers are expected to give up
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+
+				log.FromContext(r.Context()).
+					WithField("panic", fmt.Sprint(recovered)).
+					WithField("stack", string(debug.Stack())).Error("recovered from panic serving request")
+
+				if !recorder.WroteHeader() {
+					WriteProblem(recorder, r, internalProblem())
+				}
+			}()
+
+			next.ServeHTTP(recorder, r)
+		})
+	}
+}
+
+// RouteTemplate returns the path template of the route of the router that matches the request, or "unmatched"
+func RouteTemplate(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if !router.Match(r, &match) || match.Route == nil {
+		return "unmatched"
+	}
+
+	template, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return "unmatched"
+	}
+
+	return template
+}
+
+// ResponseRecorder is a http.ResponseWriter that keeps track of the status code and of the bytes written
+type ResponseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// NewResponseRecorder creates a ResponseRecorder that writes to w
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w}
+}
+
+func (r *ResponseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *ResponseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Flush sends the buffered data to the client, if the underlying http.ResponseWriter supports it
+func (r *ResponseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// WroteHeader tells whether the status code was written already
+func (r *ResponseRecorder) WroteHeader() bool {
+	return r.status != 0
+}
+
+// Status returns the status code written, 200 if the handler wrote nothing
+func (r *ResponseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+
+	return r.status
+}
+
+// Bytes returns the amount of bytes of the body written
+func (r *ResponseRecorder) Bytes() int {
+	return r.bytes
+}
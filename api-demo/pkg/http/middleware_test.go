@@ -0,0 +1,105 @@
+package http_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	customhttp "api-demo/pkg/http"
+	"api-demo/pkg/log"
+)
+
+func TestMiddlewares(t *testing.T) {
+
+	tests := map[string]struct {
+		path            string
+		requestID       string
+		expectedStatus  int
+		expectedRoute   string
+		checkRequestID  func(t *testing.T, requestID string)
+		expectedProblem bool
+	}{
+		"should log the route template and reuse the request ID of the client": {
+			path:           "/users/42",
+			requestID:      "client-id",
+			expectedStatus: http.StatusCreated,
+			expectedRoute:  "/users/{id}",
+			checkRequestID: func(t *testing.T, requestID string) {
+				require.Equal(t, "client-id", requestID)
+			},
+		},
+		"should recover from panics answering with a problem": {
+			path:           "/panic",
+			expectedStatus: http.StatusInternalServerError,
+			expectedRoute:  "/panic",
+			checkRequestID: func(t *testing.T, requestID string) {
+				require.NotEmpty(t, requestID)
+			},
+			expectedProblem: true,
+		},
+		"should log unmatched routes": {
+			path:           "/nowhere",
+			expectedStatus: http.StatusNotFound,
+			expectedRoute:  "unmatched",
+			checkRequestID: func(t *testing.T, requestID string) {
+				require.NotEmpty(t, requestID)
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			logs := &bytes.Buffer{}
+			logger := log.New(log.WithOutput(logs), log.WithFormatter(&logrus.JSONFormatter{}))
+
+			router := mux.NewRouter()
+			router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+				require.NotEmpty(t, customhttp.RequestIDFromContext(r.Context()))
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte("created"))
+			})
+			router.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			})
+
+			handler := customhttp.Chain(router,
+				customhttp.RequestIDMiddleware(),
+				customhttp.RequestLoggerMiddleware(logger),
+				customhttp.AccessLogMiddleware(router),
+				customhttp.RecoveryMiddleware())
+
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.requestID != "" {
+				r.Header.Set(customhttp.RequestIDHeader, test.requestID)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, test.expectedStatus, w.Code)
+			requestID := w.Header().Get(customhttp.RequestIDHeader)
+			test.checkRequestID(t, requestID)
+
+			if test.expectedProblem {
+				var problem customhttp.Problem
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+				require.Equal(t, requestID, problem.RequestID)
+			}
+
+			var accessLog map[string]interface{}
+			lines := bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n"))
+			require.NoError(t, json.Unmarshal(lines[len(lines)-1], &accessLog))
+			require.Equal(t, "request served", accessLog["msg"])
+			require.Equal(t, test.expectedRoute, accessLog["route"])
+			require.Equal(t, float64(test.expectedStatus), accessLog["status"])
+			require.Equal(t, float64(w.Body.Len()), accessLog["bytes"])
+			require.Equal(t, requestID, accessLog["request_id"])
+		})
+	}
+}
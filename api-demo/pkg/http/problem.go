@@ -0,0 +1,180 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"api-demo/pkg/log"
+)
+
+// ProblemContentType is the content type of RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+// problemTypePrefix prefixes the code of an error to build the URI that identifies its problem type
+const problemTypePrefix = "urn:api-demo:problem:"
+
+// fallbackProblem is written when a Problem can't be encoded
+var fallbackProblem = []byte(`{"type":"about:blank","title":"Internal Server Error","status":500}` + "\n")
+
+// ClassifiedError is implemented by errors that tell how they should be reported to clients, errors that don't
+// implement it are considered internal
+type ClassifiedError interface {
+	error
+
+	// ErrorKind tells the class of the error, like "not_found" or "validation", it defines the status code
+	ErrorKind() string
+
+	// ErrorCode is a machine-readable code that identifies the error
+	ErrorCode() string
+
+	// PublicMessage is the message that's safe to be sent to clients
+	PublicMessage() string
+}
+
+// fieldError is implemented by errors caused by a single field of the input
+type fieldError interface {
+	ErrorField() string
+}
+
+// fieldErrors is implemented by errors that group the errors of many fields of the input
+type fieldErrors interface {
+	FieldErrors() []error
+}
+
+// kindStatuses maps the kinds of ClassifiedError to status codes, unknown kinds are considered internal
+var kindStatuses = map[string]int{
+	"validation":         http.StatusBadRequest,
+	"unauthorized":       http.StatusUnauthorized,
+	"forbidden":          http.StatusForbidden,
+	"not_found":          http.StatusNotFound,
+	"conflict":           http.StatusConflict,
+	"insufficient_funds": http.StatusUnprocessableEntity,
+	"internal":           http.StatusInternalServerError,
+}
+
+// Problem describes an error following RFC 7807, with the code, the invalid fields and the request ID as extensions
+type Problem struct {
+	Type      string         `json:"type"`
+	Title     string         `json:"title"`
+	Status    int            `json:"status"`
+	Detail    string         `json:"detail,omitempty"`
+	Instance  string         `json:"instance,omitempty"`
+	Code      string         `json:"code,omitempty"`
+	Errors    []FieldProblem `json:"errors,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+// FieldProblem describes why a field of the input is invalid
+type FieldProblem struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// NewProblem creates the Problem that describes err. Errors that aren't a ClassifiedError, or that are internal, are
+// described without any detail.
+func NewProblem(err error) *Problem {
+	var classified ClassifiedError
+	if !errors.As(err, &classified) {
+		return internalProblem()
+	}
+
+	status, ok := kindStatuses[classified.ErrorKind()]
+	if !ok || status >= http.StatusInternalServerError {
+		return internalProblem()
+	}
+
+	problem := &Problem{
+		Type:   problemTypePrefix + classified.ErrorCode(),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: classified.PublicMessage(),
+		Code:   classified.ErrorCode(),
+	}
+
+	var grouped fieldErrors
+	if errors.As(err, &grouped) {
+		for _, fieldErr := range grouped.FieldErrors() {
+			problem.addFieldError(fieldErr)
+		}
+	}
+
+	if len(problem.Errors) == 0 {
+		problem.addFieldError(classified)
+	}
+
+	return problem
+}
+
+// internalProblem describes an internal error
+func internalProblem() *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusInternalServerError),
+		Status: http.StatusInternalServerError,
+		Code:   "internal",
+	}
+}
+
+// addFieldError adds err to the invalid fields, if it was caused by a field
+func (p *Problem) addFieldError(err error) {
+	var field fieldError
+	var classified ClassifiedError
+	if !errors.As(err, &field) || field.ErrorField() == "" || !errors.As(err, &classified) {
+		return
+	}
+
+	p.Errors = append(p.Errors, FieldProblem{
+		Field:  field.ErrorField(),
+		Code:   classified.ErrorCode(),
+		Detail: classified.PublicMessage(),
+	})
+}
+
+// WriteError writes err as a Problem, with the status code of its kind. The details of internal errors aren't sent to
+// the client, they're logged with the logger of the request context instead.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	problem := NewProblem(err)
+
+	if problem.Status >= http.StatusInternalServerError {
+		log.FromContext(r.Context()).
+			WithError(err).
+			WithField("request_id", RequestID(r)).
+			WithField("method", r.Method).
+			WithField("path", r.URL.Path).Error("failed to serve request")
+	}
+
+	WriteProblem(w, r, problem)
+}
+
+// WriteProblem writes the problem, filling the instance and the request ID from the request when they're empty. If the
+// problem can't be encoded, a generic internal error is written instead.
+func WriteProblem(w http.ResponseWriter, r *http.Request, problem *Problem) {
+	if problem.Instance == "" {
+		problem.Instance = r.URL.Path
+	}
+
+	if problem.RequestID == "" {
+		problem.RequestID = RequestID(r)
+	}
+
+	w.Header().Set(RequestIDHeader, problem.RequestID)
+	w.Header().Set("Content-Type", ProblemContentType)
+
+	body, err := json.Marshal(problem)
+	if err != nil {
+		log.FromContext(r.Context()).
+			WithError(err).
+			WithField("request_id", problem.RequestID).Error("failed to encode problem")
+
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write(fallbackProblem)
+		return
+	}
+
+	w.WriteHeader(problem.Status)
+
+	// the client may be gone already, there's nobody left to tell about failing to write
+	_, _ = w.Write(append(body, '\n'))
+}
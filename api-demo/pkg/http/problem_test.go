@@ -0,0 +1,156 @@
+package http_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	customhttp "api-demo/pkg/http"
+	"api-demo/pkg/log"
+)
+
+// testError is a customhttp.ClassifiedError
+type testError struct {
+	kind   string
+	code   string
+	field  string
+	fields []error
+}
+
+func (e *testError) Error() string         { return "detailed " + e.code }
+func (e *testError) ErrorKind() string     { return e.kind }
+func (e *testError) ErrorCode() string     { return e.code }
+func (e *testError) PublicMessage() string { return "public " + e.code }
+func (e *testError) ErrorField() string    { return e.field }
+func (e *testError) FieldErrors() []error  { return e.fields }
+
+func TestWriteError(t *testing.T) {
+
+	tests := map[string]struct {
+		err             error
+		requestID       string
+		expectedProblem customhttp.Problem
+		expectedLog     bool
+	}{
+		"should describe classified errors": {
+			err:       &testError{kind: "insufficient_funds", code: "insufficient_funds"},
+			requestID: "req-1",
+			expectedProblem: customhttp.Problem{
+				Type:      "urn:api-demo:problem:insufficient_funds",
+				Title:     "Unprocessable Entity",
+				Status:    http.StatusUnprocessableEntity,
+				Detail:    "public insufficient_funds",
+				Instance:  "/me/transactions",
+				Code:      "insufficient_funds",
+				RequestID: "req-1",
+			},
+		},
+		"should list the invalid fields": {
+			err: &testError{kind: "validation", code: "invalid_input", fields: []error{
+				&testError{kind: "validation", code: "invalid_limit", field: "limit"},
+				&testError{kind: "validation", code: "invalid_from", field: "from"},
+			}},
+			requestID: "req-2",
+			expectedProblem: customhttp.Problem{
+				Type:     "urn:api-demo:problem:invalid_input",
+				Title:    "Bad Request",
+				Status:   http.StatusBadRequest,
+				Detail:   "public invalid_input",
+				Instance: "/me/transactions",
+				Code:     "invalid_input",
+				Errors: []customhttp.FieldProblem{
+					{Field: "limit", Code: "invalid_limit", Detail: "public invalid_limit"},
+					{Field: "from", Code: "invalid_from", Detail: "public invalid_from"},
+				},
+				RequestID: "req-2",
+			},
+		},
+		"should list the field of a single field error": {
+			err:       &testError{kind: "validation", code: "invalid_amount", field: "amount"},
+			requestID: "req-3",
+			expectedProblem: customhttp.Problem{
+				Type:     "urn:api-demo:problem:invalid_amount",
+				Title:    "Bad Request",
+				Status:   http.StatusBadRequest,
+				Detail:   "public invalid_amount",
+				Instance: "/me/transactions",
+				Code:     "invalid_amount",
+				Errors: []customhttp.FieldProblem{
+					{Field: "amount", Code: "invalid_amount", Detail: "public invalid_amount"},
+				},
+				RequestID: "req-3",
+			},
+		},
+		"should hide and log the details of unclassified errors": {
+			err:       errors.New("dial tcp 10.0.0.1:5432: connection refused"),
+			requestID: "req-4",
+			expectedProblem: customhttp.Problem{
+				Type:      "about:blank",
+				Title:     "Internal Server Error",
+				Status:    http.StatusInternalServerError,
+				Instance:  "/me/transactions",
+				Code:      "internal",
+				RequestID: "req-4",
+			},
+			expectedLog: true,
+		},
+		"should hide and log the details of internal errors": {
+			err:       &testError{kind: "internal", code: "ledger_unbalanced"},
+			requestID: "req-5",
+			expectedProblem: customhttp.Problem{
+				Type:      "about:blank",
+				Title:     "Internal Server Error",
+				Status:    http.StatusInternalServerError,
+				Instance:  "/me/transactions",
+				Code:      "internal",
+				RequestID: "req-5",
+			},
+			expectedLog: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			logs := &bytes.Buffer{}
+			logger := log.New(log.WithOutput(logs), log.WithLevel(logrus.InfoLevel))
+
+			r := httptest.NewRequest(http.MethodGet, "/me/transactions?limit=x", nil)
+			r = r.WithContext(customhttp.ContextWithRequestID(log.ContextWithLogger(r.Context(), logger),
+				test.requestID))
+			w := httptest.NewRecorder()
+
+			customhttp.WriteError(w, r, test.err)
+
+			require.Equal(t, test.expectedProblem.Status, w.Code)
+			require.Equal(t, customhttp.ProblemContentType, w.Header().Get("Content-Type"))
+			require.Equal(t, test.requestID, w.Header().Get(customhttp.RequestIDHeader))
+
+			var problem customhttp.Problem
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+			require.Equal(t, test.expectedProblem, problem)
+
+			require.Equal(t, test.expectedLog, logs.Len() > 0)
+			require.NotContains(t, w.Body.String(), "detailed")
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(customhttp.RequestIDHeader, "client-id")
+	require.Equal(t, "client-id", customhttp.RequestID(r))
+
+	r.Header.Set(customhttp.RequestIDHeader, "forged\nlog line")
+	require.NotEqual(t, "forged\nlog line", customhttp.RequestID(r))
+	require.NotEmpty(t, customhttp.RequestID(r))
+
+	r = r.WithContext(customhttp.ContextWithRequestID(r.Context(), "context-id"))
+	require.Equal(t, "context-id", customhttp.RequestID(r))
+}
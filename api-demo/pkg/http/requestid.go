@@ -0,0 +1,52 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the header that carries the ID of a request, so clients can refer to it when asking for support
+const RequestIDHeader = "X-Request-ID"
+
+const contextRequestIDKey = "request_id"
+
+// ContextWithRequestID injects the ID of the request being served into the context
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextRequestIDKey, requestID)
+}
+
+// RequestIDFromContext fetches the ID of the request being served from the context, it's empty if there's none
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(contextRequestIDKey).(string)
+	return requestID
+}
+
+// RequestID returns the ID of a request: the one in its context, the one sent by the client or a new one, in this order
+func RequestID(r *http.Request) string {
+	if requestID := RequestIDFromContext(r.Context()); requestID != "" {
+		return requestID
+	}
+
+	if requestID := r.Header.Get(RequestIDHeader); isValidRequestID(requestID) {
+		return requestID
+	}
+
+	return uuid.New().String()
+}
+
+// isValidRequestID accepts request IDs sent by clients that are short and printable, so they can't forge log lines
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 128 {
+		return false
+	}
+
+	for _, c := range requestID {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
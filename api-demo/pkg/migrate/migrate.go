@@ -0,0 +1,346 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultTable is the table where the applied migrations are recorded when no table is configured
+const DefaultTable = "schema_migrations"
+
+// DefaultLockKey is the key of the Postgres advisory lock held while migrating when no key is configured
+const DefaultLockKey int64 = 7357001
+
+// ErrChecksumMismatch is returned when a migration was modified after being applied
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+const (
+	// StatePending means the migration wasn't applied yet
+	StatePending = "pending"
+
+	// StateApplied means the migration was applied as it's now
+	StateApplied = "applied"
+
+	// StateModified means the migration was modified after being applied
+	StateModified = "modified"
+
+	// StateUnknown means the migration was applied but isn't known, e.g. it was applied by a newer version of the app
+	StateUnknown = "unknown"
+)
+
+// Migration is a versioned change of the schema, Down reverts what Up does
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum returns the SHA-256 of the Up SQL, recorded when the migration is applied to detect later modifications
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+// Status is the state of a migration in a database
+type Status struct {
+	Version   int
+	Name      string
+	State     string
+	AppliedAt *time.Time
+}
+
+// appliedMigration is a migration recorded as applied in the migrations table
+type appliedMigration struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Validate checks that the migrations have positive versions in ascending order, names and Up SQL
+func Validate(migrations []Migration) error {
+	for i, migration := range migrations {
+		if migration.Version <= 0 {
+			return fmt.Errorf("migration %q has the non positive version %d", migration.Name, migration.Version)
+		}
+
+		if i > 0 && migration.Version <= migrations[i-1].Version {
+			return fmt.Errorf("migration %d comes after %d, versions should be in ascending order", migration.Version,
+				migrations[i-1].Version)
+		}
+
+		if migration.Name == "" || migration.Up == "" {
+			return fmt.Errorf("migration %d should have a name and the Up SQL", migration.Version)
+		}
+	}
+
+	return nil
+}
+
+// Migrator applies and rolls back migrations on a Postgres database. Every operation holds an advisory lock, so
+// concurrent instances of the app don't race to migrate the same database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	table      string
+	lockKey    int64
+}
+
+// Opt is an option that can be passed to NewMigrator to configure the migrator
+type Opt func(*Migrator)
+
+// WithTable returns an Opt that sets the table where the applied migrations are recorded
+func WithTable(table string) Opt {
+	return func(m *Migrator) {
+		m.table = table
+	}
+}
+
+// WithLockKey returns an Opt that sets the key of the advisory lock held while migrating
+func WithLockKey(key int64) Opt {
+	return func(m *Migrator) {
+		m.lockKey = key
+	}
+}
+
+// NewMigrator creates a Migrator of the given migrations, which should be valid according to Validate
+func NewMigrator(db *sql.DB, migrations []Migration, opts ...Opt) (*Migrator, error) {
+	if err := Validate(migrations); err != nil {
+		return nil, err
+	}
+
+	m := &Migrator{db: db, migrations: migrations, table: DefaultTable, lockKey: DefaultLockKey}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m, nil
+}
+
+// Up applies the pending migrations in ascending order, each one in its own transaction, returning the applied ones.
+// It fails without applying anything when an applied migration was modified.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			err := m.inTx(ctx, conn, migration.Up,
+				`INSERT INTO `+m.quotedTable()+` (version, name, checksum, applied_at) VALUES ($1, $2, $3, now())`,
+				migration.Version, migration.Name, migration.Checksum())
+			if err != nil {
+				return fmt.Errorf("error applying migration %d (%s): %w", migration.Version, migration.Name, err)
+			}
+
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down rolls back the last steps applied migrations in descending order, each one in its own transaction, returning
+// the rolled back ones
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		if err := m.verify(applied); err != nil {
+			return err
+		}
+
+		known := make(map[int]Migration, len(m.migrations))
+		for _, migration := range m.migrations {
+			known[migration.Version] = migration
+		}
+
+		versions := make([]int, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		for i := 0; i < steps && i < len(versions); i++ {
+			migration, ok := known[versions[i]]
+			if !ok {
+				return fmt.Errorf("migration %d (%s) is unknown to this version of the app, it can't be rolled back",
+					versions[i], applied[versions[i]].name)
+			}
+
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d (%s) can't be rolled back", migration.Version, migration.Name)
+			}
+
+			err := m.inTx(ctx, conn, migration.Down, `DELETE FROM `+m.quotedTable()+` WHERE version = $1`,
+				migration.Version)
+			if err != nil {
+				return fmt.Errorf("error rolling back migration %d (%s): %w", migration.Version, migration.Name, err)
+			}
+
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Status returns the state of the known migrations and of the applied ones that aren't known, by version
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		for _, migration := range m.migrations {
+			status := Status{Version: migration.Version, Name: migration.Name, State: StatePending}
+			if record, ok := applied[migration.Version]; ok {
+				appliedAt := record.appliedAt
+				status.AppliedAt = &appliedAt
+				status.State = StateApplied
+				if record.checksum != migration.Checksum() {
+					status.State = StateModified
+				}
+
+				delete(applied, migration.Version)
+			}
+
+			statuses = append(statuses, status)
+		}
+
+		for _, record := range applied {
+			appliedAt := record.appliedAt
+			statuses = append(statuses, Status{Version: record.version, Name: record.name, State: StateUnknown,
+				AppliedAt: &appliedAt})
+		}
+
+		sort.Slice(statuses, func(i, j int) bool {
+			return statuses[i].Version < statuses[j].Version
+		})
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// verify checks that the applied migrations that are known weren't modified, the unknown ones are expected when a
+// newer version of the app migrated the database already
+func (m *Migrator) verify(applied map[int]appliedMigration) error {
+	for _, migration := range m.migrations {
+		record, ok := applied[migration.Version]
+		if ok && record.checksum != migration.Checksum() {
+			return fmt.Errorf("migration %d (%s) was modified after being applied: %w", migration.Version,
+				migration.Name, ErrChecksumMismatch)
+		}
+	}
+
+	return nil
+}
+
+// withLock runs fn on a connection holding the advisory lock, with the migrations table created and the applied
+// migrations loaded
+func (m *Migrator) withLock(ctx context.Context, fn func(*sql.Conn, map[int]appliedMigration) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting a connection: %w", err)
+	}
+
+	defer conn.Close()
+
+	// the lock is held by the session, so it's released even if the connection is lost
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, m.lockKey); err != nil {
+		return fmt.Errorf("error acquiring the migrations lock: %w", err)
+	}
+
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, m.lockKey)
+	}()
+
+	const createQuery = ` (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT                        NOT NULL,
+		checksum   TEXT                        NOT NULL,
+		applied_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+	)`
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+m.quotedTable()+createQuery); err != nil {
+		return fmt.Errorf("error creating the migrations table: %w", err)
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+// applied loads the migrations recorded as applied
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM `+m.quotedTable())
+	if err != nil {
+		return nil, fmt.Errorf("error loading the applied migrations: %w", err)
+	}
+
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var record appliedMigration
+		if err := rows.Scan(&record.version, &record.name, &record.checksum, &record.appliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning applied migration: %w", err)
+		}
+
+		applied[record.version] = record
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error loading the applied migrations: %w", err)
+	}
+
+	return applied, nil
+}
+
+// inTx runs the SQL of a migration and the query recording it in a single transaction
+func (m *Migrator) inTx(ctx context.Context, conn *sql.Conn, migrationSQL string, recordQuery string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// migrations may have several statements, which are only supported by queries without arguments
+	if _, err := tx.ExecContext(ctx, migrationSQL); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, recordQuery, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) quotedTable() string {
+	return pq.QuoteIdentifier(m.table)
+}
@@ -0,0 +1,317 @@
+package migrate_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	// register the pg driver
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/pkg/migrate"
+)
+
+// testDSNEnv is the env var with the DSN of the database the tests run on, they're skipped when it isn't set. The tests
+// create and drop their own tables.
+const testDSNEnv = "API_DEMO_TEST_POSTGRES_DSN"
+
+// testTable is the migrations table of the tests, so they don't touch the migrations of the app
+const testTable = "migrate_test_migrations"
+
+// testLockKey is the advisory lock of the tests, so they don't wait for the app migrating the same database
+const testLockKey int64 = 7357999
+
+// testMigrations create the tables of the tests
+var testMigrations = []migrate.Migration{
+	{Version: 1, Name: "accounts", Up: "CREATE TABLE migrate_test_accounts (id INTEGER PRIMARY KEY)",
+		Down: "DROP TABLE migrate_test_accounts"},
+	{Version: 2, Name: "entries", Up: "CREATE TABLE migrate_test_entries (id INTEGER PRIMARY KEY)",
+		Down: "DROP TABLE migrate_test_entries"},
+	{Version: 3, Name: "balances", Up: "ALTER TABLE migrate_test_accounts ADD COLUMN balance BIGINT NOT NULL DEFAULT 0",
+		Down: "ALTER TABLE migrate_test_accounts DROP COLUMN balance"},
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		migrations    []migrate.Migration
+		expectedError string
+	}{
+		"valid migrations": {
+			migrations: []migrate.Migration{
+				{Version: 1, Name: "first", Up: "CREATE TABLE a ()", Down: "DROP TABLE a"},
+				{Version: 3, Name: "third", Up: "CREATE TABLE b ()"},
+			},
+		},
+		"no migrations": {},
+		"non positive version": {
+			migrations:    []migrate.Migration{{Version: 0, Name: "first", Up: "CREATE TABLE a ()"}},
+			expectedError: `migration "first" has the non positive version 0`,
+		},
+		"versions out of order": {
+			migrations: []migrate.Migration{
+				{Version: 2, Name: "second", Up: "CREATE TABLE b ()"},
+				{Version: 1, Name: "first", Up: "CREATE TABLE a ()"},
+			},
+			expectedError: "migration 1 comes after 2, versions should be in ascending order",
+		},
+		"repeated version": {
+			migrations: []migrate.Migration{
+				{Version: 1, Name: "first", Up: "CREATE TABLE a ()"},
+				{Version: 1, Name: "again", Up: "CREATE TABLE b ()"},
+			},
+			expectedError: "migration 1 comes after 1, versions should be in ascending order",
+		},
+		"missing up": {
+			migrations:    []migrate.Migration{{Version: 1, Name: "first", Down: "DROP TABLE a"}},
+			expectedError: "migration 1 should have a name and the Up SQL",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := migrate.Validate(tt.migrations)
+			if tt.expectedError == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			require.EqualError(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestMigration_Checksum(t *testing.T) {
+	migration := migrate.Migration{Version: 1, Name: "first", Up: "CREATE TABLE a ()", Down: "DROP TABLE a"}
+
+	require.Len(t, migration.Checksum(), 64)
+
+	renamed := migration
+	renamed.Name = "renamed"
+	renamed.Down = "DROP TABLE IF EXISTS a"
+	require.Equal(t, migration.Checksum(), renamed.Checksum(), "only the Up SQL is part of the checksum")
+
+	modified := migration
+	modified.Up = "CREATE TABLE b ()"
+	require.NotEqual(t, migration.Checksum(), modified.Checksum())
+}
+
+// newTestDB connects to the database of the tests, dropping their tables before and after the test
+func newTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s isn't set", testDSNEnv)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+
+	drop := func() {
+		_, err := db.Exec(`DROP TABLE IF EXISTS ` + testTable +
+			`, migrate_test_accounts, migrate_test_entries, migrate_test_broken`)
+		require.NoError(t, err)
+	}
+
+	drop()
+	t.Cleanup(func() {
+		drop()
+		_ = db.Close()
+	})
+
+	return db
+}
+
+func newTestMigrator(t *testing.T, db *sql.DB, migrations []migrate.Migration) *migrate.Migrator {
+	migrator, err := migrate.NewMigrator(db, migrations, migrate.WithTable(testTable), migrate.WithLockKey(testLockKey))
+	require.NoError(t, err)
+
+	return migrator
+}
+
+func versions(migrations []migrate.Migration) []int {
+	versions := []int{}
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version)
+	}
+
+	return versions
+}
+
+func states(statuses []migrate.Status) map[int]string {
+	states := map[int]string{}
+	for _, status := range statuses {
+		states[status.Version] = status.State
+	}
+
+	return states
+}
+
+func TestMigrator_UpAndDown(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	migrator := newTestMigrator(t, db, testMigrations)
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{1: migrate.StatePending, 2: migrate.StatePending, 3: migrate.StatePending},
+		states(statuses))
+
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3}, versions(applied))
+
+	_, err = db.Exec(`INSERT INTO migrate_test_accounts (id, balance) VALUES (1, 10)`)
+	require.NoError(t, err)
+
+	applied, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Empty(t, applied, "the applied migrations aren't applied again")
+
+	statuses, err = migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{1: migrate.StateApplied, 2: migrate.StateApplied, 3: migrate.StateApplied},
+		states(statuses))
+	for _, status := range statuses {
+		require.NotNil(t, status.AppliedAt)
+	}
+
+	rolledBack, err := migrator.Down(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, []int{3, 2}, versions(rolledBack))
+
+	var exists bool
+	require.NoError(t, db.QueryRow(`SELECT to_regclass('migrate_test_entries') IS NOT NULL`).Scan(&exists))
+	require.False(t, exists)
+
+	statuses, err = migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{1: migrate.StateApplied, 2: migrate.StatePending, 3: migrate.StatePending},
+		states(statuses))
+
+	applied, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3}, versions(applied))
+}
+
+func TestMigrator_FailedMigration(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	broken := append([]migrate.Migration{}, testMigrations[:2]...)
+	broken = append(broken, migrate.Migration{Version: 3, Name: "broken",
+		Up: "CREATE TABLE migrate_test_broken (id INTEGER PRIMARY KEY); ALTER TABLE missing ADD COLUMN a INTEGER"})
+
+	applied, err := newTestMigrator(t, db, broken).Up(ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error applying migration 3 (broken)")
+	require.Equal(t, []int{1, 2}, versions(applied), "the migrations before the failed one stay applied")
+
+	var exists bool
+	require.NoError(t, db.QueryRow(`SELECT to_regclass('migrate_test_broken') IS NOT NULL`).Scan(&exists))
+	require.False(t, exists, "the failed migration is rolled back as a whole")
+
+	statuses, err := newTestMigrator(t, db, broken).Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, migrate.StatePending, states(statuses)[3])
+}
+
+func TestMigrator_ChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	_, err := newTestMigrator(t, db, testMigrations[:2]).Up(ctx)
+	require.NoError(t, err)
+
+	modified := append([]migrate.Migration{}, testMigrations...)
+	modified[1].Up = "CREATE TABLE migrate_test_entries (id BIGINT PRIMARY KEY)"
+	migrator := newTestMigrator(t, db, modified)
+
+	applied, err := migrator.Up(ctx)
+	require.True(t, errors.Is(err, migrate.ErrChecksumMismatch))
+	require.Empty(t, applied, "nothing is applied when an applied migration was modified")
+
+	_, err = migrator.Down(ctx, 1)
+	require.True(t, errors.Is(err, migrate.ErrChecksumMismatch))
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{1: migrate.StateApplied, 2: migrate.StateModified, 3: migrate.StatePending},
+		states(statuses))
+}
+
+func TestMigrator_UnknownMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	// a newer version of the app applied the migration 3
+	_, err := newTestMigrator(t, db, testMigrations).Up(ctx)
+	require.NoError(t, err)
+
+	migrator := newTestMigrator(t, db, testMigrations[:2])
+
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Empty(t, applied)
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{1: migrate.StateApplied, 2: migrate.StateApplied, 3: migrate.StateUnknown},
+		states(statuses))
+
+	_, err = migrator.Down(ctx, 1)
+	require.EqualError(t, err, "migration 3 (balances) is unknown to this version of the app, it can't be rolled back")
+}
+
+func TestMigrator_Lock(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	migrator := newTestMigrator(t, db, testMigrations)
+
+	// another instance is migrating the database
+	conn, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, testLockKey)
+	require.NoError(t, err)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	applied, err := migrator.Up(waitCtx)
+	require.Error(t, err, "the migrations wait for the lock")
+	require.Contains(t, err.Error(), "error acquiring the migrations lock")
+	require.Empty(t, applied)
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, testLockKey)
+	require.NoError(t, err)
+
+	// concurrent instances apply every migration once
+	migrators := []*migrate.Migrator{migrator, newTestMigrator(t, db, testMigrations),
+		newTestMigrator(t, db, testMigrations)}
+
+	var wg sync.WaitGroup
+	results := make([][]migrate.Migration, len(migrators))
+	errs := make([]error, len(migrators))
+	for i := range migrators {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = migrators[i].Up(ctx)
+		}(i)
+	}
+
+	wg.Wait()
+
+	var all []migrate.Migration
+	for i := range results {
+		require.NoError(t, errs[i])
+		all = append(all, results[i]...)
+	}
+
+	require.ElementsMatch(t, []int{1, 2, 3}, versions(all))
+}
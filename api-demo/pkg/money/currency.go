@@ -0,0 +1,96 @@
+package money
+
+import (
+	"fmt"
+)
+
+// Currency is an ISO 4217 currency code, e.g. "USD"
+type Currency string
+
+const (
+	BRL Currency = "BRL"
+	EUR Currency = "EUR"
+	GBP Currency = "GBP"
+	JPY Currency = "JPY"
+	KWD Currency = "KWD"
+	USD Currency = "USD"
+)
+
+// minorUnits is the number of fractional digits of each supported currency, as defined by ISO 4217
+var minorUnits = map[Currency]int{
+	BRL: 2,
+	EUR: 2,
+	GBP: 2,
+	JPY: 0,
+	KWD: 3,
+	USD: 2,
+}
+
+// ParseCurrency parses an ISO 4217 code like "USD" into a Currency, codes of unsupported currencies are rejected
+func ParseCurrency(code string) (Currency, error) {
+	currency := Currency(code)
+	if _, ok := minorUnits[currency]; !ok {
+		return "", fmt.Errorf("unsupported currency %q", code)
+	}
+
+	return currency, nil
+}
+
+// MinorUnits returns the number of fractional digits of the currency, e.g. 2 for USD and 0 for JPY
+func (c Currency) MinorUnits() int {
+	return minorUnits[c]
+}
+
+// Parse parses a decimal representation like "10" or "0.01" into an Amount of the currency, just like the Parse
+// function, but allowing as many fractional digits as the minor units of the currency
+func (c Currency) Parse(s string) (Amount, error) {
+	return parse(s, c.MinorUnits())
+}
+
+// Format formats an amount of the currency as a decimal with exactly its minor units as fractional digits, e.g. "10.50"
+// for USD and "1050" for JPY
+func (c Currency) Format(a Amount) string {
+	return format(a, c.MinorUnits())
+}
+
+// Money is an amount along with its currency
+type Money struct {
+	Amount   Amount
+	Currency Currency
+}
+
+// String formats the money as its amount followed by its currency, e.g. "10.50 USD"
+func (m Money) String() string {
+	return m.Currency.Format(m.Amount) + " " + string(m.Currency)
+}
+
+// Float64 converts the amount to major units of its currency, e.g. 10.5. Like Amount.Float64, it's only meant for
+// reporting.
+func (m Money) Float64() float64 {
+	return float64(m.Amount) / float64(pow10(m.Currency.MinorUnits()))
+}
+
+// MarshalJSON encodes the amount as a JSON number formatted with the minor units of its currency, the currency itself
+// is expected to be encoded along with it, e.g. in a sibling field
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.Currency.Format(m.Amount)), nil
+}
+
+// Decimal is the text of a decimal amount whose currency isn't known yet, decoded either from a JSON number or a JSON
+// string without going through float64. It's parsed by Currency.Parse once the currency is known.
+type Decimal string
+
+// UnmarshalJSON decodes the text of the decimal, it's only validated when parsed
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if isNull(data) {
+		return nil
+	}
+
+	text, err := decimalText(data)
+	if err != nil {
+		return err
+	}
+
+	*d = Decimal(text)
+	return nil
+}
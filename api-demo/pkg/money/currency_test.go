@@ -0,0 +1,98 @@
+package money_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"api-demo/pkg/money"
+)
+
+func TestParseCurrency(t *testing.T) {
+
+	currency, err := money.ParseCurrency("USD")
+	require.NoError(t, err)
+	require.Equal(t, money.USD, currency)
+
+	for _, code := range []string{"", "usd", "XYZ", "US"} {
+		_, err := money.ParseCurrency(code)
+		require.Error(t, err, code)
+	}
+}
+
+func TestCurrency_Parse(t *testing.T) {
+
+	tests := map[string]struct {
+		currency money.Currency
+		input    string
+		expected money.Amount
+		fails    bool
+	}{
+		"should parse cents of USD":                          {currency: money.USD, input: "10.5", expected: 1050},
+		"should parse whole JPY":                             {currency: money.JPY, input: "1050", expected: 1050},
+		"should parse fils of KWD":                           {currency: money.KWD, input: "1.005", expected: 1005},
+		"should reject fractional JPY":                       {currency: money.JPY, input: "10.5", fails: true},
+		"should reject more fractional digits than KWD has":  {currency: money.KWD, input: "0.0001", fails: true},
+		"should reject amounts that don't fit in minor unit": {currency: money.KWD, input: "9223372036854776", fails: true},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			amount, err := test.currency.Parse(test.input)
+			if test.fails {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expected, amount)
+		})
+	}
+}
+
+func TestCurrency_Format(t *testing.T) {
+	require.Equal(t, "10.50", money.USD.Format(1050))
+	require.Equal(t, "1050", money.JPY.Format(1050))
+	require.Equal(t, "-1.050", money.KWD.Format(-1050))
+	require.Equal(t, "0.005", money.KWD.Format(5))
+}
+
+func TestMoney(t *testing.T) {
+
+	m := money.Money{Amount: 1050, Currency: money.JPY}
+	require.Equal(t, "1050 JPY", m.String())
+	require.Equal(t, 1050.0, m.Float64())
+
+	m = money.Money{Amount: 1050, Currency: money.KWD}
+	require.Equal(t, "1.050 KWD", m.String())
+	require.Equal(t, 1.05, m.Float64())
+
+	encoded, err := json.Marshal(struct {
+		Amount money.Money `json:"amount"`
+	}{m})
+	require.NoError(t, err)
+	require.Equal(t, `{"amount":1.050}`, string(encoded))
+}
+
+func TestDecimal_JSON(t *testing.T) {
+
+	var payload struct {
+		FromNumber money.Decimal  `json:"from_number"`
+		FromString money.Decimal  `json:"from_string"`
+		Missing    *money.Decimal `json:"missing"`
+	}
+
+	err := json.Unmarshal([]byte(`{"from_number": 0.125, "from_string": "1000"}`), &payload)
+	require.NoError(t, err)
+	require.Equal(t, money.Decimal("0.125"), payload.FromNumber)
+	require.Equal(t, money.Decimal("1000"), payload.FromString)
+	require.Nil(t, payload.Missing)
+
+	amount, err := money.KWD.Parse(string(payload.FromNumber))
+	require.NoError(t, err)
+	require.Equal(t, money.Amount(125), amount)
+
+	_, err = money.USD.Parse(string(payload.FromNumber))
+	require.Error(t, err)
+}
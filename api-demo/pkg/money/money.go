@@ -0,0 +1,206 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Decimals is the number of fractional digits of the amounts parsed and formatted without a currency, as Parse, String
+// and the JSON encoding of Amount do. Amounts of a Currency have its own number of minor units instead.
+const Decimals = 2
+
+// Amount represents a monetary value as an integer number of minor units (e.g. cents), so arithmetic on it is exact,
+// unlike arithmetic on binary floating point numbers. How many minor units make a major unit depends on the currency
+// of the amount.
+type Amount int64
+
+// Parse parses a decimal representation like "10", "-3.5" or "0.01" into an Amount with Decimals fractional digits.
+// Amounts having more fractional digits than Decimals, exponents or any other kind of formatting are rejected.
+func Parse(s string) (Amount, error) {
+	return parse(s, Decimals)
+}
+
+// MustParse is like Parse but panics if the amount is invalid, it's intended for constants and tests
+func MustParse(s string) Amount {
+	amount, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return amount
+}
+
+// String formats the amount as a decimal with exactly Decimals fractional digits, e.g. "10.50"
+func (a Amount) String() string {
+	return format(a, Decimals)
+}
+
+// Float64 converts the amount to major units, e.g. 10.5. The conversion may lose precision, so it's only meant for
+// reporting, like metrics, never for calculations.
+func (a Amount) Float64() float64 {
+	return float64(a) / float64(pow10(Decimals))
+}
+
+// MarshalJSON encodes the amount as a JSON number holding its exact decimal representation
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON decodes the amount either from a JSON number or a JSON string, in both cases the text is parsed
+// exactly, without going through float64
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if isNull(data) {
+		return nil
+	}
+
+	text, err := decimalText(data)
+	if err != nil {
+		return err
+	}
+
+	amount, err := Parse(text)
+	if err != nil {
+		return err
+	}
+
+	*a = amount
+	return nil
+}
+
+// Add returns the sum of a and b, failing if the result overflows
+func (a Amount) Add(b Amount) (Amount, error) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, errors.New("amount overflow")
+	}
+
+	return sum, nil
+}
+
+// Sub returns the difference between a and b, failing if the result overflows
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if b == math.MinInt64 {
+		return 0, errors.New("amount overflow")
+	}
+
+	return a.Add(-b)
+}
+
+// parse parses a decimal representation into an Amount having the given number of fractional digits
+func parse(s string, decimals int) (Amount, error) {
+	value := s
+
+	negative := strings.HasPrefix(value, "-")
+	if negative {
+		value = value[1:]
+	}
+
+	integerPart, fractionalPart := value, ""
+	if dot := strings.IndexByte(value, '.'); dot >= 0 {
+		integerPart, fractionalPart = value[:dot], value[dot+1:]
+		if fractionalPart == "" {
+			return 0, fmt.Errorf("invalid amount %q: missing fractional digits", s)
+		}
+	}
+
+	if integerPart == "" || !isDigits(integerPart) || !isDigits(fractionalPart) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	if len(fractionalPart) > decimals {
+		if decimals == 0 {
+			return 0, fmt.Errorf("invalid amount %q: fractional digits aren't allowed", s)
+		}
+
+		return 0, fmt.Errorf("invalid amount %q: at most %d fractional digits are allowed", s, decimals)
+	}
+
+	scale := pow10(decimals)
+	major, err := strconv.ParseInt(integerPart, 10, 64)
+	if err != nil || major > math.MaxInt64/scale {
+		return 0, fmt.Errorf("invalid amount %q: out of range", s)
+	}
+
+	var minor int64
+	if fractionalPart != "" {
+		// right pads the fractional part, e.g. "5" becomes "50" when there are 2 decimals
+		fractionalPart += strings.Repeat("0", decimals-len(fractionalPart))
+		minor, _ = strconv.ParseInt(fractionalPart, 10, 64)
+	}
+
+	total := major*scale + minor
+	if total < 0 {
+		return 0, fmt.Errorf("invalid amount %q: out of range", s)
+	}
+
+	if negative {
+		total = -total
+	}
+
+	return Amount(total), nil
+}
+
+// format formats the amount as a decimal with exactly the given number of fractional digits
+func format(a Amount, decimals int) string {
+	value := int64(a)
+
+	sign := ""
+	if value < 0 {
+		sign = "-"
+	}
+
+	// works on unsigned values so math.MinInt64 doesn't overflow when negated
+	abs := uint64(value)
+	if value < 0 {
+		abs = -abs
+	}
+
+	scale := uint64(pow10(decimals))
+	major := abs / scale
+	if decimals == 0 {
+		return fmt.Sprintf("%s%d", sign, major)
+	}
+
+	return fmt.Sprintf("%s%d.%0*d", sign, major, decimals, abs%scale)
+}
+
+// decimalText returns the text of a decimal encoded either as a JSON number or a JSON string
+func decimalText(data []byte) (string, error) {
+	text := string(bytes.TrimSpace(data))
+	if strings.HasPrefix(text, `"`) {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return "", err
+		}
+	}
+
+	return text, nil
+}
+
+// isNull tells whether data is the JSON null
+func isNull(data []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(data), []byte("null"))
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func pow10(n int) int64 {
+	result := int64(1)
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+
+	return result
+}
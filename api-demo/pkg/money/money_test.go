@@ -0,0 +1,79 @@
+package money_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"api-demo/pkg/money"
+)
+
+func TestParse(t *testing.T) {
+
+	tests := map[string]struct {
+		input    string
+		expected money.Amount
+		fails    bool
+	}{
+		"should parse an integer amount":                      {input: "10", expected: 1000},
+		"should parse an amount with one fractional digit":    {input: "10.5", expected: 1050},
+		"should parse an amount with two fractional digits":   {input: "0.01", expected: 1},
+		"should parse a negative amount":                      {input: "-3.25", expected: -325},
+		"should parse zero":                                   {input: "0.00", expected: 0},
+		"should reject more fractional digits than allowed":   {input: "0.001", fails: true},
+		"should reject exponents":                             {input: "1e2", fails: true},
+		"should reject a missing integer part":                {input: ".5", fails: true},
+		"should reject a missing fractional part":             {input: "5.", fails: true},
+		"should reject an explicit plus sign":                 {input: "+5", fails: true},
+		"should reject an empty string":                       {input: "", fails: true},
+		"should reject amounts that don't fit in minor units": {input: "92233720368547758.08", fails: true},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			amount, err := money.Parse(test.input)
+			if test.fails {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expected, amount)
+		})
+	}
+}
+
+func TestAmount_String(t *testing.T) {
+	require.Equal(t, "10.50", money.Amount(1050).String())
+	require.Equal(t, "0.01", money.Amount(1).String())
+	require.Equal(t, "-3.25", money.Amount(-325).String())
+	require.Equal(t, "-92233720368547758.08", money.Amount(-9223372036854775808).String())
+}
+
+func TestAmount_Float64(t *testing.T) {
+	require.Equal(t, 10.5, money.Amount(1050).Float64())
+	require.Equal(t, -0.01, money.Amount(-1).Float64())
+}
+
+func TestAmount_JSON(t *testing.T) {
+
+	var payload struct {
+		FromNumber money.Amount `json:"from_number"`
+		FromString money.Amount `json:"from_string"`
+	}
+
+	err := json.Unmarshal([]byte(`{"from_number": 0.1, "from_string": "0.2"}`), &payload)
+	require.NoError(t, err)
+
+	sum, err := payload.FromNumber.Add(payload.FromString)
+	require.NoError(t, err)
+	require.Equal(t, money.MustParse("0.3"), sum)
+
+	encoded, err := json.Marshal(payload)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"from_number": 0.10, "from_string": 0.20}`, string(encoded))
+
+	err = json.Unmarshal([]byte(`{"from_number": 0.123}`), &payload)
+	require.Error(t, err)
+}
@@ -3,6 +3,9 @@ package pqutil
 import (
 	"context"
 	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
 )
 
 // Queryer represents the ability to query SQL databases
@@ -30,3 +33,38 @@ type ScannerIter interface {
 	Scanner
 	Iter
 }
+
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	uniqueViolation      = "23505"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// IsUniqueViolation reports whether the error was caused by a unique constraint violation
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// UniqueViolationConstraint returns the name of the constraint violated when the error was caused by a unique
+// constraint violation
+func UniqueViolationConstraint(err error) (string, bool) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != uniqueViolation {
+		return "", false
+	}
+
+	return pqErr.Constraint, true
+}
+
+// IsRetryable reports whether the error aborted a transaction that may succeed if retried, which is the case of
+// deadlocks and serialization failures
+func IsRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	return pqErr.Code == serializationFailure || pqErr.Code == deadlockDetected
+}
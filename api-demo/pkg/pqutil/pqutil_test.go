@@ -0,0 +1,39 @@
+package pqutil_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"api-demo/pkg/pqutil"
+)
+
+func TestIsRetryable(t *testing.T) {
+
+	tests := map[string]struct {
+		err       error
+		retryable bool
+	}{
+		"should retry deadlocks":                    {err: &pq.Error{Code: "40P01"}, retryable: true},
+		"should retry serialization failures":       {err: &pq.Error{Code: "40001"}, retryable: true},
+		"should retry wrapped serialization errors": {err: fmt.Errorf("commit: %w", &pq.Error{Code: "40001"}), retryable: true},
+		"should not retry unique violations":        {err: &pq.Error{Code: "23505"}},
+		"should not retry errors that aren't pq's":  {err: errors.New("connection refused")},
+		"should not retry nil errors":               {err: nil},
+	}
+
+	for title, test := range tests {
+		t.Run(title, func(t *testing.T) {
+			require.Equal(t, test.retryable, pqutil.IsRetryable(test.err))
+		})
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	require.True(t, pqutil.IsUniqueViolation(fmt.Errorf("insert: %w", &pq.Error{Code: "23505"})))
+	require.False(t, pqutil.IsUniqueViolation(&pq.Error{Code: "40001"}))
+	require.False(t, pqutil.IsUniqueViolation(errors.New("connection refused")))
+}
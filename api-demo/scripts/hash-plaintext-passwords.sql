@@ -0,0 +1,15 @@
+-- One-off migration for databases created before passwords were hashed: replaces every plaintext password by its bcrypt
+-- hash. Hashes are created with cost 10, users are transparently rehashed with the configured cost on their next login.
+-- It's safe to run it more than once, passwords that are already hashed are skipped.
+--
+-- Usage: psql -v ON_ERROR_STOP=1 -f scripts/hash-plaintext-passwords.sql
+
+BEGIN;
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+UPDATE users
+SET password = crypt(password, gen_salt('bf', 10))
+WHERE password !~ '^\$2[abxy]?\$[0-9]{2}\$';
+
+COMMIT;